@@ -8,12 +8,17 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/diag"
+	"github.com/vishnunath-suresh/fin-project/internal/difftest"
 	"github.com/vishnunath-suresh/fin-project/internal/format"
 	"github.com/vishnunath-suresh/fin-project/internal/generator"
+	"github.com/vishnunath-suresh/fin-project/internal/interp"
 	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/lsp"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+	"github.com/vishnunath-suresh/fin-project/internal/opt"
 	"github.com/vishnunath-suresh/fin-project/internal/parser"
 	"github.com/vishnunath-suresh/fin-project/internal/sema"
 	"github.com/vishnunath-suresh/fin-project/internal/version"
@@ -34,6 +39,12 @@ func main() {
 		astCmd(os.Args[2:])
 	case "fmt":
 		fmtCmd(os.Args[2:])
+	case "run":
+		runCmd(os.Args[2:])
+	case "difftest":
+		difftestCmd(os.Args[2:])
+	case "lsp":
+		lspCmd(os.Args[2:])
 	case "version":
 		fmt.Println(version.Version)
 		os.Exit(0)
@@ -44,50 +55,41 @@ func main() {
 	}
 }
 
-// printDiagnostics renders errors with file:line:col style and grouping.
+// printDiagnostics renders errors as diagnostics with source snippets. The
+// source is read back from file; if that fails the snippets are omitted.
 func printDiagnostics(w io.Writer, file string, err error) {
 	if err == nil {
 		return
 	}
-	// Flatten joined errors.
-	var errs []error
-	if j, ok := err.(interface{ Unwrap() []error }); ok {
-		errs = j.Unwrap()
-	} else {
-		errs = []error{err}
-	}
-	for _, e := range errs {
-		msg := strings.TrimSpace(e.Error())
-		prefix := colorize("error:", red)
-		switch v := e.(type) {
-		case interface{ Pos() ast.Pos }:
-			pos := v.Pos()
-			fmt.Fprintf(w, "%s %s:%d:%d %s\n", prefix, file, pos.Line, pos.Column, msg)
-		default:
-			fmt.Fprintf(w, "%s %s\n", prefix, msg)
-		}
-	}
+	src, _ := os.ReadFile(file)
+	p := diag.NewPrinter(file, string(src), !noColor)
+	p.FprintAll(w, diagnostics(err))
 }
 
-var (
-	red     = "\x1b[31m"
-	reset   = "\x1b[0m"
-	noColor = os.Getenv("NO_COLOR") != ""
-)
-
-func colorize(s, c string) string {
-	if noColor {
-		return s
+// diagnostics converts err into diagnostics, reading back the source of
+// the imported file a diagnostic is in when it does not carry it.
+func diagnostics(err error) []diag.Diagnostic {
+	ds := diag.FromError(err)
+	for i, d := range ds {
+		if d.File != "" && d.Source == "" {
+			src, _ := os.ReadFile(d.File)
+			ds[i].Source = string(src)
+		}
 	}
-	return c + s + reset
+	return ds
 }
 
+var noColor = os.Getenv("NO_COLOR") != ""
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage:\n")
-	fmt.Fprintf(os.Stderr, "  fin build <file.fin> [-o output.bat]\n")
-	fmt.Fprintf(os.Stderr, "  fin check <file.fin>\n")
+	fmt.Fprintf(os.Stderr, "  fin build [-o output.bat] [-O] [-strict] [-precision n] [-format text|json|sarif] <file.fin>\n")
+	fmt.Fprintf(os.Stderr, "  fin check [-format text|json|sarif] <file.fin>\n")
 	fmt.Fprintf(os.Stderr, "  fin ast <file.fin>\n")
 	fmt.Fprintf(os.Stderr, "  fin fmt [-w] <file.fin>\n")
+	fmt.Fprintf(os.Stderr, "  fin run [-precision n] <file.fin> [args...]\n")
+	fmt.Fprintf(os.Stderr, "  fin difftest [-precision n] <file.fin> [args...]\n")
+	fmt.Fprintf(os.Stderr, "  fin lsp\n")
 	fmt.Fprintf(os.Stderr, "  fin version\n")
 }
 
@@ -96,6 +98,10 @@ func buildCmd(args []string) {
 	flags.SetOutput(os.Stderr)
 	var outPath string
 	flags.StringVar(&outPath, "o", "", "output batch file")
+	optimize := flags.Bool("O", false, "fold constants and remove unreachable code")
+	strict := flags.Bool("strict", false, "abort the script when a command or function call fails")
+	precision := precisionFlag(flags)
+	format := formatFlag(flags)
 	if err := flags.Parse(args); err != nil {
 		os.Exit(2)
 	}
@@ -103,53 +109,103 @@ func buildCmd(args []string) {
 		fmt.Fprintln(os.Stderr, "build requires exactly one input file")
 		os.Exit(2)
 	}
+	validateFormat(*format)
+	validatePrecision(*precision)
 	inPath := flags.Arg(0)
-	if err := validateFinPath(inPath); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	out, err := compile(inPath, *optimize, generator.Options{Strict: *strict, File: inPath, Precision: *precision})
+	if err == nil {
+		if outPath == "" {
+			base := filepath.Base(inPath)
+			outPath = base[:len(base)-len(filepath.Ext(base))] + ".bat"
+		}
+		if err := atomicWriteFile(outPath, []byte(out), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
+	reportAndExit(*format, inPath, err)
+}
 
-	prog, err := loadAndAnalyze(inPath)
-	if err != nil {
-		printDiagnostics(os.Stderr, inPath, err)
-		os.Exit(1)
+func checkCmd(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.SetOutput(os.Stderr)
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		os.Exit(2)
 	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "check requires exactly one input file")
+		os.Exit(2)
+	}
+	validateFormat(*format)
+	// If generate detects unsupported nodes, surface it as an error even in check.
+	_, err := compile(flags.Arg(0), false, generator.Options{})
+	reportAndExit(*format, flags.Arg(0), err)
+}
 
-	out, err := generate(prog)
+// compile runs every compiler phase on path and returns the batch output.
+// The program is optimized before generation when optimize is set.
+func compile(path string, optimize bool, opts generator.Options) (string, error) {
+	if err := validateFinPath(path); err != nil {
+		return "", err
+	}
+	prog, err := loadAndAnalyze(path)
 	if err != nil {
-		printDiagnostics(os.Stderr, inPath, err)
-		os.Exit(1)
+		return "", err
 	}
-
-	if outPath == "" {
-		base := filepath.Base(inPath)
-		outPath = base[:len(base)-len(filepath.Ext(base))] + ".bat"
+	if optimize {
+		prog = opt.Optimize(prog)
 	}
-	if err := atomicWriteFile(outPath, []byte(out), 0644); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	return generate(prog, opts)
+}
+
+// formatFlag registers the -format flag shared by build and check.
+func formatFlag(flags *flag.FlagSet) *string {
+	return flags.String("format", diag.FormatText, "diagnostics format: text, json or sarif")
+}
+
+// precisionFlag registers the -precision flag shared by build, run and
+// difftest.
+func precisionFlag(flags *flag.FlagSet) *int {
+	return flags.Int("precision", sema.DefaultPrecision, "digits decimals keep after the point")
+}
+
+// validatePrecision exits with a usage error for a -precision value out of
+// range.
+func validatePrecision(precision int) {
+	if precision < 1 || precision > sema.MaxPrecision {
+		fmt.Fprintf(os.Stderr, "precision %d out of range: want 1 to %d\n", precision, sema.MaxPrecision)
+		os.Exit(2)
 	}
-	os.Exit(0)
 }
 
-func checkCmd(args []string) {
-	if len(args) != 1 {
-		fmt.Fprintln(os.Stderr, "check requires exactly one input file")
+// validateFormat exits with a usage error for an unknown -format value.
+func validateFormat(format string) {
+	switch format {
+	case diag.FormatText, diag.FormatJSON, diag.FormatSARIF:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown diagnostics format %q: want text, json or sarif\n", format)
 		os.Exit(2)
 	}
-	if err := validateFinPath(args[0]); err != nil {
-		printDiagnostics(os.Stderr, args[0], err)
-		os.Exit(1)
+}
+
+// reportAndExit writes the diagnostics for err and exits with 1 if there are
+// any. Text goes to stderr and only when there is something to report; json
+// and sarif go to stdout and are always written.
+func reportAndExit(format, file string, err error) {
+	switch format {
+	case diag.FormatText:
+		if err != nil {
+			printDiagnostics(os.Stderr, file, err)
+		}
+	default:
+		src, _ := os.ReadFile(file)
+		if werr := diag.Write(os.Stdout, format, file, string(src), diagnostics(err), false); werr != nil {
+			fmt.Fprintln(os.Stderr, werr)
+			os.Exit(1)
+		}
 	}
-	prog, err := loadAndAnalyze(args[0])
 	if err != nil {
-		printDiagnostics(os.Stderr, args[0], err)
-		os.Exit(1)
-	}
-
-	// If generate detects unsupported nodes, surface it as an error even in check.
-	if _, err := generate(prog); err != nil {
-		printDiagnostics(os.Stderr, args[0], err)
 		os.Exit(1)
 	}
 	os.Exit(0)
@@ -189,7 +245,13 @@ func fmtCmd(args []string) {
 		printDiagnostics(os.Stderr, path, err)
 		os.Exit(1)
 	}
-	prog, err := loadAndAnalyze(path)
+	// The linked program holds the imported functions too, so the file is
+	// checked with its imports and formatted on its own.
+	if _, err := loadAndAnalyze(path); err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		os.Exit(1)
+	}
+	prog, err := parseFile(path)
 	if err != nil {
 		printDiagnostics(os.Stderr, path, err)
 		os.Exit(1)
@@ -211,30 +273,123 @@ func fmtCmd(args []string) {
 	os.Exit(0)
 }
 
+// runCmd evaluates a script directly with the tree-walking interpreter, so Fin
+// can be exercised on any OS without going through cmd.exe.
+func runCmd(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.SetOutput(os.Stderr)
+	precision := precisionFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "run requires an input file")
+		os.Exit(2)
+	}
+	validatePrecision(*precision)
+	args = flags.Args()
+	path := args[0]
+	if err := validateFinPath(path); err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		os.Exit(1)
+	}
+	prog, err := loadAndAnalyze(path)
+	if err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		os.Exit(1)
+	}
+	in := interp.New(interp.Options{Args: args[1:], Precision: *precision})
+	if err := in.Run(prog); err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		// A strict script ends with the code of the failing command, as the
+		// generated batch file does.
+		var failed *interp.CommandFailedError
+		if errors.As(err, &failed) {
+			os.Exit(failed.Code)
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// difftestCmd runs a script both through the interpreter and as generated
+// batch under the emulator, and reports the first statement where they differ.
+func difftestCmd(args []string) {
+	flags := flag.NewFlagSet("difftest", flag.ExitOnError)
+	flags.SetOutput(os.Stderr)
+	precision := precisionFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if flags.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "difftest requires an input file")
+		os.Exit(2)
+	}
+	validatePrecision(*precision)
+	args = flags.Args()
+	path := args[0]
+	if err := validateFinPath(path); err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		os.Exit(1)
+	}
+	prog, err := loadAndAnalyze(path)
+	if err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		os.Exit(1)
+	}
+	res, err := difftest.Check(prog, difftest.Options{Args: args[1:], Exists: hostExists, Precision: *precision})
+	if err != nil {
+		printDiagnostics(os.Stderr, path, err)
+		os.Exit(1)
+	}
+	if d := res.Divergence; d != nil {
+		printDiagnostics(os.Stderr, path, d)
+		os.Exit(1)
+	}
+	fmt.Printf("ok: %d statements agree\n", res.Steps)
+	os.Exit(0)
+}
+
+// lspCmd serves the Language Server Protocol over stdin and stdout.
+func lspCmd(args []string) {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "lsp takes no arguments")
+		os.Exit(2)
+	}
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func hostExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadAndAnalyze loads the program at path together with the files it
+// imports, and returns it linked into one program.
 func loadAndAnalyze(path string) (*ast.Program, error) {
+	return module.Load(path)
+}
+
+// parseFile parses the file at path on its own, without its imports.
+func parseFile(path string) (*ast.Program, error) {
 	src, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-
-	l := lexer.New(string(src))
-	toks := parser.CollectTokens(l)
-	p := parser.New(toks)
+	p := parser.New(parser.CollectTokens(lexer.New(string(src))))
 	prog := p.ParseProgram()
 	if perrs := p.Errors(); len(perrs) > 0 {
-		return nil, multiError("parse errors", perrs)
-	}
-
-	a := sema.New()
-	if err := a.Analyze(prog); err != nil {
-		return nil, err
+		return nil, errors.Join(perrs...)
 	}
-
 	return prog, nil
 }
 
-func generate(prog *ast.Program) (string, error) {
-	g := generator.NewBatchGenerator()
+func generate(prog *ast.Program, opts generator.Options) (string, error) {
+	g := generator.NewBatchGeneratorWithOptions(opts)
 	return g.Generate(prog)
 }
 
@@ -254,13 +409,6 @@ func printError(w io.Writer, err error) {
 	fmt.Fprintln(w, err)
 }
 
-func multiError(prefix string, errs []error) error {
-	if len(errs) == 1 {
-		return errs[0]
-	}
-	return fmt.Errorf("%s: %w", prefix, errors.Join(errs...))
-}
-
 func validateFinPath(path string) error {
 	if filepath.Ext(path) != ".fin" {
 		return fmt.Errorf("input must have .fin extension: %s", path)
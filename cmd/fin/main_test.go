@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -28,6 +30,80 @@ func TestCLI_Build_Valid(t *testing.T) {
 	}
 }
 
+func TestCLI_Build_Strict(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "deploy.fin")
+	if err := os.WriteFile(finPath, []byte("run \"git pull\"\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.bat")
+	cmd := exec.Command("go", "run", "./cmd/fin", "build", "--strict", "-o", outPath, finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("build strict failed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	bat, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !strings.Contains(string(bat), "deploy.fin:1: command failed with exit code !status!") {
+		t.Fatalf("expected strict check naming the source, got:\n%s", bat)
+	}
+}
+
+func TestCLI_Build_StrictImported(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "st.fin")
+	if err := os.WriteFile(finPath, []byte("strict\nimport \"lib/boom.fin\"\nboom\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tmp, "lib"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	lib := "fn boom\n    run \"exit 3\"\nend\n"
+	if err := os.WriteFile(filepath.Join(tmp, "lib", "boom.fin"), []byte(lib), 0644); err != nil {
+		t.Fatalf("write lib: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.bat")
+	cmd := exec.Command("go", "run", "./cmd/fin", "build", "-o", outPath, finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("build failed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	bat, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !strings.Contains(string(bat), "boom.fin:2: command failed with exit code !status!") {
+		t.Fatalf("expected strict check naming the imported file, got:\n%s", bat)
+	}
+}
+
+func TestCLI_Build_Optimized(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "opt.fin")
+	src := "set x 2 ** 10\nif false\n    echo \"never\"\nend\necho \"x is $x\"\n"
+	if err := os.WriteFile(finPath, []byte(src), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.bat")
+	cmd := exec.Command("go", "run", "./cmd/fin", "build", "-O", "-o", outPath, finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("build -O failed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	bat, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if !strings.Contains(string(bat), "set x=1024\necho x is 1024\n") || strings.Contains(string(bat), "never") {
+		t.Fatalf("expected folded and pruned code, got:\n%s", bat)
+	}
+}
+
 func TestCLI_Fmt(t *testing.T) {
 	tmp := t.TempDir()
 	finPath := filepath.Join(tmp, "fmt.fin")
@@ -153,6 +229,124 @@ func TestCLI_Check_Invalid(t *testing.T) {
 	}
 }
 
+func TestCLI_Check_ParseErrorPositions(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "syntax.fin")
+	if err := os.WriteFile(finPath, []byte("set\nfor i 1..2\nend\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "check", finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output: %s", code, output)
+	}
+	for _, want := range []string{"syntax.fin:1:4\n", "syntax.fin:2:7\n"} {
+		if !strings.Contains(string(output), want) {
+			t.Fatalf("expected %q in output, got: %s", want, output)
+		}
+	}
+}
+
+func TestCLI_Check_JSON(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "invalid.fin")
+	if err := os.WriteFile(finPath, []byte("echo 1\nreturn 1\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "check", "-format", "json", finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.Output()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output: %s", code, output)
+	}
+	var ds []struct {
+		File   string `json:"file"`
+		Line   int    `json:"line"`
+		Column int    `json:"column"`
+		Code   string `json:"code"`
+	}
+	if err := json.Unmarshal(output, &ds); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, output)
+	}
+	if len(ds) != 1 || ds[0].File != finPath || ds[0].Line != 2 || ds[0].Column != 1 || ds[0].Code != "E0008" {
+		t.Fatalf("unexpected diagnostics: %+v", ds)
+	}
+
+	okPath := filepath.Join(tmp, "valid.fin")
+	if err := os.WriteFile(okPath, []byte("echo 1\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd = exec.Command("go", "run", "./cmd/fin", "check", "-format=json", okPath)
+	cmd.Dir = projectRoot(t)
+	output, err = cmd.Output()
+	if err != nil || strings.TrimSpace(string(output)) != "[]" {
+		t.Fatalf("expected [] for a clean file, got err=%v output: %s", err, output)
+	}
+
+	cmd = exec.Command("go", "run", "./cmd/fin", "check", "-format=xml", okPath)
+	cmd.Dir = projectRoot(t)
+	output, err = cmd.CombinedOutput()
+	if !strings.Contains(string(output), "unknown diagnostics format") {
+		t.Fatalf("expected unknown format error, got err=%v output: %s", err, output)
+	}
+}
+
+func TestCLI_Build_SARIF(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "invalid.fin")
+	if err := os.WriteFile(finPath, []byte("echo $missing\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	outPath := filepath.Join(tmp, "out.bat")
+	cmd := exec.Command("go", "run", "./cmd/fin", "build", "-format", "sarif", "-o", outPath, finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.Output()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output: %s", code, output)
+	}
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID string `json:"ruleId"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(output, &log); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, output)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 || log.Runs[0].Results[0].RuleID != "E0002" {
+		t.Fatalf("unexpected SARIF log: %s", output)
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Fatalf("build with errors should not write %s", outPath)
+	}
+}
+
+func TestCLI_LSP(t *testing.T) {
+	var in strings.Builder
+	for _, m := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "lsp")
+	cmd.Dir = projectRoot(t)
+	cmd.Stdin = strings.NewReader(in.String())
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("lsp failed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	for _, want := range []string{`"definitionProvider":true`, `{"jsonrpc":"2.0","id":2,"result":null}`} {
+		if !strings.Contains(string(output), want) {
+			t.Fatalf("expected %s in output, got: %s", want, output)
+		}
+	}
+}
+
 func TestCLI_AST_Valid(t *testing.T) {
 	tmp := t.TempDir()
 	finPath := filepath.Join(tmp, "valid.fin")
@@ -170,6 +364,157 @@ func TestCLI_AST_Valid(t *testing.T) {
 	}
 }
 
+func TestCLI_Run(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "run.fin")
+	src := strings.Join([]string{
+		"fn add a b",
+		"    return $a + $b",
+		"end",
+		"echo \"sum: $(add 2 3)\"",
+		"",
+	}, "\n")
+	if err := os.WriteFile(finPath, []byte(src), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "run", finPath, "extra")
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("expected run to succeed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	if string(output) != "sum: 5\n" {
+		t.Fatalf("unexpected run output: %q", output)
+	}
+}
+
+func TestCLI_Run_Precision(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "third.fin")
+	if err := os.WriteFile(finPath, []byte("echo 1 / 3.0\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "run", "-precision", "4", finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("expected run to succeed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	if string(output) != "0.3333\n" {
+		t.Fatalf("unexpected run output: %q", output)
+	}
+
+	cmd = exec.Command("go", "run", "./cmd/fin", "build", "-precision", "9", finPath)
+	cmd.Dir = projectRoot(t)
+	output, err = cmd.CombinedOutput()
+	if exitCode(err) == 0 || !strings.Contains(string(output), "precision 9 out of range: want 1 to 4") {
+		t.Fatalf("expected a usage error, got: %s", output)
+	}
+}
+
+func TestCLI_Run_RuntimeError(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "div.fin")
+	if err := os.WriteFile(finPath, []byte("set z 0\nset x 1 / $z\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "run", finPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output: %s", code, output)
+	}
+	if !strings.Contains(string(output), "div.fin:2:") || !strings.Contains(string(output), "division by zero") {
+		t.Fatalf("expected positioned runtime error, got: %s", output)
+	}
+}
+
+func TestCLI_Run_RuntimeErrorInImport(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "main.fin")
+	if err := os.WriteFile(finPath, []byte("import \"lib/boom.fin\"\nset z 0.0\necho (div $z)\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tmp, "lib"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	lib := "fn div x\n    return 1.0 / $x\nend\n"
+	if err := os.WriteFile(filepath.Join(tmp, "lib", "boom.fin"), []byte(lib), 0644); err != nil {
+		t.Fatalf("write lib: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "run", finPath)
+	cmd.Dir = projectRoot(t)
+	cmd.Env = append(os.Environ(), "NO_COLOR=1")
+	output, err := cmd.CombinedOutput()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output: %s", code, output)
+	}
+	if !strings.Contains(string(output), "boom.fin:2:16") || !strings.Contains(string(output), "return 1.0 / $x") {
+		t.Fatalf("expected the error in the imported file, got: %s", output)
+	}
+}
+
+func TestCLI_Run_StrictExitCode(t *testing.T) {
+	tmp := t.TempDir()
+	finPath := filepath.Join(tmp, "strict.fin")
+	src := "strict\nunchecked\n    run \"exit 2\"\nend\necho \"after $status\"\nrun \"exit 3\"\necho \"not reached\"\n"
+	if err := os.WriteFile(finPath, []byte(src), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	// go run exits with 1 whenever the program fails, so build a binary to
+	// see the real exit code.
+	bin := filepath.Join(tmp, "fin")
+	build := exec.Command("go", "build", "-o", bin, "./cmd/fin")
+	build.Dir = projectRoot(t)
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build fin: %v\noutput: %s", err, output)
+	}
+	cmd := exec.Command(bin, "run", finPath)
+	output, err := cmd.Output()
+	if code := exitCode(err); code != 3 {
+		t.Fatalf("expected exit code 3, got %d; output: %s", code, output)
+	}
+	if string(output) != "after 2\n" {
+		t.Fatalf("unexpected run output: %q", output)
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && !strings.Contains(string(ee.Stderr), "strict.fin:6:") {
+		t.Fatalf("expected positioned failure, got: %s", ee.Stderr)
+	}
+}
+
+func TestCLI_Difftest(t *testing.T) {
+	tmp := t.TempDir()
+	okPath := filepath.Join(tmp, "ok.fin")
+	if err := os.WriteFile(okPath, []byte("set x 2\nx = $x * 3\necho \"x=$x\"\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd := exec.Command("go", "run", "./cmd/fin", "difftest", okPath)
+	cmd.Dir = projectRoot(t)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("expected difftest to succeed (code=%d): %v\noutput: %s", exitCode(err), err, output)
+	}
+	if string(output) != "ok: 3 statements agree\n" {
+		t.Fatalf("unexpected difftest output: %q", output)
+	}
+
+	badPath := filepath.Join(tmp, "case.fin")
+	if err := os.WriteFile(badPath, []byte("set n 1\nset N 2\n"), 0644); err != nil {
+		t.Fatalf("write fin: %v", err)
+	}
+	cmd = exec.Command("go", "run", "./cmd/fin", "difftest", badPath)
+	cmd.Dir = projectRoot(t)
+	cmd.Env = append(os.Environ(), "NO_COLOR=1")
+	output, err = cmd.CombinedOutput()
+	if code := exitCode(err); code != 1 {
+		t.Fatalf("expected exit code 1, got %d; output: %s", code, output)
+	}
+	if !strings.Contains(string(output), "case.fin:2:1") || !strings.Contains(string(output), "variable n differs") {
+		t.Fatalf("expected positioned divergence, got: %s", output)
+	}
+}
+
 func projectRoot(t *testing.T) string {
 	_, file, _, ok := runtime.Caller(0)
 	if !ok {
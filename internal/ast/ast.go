@@ -1,9 +1,14 @@
 package ast
 
+import "strings"
+
 // Pos represents a source position.
 type Pos struct {
 	Line   int
 	Column int
+	// File is the path of the file the position is in, when it is an
+	// imported file linked into the program; empty in the main file.
+	File string
 }
 
 //
@@ -31,12 +36,74 @@ type Expr interface {
 
 type Program struct {
 	Statements []Statement
+	Trivia     map[Node]*Trivia // comments and blank lines; nil when there are none
 	P          Pos
 }
 
 func (p *Program) Pos() Pos { return p.P }
 func (*Program) node()      {}
 
+//
+// ---- Comments ----
+//
+
+// Comment is a `#` comment as written in the source, including the '#'.
+type Comment struct {
+	Text  string
+	Blank bool // one or more blank lines precede the comment
+	P     Pos
+}
+
+// Trivia is the source layout attached to a node that the AST itself does not
+// carry. Only the formatter reads it.
+type Trivia struct {
+	Blank    bool      // one or more blank lines precede the statement
+	Leading  []Comment // whole-line comments directly above the statement
+	Header   *Comment  // block statements: comment on the opening line
+	Trailing *Comment  // comment at the end of the statement's last line
+	// Footers holds the comments after the last statement of each block,
+	// in source order: the then and else blocks of an if, the body of a
+	// loop or function, or the end of the file for the Program.
+	Footers [][]Comment
+}
+
+// Substitutions returns the byte offsets [start, end) of each $(...) call
+// substitution in a string literal's value. $$ escapes a dollar sign. A
+// substitution that is never closed is not reported.
+func Substitutions(s string) [][2]int {
+	var out [][2]int
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			continue
+		}
+		if s[i+1] == '$' {
+			i++
+			continue
+		}
+		if s[i+1] != '(' {
+			continue
+		}
+		depth, quoted := 0, false
+		for j := i + 1; j < len(s); j++ {
+			switch c := s[j]; {
+			case c == '"':
+				quoted = !quoted
+			case quoted:
+			case c == '(':
+				depth++
+			case c == ')':
+				depth--
+			}
+			if depth == 0 {
+				out = append(out, [2]int{i, j + 1})
+				i = j
+				break
+			}
+		}
+	}
+	return out
+}
+
 //
 // ---- Statements ----
 //
@@ -51,8 +118,12 @@ func (s *SetStmt) Pos() Pos { return s.P }
 func (*SetStmt) node()      {}
 func (*SetStmt) stmt()      {}
 
+// AssignStmt stores a new value in a variable. Op is the operator of a
+// compound assignment, such as "+=" or "++", and is empty for a plain `=`.
+// `x++` and `x--` have no Value.
 type AssignStmt struct {
 	Name  string
+	Op    string
 	Value Expr
 	P     Pos
 }
@@ -61,6 +132,21 @@ func (s *AssignStmt) Pos() Pos { return s.P }
 func (*AssignStmt) node()      {}
 func (*AssignStmt) stmt()      {}
 
+// Result returns the expression whose value the assignment stores: Value for
+// a plain `=`, `$x + 2` for `x += 2` and `$x + 1` for `x++`.
+func (s *AssignStmt) Result() Expr {
+	x := &IdentExpr{Name: s.Name, P: s.P}
+	switch s.Op {
+	case "":
+		return s.Value
+	case "++":
+		return &BinaryExpr{Left: x, Op: "+", Right: &NumberLit{Value: "1", P: s.P}, P: s.P}
+	case "--":
+		return &BinaryExpr{Left: x, Op: "-", Right: &NumberLit{Value: "1", P: s.P}, P: s.P}
+	}
+	return &BinaryExpr{Left: x, Op: strings.TrimSuffix(s.Op, "="), Right: s.Value, P: s.P}
+}
+
 type EchoStmt struct {
 	Value Expr
 	P     Pos
@@ -70,8 +156,11 @@ func (s *EchoStmt) Pos() Pos { return s.P }
 func (*EchoStmt) node()      {}
 func (*EchoStmt) stmt()      {}
 
+// RunStmt runs a command line. Args are appended to Command, each quoted
+// for cmd.exe.
 type RunStmt struct {
 	Command Expr
+	Args    []Expr
 	P       Pos
 }
 
@@ -101,16 +190,45 @@ func (*FnDecl) node()      {}
 func (*FnDecl) stmt()      {}
 
 type IfStmt struct {
-	Cond Expr
-	Then []Statement
-	Else []Statement
-	P    Pos
+	Cond    Expr
+	Then    []Statement
+	ElseIfs []ElseIf // `else if` branches, tested in order when Cond is false
+	Else    []Statement
+	P       Pos
 }
 
 func (s *IfStmt) Pos() Pos { return s.P }
 func (*IfStmt) node()      {}
 func (*IfStmt) stmt()      {}
 
+// ElseIf is one `else if COND` branch of an IfStmt.
+type ElseIf struct {
+	Cond Expr
+	Body []Statement
+	P    Pos
+}
+
+// MatchStmt runs the first case with a value equal to Subject, or the else
+// arm, Default, when no case matches.
+type MatchStmt struct {
+	Subject Expr
+	Cases   []MatchCase
+	Default []Statement
+	P       Pos
+}
+
+func (s *MatchStmt) Pos() Pos { return s.P }
+func (*MatchStmt) node()      {}
+func (*MatchStmt) stmt()      {}
+
+// MatchCase is one `case VALUE, ...` arm. Values are string or number
+// literals.
+type MatchCase struct {
+	Values []Expr
+	Body   []Statement
+	P      Pos
+}
+
 type ForStmt struct {
 	Var   string
 	Start Expr
@@ -123,6 +241,20 @@ func (s *ForStmt) Pos() Pos { return s.P }
 func (*ForStmt) node()      {}
 func (*ForStmt) stmt()      {}
 
+// ForEachStmt iterates over the elements of a list, `for item in $xs`, or the
+// entries of a map, `for key, value in $m`.
+type ForEachStmt struct {
+	Key      string // map key variable; empty for a list
+	Var      string // list element or map value
+	Iterable Expr   // an IdentExpr or PropertyExpr naming the list or map
+	Body     []Statement
+	P        Pos
+}
+
+func (s *ForEachStmt) Pos() Pos { return s.P }
+func (*ForEachStmt) node()      {}
+func (*ForEachStmt) stmt()      {}
+
 type WhileStmt struct {
 	Cond Expr
 	Body []Statement
@@ -158,6 +290,117 @@ func (s *ContinueStmt) Pos() Pos { return s.P }
 func (*ContinueStmt) node()      {}
 func (*ContinueStmt) stmt()      {}
 
+// StrictStmt is the `strict` directive on the first line of a file. It makes
+// a failing run or function call abort the script.
+type StrictStmt struct {
+	P Pos
+}
+
+func (s *StrictStmt) Pos() Pos { return s.P }
+func (*StrictStmt) node()      {}
+func (*StrictStmt) stmt()      {}
+
+// ImportStmt is `import "path" [as alias]` at the top level of a file. It
+// makes the functions declared in the file at path, relative to the
+// importing file, callable by name, or as alias.name when aliased.
+type ImportStmt struct {
+	Path  string
+	Alias string // empty when not aliased
+	P     Pos
+}
+
+func (s *ImportStmt) Pos() Pos { return s.P }
+func (*ImportStmt) node()      {}
+func (*ImportStmt) stmt()      {}
+
+// ParamsStmt is the `params ... end` block at the top level of a script. It
+// declares the command-line arguments the script takes and, where it runs,
+// sets each parameter as a variable from them.
+type ParamsStmt struct {
+	Params []Param
+	P      Pos
+}
+
+func (s *ParamsStmt) Pos() Pos { return s.P }
+func (*ParamsStmt) node()      {}
+func (*ParamsStmt) stmt()      {}
+
+// Param is one `param NAME [default VALUE]` or `flag NAME` line of a params
+// block. A param without a default is required. A flag is true when given
+// and false otherwise. Defaults are string or number literals.
+type Param struct {
+	Name    string
+	Default Expr // nil for a required param and for a flag
+	Flag    bool
+	P       Pos
+}
+
+// Option returns the long option that sets p, such as --env.
+func (p Param) Option() string {
+	if p.Flag {
+		return "--" + p.Name
+	}
+	return "--" + p.Name + " VALUE"
+}
+
+// Usage returns the lines of the usage text --help prints: one for each
+// parameter, with its default or whether it is required, then one for
+// --help itself.
+func (s *ParamsStmt) Usage() []string {
+	width := len("--help")
+	for _, p := range s.Params {
+		width = max(width, len(p.Option()))
+	}
+	line := func(option, desc string) string {
+		if desc == "" {
+			return "  " + option
+		}
+		return "  " + option + strings.Repeat(" ", width-len(option)+2) + desc
+	}
+	lines := []string{"Options:"}
+	for _, p := range s.Params {
+		desc := ""
+		switch d := p.Default.(type) {
+		case *StringLit:
+			desc = "default: " + d.Value
+		case *NumberLit:
+			desc = "default: " + d.Value
+		case nil:
+			if !p.Flag {
+				desc = "required"
+			}
+		}
+		lines = append(lines, line(p.Option(), desc))
+	}
+	return append(lines, line("--help", "show this help"))
+}
+
+// UncheckedStmt is an `unchecked ... end` block whose commands may fail
+// without aborting a strict script.
+type UncheckedStmt struct {
+	Body []Statement
+	P    Pos
+}
+
+func (s *UncheckedStmt) Pos() Pos { return s.P }
+func (*UncheckedStmt) node()      {}
+func (*UncheckedStmt) stmt()      {}
+
+// TryStmt runs Body and, when a command in it fails, Catch with the exit code
+// and command line in the map named Err. Finally runs last however the other
+// blocks are left.
+type TryStmt struct {
+	Body    []Statement
+	Err     string // catch variable; empty when there is no catch block
+	Catch   []Statement
+	Finally []Statement
+	P       Pos
+}
+
+func (s *TryStmt) Pos() Pos { return s.P }
+func (*TryStmt) node()      {}
+func (*TryStmt) stmt()      {}
+
 //
 // ---- Conditions ----
 //
@@ -186,7 +429,11 @@ func (*IdentExpr) expr()      {}
 
 type StringLit struct {
 	Value string
-	P     Pos
+	Calls []*CallExpr // the $(...) substitutions in Value, in order
+	// Vars holds the $name, $name.field and $name[index] references in
+	// Value, in order, as an IdentExpr, PropertyExpr or IndexExpr.
+	Vars []Expr
+	P    Pos
 }
 
 func (e *StringLit) Pos() Pos { return e.P }
@@ -270,6 +517,29 @@ func (e *UnaryExpr) Pos() Pos { return e.P }
 func (*UnaryExpr) node()      {}
 func (*UnaryExpr) expr()      {}
 
+// CallExpr is a function call used as a value: `(name args...)`, or
+// `$(name args...)` inside a string.
+type CallExpr struct {
+	Name string
+	Args []Expr
+	P    Pos // position of the function name
+}
+
+func (e *CallExpr) Pos() Pos { return e.P }
+func (*CallExpr) node()      {}
+func (*CallExpr) expr()      {}
+
+// RunExpr captures the output of a command: `(run "cmd" args...)`.
+type RunExpr struct {
+	Command Expr
+	Args    []Expr
+	P       Pos // position of the run keyword
+}
+
+func (e *RunExpr) Pos() Pos { return e.P }
+func (*RunExpr) node()      {}
+func (*RunExpr) expr()      {}
+
 type BoolLit struct {
 	Value bool
 	P     Pos
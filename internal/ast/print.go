@@ -49,14 +49,23 @@ func (p *printer) printNode(n Node, level int, label string) {
 		fmt.Fprintf(p.buf, "SetStmt name=%s @%d:%d\n", node.Name, node.P.Line, node.P.Column)
 		p.printNode(node.Value, level+1, "value")
 	case *AssignStmt:
-		fmt.Fprintf(p.buf, "AssignStmt name=%s @%d:%d\n", node.Name, node.P.Line, node.P.Column)
-		p.printNode(node.Value, level+1, "value")
+		if node.Op != "" {
+			fmt.Fprintf(p.buf, "AssignStmt name=%s op=%s @%d:%d\n", node.Name, node.Op, node.P.Line, node.P.Column)
+		} else {
+			fmt.Fprintf(p.buf, "AssignStmt name=%s @%d:%d\n", node.Name, node.P.Line, node.P.Column)
+		}
+		if node.Value != nil {
+			p.printNode(node.Value, level+1, "value")
+		}
 	case *EchoStmt:
 		fmt.Fprintf(p.buf, "EchoStmt @%d:%d\n", node.P.Line, node.P.Column)
 		p.printNode(node.Value, level+1, "value")
 	case *RunStmt:
 		fmt.Fprintf(p.buf, "RunStmt @%d:%d\n", node.P.Line, node.P.Column)
 		p.printNode(node.Command, level+1, "command")
+		for i, arg := range node.Args {
+			p.printNode(arg, level+1, fmt.Sprintf("arg[%d]", i))
+		}
 	case *CallStmt:
 		fmt.Fprintf(p.buf, "CallStmt name=%s @%d:%d\n", node.Name, node.P.Line, node.P.Column)
 		for i, arg := range node.Args {
@@ -75,6 +84,14 @@ func (p *printer) printNode(n Node, level int, label string) {
 		for _, s := range node.Then {
 			p.printNode(s, level+2, "")
 		}
+		for _, b := range node.ElseIfs {
+			p.indent(level + 1)
+			fmt.Fprintf(p.buf, "else if @%d:%d:\n", b.P.Line, b.P.Column)
+			p.printNode(b.Cond, level+2, "cond")
+			for _, s := range b.Body {
+				p.printNode(s, level+2, "")
+			}
+		}
 		if len(node.Else) > 0 {
 			p.indent(level + 1)
 			p.buf.WriteString("else:\n")
@@ -82,6 +99,26 @@ func (p *printer) printNode(n Node, level int, label string) {
 				p.printNode(s, level+2, "")
 			}
 		}
+	case *MatchStmt:
+		fmt.Fprintf(p.buf, "MatchStmt @%d:%d\n", node.P.Line, node.P.Column)
+		p.printNode(node.Subject, level+1, "subject")
+		for _, c := range node.Cases {
+			p.indent(level + 1)
+			fmt.Fprintf(p.buf, "case @%d:%d:\n", c.P.Line, c.P.Column)
+			for i, v := range c.Values {
+				p.printNode(v, level+2, fmt.Sprintf("value[%d]", i))
+			}
+			for _, s := range c.Body {
+				p.printNode(s, level+2, "")
+			}
+		}
+		if len(node.Default) > 0 {
+			p.indent(level + 1)
+			p.buf.WriteString("else:\n")
+			for _, s := range node.Default {
+				p.printNode(s, level+2, "")
+			}
+		}
 	case *ForStmt:
 		fmt.Fprintf(p.buf, "ForStmt var=%s @%d:%d\n", node.Var, node.P.Line, node.P.Column)
 		p.printNode(node.Start, level+1, "start")
@@ -89,6 +126,16 @@ func (p *printer) printNode(n Node, level int, label string) {
 		for _, s := range node.Body {
 			p.printNode(s, level+1, "body")
 		}
+	case *ForEachStmt:
+		if node.Key != "" {
+			fmt.Fprintf(p.buf, "ForEachStmt key=%s var=%s @%d:%d\n", node.Key, node.Var, node.P.Line, node.P.Column)
+		} else {
+			fmt.Fprintf(p.buf, "ForEachStmt var=%s @%d:%d\n", node.Var, node.P.Line, node.P.Column)
+		}
+		p.printNode(node.Iterable, level+1, "in")
+		for _, s := range node.Body {
+			p.printNode(s, level+1, "body")
+		}
 	case *WhileStmt:
 		fmt.Fprintf(p.buf, "WhileStmt @%d:%d\n", node.P.Line, node.P.Column)
 		p.printNode(node.Cond, level+1, "cond")
@@ -102,6 +149,47 @@ func (p *printer) printNode(n Node, level int, label string) {
 		fmt.Fprintf(p.buf, "BreakStmt @%d:%d\n", node.P.Line, node.P.Column)
 	case *ContinueStmt:
 		fmt.Fprintf(p.buf, "ContinueStmt @%d:%d\n", node.P.Line, node.P.Column)
+	case *StrictStmt:
+		fmt.Fprintf(p.buf, "StrictStmt @%d:%d\n", node.P.Line, node.P.Column)
+	case *ImportStmt:
+		if node.Alias != "" {
+			fmt.Fprintf(p.buf, "ImportStmt path=%q as=%s @%d:%d\n", node.Path, node.Alias, node.P.Line, node.P.Column)
+		} else {
+			fmt.Fprintf(p.buf, "ImportStmt path=%q @%d:%d\n", node.Path, node.P.Line, node.P.Column)
+		}
+	case *ParamsStmt:
+		fmt.Fprintf(p.buf, "ParamsStmt @%d:%d\n", node.P.Line, node.P.Column)
+		for _, param := range node.Params {
+			p.indent(level + 1)
+			if param.Flag {
+				fmt.Fprintf(p.buf, "flag %s @%d:%d\n", param.Name, param.P.Line, param.P.Column)
+				continue
+			}
+			fmt.Fprintf(p.buf, "param %s @%d:%d\n", param.Name, param.P.Line, param.P.Column)
+			if param.Default != nil {
+				p.printNode(param.Default, level+2, "default")
+			}
+		}
+	case *TryStmt:
+		if node.Err != "" {
+			fmt.Fprintf(p.buf, "TryStmt err=%s @%d:%d\n", node.Err, node.P.Line, node.P.Column)
+		} else {
+			fmt.Fprintf(p.buf, "TryStmt @%d:%d\n", node.P.Line, node.P.Column)
+		}
+		for _, s := range node.Body {
+			p.printNode(s, level+1, "body")
+		}
+		for _, s := range node.Catch {
+			p.printNode(s, level+1, "catch")
+		}
+		for _, s := range node.Finally {
+			p.printNode(s, level+1, "finally")
+		}
+	case *UncheckedStmt:
+		fmt.Fprintf(p.buf, "UncheckedStmt @%d:%d\n", node.P.Line, node.P.Column)
+		for _, s := range node.Body {
+			p.printNode(s, level+1, "body")
+		}
 	case *ExistsCond:
 		fmt.Fprintf(p.buf, "ExistsCond @%d:%d\n", node.P.Line, node.P.Column)
 		p.printNode(node.Path, level+1, "path")
@@ -109,6 +197,23 @@ func (p *printer) printNode(n Node, level int, label string) {
 		fmt.Fprintf(p.buf, "IdentExpr %s @%d:%d\n", node.Name, node.P.Line, node.P.Column)
 	case *StringLit:
 		fmt.Fprintf(p.buf, "StringLit %q @%d:%d\n", node.Value, node.P.Line, node.P.Column)
+		for i, call := range node.Calls {
+			p.printNode(call, level+1, fmt.Sprintf("call[%d]", i))
+		}
+		for i, v := range node.Vars {
+			p.printNode(v, level+1, fmt.Sprintf("var[%d]", i))
+		}
+	case *CallExpr:
+		fmt.Fprintf(p.buf, "CallExpr name=%s @%d:%d\n", node.Name, node.P.Line, node.P.Column)
+		for i, arg := range node.Args {
+			p.printNode(arg, level+1, fmt.Sprintf("arg[%d]", i))
+		}
+	case *RunExpr:
+		fmt.Fprintf(p.buf, "RunExpr @%d:%d\n", node.P.Line, node.P.Column)
+		p.printNode(node.Command, level+1, "command")
+		for i, arg := range node.Args {
+			p.printNode(arg, level+1, fmt.Sprintf("arg[%d]", i))
+		}
 	case *NumberLit:
 		fmt.Fprintf(p.buf, "NumberLit %s @%d:%d\n", node.Value, node.P.Line, node.P.Column)
 	case *BoolLit:
@@ -0,0 +1,311 @@
+package batchemu
+
+import (
+	"strconv"
+	"strings"
+)
+
+// arithError is a `set /a` failure: the message cmd.exe prints and the
+// ERRORLEVEL it sets.
+type arithError struct {
+	msg   string
+	level int
+}
+
+var (
+	errMissingOperand  = &arithError{"Missing operand.", levelMissingOperand}
+	errMissingOperator = &arithError{"Missing operator.", levelMissingOperator}
+	errUnbalanced      = &arithError{"Unbalanced parentheses.", levelUnbalanced}
+	errInvalidNumber   = &arithError{"Invalid number.  Numeric constants are either decimal (17),\nhexadecimal (0x11), or octal (021).", levelInvalidNumber}
+	errNumberTooLarge  = &arithError{"Invalid number.  Numbers are limited to 32-bits of precision.", levelInvalidNumber}
+	errDivideByZero    = &arithError{"Divide by zero error.", levelDivideByZero}
+)
+
+type arithKind int
+
+const (
+	aNum arithKind = iota
+	aIdent
+	aOp
+	aEOF
+)
+
+type arithTok struct {
+	kind arithKind
+	text string
+	num  int32
+}
+
+// arithOps lists operators longest first so the tokenizer matches greedily.
+var arithOps = []string{
+	"<<=", ">>=",
+	"<<", ">>", "*=", "/=", "%=", "+=", "-=", "&=", "^=", "|=",
+	"(", ")", "!", "~", "*", "/", "%", "+", "-", "&", "^", "|", "=", ",",
+}
+
+// binaryLevels gives cmd.exe's binary operator precedence, loosest first.
+// Assignment and the comma operator are handled separately.
+var binaryLevels = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type arith struct {
+	toks []arithTok
+	pos  int
+	env  *env
+}
+
+// evalArith evaluates a `set /a` expression with 32-bit wrapping integers,
+// assigning variables in env as it goes. Undefined or non-numeric variables
+// read as zero.
+func evalArith(expr string, e *env) (int32, *arithError) {
+	toks, err := tokenizeArith(expr)
+	if err != nil {
+		return 0, err
+	}
+	a := &arith{toks: toks, env: e}
+	v, err := a.comma()
+	if err != nil {
+		return 0, err
+	}
+	switch tok := a.peek(); {
+	case tok.kind == aEOF:
+		return v, nil
+	case tok.text == ")":
+		return 0, errUnbalanced
+	default:
+		return 0, errMissingOperator
+	}
+}
+
+func tokenizeArith(s string) ([]arithTok, *arithError) {
+	var toks []arithTok
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c == ' ' || c == '\t' {
+			i++
+			continue
+		}
+		if op := matchArithOp(s[i:]); op != "" {
+			toks = append(toks, arithTok{kind: aOp, text: op})
+			i += len(op)
+			continue
+		}
+		j := i
+		for j < len(s) && s[j] != ' ' && s[j] != '\t' && matchArithOp(s[j:]) == "" {
+			j++
+		}
+		word := s[i:j]
+		i = j
+		if !isDigit(word[0]) {
+			toks = append(toks, arithTok{kind: aIdent, text: word})
+			continue
+		}
+		n, err := parseArithNumber(word)
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, arithTok{kind: aNum, text: word, num: n})
+	}
+	return append(toks, arithTok{kind: aEOF}), nil
+}
+
+func matchArithOp(s string) string {
+	for _, op := range arithOps {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// parseArithNumber parses a numeric literal. Decimal literals must fit in a
+// signed 32-bit integer; hex and octal may use all 32 bits.
+func parseArithNumber(word string) (int32, *arithError) {
+	base, digits := 10, word
+	switch {
+	case len(word) > 2 && (word[:2] == "0x" || word[:2] == "0X"):
+		base, digits = 16, word[2:]
+	case len(word) > 1 && word[0] == '0':
+		base, digits = 8, word[1:]
+	}
+	u, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return 0, errNumberTooLarge
+		}
+		return 0, errInvalidNumber
+	}
+	if u > 0xFFFFFFFF || (base == 10 && u > 0x7FFFFFFF) {
+		return 0, errNumberTooLarge
+	}
+	return int32(uint32(u)), nil
+}
+
+func (a *arith) peek() arithTok { return a.toks[a.pos] }
+
+func (a *arith) next() arithTok {
+	t := a.toks[a.pos]
+	if t.kind != aEOF {
+		a.pos++
+	}
+	return t
+}
+
+func (a *arith) comma() (int32, *arithError) {
+	v, err := a.assign()
+	for err == nil && a.peek().text == "," {
+		a.next()
+		v, err = a.assign()
+	}
+	return v, err
+}
+
+func (a *arith) assign() (int32, *arithError) {
+	if a.peek().kind == aIdent {
+		op := a.toks[a.pos+1]
+		if op.kind == aOp && strings.HasSuffix(op.text, "=") {
+			name := a.next().text
+			a.next()
+			rhs, err := a.assign()
+			if err != nil {
+				return 0, err
+			}
+			v := rhs
+			if op.text != "=" {
+				v, err = applyBinary(strings.TrimSuffix(op.text, "="), a.variable(name), rhs)
+				if err != nil {
+					return 0, err
+				}
+			}
+			a.env.set(name, itoa(v))
+			return v, nil
+		}
+	}
+	return a.binary(0)
+}
+
+func (a *arith) binary(level int) (int32, *arithError) {
+	if level == len(binaryLevels) {
+		return a.unary()
+	}
+	left, err := a.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		tok := a.peek()
+		if tok.kind != aOp || !contains(binaryLevels[level], tok.text) {
+			return left, nil
+		}
+		a.next()
+		right, err := a.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		if left, err = applyBinary(tok.text, left, right); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (a *arith) unary() (int32, *arithError) {
+	tok := a.peek()
+	if tok.kind == aOp {
+		switch tok.text {
+		case "!", "~", "-", "+":
+			a.next()
+			v, err := a.unary()
+			if err != nil {
+				return 0, err
+			}
+			switch tok.text {
+			case "!":
+				if v == 0 {
+					return 1, nil
+				}
+				return 0, nil
+			case "~":
+				return ^v, nil
+			case "-":
+				return -v, nil
+			}
+			return v, nil
+		}
+	}
+	return a.primary()
+}
+
+func (a *arith) primary() (int32, *arithError) {
+	tok := a.next()
+	switch tok.kind {
+	case aNum:
+		return tok.num, nil
+	case aIdent:
+		return a.variable(tok.text), nil
+	case aOp:
+		if tok.text != "(" {
+			return 0, errMissingOperand
+		}
+		v, err := a.comma()
+		if err != nil {
+			return 0, err
+		}
+		if a.next().text != ")" {
+			return 0, errUnbalanced
+		}
+		return v, nil
+	}
+	return 0, errMissingOperand
+}
+
+// variable reads a variable as a number; anything unparsable is zero.
+func (a *arith) variable(name string) int32 {
+	v, _ := a.env.get(name)
+	n, _ := parseNumber(strings.TrimSpace(v))
+	return n
+}
+
+func applyBinary(op string, l, r int32) (int32, *arithError) {
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, errDivideByZero
+		}
+		if op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	case "<<":
+		return l << (uint32(r) & 31), nil
+	case ">>":
+		return l >> (uint32(r) & 31), nil
+	case "&":
+		return l & r, nil
+	case "^":
+		return l ^ r, nil
+	case "|":
+		return l | r, nil
+	}
+	return 0, errMissingOperator
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+// Package batchemu executes batch scripts with cmd.exe semantics so generated
+// output can be checked in tests on any platform.
+//
+// Only the subset of cmd.exe the Fin generator relies on is modelled: percent
+// and delayed (!var!) expansion with the substring and substitution
+// modifiers, carets and quoting, &, &&, || and blocks,
+// `if` in all its forms, echo, set and set /a, goto, call, setlocal/endlocal
+// and exit, `for /f` over the output of a command, and redirecting a command's
+// output to stderr with >&2. Other redirections, pipes and other forms of
+// `for` are rejected with an *Error rather than approximated. Command echoing
+// is not emulated; scripts are expected to start with `@echo off`.
+package batchemu
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultMaxSteps bounds the number of commands a script may execute, so an
+// accidental infinite loop fails a test instead of hanging it.
+const DefaultMaxSteps = 1_000_000
+
+// maxCallDepth bounds nested `call :label` frames.
+const maxCallDepth = 1000
+
+// ExecFunc runs an external command line and returns its exit code, which
+// becomes ERRORLEVEL. env holds the script's environment as NAME=value pairs.
+type ExecFunc func(command string, env []string, stdout, stderr io.Writer) (int, error)
+
+// Options configures a Machine. Zero values select defaults.
+type Options struct {
+	Stdout   io.Writer
+	Stderr   io.Writer
+	Args     []string // script arguments, visible as %1..%9 and %*
+	Env      map[string]string
+	Exec     ExecFunc // nil reports every external command as not recognized
+	Exists   func(path string) bool
+	MaxSteps int
+	// Trace, if set, is called with the 1-based script line of each simple
+	// command after it completes. For `call :label` that is after the called
+	// frame returns.
+	Trace func(line int)
+}
+
+// Machine holds the state of one script execution.
+type Machine struct {
+	opts   Options
+	lines  []string
+	labels map[string][]int
+
+	env        *env
+	delayed    bool
+	echo       bool
+	errorLevel int
+	failed     bool // outcome of the last command, for && and ||
+	steps      int
+	depth      int
+}
+
+// frame is the batch context of the script itself or of a `call :label`.
+type frame struct {
+	args   []string // args[0] is the script or label name
+	shift  int      // arguments shift has moved out of %0..%9
+	pc     int      // index of the next line to read
+	locals []localState
+}
+
+// localState is what setlocal saves and endlocal restores.
+type localState struct {
+	env     *env
+	delayed bool
+}
+
+// control tells the run loop how a command left the flow of execution.
+type control int
+
+const (
+	ctlNext control = iota
+	ctlGoto         // pc was moved; abandon the rest of the line
+	ctlEOF          // return from the current frame
+	ctlExit         // terminate the whole script
+)
+
+// New prepares script for execution.
+func New(script string, opts Options) *Machine {
+	if opts.Stdout == nil {
+		opts.Stdout = io.Discard
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = io.Discard
+	}
+	if opts.Exists == nil {
+		opts.Exists = hostExists
+	}
+	if opts.MaxSteps <= 0 {
+		opts.MaxSteps = DefaultMaxSteps
+	}
+	m := &Machine{
+		opts:   opts,
+		lines:  strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n"),
+		labels: make(map[string][]int),
+		env:    newEnv(),
+		echo:   true,
+	}
+	for name, value := range opts.Env {
+		m.env.set(name, value)
+	}
+	for i, line := range m.lines {
+		if name, ok := labelName(line); ok {
+			m.labels[name] = append(m.labels[name], i)
+		}
+	}
+	return m
+}
+
+// Run executes the script from the top. A non-nil error means the script
+// could not be executed as cmd.exe would; ordinary command failures only set
+// ERRORLEVEL.
+func (m *Machine) Run() error {
+	args := append([]string{"script"}, m.opts.Args...)
+	_, err := m.runFrame(&frame{args: args})
+	return err
+}
+
+// ErrorLevel returns the current ERRORLEVEL.
+func (m *Machine) ErrorLevel() int { return m.errorLevel }
+
+// Lookup returns the value of an environment variable. Names are
+// case-insensitive.
+func (m *Machine) Lookup(name string) (string, bool) { return m.env.get(name) }
+
+// Vars returns a copy of the current environment.
+func (m *Machine) Vars() map[string]string { return m.env.snapshot() }
+
+// Depth returns the number of `call :label` frames currently running.
+func (m *Machine) Depth() int { return m.depth }
+
+// runFrame executes lines until the frame returns. Any setlocal still active
+// in the frame is ended on the way out, as cmd.exe does.
+func (m *Machine) runFrame(f *frame) (control, error) {
+	defer func() {
+		for len(f.locals) > 0 {
+			m.endlocal(f)
+		}
+	}()
+	for f.pc < len(m.lines) {
+		nodes, next, err := m.readCommand(f)
+		if err != nil {
+			return ctlExit, err
+		}
+		f.pc = next
+		for _, n := range nodes {
+			ctl, err := m.exec(n, f)
+			if err != nil {
+				return ctlExit, err
+			}
+			if ctl == ctlGoto {
+				break
+			}
+			if ctl != ctlNext {
+				return ctl, nil
+			}
+		}
+	}
+	return ctlEOF, nil
+}
+
+// readCommand reads one logical line starting at f.pc, pulling in further
+// physical lines while a parenthesised block is open. Percent expansion runs
+// on each physical line as it is read.
+func (m *Machine) readCommand(f *frame) ([]node, int, error) {
+	var chars []bchar
+	for end := f.pc; end < len(m.lines); end++ {
+		chars = append(chars, lexLine(m.expandPercents(m.lines[end], f), end+1)...)
+		nodes, err := parseCommands(chars)
+		if err == errIncomplete {
+			continue
+		}
+		return nodes, end + 1, err
+	}
+	return nil, len(m.lines), errAt(f.pc+1, "unbalanced parentheses")
+}
+
+func (m *Machine) exec(n node, f *frame) (control, error) {
+	m.steps++
+	if m.steps > m.opts.MaxSteps {
+		return ctlExit, errAt(n.line(), "step limit of %d exceeded", m.opts.MaxSteps)
+	}
+	switch c := n.(type) {
+	case *noopCmd:
+		return ctlNext, nil
+	case *blockCmd:
+		for _, sub := range c.body {
+			if ctl, err := m.exec(sub, f); err != nil || ctl != ctlNext {
+				return ctl, err
+			}
+		}
+		return ctlNext, nil
+	case *chainCmd:
+		ctl, err := m.exec(c.left, f)
+		if err != nil || ctl != ctlNext {
+			return ctl, err
+		}
+		if (c.op == "&&") == m.failed {
+			return ctlNext, nil
+		}
+		return m.exec(c.right, f)
+	case *forCmd:
+		return m.execFor(c, f)
+	case *ifCmd:
+		ok, err := m.evalIf(c)
+		if err != nil {
+			return ctlExit, err
+		}
+		if ok {
+			return m.exec(c.then, f)
+		}
+		if c.els != nil {
+			return m.exec(c.els, f)
+		}
+		return ctlNext, nil
+	case *simpleCmd:
+		ctl, err := m.execSimple(c, f)
+		if err == nil && m.opts.Trace != nil {
+			m.opts.Trace(c.ln)
+		}
+		return ctl, err
+	default:
+		return ctlExit, errAt(n.line(), "unsupported command %T", n)
+	}
+}
+
+// findLabel locates a label the way goto and call do: searching forward from
+// the current position and wrapping around to the top of the file.
+func (m *Machine) findLabel(name string, from int) (int, bool) {
+	idxs := m.labels[strings.ToLower(name)]
+	if len(idxs) == 0 {
+		return 0, false
+	}
+	for _, i := range idxs {
+		if i >= from {
+			return i, true
+		}
+	}
+	return idxs[0], true
+}
+
+// labelName reports the label a line defines, if any.
+func labelName(line string) (string, bool) {
+	t := strings.TrimLeft(line, " \t@")
+	if !strings.HasPrefix(t, ":") || strings.HasPrefix(t, "::") {
+		return "", false
+	}
+	t = t[1:]
+	end := strings.IndexAny(t, " \t:;,=+")
+	if end >= 0 {
+		t = t[:end]
+	}
+	if t == "" {
+		return "", false
+	}
+	return strings.ToLower(t), true
+}
+
+func hostExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
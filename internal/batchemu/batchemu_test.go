@@ -0,0 +1,365 @@
+package batchemu
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRun_EchoForms(t *testing.T) {
+	script := "@echo off\n" +
+		"echo\n" +
+		"echo.\n" +
+		"echo   padded\n" +
+		"echo 3 ^> 2 ^& \"a & b\"\n" +
+		"echo on\n" +
+		"echo\n"
+	out := mustRun(t, script, Options{})
+	want := "ECHO is off.\n\n  padded\n3 > 2 & \"a & b\"\nECHO is on.\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_PercentAndDelayedExpansion(t *testing.T) {
+	script := "@echo off\n" +
+		"set x=1\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set x=2 & echo %x% !x!\n" +
+		"echo 100%% %1 %~2 [%undefined%] !missing!\n" +
+		"echo Wow^^! lone ! bang\n" +
+		"endlocal\n" +
+		"echo %x% !x!\n"
+	out := mustRun(t, script, Options{Args: []string{"one", "\"two words\""}})
+	want := "1 2 \n100% one two words [] \nWow! lone  bang\n1 !x!\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_Shift(t *testing.T) {
+	script := "@echo off\n" +
+		":next\n" +
+		"if \"%~1\"==\"\" goto done\n" +
+		"echo [%0] [%~1] [%*]\n" +
+		"shift\n" +
+		"goto next\n" +
+		":done\n" +
+		"call :sub a b\n" +
+		"echo [%1]\n" +
+		"goto :eof\n" +
+		":sub\n" +
+		"shift\n" +
+		"echo [%0] [%1] [%2]\n"
+	out := mustRun(t, script, Options{Args: []string{"one", "\"two words\""}})
+	want := "[script] [one] [one \"two words\"]\n[one] [two words] [one \"two words\"]\n[a] [b] []\n[]\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_ExpansionModifiers(t *testing.T) {
+	script := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set s=Hello World\n" +
+		"set n=3\n" +
+		"echo [%s:~6%] [!s:~0,5!] [!s:~-5,3!] [!s:~2,-3!] [!s:~%n%,-%n%!] [!s:~20!] [!s:~x,2!]\n" +
+		"echo [!s:o=0!] [!s:L=!] [!s:*o=!] [!s:*zz=!] [!s:=x!] [!missing:~1!]\n"
+	out := mustRun(t, script, Options{})
+	want := "[World] [Hello] [Wor] [llo Wo] [lo Wo] [] [He]\n[Hell0 W0rld] [Heo Word] [ World] [Hello World] [Hello World] []\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_CaseInsensitiveVariables(t *testing.T) {
+	m, out := runMachine(t, "@echo off\nset Name=a\nset NAME=b\necho %name%\n", Options{})
+	if out != "b\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if v, ok := m.Lookup("nAmE"); !ok || v != "b" {
+		t.Fatalf("Lookup = %q, %v", v, ok)
+	}
+	if _, ok := m.Vars()["NAME"]; !ok {
+		t.Fatalf("Vars should keep the last spelling, got %v", m.Vars())
+	}
+}
+
+func TestRun_SetArithmetic(t *testing.T) {
+	script := "@echo off\n" +
+		"set /a \"a=7, b=a/2, c=-a %% 3, d=1 << 4 | 1\"\n" +
+		"set /a e=2147483647 + 1\n" +
+		"set /a \"f=(a + b) * 2\"\n" +
+		"set /a g=010 + 0x10, a+=3\n" +
+		"set word=abc\n" +
+		"set /a h=word + 1\n" +
+		"echo %a% %b% %c% %d% %e% %f% %g% %h%\n"
+	out := mustRun(t, script, Options{})
+	if out != "10 3 -1 17 -2147483648 20 24 1\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_SetArithmeticErrors(t *testing.T) {
+	cases := []struct {
+		expr  string
+		level int
+		msg   string
+	}{
+		{"x=2 ** 3", levelMissingOperand, "Missing operand."},
+		{"x=1 / 0", levelDivideByZero, "Divide by zero error."},
+		{"x=(1 + 2", levelUnbalanced, "Unbalanced parentheses."},
+		{"x=1 2", levelMissingOperator, "Missing operator."},
+		{"x=09", levelInvalidNumber, "Invalid number."},
+		{"x=4294967296", levelInvalidNumber, "32-bits"},
+	}
+	for _, tc := range cases {
+		var stderr bytes.Buffer
+		m := New("set /a "+tc.expr+"\n", Options{Stderr: &stderr})
+		if err := m.Run(); err != nil {
+			t.Fatalf("%s: run error: %v", tc.expr, err)
+		}
+		if m.ErrorLevel() != tc.level {
+			t.Fatalf("%s: errorlevel = %d, want %d", tc.expr, m.ErrorLevel(), tc.level)
+		}
+		if !strings.Contains(stderr.String(), tc.msg) {
+			t.Fatalf("%s: stderr = %q, want %q", tc.expr, stderr.String(), tc.msg)
+		}
+	}
+}
+
+func TestRun_IfForms(t *testing.T) {
+	script := "@echo off\n" +
+		"set n=010\n" +
+		"if %n% EQU 8 echo octal\n" +
+		"if \"%n%\"==\"010\" (echo quoted) else (echo no)\n" +
+		"if /i abc==ABC echo icase\n" +
+		"if not abc==ABC echo not\n" +
+		"if 10 GTR 9 echo numeric\n" +
+		"if a10 GTR a9 (echo wrong) else echo string\n" +
+		"if defined n if not defined m echo defined\n" +
+		"if exist here.txt echo exists\n" +
+		"if 1==2 echo no & echo still-no\n" +
+		"if 1==1 (\n" +
+		"    echo multi\n" +
+		") else (\n" +
+		"    echo never\n" +
+		")\n"
+	exists := func(path string) bool { return path == "here.txt" }
+	out := mustRun(t, script, Options{Exists: exists})
+	want := "octal\nquoted\nicase\nnot\nnumeric\nstring\ndefined\nexists\nmulti\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_IfStringOrder(t *testing.T) {
+	script := "@echo off\n" +
+		"if a LSS B echo a-B\n" +
+		"if a LSS A echo a-A\n" +
+		"if /i a LSS A (echo wrong) else echo icase\n" +
+		"if coop LSS co-op echo coop\n" +
+		"if a9 LSS a10 (echo wrong) else echo text\n" +
+		"if _x LSS 010 echo punct\n"
+	out := mustRun(t, script, Options{})
+	want := "a-B\na-A\nicase\ncoop\ntext\npunct\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_GotoAndCall(t *testing.T) {
+	script := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set i=0\n" +
+		":top\n" +
+		"set /a i+=1\n" +
+		"if !i! LSS 3 goto top\n" +
+		"call :show %i% \"x y\"\n" +
+		"echo back !local! !ERRORLEVEL!\n" +
+		"goto :eof\n" +
+		":show\n" +
+		"setlocal\n" +
+		"set local=1\n" +
+		"echo %0 %1 %2 %~2\n" +
+		"exit /b 4\n"
+	out := mustRun(t, script, Options{})
+	want := ":show 3 \"x y\" x y\nback  4\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_GotoInsideBlockAbandonsBlock(t *testing.T) {
+	script := "@echo off\n" +
+		"if 1==1 (\n" +
+		"    echo before\n" +
+		"    goto out\n" +
+		"    echo skipped\n" +
+		")\n" +
+		":out\n" +
+		"echo after\n"
+	if out := mustRun(t, script, Options{}); out != "before\nafter\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_CallSecondExpansion(t *testing.T) {
+	script := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set nums_1=20\n" +
+		"set i=1\n" +
+		"call set v=%%nums_!i!%%\n" +
+		"echo !v!\n"
+	if out := mustRun(t, script, Options{}); out != "20\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_ChainsAndExternalCommands(t *testing.T) {
+	var calls []string
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		calls = append(calls, command)
+		if strings.HasPrefix(command, "fail") {
+			return 2, nil
+		}
+		return 0, nil
+	}
+	script := "@echo off\n" +
+		"set greeting=hi\n" +
+		"ok %greeting% && echo and-ran\n" +
+		"fail || echo or-ran\n" +
+		"fail && echo skipped\n" +
+		"echo level %errorlevel%\n"
+	out := mustRun(t, script, Options{Exec: exec})
+	if out != "and-ran\nor-ran\nlevel 2\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if strings.Join(calls, "|") != "ok hi|fail|fail" {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+}
+
+func TestRun_ForCommandOutput(t *testing.T) {
+	var calls []string
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		calls = append(calls, command)
+		io.WriteString(stdout, "first\r\n\n;comment\nsecond 100%\n")
+		return 0, nil
+	}
+	script := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set tool=list\n" +
+		"for /f \"usebackq delims=\" %%l in (`!tool! ^| sort \"a (b)\"`) do echo [%%l]& set \"last=%%l\"\n" +
+		"echo last=!last!\n"
+	out := mustRun(t, script, Options{Exec: exec})
+	if out != "[first]\n[second 100%]\nlast=second 100%\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if strings.Join(calls, "|") != `list | sort "a (b)"` {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+}
+
+func TestRun_RedirectToStderr(t *testing.T) {
+	var stderr bytes.Buffer
+	script := "@echo off\n" +
+		">&2 echo to stderr\n" +
+		"echo also 1>&2\n" +
+		"echo to stdout\n"
+	out := mustRun(t, script, Options{Stderr: &stderr})
+	if out != "to stdout\n" {
+		t.Fatalf("unexpected stdout %q", out)
+	}
+	if stderr.String() != "to stderr\nalso \n" {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	var stderr bytes.Buffer
+	m := New("nosuchtool arg\n", Options{Stderr: &stderr})
+	if err := m.Run(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if m.ErrorLevel() != levelNotRecognized {
+		t.Fatalf("errorlevel = %d", m.ErrorLevel())
+	}
+	if !strings.Contains(stderr.String(), "'nosuchtool' is not recognized") {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestRun_Exit(t *testing.T) {
+	script := "@echo off\n" +
+		"call :sub\n" +
+		"echo unreachable\n" +
+		":sub\n" +
+		"exit 3\n"
+	m, out := runMachine(t, script, Options{})
+	if out != "" || m.ErrorLevel() != 3 {
+		t.Fatalf("exit should stop the script: out=%q level=%d", out, m.ErrorLevel())
+	}
+}
+
+func TestRun_StrayParenIgnoresLine(t *testing.T) {
+	if out := mustRun(t, "@echo off\n) echo hidden\necho shown\n", Options{}); out != "shown\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		line   int
+		msg    string
+	}{
+		{"missing label", "@echo off\ngoto nowhere\n", 2, "cannot find the batch label"},
+		{"redirection", "echo hi > out.txt\n", 1, "redirection is not supported"},
+		{"redirection to stdout", "echo hi 2>&1\n", 1, "redirection is not supported"},
+		{"pipe", "echo hi | more\n", 1, "pipes are not supported"},
+		{"unbalanced", "if 1==1 (\necho hi\n", 1, "unbalanced parentheses"},
+		{"bad if", "if 1 FOO 2 echo\n", 1, "FOO was unexpected"},
+		{"for over a set", "for %%i in (a b) do echo %%i\n", 1, "only for /f is supported"},
+		{"for /f over a file", "for /f \"usebackq delims=\" %%l in (\"x.txt\") do echo %%l\n", 1, "only for /f over a `command`"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := New(tc.script, Options{}).Run()
+			var be *Error
+			if !errors.As(err, &be) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+			if be.Line != tc.line || !strings.Contains(be.Msg, tc.msg) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestRun_MaxSteps(t *testing.T) {
+	err := New(":loop\ngoto loop\n", Options{MaxSteps: 100}).Run()
+	if err == nil || !strings.Contains(err.Error(), "step limit") {
+		t.Fatalf("expected step limit error, got %v", err)
+	}
+}
+
+func mustRun(t *testing.T, script string, opts Options) string {
+	t.Helper()
+	_, out := runMachine(t, script, opts)
+	return out
+}
+
+func runMachine(t *testing.T, script string, opts Options) (*Machine, string) {
+	t.Helper()
+	var out bytes.Buffer
+	opts.Stdout = &out
+	m := New(script, opts)
+	if err := m.Run(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	return m, out.String()
+}
@@ -0,0 +1,476 @@
+package batchemu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/cmdexe"
+)
+
+// execSimple runs one simple command after delayed expansion.
+func (m *Machine) execSimple(c *simpleCmd, f *frame) (control, error) {
+	text := c.text
+	if !c.expanded {
+		text = m.expandDelayed(text)
+	}
+	name, rest := splitCommand(text)
+	m.failed = false
+	if c.stderr {
+		stdout := m.opts.Stdout
+		m.opts.Stdout = m.opts.Stderr
+		defer func() { m.opts.Stdout = stdout }()
+	}
+	switch strings.ToLower(name) {
+	case "":
+		return ctlNext, nil
+	case "echo":
+		m.execEcho(rest)
+	case "set":
+		m.execSet(rest)
+	case "goto":
+		return m.execGoto(rest, f, c.ln)
+	case "call":
+		return m.execCall(rest, f, c.ln)
+	case "setlocal":
+		m.setlocal(rest, f)
+	case "endlocal":
+		m.endlocal(f)
+	case "exit":
+		return m.execExit(rest)
+	case "shift":
+		f.shift++
+	default:
+		return ctlNext, m.execExternal(strings.Trim(text, " \t@"))
+	}
+	return ctlNext, nil
+}
+
+// splitCommand separates the command name from its tail. The tail keeps its
+// leading delimiter, which echo needs to tell `echo.` from `echo x`.
+func splitCommand(text string) (string, string) {
+	t := strings.TrimLeft(text, " \t@")
+	end := strings.IndexAny(t, " \t,;=./:(+")
+	if end < 0 {
+		return t, ""
+	}
+	return t[:end], t[end:]
+}
+
+func (m *Machine) execEcho(rest string) {
+	if rest == "" {
+		m.echoState()
+		return
+	}
+	msg := rest[1:]
+	if strings.IndexByte(" \t,;=", rest[0]) >= 0 {
+		switch strings.ToLower(strings.TrimSpace(msg)) {
+		case "":
+			m.echoState()
+			return
+		case "on":
+			m.echo = true
+			return
+		case "off":
+			m.echo = false
+			return
+		}
+	}
+	fmt.Fprintln(m.opts.Stdout, msg)
+}
+
+func (m *Machine) echoState() {
+	if m.echo {
+		fmt.Fprintln(m.opts.Stdout, "ECHO is on.")
+	} else {
+		fmt.Fprintln(m.opts.Stdout, "ECHO is off.")
+	}
+}
+
+func (m *Machine) execSet(rest string) {
+	arg := strings.TrimLeft(rest, " \t")
+	if len(arg) >= 2 && arg[0] == '/' {
+		switch strings.ToLower(arg[:2]) {
+		case "/a":
+			m.setArith(arg[2:])
+			return
+		case "/p":
+			m.fail(1, "set /p is not supported")
+			return
+		}
+	}
+	if strings.HasPrefix(arg, "\"") {
+		if end := strings.LastIndexByte(arg, '"'); end > 0 {
+			arg = arg[1:end]
+		} else {
+			arg = arg[1:]
+		}
+	}
+	eq := strings.IndexByte(arg, '=')
+	switch {
+	case eq < 0:
+		m.listVars(arg)
+	case eq == 0:
+		m.fail(1, "The syntax of the command is incorrect.")
+	default:
+		m.env.set(arg[:eq], arg[eq+1:])
+	}
+}
+
+// listVars prints every variable whose name starts with prefix.
+func (m *Machine) listVars(prefix string) {
+	var out []string
+	for _, kv := range m.env.list() {
+		if len(kv) >= len(prefix) && strings.EqualFold(kv[:len(prefix)], prefix) {
+			out = append(out, kv)
+		}
+	}
+	if len(out) == 0 {
+		m.fail(1, "Environment variable %s not defined", prefix)
+		return
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToUpper(out[i]) < strings.ToUpper(out[j]) })
+	for _, kv := range out {
+		fmt.Fprintln(m.opts.Stdout, kv)
+	}
+}
+
+func (m *Machine) setArith(expr string) {
+	expr = strings.ReplaceAll(expr, "\"", "")
+	if strings.TrimSpace(expr) == "" {
+		m.fail(1, "The syntax of the command is incorrect.")
+		return
+	}
+	if _, err := evalArith(expr, m.env); err != nil {
+		m.fail(err.level, "%s", err.msg)
+	}
+}
+
+func (m *Machine) execGoto(rest string, f *frame, ln int) (control, error) {
+	args := splitArgs(rest)
+	if len(args) == 0 {
+		return ctlExit, errAt(ln, "no batch label specified to goto command")
+	}
+	label := strings.TrimPrefix(args[0], ":")
+	if strings.EqualFold(label, "eof") {
+		return ctlEOF, nil
+	}
+	i, ok := m.findLabel(label, f.pc)
+	if !ok {
+		return ctlExit, errAt(ln, "the system cannot find the batch label specified - %s", label)
+	}
+	f.pc = i + 1
+	return ctlGoto, nil
+}
+
+// execCall runs `call :label args` in a new frame, or gives any other command
+// a second round of percent expansion and runs it.
+func (m *Machine) execCall(rest string, f *frame, ln int) (control, error) {
+	tail := strings.TrimLeft(rest, " \t,;=")
+	if strings.HasPrefix(tail, ":") {
+		args := splitArgs(tail)
+		i, ok := m.findLabel(args[0][1:], f.pc)
+		if !ok {
+			return ctlExit, errAt(ln, "the system cannot find the batch label specified - %s", args[0][1:])
+		}
+		if m.depth >= maxCallDepth {
+			return ctlExit, errAt(ln, "maximum call depth of %d exceeded", maxCallDepth)
+		}
+		m.depth++
+		ctl, err := m.runFrame(&frame{args: args, pc: i + 1})
+		m.depth--
+		if err != nil || ctl == ctlExit {
+			return ctlExit, err
+		}
+		m.failed = m.errorLevel != 0
+		return ctlNext, nil
+	}
+	nodes, err := parseCommands(lexLine(m.expandPercents(tail, f), ln))
+	if err != nil {
+		if err == errIncomplete {
+			err = errAt(ln, "unbalanced parentheses")
+		}
+		return ctlExit, err
+	}
+	for _, n := range nodes {
+		markExpanded(n)
+		if ctl, err := m.exec(n, f); err != nil || ctl != ctlNext {
+			return ctl, err
+		}
+	}
+	return ctlNext, nil
+}
+
+// markExpanded flags simple commands produced by `call`, which do not get a
+// second round of delayed expansion.
+func markExpanded(n node) {
+	switch c := n.(type) {
+	case *simpleCmd:
+		c.expanded = true
+	case *blockCmd:
+		for _, sub := range c.body {
+			markExpanded(sub)
+		}
+	case *chainCmd:
+		markExpanded(c.left)
+		markExpanded(c.right)
+	}
+}
+
+func (m *Machine) setlocal(rest string, f *frame) {
+	f.locals = append(f.locals, localState{env: m.env, delayed: m.delayed})
+	m.env = m.env.clone()
+	for _, opt := range splitArgs(rest) {
+		switch strings.ToLower(opt) {
+		case "enabledelayedexpansion":
+			m.delayed = true
+		case "disabledelayedexpansion":
+			m.delayed = false
+		}
+	}
+}
+
+// endlocal restores the state saved by the frame's most recent setlocal. It
+// cannot reach a setlocal made by a calling frame.
+func (m *Machine) endlocal(f *frame) {
+	if len(f.locals) == 0 {
+		return
+	}
+	s := f.locals[len(f.locals)-1]
+	f.locals = f.locals[:len(f.locals)-1]
+	m.env = s.env
+	m.delayed = s.delayed
+}
+
+func (m *Machine) execExit(rest string) (control, error) {
+	args := splitArgs(rest)
+	ctl := ctlExit
+	if len(args) > 0 && strings.EqualFold(args[0], "/b") {
+		ctl = ctlEOF
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		n, _ := parseNumber(args[0])
+		m.errorLevel = int(n)
+	}
+	m.failed = m.errorLevel != 0
+	return ctl, nil
+}
+
+func (m *Machine) execExternal(command string) error {
+	if m.opts.Exec == nil {
+		m.notRecognized(command)
+		return nil
+	}
+	code, err := m.opts.Exec(command, m.env.list(), m.opts.Stdout, m.opts.Stderr)
+	if err != nil {
+		return err
+	}
+	m.errorLevel = code
+	m.failed = code != 0
+	return nil
+}
+
+func (m *Machine) notRecognized(command string) {
+	name, _ := splitCommand(command)
+	m.fail(levelNotRecognized, "'%s' is not recognized as an internal or external command,\noperable program or batch file.", name)
+}
+
+// execFor runs a for /f loop. The command runs once with its output
+// captured, then the body runs for each line with the loop variable
+// replaced by the line. Empty lines and lines starting with ';', the default
+// end-of-line character, are skipped.
+func (m *Machine) execFor(c *forCmd, f *frame) (control, error) {
+	command := strings.TrimSpace(m.expandDelayed(c.command))
+	if m.opts.Exec == nil {
+		m.notRecognized(command)
+		return ctlNext, nil
+	}
+	var out bytes.Buffer
+	if err := m.execCommandLine(command, &out, c.ln); err != nil {
+		return ctlExit, err
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || line[0] == ';' {
+			continue
+		}
+		if ctl, err := m.exec(substFor(c.body, c.variable, line), f); err != nil || ctl != ctlNext {
+			return ctl, err
+		}
+	}
+	return ctlNext, nil
+}
+
+// execCommandLine runs the command of a for /f loop as the cmd /c that
+// cmd.exe starts for it would, writing its output to out. Commands joined by
+// & run one after the other. echo and call run here, without delayed
+// expansion and with the percent expansion of a command line, which keeps
+// an undefined %name% as written; anything else is handed to Exec whole,
+// pipes included. The ERRORLEVEL they set is the child's and is not kept.
+func (m *Machine) execCommandLine(command string, out io.Writer, ln int) error {
+	level, failed, stdout := m.errorLevel, m.failed, m.opts.Stdout
+	defer func() { m.errorLevel, m.failed, m.opts.Stdout = level, failed, stdout }()
+	m.errorLevel = 0
+	m.opts.Stdout = out
+	for _, part := range splitCommandLine(command) {
+		name, _ := splitCommand(part)
+		switch strings.ToLower(name) {
+		case "echo", "call":
+			nodes, err := parseCommands(lexLine(m.expandCommandLine(part), ln))
+			if err != nil {
+				if err == errIncomplete {
+					err = errAt(ln, "unbalanced parentheses")
+				}
+				return err
+			}
+			for _, n := range nodes {
+				markExpanded(n)
+				if _, err := m.exec(n, &frame{}); err != nil {
+					return err
+				}
+			}
+		default:
+			code, err := m.opts.Exec(strings.TrimSpace(part), m.env.list(), out, m.opts.Stderr)
+			if err != nil {
+				return err
+			}
+			m.errorLevel = code
+		}
+	}
+	return nil
+}
+
+// splitCommandLine splits a command line at each & outside quotes.
+func splitCommandLine(command string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(command); i++ {
+		switch command[i] {
+		case '"':
+			quoted = !quoted
+		case '&':
+			if !quoted {
+				parts = append(parts, command[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, command[start:])
+}
+
+// substFor returns a copy of n with the for variable replaced by value, as
+// cmd.exe does before delayed expansion of the body.
+func substFor(n node, variable, value string) node {
+	switch c := n.(type) {
+	case *simpleCmd:
+		d := *c
+		d.text = strings.ReplaceAll(c.text, variable, value)
+		return &d
+	case *blockCmd:
+		d := *c
+		d.body = make([]node, len(c.body))
+		for i, sub := range c.body {
+			d.body[i] = substFor(sub, variable, value)
+		}
+		return &d
+	case *chainCmd:
+		d := *c
+		d.left = substFor(c.left, variable, value)
+		d.right = substFor(c.right, variable, value)
+		return &d
+	case *ifCmd:
+		d := *c
+		d.left = strings.ReplaceAll(c.left, variable, value)
+		d.right = strings.ReplaceAll(c.right, variable, value)
+		d.then = substFor(c.then, variable, value)
+		if c.els != nil {
+			d.els = substFor(c.els, variable, value)
+		}
+		return &d
+	case *forCmd:
+		d := *c
+		d.command = strings.ReplaceAll(c.command, variable, value)
+		d.body = substFor(c.body, variable, value)
+		return &d
+	}
+	return n
+}
+
+// fail reports a command failure on stderr and sets ERRORLEVEL.
+func (m *Machine) fail(level int, format string, args ...any) {
+	fmt.Fprintf(m.opts.Stderr, format+"\n", args...)
+	m.errorLevel = level
+	m.failed = true
+}
+
+// evalIf evaluates an if condition after delayed expansion of its operands.
+func (m *Machine) evalIf(c *ifCmd) (bool, error) {
+	left := m.expandDelayed(c.left)
+	right := m.expandDelayed(c.right)
+	var ok bool
+	switch c.kind {
+	case "exist":
+		ok = m.opts.Exists(unquote(left))
+	case "defined":
+		_, ok = m.env.get(left)
+	case "errorlevel":
+		n, valid := parseNumber(left)
+		if !valid {
+			return false, errAt(c.ln, "%s was unexpected at this time", left)
+		}
+		ok = m.errorLevel >= int(n)
+	case "==":
+		if c.icase {
+			ok = strings.EqualFold(left, right)
+		} else {
+			ok = left == right
+		}
+	default:
+		ok = compareIf(c.kind, left, right, c.icase)
+	}
+	return ok != c.not, nil
+}
+
+// compareIf applies EQU, NEQ, LSS, LEQ, GTR or GEQ: numerically when both
+// operands are integers, as strings otherwise.
+func compareIf(op, left, right string, icase bool) bool {
+	cmp := 0
+	ln, lok := parseNumber(left)
+	rn, rok := parseNumber(right)
+	if lok && rok {
+		cmp = compareInts(ln, rn)
+	} else {
+		cmp = cmdexe.CompareStrings(left, right, icase)
+	}
+	switch op {
+	case "EQU":
+		return cmp == 0
+	case "NEQ":
+		return cmp != 0
+	case "LSS":
+		return cmp < 0
+	case "LEQ":
+		return cmp <= 0
+	case "GTR":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compareInts(a, b int32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// itoa formats an arithmetic result.
+func itoa(n int32) string { return strconv.FormatInt(int64(n), 10) }
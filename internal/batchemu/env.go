@@ -0,0 +1,63 @@
+package batchemu
+
+import (
+	"sort"
+	"strings"
+)
+
+// env is a cmd.exe environment block. Names are case-insensitive; the
+// spelling used by the most recent assignment is kept for display.
+type env struct {
+	vars map[string]envVar
+}
+
+type envVar struct {
+	name  string
+	value string
+}
+
+func newEnv() *env {
+	return &env{vars: make(map[string]envVar)}
+}
+
+func (e *env) get(name string) (string, bool) {
+	v, ok := e.vars[strings.ToUpper(name)]
+	return v.value, ok
+}
+
+// set assigns a variable; an empty value removes it, as `set name=` does.
+func (e *env) set(name, value string) {
+	key := strings.ToUpper(name)
+	if value == "" {
+		delete(e.vars, key)
+		return
+	}
+	e.vars[key] = envVar{name: name, value: value}
+}
+
+func (e *env) clone() *env {
+	c := &env{vars: make(map[string]envVar, len(e.vars))}
+	for k, v := range e.vars {
+		c.vars[k] = v
+	}
+	return c
+}
+
+// snapshot returns the variables keyed by their display names.
+func (e *env) snapshot() map[string]string {
+	out := make(map[string]string, len(e.vars))
+	for _, v := range e.vars {
+		out[v.name] = v.value
+	}
+	return out
+}
+
+// list returns NAME=value pairs sorted by name, for child processes.
+func (e *env) list() []string {
+	out := make([]string, 0, len(e.vars))
+	for _, v := range e.vars {
+		out = append(out, v.name+"="+v.value)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,32 @@
+package batchemu
+
+import "fmt"
+
+// Error reports a script the emulator cannot execute: a syntax error, a
+// missing label, or a construct outside the supported cmd.exe subset.
+// Line is 1-based; zero means the error is not tied to a line.
+type Error struct {
+	Msg  string
+	Line int
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("batch error at line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("batch error: %s", e.Msg)
+}
+
+func errAt(line int, format string, args ...any) error {
+	return &Error{Msg: fmt.Sprintf(format, args...), Line: line}
+}
+
+// Error levels cmd.exe reports for `set /a` failures.
+const (
+	levelMissingOperator = 1073750989
+	levelMissingOperand  = 1073750990
+	levelUnbalanced      = 1073750991
+	levelInvalidNumber   = 1073750992
+	levelDivideByZero    = 1073750993
+	levelNotRecognized   = 9009
+)
@@ -0,0 +1,430 @@
+package batchemu
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/generator"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+	"github.com/vishnunath-suresh/fin-project/internal/opt"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// TestExamples compiles every program in examples/ and runs the generated
+// batch file, comparing stdout with the example's expected output header.
+func TestExamples(t *testing.T) {
+	runExamples(t, false)
+}
+
+// TestExamples_Optimized runs the examples compiled with -O.
+func TestExamples_Optimized(t *testing.T) {
+	runExamples(t, true)
+}
+
+func runExamples(t *testing.T, optimize bool) {
+	files, err := filepath.Glob(filepath.Join(projectRoot(t), "examples", "*.fin"))
+	if err != nil {
+		t.Fatalf("glob examples: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no examples found")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			prog, err := module.Load(file)
+			if err != nil {
+				t.Fatalf("load errors: %v", err)
+			}
+			if optimize {
+				prog = opt.Optimize(prog)
+			}
+			out, err := runBatch(generate(t, prog), Options{})
+			if err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+			want := expectedOutput(string(src))
+			if out != want {
+				t.Fatalf("output mismatch\nwant:\n%s\nhave:\n%s", want, out)
+			}
+		})
+	}
+}
+
+// TestStrictProgram runs the checks the generator adds in strict mode: a
+// failure inside an unchecked block is tolerated, one outside it is reported
+// and ends the script with the command's exit code.
+func TestStrictProgram(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		if strings.HasPrefix(command, "fail") {
+			return 5, nil
+		}
+		return 0, nil
+	}
+	src := "strict\n" +
+		"fn deploy target\n" +
+		"    run \"fail\" $target\n" +
+		"    echo \"deployed $target\"\n" +
+		"end\n" +
+		"unchecked\n" +
+		"    deploy \"a\"\n" +
+		"    echo \"status $status\"\n" +
+		"end\n" +
+		"deploy \"b\"\n" +
+		"echo \"not reached\"\n"
+	var out, stderr bytes.Buffer
+	m := New(compile(t, src), Options{Stdout: &out, Stderr: &stderr, Exec: exec})
+	if err := m.Run(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out.String() != "status \n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	want := "fin: line 3: command failed with exit code 5\n"
+	if stderr.String() != want+want {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+	if m.ErrorLevel() != 5 {
+		t.Fatalf("errorlevel = %d, want 5", m.ErrorLevel())
+	}
+}
+
+func compile(t *testing.T, src string) string {
+	t.Helper()
+	p := parser.New(parser.CollectTokens(lexer.New(src)))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if err := sema.New().Analyze(prog); err != nil {
+		t.Fatalf("sema errors: %v", err)
+	}
+	return generate(t, prog)
+}
+
+func generate(t *testing.T, prog *ast.Program) string {
+	t.Helper()
+	out, err := generator.NewBatchGenerator().Generate(prog)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	return out
+}
+
+func runBatch(script string, opts Options) (string, error) {
+	var out bytes.Buffer
+	opts.Stdout = &out
+	err := New(script, opts).Run()
+	return out.String(), err
+}
+
+// expectedOutput extracts the "# Expected output:" block from an example header.
+func expectedOutput(src string) string {
+	var b strings.Builder
+	in := false
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "# Expected output:") {
+			in = true
+			continue
+		}
+		if !in {
+			continue
+		}
+		if !strings.HasPrefix(line, "#   ") {
+			break
+		}
+		b.WriteString(strings.TrimPrefix(line, "#   "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func projectRoot(t *testing.T) string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatalf("cannot determine caller")
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+// TestCaptureProgram checks that a command capture sets $status to the
+// command's exit code, whether or not the command printed anything.
+func TestCaptureProgram(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		switch command {
+		case "head":
+			io.WriteString(stdout, "\r\nabc123\r\nextra\r\n")
+			return 0, nil
+		case "silent":
+			return 0, nil
+		case `broken "x y"`:
+			io.WriteString(stdout, "oops\n")
+			return 2, nil
+		}
+		return 3, nil
+	}
+	src := "set arg \"x y\"\n" +
+		"set head (run \"head\")\n" +
+		"echo \"[$head] $status\"\n" +
+		"set quiet (run \"silent\")\n" +
+		"echo \"[$quiet] $status\"\n" +
+		"set bad (run \"broken\" $arg)\n" +
+		"echo \"[$bad] $status\"\n" +
+		"set none (run \"missing\")\n" +
+		"echo \"[$none] $status\"\n"
+	out, err := runBatch(compile(t, src), Options{Exec: exec})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "[abc123] 0\n[] 0\n[oops] 2\n[] 3\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
+
+func TestTryProgram(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		if strings.HasPrefix(command, "fail") {
+			return 3, nil
+		}
+		return 0, nil
+	}
+	src := "fn probe\n" +
+		"    try\n" +
+		"        run \"fail\" \"a & b\"\n" +
+		"        echo \"not reached\"\n" +
+		"    catch err\n" +
+		"        echo \"caught $err.code $err.command\"\n" +
+		"        return \"from catch\"\n" +
+		"    finally\n" +
+		"        echo \"cleanup\"\n" +
+		"    end\n" +
+		"    return \"not reached\"\n" +
+		"end\n" +
+		"echo (probe)\n" +
+		"for i in 1..3\n" +
+		"    try\n" +
+		"        if $i == 2\n" +
+		"            break\n" +
+		"        end\n" +
+		"        run \"fail\"\n" +
+		"        echo \"ignored\"\n" +
+		"    finally\n" +
+		"        echo \"finally $i\"\n" +
+		"    end\n" +
+		"end\n" +
+		"try\n" +
+		"    try\n" +
+		"        run \"fail inner\"\n" +
+		"    finally\n" +
+		"        echo \"inner finally\"\n" +
+		"    end\n" +
+		"    echo \"not reached\"\n" +
+		"catch e\n" +
+		"    echo \"outer caught $e.command\"\n" +
+		"end\n"
+	out, err := runBatch(compile(t, src), Options{Exec: exec})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "caught 3 fail \"a & b\"\ncleanup\nfrom catch\n" +
+		"ignored\nfinally 1\nfinally 2\n" +
+		"inner finally\nouter caught fail inner\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
+
+func TestContinueProgram(t *testing.T) {
+	src := "for i in 0..3\n" +
+		"    if $i == 1\n" +
+		"        continue\n" +
+		"    end\n" +
+		"    echo \"plain $i\"\n" +
+		"end\n" +
+		"for i in 0..3\n" +
+		"    try\n" +
+		"        if $i % 2 == 0\n" +
+		"            continue\n" +
+		"        end\n" +
+		"        echo \"body $i\"\n" +
+		"    finally\n" +
+		"        echo \"finally $i\"\n" +
+		"    end\n" +
+		"end\n"
+	out, err := runBatch(compile(t, src), Options{MaxSteps: 10_000})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "plain 0\nplain 2\nplain 3\n" +
+		"finally 0\nbody 1\nfinally 1\nfinally 2\nbody 3\nfinally 3\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
+
+func TestTryStrictProgram(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		if strings.HasPrefix(command, "fail") {
+			return 4, nil
+		}
+		return 0, nil
+	}
+	src := "strict\n" +
+		"fn deploy\n" +
+		"    run \"fail\"\n" +
+		"end\n" +
+		"try\n" +
+		"    deploy\n" +
+		"catch err\n" +
+		"    echo \"caught $err.code $err.command\"\n" +
+		"end\n" +
+		"try\n" +
+		"    run \"fail\"\n" +
+		"finally\n" +
+		"    echo \"cleanup\"\n" +
+		"end\n" +
+		"echo \"not reached\"\n"
+	var out, stderr bytes.Buffer
+	m := New(compile(t, src), Options{Stdout: &out, Stderr: &stderr, Exec: exec})
+	if err := m.Run(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out.String() != "caught 4 deploy\ncleanup\n" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	want := "fin: line 3: command failed with exit code 4\n" +
+		"fin: line 11: command failed with exit code 4\n"
+	if stderr.String() != want {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+	if m.ErrorLevel() != 4 {
+		t.Fatalf("errorlevel = %d, want 4", m.ErrorLevel())
+	}
+}
+
+func TestConditionProgram(t *testing.T) {
+	src := "fn loud word\n" +
+		"    echo \"called $word\"\n" +
+		"    return true\n" +
+		"end\n" +
+		"set n 2\n" +
+		"if exists \"here.txt\" && !exists \"gone.txt\"\n" +
+		"    echo \"files ok\"\n" +
+		"end\n" +
+		"if exists \"gone.txt\" || $n == 2\n" +
+		"    echo \"either\"\n" +
+		"end\n" +
+		"if !(exists \"gone.txt\" || $n > 5)\n" +
+		"    echo \"neither\"\n" +
+		"end\n" +
+		"if $n > 5 && (loud \"and\")\n" +
+		"    echo \"not reached\"\n" +
+		"else if $n < 5 || (loud \"or\")\n" +
+		"    echo \"short circuit\"\n" +
+		"end\n" +
+		"set count 0\n" +
+		"while !exists \"gone.txt\" && $count < 3\n" +
+		"    count = $count + 1\n" +
+		"end\n" +
+		"echo \"count $count\"\n" +
+		"while $count > 0 || (loud \"loop\") == false\n" +
+		"    count = $count - 1\n" +
+		"end\n" +
+		"echo \"count $count\"\n"
+	exists := func(path string) bool { return path == "here.txt" }
+	out, err := runBatch(compile(t, src), Options{Exists: exists})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "files ok\neither\nneither\nshort circuit\ncount 3\ncalled loop\ncount 0\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
+
+// TestPowProgram runs the pow helper the ** operator compiles to, including
+// an overflow, which is reported and ends the script.
+func TestPowProgram(t *testing.T) {
+	src := "fn square n\n" +
+		"    return $n ** 2\n" +
+		"end\n" +
+		"set two 2\n" +
+		"set zero 0\n" +
+		"set neg (-1)\n" +
+		"echo $two ** 10\n" +
+		"echo (-3) ** 3 + (square 4)\n" +
+		"echo \"$(square 9) $zero\"\n" +
+		"set a $zero ** $zero\n" +
+		"set b $two ** (-1)\n" +
+		"set c $neg ** (-3)\n" +
+		"set d (-2) ** 31\n" +
+		"set e 2 ** 3 ** 2\n" +
+		"echo \"$a $b $c $d $e\"\n" +
+		"set f $two ** 31\n" +
+		"echo \"not reached\"\n"
+	var out, stderr bytes.Buffer
+	m := New(compile(t, src), Options{Stdout: &out, Stderr: &stderr})
+	if err := m.Run(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "1024\n-11\n81 0\n1 0 -1 -2147483648 512\n"
+	if out.String() != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out.String())
+	}
+	if stderr.String() != "fin: line 16: integer overflow: 2 ** 31 does not fit in 32 bits\n" {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+	if m.ErrorLevel() != 1 {
+		t.Fatalf("exit code = %d, want 1", m.ErrorLevel())
+	}
+}
+
+func TestOperatorsProgram(t *testing.T) {
+	src := "fn low_byte v\n" +
+		"    return $v & 255\n" +
+		"end\n" +
+		"set a 13\n" +
+		"set b 6\n" +
+		"echo $a % $b\n" +
+		"echo \"$(low_byte 4660) $(low_byte (-1))\"\n" +
+		"set r [$a & $b, $a | $b, $a ^ $b, $a << 2, -$a >> 1, ($a | $b) & 3]\n" +
+		"echo \"$r[0] $r[1] $r[2] $r[3] $r[4] $r[5]\"\n" +
+		"if $a & 1 == 1 && $a >> 2 > 2\n" +
+		"    echo \"odd\"\n" +
+		"end\n" +
+		"for i in 0 .. $b % 4\n" +
+		"    echo \"i $i\"\n" +
+		"end\n" +
+		"set x 10\n" +
+		"x += 5\n" +
+		"x -= 2 * 3\n" +
+		"x *= $a - 11\n" +
+		"x /= 4\n" +
+		"x %= 3\n" +
+		"x++\n" +
+		"x--\n" +
+		"echo $x\n"
+	var out bytes.Buffer
+	m := New(compile(t, src), Options{Stdout: &out})
+	if err := m.Run(); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "1\n52 255\n4 15 11 52 -7 3\nodd\ni 0\ni 1\ni 2\n1\n"
+	if out.String() != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out.String())
+	}
+}
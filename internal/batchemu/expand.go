@@ -0,0 +1,260 @@
+package batchemu
+
+import (
+	"strconv"
+	"strings"
+)
+
+// expandPercents performs phase-1 expansion of one physical line: %% is a
+// literal percent, %0..%9, %~0..%~9 and %* read the frame arguments, and
+// %name% reads a variable. Undefined variables expand to nothing and a lone
+// percent sign is dropped, as in a batch file. As in cmd.exe, shift moves
+// %0..%9 along the arguments but leaves %* as it was.
+func (m *Machine) expandPercents(line string, f *frame) string {
+	args := f.args
+	if strings.IndexByte(line, '%') < 0 {
+		return line
+	}
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] != '%' {
+			b.WriteByte(line[i])
+			continue
+		}
+		if i+1 >= len(line) {
+			break
+		}
+		next := line[i+1]
+		switch {
+		case next == '%':
+			b.WriteByte('%')
+			i++
+		case next == '*':
+			if len(args) > 1 {
+				b.WriteString(strings.Join(args[1:], " "))
+			}
+			i++
+		case isDigit(next):
+			b.WriteString(argAt(args, f.shift+int(next-'0')))
+			i++
+		case next == '~' && i+2 < len(line) && isDigit(line[i+2]):
+			b.WriteString(unquote(argAt(args, f.shift+int(line[i+2]-'0'))))
+			i += 2
+		default:
+			end := strings.IndexByte(line[i+1:], '%')
+			if end < 0 {
+				continue
+			}
+			b.WriteString(m.variable(line[i+1 : i+1+end]))
+			i += end + 1
+		}
+	}
+	return b.String()
+}
+
+// expandCommandLine performs the percent expansion of a command line typed
+// at the prompt or given to cmd /c. Unlike in a batch file, an undefined
+// %name% and a lone percent sign are kept as written, and there are no
+// arguments to read.
+func (m *Machine) expandCommandLine(line string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(line, '%')
+		if i < 0 {
+			break
+		}
+		end := strings.IndexByte(line[i+1:], '%')
+		if end < 0 {
+			break
+		}
+		ref := line[i+1 : i+1+end]
+		name, _, _ := strings.Cut(ref, ":")
+		if _, ok := m.env.get(name); ok || strings.EqualFold(name, "errorlevel") {
+			b.WriteString(line[:i] + m.variable(ref))
+		} else {
+			b.WriteString(line[:i+2+end])
+		}
+		line = line[i+2+end:]
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// expandDelayed performs phase-5 expansion of !name! references. It only
+// runs when delayed expansion is enabled and the text contains a '!'; in that
+// case carets escape the following character and an unmatched '!' is removed.
+func (m *Machine) expandDelayed(text string) string {
+	if !m.delayed || strings.IndexByte(text, '!') < 0 {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '^':
+			if i+1 < len(text) {
+				i++
+				b.WriteByte(text[i])
+			}
+		case '!':
+			end := strings.IndexByte(text[i+1:], '!')
+			if end < 0 {
+				continue
+			}
+			b.WriteString(m.variable(text[i+1 : i+1+end]))
+			i += end + 1
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
+
+// variable reads a variable for expansion, falling back to the dynamic
+// ERRORLEVEL value when no variable of that name is set. The reference may
+// end in a modifier, name:~start,length or name:old=new, applied to the
+// value; an undefined variable expands to nothing either way.
+func (m *Machine) variable(ref string) string {
+	name, mod, modified := strings.Cut(ref, ":")
+	v, ok := m.env.get(name)
+	if !ok && strings.EqualFold(name, "errorlevel") {
+		v, ok = strconv.Itoa(m.errorLevel), true
+	}
+	if !ok || !modified {
+		return v
+	}
+	if spec, ok := strings.CutPrefix(mod, "~"); ok {
+		return substring(v, spec)
+	}
+	if old, repl, ok := strings.Cut(mod, "="); ok && old != "" {
+		return substitute(v, old, repl)
+	}
+	return v
+}
+
+// substring applies a ~start,length modifier. A negative start counts from
+// the end of the value and a negative length leaves that many characters off
+// its end; without a length the rest of the value is kept. Numbers that do
+// not parse read as zero.
+func substring(v, spec string) string {
+	startText, lengthText, hasLength := strings.Cut(spec, ",")
+	n := len(v)
+	s, _ := parseNumber(strings.TrimSpace(startText))
+	start := int(s)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	start = min(start, n)
+	end := n
+	if hasLength {
+		l, _ := parseNumber(strings.TrimSpace(lengthText))
+		if end = start + int(l); l < 0 {
+			end = n + int(l)
+		}
+	}
+	end = min(max(end, start), n)
+	return v[start:end]
+}
+
+// substitute applies an old=new modifier: every occurrence of old is
+// replaced, ignoring case. When old starts with '*', everything up to and
+// including the first occurrence of the rest is replaced instead.
+func substitute(v, old, repl string) string {
+	if rest, ok := strings.CutPrefix(old, "*"); ok {
+		if rest == "" {
+			return v
+		}
+		if i := indexFold(v, rest, 0); i >= 0 {
+			return repl + v[i+len(rest):]
+		}
+		return v
+	}
+	var b strings.Builder
+	last := 0
+	for i := indexFold(v, old, 0); i >= 0; i = indexFold(v, old, last) {
+		b.WriteString(v[last:i] + repl)
+		last = i + len(old)
+	}
+	b.WriteString(v[last:])
+	return b.String()
+}
+
+// indexFold returns the index of the first occurrence of sub in s at or
+// after from, comparing without regard to case, or -1.
+func indexFold(s, sub string, from int) int {
+	for i := from; i+len(sub) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func argAt(args []string, n int) string {
+	if n < len(args) {
+		return args[n]
+	}
+	return ""
+}
+
+// unquote strips one pair of surrounding double quotes, as %~1 does.
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return strings.TrimPrefix(s, "\"")
+}
+
+// splitArgs splits a command tail into arguments the way cmd.exe does for
+// %1..%9: on spaces, tabs, commas, semicolons and equals signs outside quotes.
+// Quotes are kept.
+func splitArgs(s string) []string {
+	var args []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			inQuote = !inQuote
+		} else if !inQuote && strings.IndexByte(" \t,;=", c) >= 0 {
+			if cur.Len() > 0 {
+				args = append(args, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if cur.Len() > 0 {
+		args = append(args, cur.String())
+	}
+	return args
+}
+
+// parseNumber reads an integer the way cmd.exe does for `if` comparisons and
+// `exit`: optional sign, then decimal, 0x-prefixed hex or 0-prefixed octal.
+func parseNumber(s string) (int32, bool) {
+	t := s
+	neg := false
+	if t != "" && (t[0] == '-' || t[0] == '+') {
+		neg = t[0] == '-'
+		t = t[1:]
+	}
+	base := 10
+	switch {
+	case len(t) > 2 && (t[:2] == "0x" || t[:2] == "0X"):
+		base, t = 16, t[2:]
+	case len(t) > 1 && t[0] == '0':
+		base, t = 8, t[1:]
+	}
+	u, err := strconv.ParseUint(t, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	n := int32(uint32(u))
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
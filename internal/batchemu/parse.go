@@ -0,0 +1,499 @@
+package batchemu
+
+import (
+	"errors"
+	"strings"
+)
+
+// node is one parsed command. Parsing follows cmd.exe's phase 2: carets and
+// quotes are resolved, the line is split on &, &&, || and parenthesised
+// blocks, and `if` headers are tokenised. Delayed !var! expansion is left for
+// execution time.
+type node interface {
+	line() int
+}
+
+type simpleCmd struct {
+	text string
+	ln   int
+	// expanded marks text that already went through delayed expansion, as
+	// the command produced by `call` does.
+	expanded bool
+	// stderr marks a command redirected with >&2 or 1>&2.
+	stderr bool
+}
+
+type blockCmd struct {
+	body []node
+	ln   int
+}
+
+type ifCmd struct {
+	not   bool
+	icase bool
+	kind  string // exist, defined, errorlevel, == or one of EQU NEQ LSS LEQ GTR GEQ
+	left  string
+	right string
+	then  node
+	els   node
+	ln    int
+}
+
+// forCmd is `for /f ["options"] %%v in (`command`) do body`, the only form
+// of for the emulator runs.
+type forCmd struct {
+	variable string // the loop variable, such as %l
+	command  string
+	body     node
+	ln       int
+}
+
+type chainCmd struct {
+	op    string // && or ||
+	left  node
+	right node
+	ln    int
+}
+
+type noopCmd struct {
+	ln int
+}
+
+func (c *simpleCmd) line() int { return c.ln }
+func (c *blockCmd) line() int  { return c.ln }
+func (c *ifCmd) line() int     { return c.ln }
+func (c *forCmd) line() int    { return c.ln }
+func (c *chainCmd) line() int  { return c.ln }
+func (c *noopCmd) line() int   { return c.ln }
+
+// bchar is one character after caret and quote processing.
+type bchar struct {
+	c       byte
+	special bool // unquoted, unescaped operator character
+	ln      int
+}
+
+// errIncomplete signals that a parenthesised block continues on later lines.
+var errIncomplete = errors.New("incomplete block")
+
+// lexLine applies caret escapes and quote tracking to one physical line.
+func lexLine(text string, ln int) []bchar {
+	var out []bchar
+	inQuote := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+			out = append(out, bchar{c: c, ln: ln})
+		case inQuote:
+			out = append(out, bchar{c: c, ln: ln})
+		case c == '^':
+			if i+1 < len(text) {
+				i++
+				out = append(out, bchar{c: text[i], ln: ln})
+			}
+		case strings.IndexByte("&|()<>", c) >= 0:
+			out = append(out, bchar{c: c, special: true, ln: ln})
+		default:
+			out = append(out, bchar{c: c, ln: ln})
+		}
+	}
+	return append(out, bchar{c: '\n', special: true, ln: ln})
+}
+
+type cmdParser struct {
+	chars []bchar
+	pos   int
+}
+
+// parseCommands parses a logical line. It returns errIncomplete when a block
+// is still open at the end of the input.
+func parseCommands(chars []bchar) ([]node, error) {
+	p := &cmdParser{chars: chars}
+	return p.parseSeq(0)
+}
+
+func (p *cmdParser) eof() bool { return p.pos >= len(p.chars) }
+
+func (p *cmdParser) lineNo() int {
+	if p.eof() {
+		if len(p.chars) == 0 {
+			return 0
+		}
+		return p.chars[len(p.chars)-1].ln
+	}
+	return p.chars[p.pos].ln
+}
+
+func (p *cmdParser) isSpecial(c byte) bool {
+	return p.isSpecialAt(p.pos, c)
+}
+
+func (p *cmdParser) isSpecialAt(i int, c byte) bool {
+	return i < len(p.chars) && p.chars[i].special && p.chars[i].c == c
+}
+
+func (p *cmdParser) peekChar() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.chars[p.pos].c
+}
+
+func (p *cmdParser) skipSpaces() {
+	for !p.eof() {
+		ch := p.chars[p.pos]
+		if ch.special || (ch.c != ' ' && ch.c != '\t') {
+			return
+		}
+		p.pos++
+	}
+}
+
+// skipCommandStart skips the delimiters cmd.exe ignores before a command.
+func (p *cmdParser) skipCommandStart(newlines bool) {
+	for !p.eof() {
+		ch := p.chars[p.pos]
+		switch {
+		case ch.special && ch.c == '\n' && newlines:
+		case !ch.special && strings.IndexByte(" \t;,@", ch.c) >= 0:
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *cmdParser) skipLine() {
+	for !p.eof() && !p.isSpecial('\n') {
+		p.pos++
+	}
+}
+
+// peekWord returns the lower-cased word at the cursor without consuming it.
+func (p *cmdParser) peekWord() string {
+	var b strings.Builder
+	for i := p.pos; i < len(p.chars); i++ {
+		ch := p.chars[i]
+		if ch.special || isDelim(ch.c) || ch.c == '(' {
+			break
+		}
+		b.WriteByte(ch.c)
+	}
+	return strings.ToLower(b.String())
+}
+
+// readToken reads one argument, keeping quoted sections intact.
+func (p *cmdParser) readToken() string {
+	var b strings.Builder
+	inQuote := false
+	for !p.eof() {
+		ch := p.chars[p.pos]
+		if ch.c == '"' {
+			inQuote = !inQuote
+		} else if !inQuote && (ch.special || isDelim(ch.c)) {
+			break
+		}
+		b.WriteByte(ch.c)
+		p.pos++
+	}
+	return b.String()
+}
+
+func (p *cmdParser) parseSeq(depth int) ([]node, error) {
+	var nodes []node
+	for {
+		p.skipCommandStart(true)
+		if p.eof() {
+			if depth > 0 {
+				return nil, errIncomplete
+			}
+			return nodes, nil
+		}
+		if p.isSpecial(')') {
+			if depth > 0 {
+				return nodes, nil
+			}
+			// A stray ')' outside a block makes cmd.exe ignore the line.
+			p.skipLine()
+			continue
+		}
+		n, err := p.parseChain(depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+		if p.isSpecial('&') {
+			p.pos++
+		}
+	}
+}
+
+func (p *cmdParser) parseChain(depth int) (node, error) {
+	left, err := p.parseCmd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.isSpecial('&') && p.isSpecialAt(p.pos+1, '&'):
+			op = "&&"
+		case p.isSpecial('|') && p.isSpecialAt(p.pos+1, '|'):
+			op = "||"
+		case p.isSpecial('|'):
+			return nil, errAt(p.lineNo(), "pipes are not supported")
+		default:
+			return left, nil
+		}
+		ln := p.lineNo()
+		p.pos += 2
+		p.skipCommandStart(false)
+		right, err := p.parseCmd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &chainCmd{op: op, left: left, right: right, ln: ln}
+	}
+}
+
+func (p *cmdParser) parseCmd(depth int) (node, error) {
+	p.skipCommandStart(false)
+	ln := p.lineNo()
+	if p.eof() || p.isSpecial('\n') {
+		return nil, errAt(ln, "the syntax of the command is incorrect")
+	}
+	if p.isSpecial('(') {
+		p.pos++
+		body, err := p.parseSeq(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !p.isSpecial(')') {
+			return nil, errIncomplete
+		}
+		p.pos++
+		return &blockCmd{body: body, ln: ln}, nil
+	}
+	if p.peekChar() == ':' {
+		p.skipLine()
+		return &noopCmd{ln: ln}, nil
+	}
+	switch p.peekWord() {
+	case "if":
+		return p.parseIf(depth)
+	case "for":
+		return p.parseFor(depth)
+	case "rem":
+		p.skipLine()
+		return &noopCmd{ln: ln}, nil
+	}
+	return p.parseSimple(depth)
+}
+
+func (p *cmdParser) parseSimple(depth int) (node, error) {
+	c := &simpleCmd{ln: p.lineNo()}
+	var b strings.Builder
+	for !p.eof() {
+		ch := p.chars[p.pos]
+		if ch.special {
+			switch ch.c {
+			case '\n', '&', '|':
+				c.text = b.String()
+				return c, nil
+			case ')':
+				if depth > 0 {
+					c.text = b.String()
+					return c, nil
+				}
+			case '<', '>':
+				if !p.toStderr(&b) {
+					return nil, errAt(c.ln, "redirection is not supported")
+				}
+				c.stderr = true
+				continue
+			}
+		}
+		b.WriteByte(ch.c)
+		p.pos++
+	}
+	c.text = b.String()
+	return c, nil
+}
+
+// toStderr consumes a >&2 or 1>&2 redirection at the current position,
+// removing the 1 from b, and reports whether there was one. Other
+// redirections are not supported.
+func (p *cmdParser) toStderr(b *strings.Builder) bool {
+	if p.pos+2 >= len(p.chars) || p.chars[p.pos].c != '>' || p.chars[p.pos+1].c != '&' || p.chars[p.pos+2].c != '2' {
+		return false
+	}
+	p.pos += 3
+	text := b.String()
+	if rest := strings.TrimSuffix(text, "1"); rest != text && strings.TrimRight(rest, " \t") != rest || text == "1" {
+		b.Reset()
+		b.WriteString(rest)
+	}
+	return true
+}
+
+func (p *cmdParser) parseIf(depth int) (node, error) {
+	c := &ifCmd{ln: p.lineNo()}
+	p.pos += len("if")
+	p.skipSpaces()
+	if p.peekWord() == "/i" {
+		c.icase = true
+		p.pos += 2
+		p.skipSpaces()
+	}
+	if p.peekWord() == "not" {
+		c.not = true
+		p.pos += 3
+		p.skipSpaces()
+	}
+	switch w := p.peekWord(); w {
+	case "exist", "defined", "errorlevel":
+		c.kind = w
+		p.pos += len(w)
+		p.skipSpaces()
+		c.left = p.readToken()
+	default:
+		c.left = p.readToken()
+		p.skipSpaces()
+		if p.peekChar() == '=' && p.pos+1 < len(p.chars) && p.chars[p.pos+1].c == '=' {
+			c.kind = "=="
+			for p.peekChar() == '=' {
+				p.pos++
+			}
+		} else {
+			op := strings.ToUpper(p.readToken())
+			switch op {
+			case "EQU", "NEQ", "LSS", "LEQ", "GTR", "GEQ":
+				c.kind = op
+			default:
+				return nil, errAt(c.ln, "%s was unexpected at this time", op)
+			}
+		}
+		p.skipSpaces()
+		c.right = p.readToken()
+	}
+	if c.left == "" || (c.kind != "exist" && c.kind != "defined" && c.kind != "errorlevel" && c.right == "") {
+		return nil, errAt(c.ln, "the syntax of the command is incorrect")
+	}
+	p.skipSpaces()
+
+	then, err := p.parseBody(depth)
+	if err != nil {
+		return nil, err
+	}
+	c.then = then
+	if _, ok := then.(*blockCmd); ok {
+		save := p.pos
+		p.skipSpaces()
+		if p.peekWord() == "else" {
+			p.pos += len("else")
+			p.skipSpaces()
+			els, err := p.parseBody(depth)
+			if err != nil {
+				return nil, err
+			}
+			c.els = els
+		} else {
+			p.pos = save
+		}
+	}
+	return c, nil
+}
+
+// parseFor parses a for /f loop over the output of a command. Only the
+// usebackq and empty delims= options are accepted, so each line is read
+// whole.
+func (p *cmdParser) parseFor(depth int) (node, error) {
+	c := &forCmd{ln: p.lineNo()}
+	p.pos += len("for")
+	p.skipSpaces()
+	if p.peekWord() != "/f" {
+		return nil, errAt(c.ln, "only for /f is supported")
+	}
+	p.pos += 2
+	p.skipSpaces()
+	if p.peekChar() == '"' {
+		opts := strings.Trim(p.readToken(), "\"")
+		if opts != "usebackq delims=" {
+			return nil, errAt(c.ln, "unsupported for /f options %q", opts)
+		}
+		p.skipSpaces()
+	}
+	c.variable = p.readToken()
+	if len(c.variable) != 2 || c.variable[0] != '%' {
+		return nil, errAt(c.ln, "%s was unexpected at this time", c.variable)
+	}
+	p.skipSpaces()
+	if p.peekWord() != "in" {
+		return nil, errAt(c.ln, "the syntax of the command is incorrect")
+	}
+	p.pos += len("in")
+	p.skipSpaces()
+	if !p.isSpecial('(') {
+		return nil, errAt(c.ln, "the syntax of the command is incorrect")
+	}
+	p.pos++
+	var b strings.Builder
+	for !p.isSpecial(')') {
+		if p.eof() {
+			return nil, errIncomplete
+		}
+		b.WriteByte(p.chars[p.pos].c)
+		p.pos++
+	}
+	p.pos++
+	in := strings.TrimSpace(b.String())
+	if len(in) < 2 || in[0] != '`' || in[len(in)-1] != '`' {
+		return nil, errAt(c.ln, "only for /f over a `command` is supported")
+	}
+	c.command = in[1 : len(in)-1]
+	p.skipSpaces()
+	if p.peekWord() != "do" {
+		return nil, errAt(c.ln, "the syntax of the command is incorrect")
+	}
+	p.pos += len("do")
+	p.skipSpaces()
+	body, err := p.parseBody(depth)
+	if err != nil {
+		return nil, err
+	}
+	c.body = body
+	return c, nil
+}
+
+// parseBody parses the command controlled by an if or else. A block ends the
+// body; otherwise every &-separated command up to the end of the line belongs
+// to it.
+func (p *cmdParser) parseBody(depth int) (node, error) {
+	first, err := p.parseChain(depth)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := first.(*blockCmd); ok {
+		return first, nil
+	}
+	body := []node{first}
+	for p.isSpecial('&') {
+		p.pos++
+		p.skipCommandStart(false)
+		n, err := p.parseChain(depth)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, n)
+	}
+	if len(body) == 1 {
+		return first, nil
+	}
+	return &blockCmd{body: body, ln: first.line()}, nil
+}
+
+func isDelim(c byte) bool {
+	return c == ' ' || c == '\t' || c == ',' || c == ';' || c == '=' || c == '\n'
+}
@@ -0,0 +1,86 @@
+// Package cmdexe holds the rules of cmd.exe that more than one part of Fin
+// has to follow exactly: the interpreter and the optimizer compute what the
+// generated batch would, and the emulator runs it.
+package cmdexe
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CompareStrings orders two strings the way the if command does for LSS,
+// GTR and the other ordering operators when they are not both numbers.
+// cmd.exe compares them by the user's locale rather than by code point; this
+// models the word sort of English locales: hyphens and apostrophes are
+// skipped, other punctuation sorts before digits and digits before letters,
+// and letters compare without case; then lowercase sorts before uppercase,
+// and last a string with fewer skipped characters sorts first. Punctuation
+// is ordered by code point, which the locale tables only roughly follow.
+// With icase, as for if /i, case is ignored throughout.
+func CompareStrings(a, b string, icase bool) int {
+	ka, kb := sortKey(a), sortKey(b)
+	for i := 0; i < len(ka) && i < len(kb); i++ {
+		if c := comparePrimary(ka[i], kb[i]); c != 0 {
+			return c
+		}
+	}
+	if len(ka) != len(kb) {
+		return compareInts(len(ka), len(kb))
+	}
+	if icase {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+	} else {
+		for i := range ka {
+			if ka[i] != kb[i] {
+				if unicode.IsLower(ka[i]) {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// sortKey returns the runes of s that the word sort compares first.
+func sortKey(s string) []rune {
+	var key []rune
+	for _, r := range s {
+		if r != '-' && r != '\'' {
+			key = append(key, r)
+		}
+	}
+	return key
+}
+
+// comparePrimary compares two runes ignoring case: punctuation, then
+// digits, then letters.
+func comparePrimary(a, b rune) int {
+	if ca, cb := runeClass(a), runeClass(b); ca != cb {
+		return compareInts(ca, cb)
+	}
+	return compareInts(int(unicode.ToLower(a)), int(unicode.ToLower(b)))
+}
+
+func runeClass(r rune) int {
+	switch {
+	case unicode.IsLetter(r):
+		return 2
+	case unicode.IsDigit(r):
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
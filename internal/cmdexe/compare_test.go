@@ -0,0 +1,29 @@
+package cmdexe
+
+import "testing"
+
+func TestCompareStrings(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		icase bool
+		want  int
+	}{
+		{"a", "B", false, -1},
+		{"B", "a", false, 1},
+		{"a", "A", false, -1},
+		{"a", "A", true, 0},
+		{"abc", "abc", false, 0},
+		{"a10", "a9", false, -1},
+		{"_x", "010", false, -1},
+		{"010", "a", false, -1},
+		{"coop", "co-op", false, -1},
+		{"co-op", "cop", false, -1},
+		{"coop", "co-op", true, -1},
+		{"ab", "abc", false, -1},
+	}
+	for _, tt := range tests {
+		if got := CompareStrings(tt.a, tt.b, tt.icase); got != tt.want {
+			t.Errorf("CompareStrings(%q, %q, %t) = %d, want %d", tt.a, tt.b, tt.icase, got, tt.want)
+		}
+	}
+}
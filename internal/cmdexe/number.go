@@ -0,0 +1,57 @@
+package cmdexe
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseInt reads a number the way `set /a` does: optional sign, then decimal,
+// 0x-prefixed hex or 0-prefixed octal. Values wrap to 32 bits.
+func ParseInt(s string) (int32, bool) {
+	t := strings.TrimSpace(s)
+	neg := false
+	if t != "" && (t[0] == '-' || t[0] == '+') {
+		neg = t[0] == '-'
+		t = t[1:]
+	}
+	base := 10
+	switch {
+	case len(t) > 2 && (t[:2] == "0x" || t[:2] == "0X"):
+		base, t = 16, t[2:]
+	case len(t) > 1 && t[0] == '0':
+		base, t = 8, t[1:]
+	}
+	u, err := strconv.ParseUint(t, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	n := int32(uint32(u))
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// Pow raises base to exp, reporting false when the result does not fit in
+// 32 bits. Negative exponents truncate toward zero like integer division.
+func Pow(base, exp int32) (int32, bool) {
+	switch {
+	case base == 1:
+		return 1, true
+	case base == -1:
+		if exp%2 == 0 {
+			return 1, true
+		}
+		return -1, true
+	case exp < 0, base == 0 && exp > 0:
+		return 0, true
+	}
+	n := int64(1)
+	for ; exp > 0; exp-- {
+		n *= int64(base)
+		if n != int64(int32(n)) {
+			return 0, false
+		}
+	}
+	return int32(n), true
+}
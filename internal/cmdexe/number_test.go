@@ -0,0 +1,51 @@
+package cmdexe
+
+import "testing"
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int32
+		ok   bool
+	}{
+		{"42", 42, true},
+		{" -7 ", -7, true},
+		{"+010", 8, true},
+		{"0x1F", 31, true},
+		{"0", 0, true},
+		{"4294967295", -1, true},
+		{"4294967296", 0, false},
+		{"08", 0, false},
+		{"12abc", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseInt(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseInt(%q) = %d, %t, want %d, %t", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPow(t *testing.T) {
+	tests := []struct {
+		base, exp int32
+		want      int32
+		ok        bool
+	}{
+		{2, 10, 1024, true},
+		{-2, 3, -8, true},
+		{-1, 7, -1, true},
+		{1, -5, 1, true},
+		{2, -1, 0, true},
+		{0, 0, 1, true},
+		{2, 31, 0, false},
+		{-2, 31, -2147483648, true},
+	}
+	for _, tt := range tests {
+		got, ok := Pow(tt.base, tt.exp)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Pow(%d, %d) = %d, %t, want %d, %t", tt.base, tt.exp, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/difftest"
+	"github.com/vishnunath-suresh/fin-project/internal/generator"
+	"github.com/vishnunath-suresh/fin-project/internal/interp"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Error codes. They are stable so that documentation and tooling can refer
+// to them.
+const (
+	CodeSyntax            = "E0001"
+	CodeUndefined         = "E0002"
+	CodeDuplicateFunction = "E0003"
+	CodeArity             = "E0004"
+	CodeReservedName      = "E0005"
+	CodeRedefinition      = "E0006"
+	CodeDepthExceeded     = "E0007"
+	CodeReturnOutsideFn   = "E0008"
+	CodeImport            = "E0009"
+	CodeImportTopLevel    = "E0010"
+	CodeBuiltinUsage      = "E0011"
+	CodeType              = "E0012"
+	CodeParams            = "E0013"
+	CodeRunLine           = "E0014"
+	CodeUnsupported       = "E0100"
+	CodeRuntime           = "E0200"
+	CodeBehaviourDiverges = "E0300"
+)
+
+// codeTitles describes each error code, for tools that list the rules.
+var codeTitles = map[string]string{
+	CodeSyntax:            "Syntax error",
+	CodeUndefined:         "Undefined variable or function",
+	CodeDuplicateFunction: "Function defined more than once",
+	CodeArity:             "Wrong number of arguments",
+	CodeReservedName:      "Reserved name used as an identifier",
+	CodeRedefinition:      "Name redefined or shadowed",
+	CodeDepthExceeded:     "Nesting depth limit exceeded",
+	CodeReturnOutsideFn:   "Return outside a function",
+	CodeImport:            "Import cannot be resolved",
+	CodeImportTopLevel:    "Statement other than a function in an imported file",
+	CodeBuiltinUsage:      "Built-in function used in an unsupported way",
+	CodeType:              "Value used in a way its type does not allow",
+	CodeParams:            "Script parameter declared in an unsupported way",
+	CodeRunLine:           "Command line that cannot be written to a batch file",
+	CodeUnsupported:       "Construct the batch generator cannot lower",
+	CodeRuntime:           "Runtime error",
+	CodeBehaviourDiverges: "Interpreter and batch behaviour differ",
+}
+
+// FromError converts err into diagnostics. Joined errors, such as the ones
+// the parser and analyzer collect, become one diagnostic each. Errors found
+// in an imported file carry its name and source; errors at a position in an
+// imported function of a linked program, such as runtime errors, carry only
+// its name.
+func FromError(err error) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []Diagnostic
+		for _, e := range j.Unwrap() {
+			out = append(out, FromError(e)...)
+		}
+		return out
+	}
+	if fe, ok := err.(*module.FileError); ok {
+		out := FromError(fe.Err)
+		for i := range out {
+			out[i].File, out[i].Source = fe.File, fe.Source
+		}
+		return out
+	}
+	d := convert(err)
+	d.File = d.Primary.P.File
+	return []Diagnostic{d}
+}
+
+func convert(err error) Diagnostic {
+	var (
+		pe *parser.ParseError
+		ge *generator.GeneratorError
+		re *interp.RuntimeError
+		cf *interp.CommandFailedError
+		dv *difftest.Divergence
+	)
+	switch {
+	case errors.As(err, &pe):
+		return Diagnostic{Code: CodeSyntax, Msg: pe.Msg,
+			Primary: Label{P: pe.Pos(), Msg: "found " + pe.Found()}}
+	case errors.As(err, &ge):
+		return Diagnostic{Code: CodeUnsupported, Msg: ge.Msg, Primary: Label{P: ge.Pos}}
+	case errors.As(err, &re):
+		return Diagnostic{Code: CodeRuntime, Msg: re.Msg, Primary: Label{P: re.P}}
+	case errors.As(err, &cf):
+		return Diagnostic{Code: CodeRuntime, Msg: cf.Msg(), Primary: Label{P: cf.P},
+			Help: "wrap the command in `unchecked ... end` if it is allowed to fail"}
+	case errors.As(err, &dv):
+		return Diagnostic{Code: CodeBehaviourDiverges, Msg: fmt.Sprintf("%s divergence: %s", dv.Kind, dv.Msg),
+			Primary: Label{P: dv.P},
+			Notes: []string{
+				fmt.Sprintf("interpreter: %q", dv.Interp),
+				fmt.Sprintf("batch:       %q", dv.Batch),
+			}}
+	}
+	switch e := err.(type) {
+	case *module.ImportError:
+		return Diagnostic{Code: CodeImport, Msg: fmt.Sprintf("cannot import %q: %s", e.Path, e.Msg),
+			Primary: Label{P: e.P}}
+	case *module.ImportCycleError:
+		return Diagnostic{Code: CodeImport, Msg: "import cycle",
+			Primary: Label{P: e.P, Msg: "imported here"},
+			Notes:   []string{strings.Join(e.Chain, " imports ")}}
+	case *module.TopLevelError:
+		return Diagnostic{Code: CodeImportTopLevel, Msg: "imported files may only declare functions",
+			Primary: Label{P: e.P},
+			Help:    "move the statement into a function, or into the file that imports this one"}
+	case sema.UndefinedVariableError:
+		return Diagnostic{Code: CodeUndefined, Msg: fmt.Sprintf("undefined name %q", e.Name),
+			Primary: Label{P: e.P, Msg: "not declared in this scope"},
+			Help:    fmt.Sprintf("declare it first, e.g. `set %s <value>` or `fn %s ... end`", e.Name, e.Name)}
+	case sema.DuplicateFunctionError:
+		return Diagnostic{Code: CodeDuplicateFunction, Msg: fmt.Sprintf("function %q is already defined", e.Name),
+			Primary: Label{P: e.P, Msg: "redefined here"},
+			Related: related(e.Def, "first defined here"),
+			Help:    "function names must be unique; rename one of them"}
+	case sema.InvalidArityError:
+		return Diagnostic{Code: CodeArity, Msg: fmt.Sprintf("wrong number of arguments to %q", e.Name),
+			Primary: Label{P: e.P, Msg: fmt.Sprintf("expected %d, got %d", e.Expected, e.Got)}}
+	case sema.ReservedNameError:
+		return Diagnostic{Code: CodeReservedName, Msg: fmt.Sprintf("%q is a reserved name", e.Name),
+			Primary: Label{P: e.P},
+			Help:    "choose a different identifier"}
+	case sema.ShadowingError:
+		return Diagnostic{Code: CodeRedefinition, Msg: fmt.Sprintf("name %q is already defined", e.Name),
+			Primary: Label{P: e.P, Msg: "redefined here"},
+			Related: related(e.Def, "originally defined here"),
+			Help:    fmt.Sprintf("names cannot be redefined or shadowed; assign to it with `%s = <value>` instead", e.Name)}
+	case sema.DepthExceededError:
+		return Diagnostic{Code: CodeDepthExceeded, Msg: fmt.Sprintf("nesting exceeds the depth limit of %d", e.Limit),
+			Primary: Label{P: e.P}}
+	case sema.BuiltinUsageError:
+		return Diagnostic{Code: CodeBuiltinUsage, Msg: fmt.Sprintf("built-in %q %s", e.Name, e.Msg),
+			Primary: Label{P: e.P}}
+	case sema.ParamError:
+		return Diagnostic{Code: CodeParams, Msg: fmt.Sprintf("parameter %q %s", e.Name, e.Msg),
+			Primary: Label{P: e.P}}
+	case sema.RunError:
+		return Diagnostic{Code: CodeRunLine, Msg: e.Msg,
+			Primary: Label{P: e.P},
+			Help:    "run the command once per line, or pass the text through a file"}
+	case sema.TypeError:
+		label := fmt.Sprintf("%q is a %s", e.Name, e.Got)
+		if e.Name == "" {
+			label = "this is a " + e.Got.String()
+		}
+		return Diagnostic{Code: CodeType, Msg: fmt.Sprintf("%s needs a %s", e.Use, sema.JoinTypes(e.Want)),
+			Primary: Label{P: e.P, Msg: label}}
+	case sema.ReturnOutsideFunctionError:
+		return Diagnostic{Code: CodeReturnOutsideFn, Msg: "return outside of a function",
+			Primary: Label{P: e.P},
+			Help:    "return is only allowed inside `fn ... end`"}
+	}
+	d := Diagnostic{Msg: err.Error()}
+	if p, ok := err.(interface{ Pos() ast.Pos }); ok {
+		d.Primary.P = p.Pos()
+	}
+	return d
+}
+
+func related(pos ast.Pos, msg string) []Label {
+	if pos.Line == 0 {
+		return nil
+	}
+	return []Label{{P: pos, Msg: msg}}
+}
@@ -0,0 +1,278 @@
+// Package diag renders compiler diagnostics against the source they refer to:
+// a header with the severity and error code, the location, the offending
+// lines with the span underlined, secondary labels, and help text.
+//
+//	error[E0006]: name "x" is already defined
+//	 --> script.fin:4:5
+//	  |
+//	1 | set x 1
+//	  | --- originally defined here
+//	...
+//	4 |     set x 2
+//	  |     ^^^ redefined here
+//	  |
+//	  = help: names cannot be redefined or shadowed; assign to it with `x = <value>` instead
+package diag
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// Severity classifies a diagnostic.
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Label marks a span of source with an optional message.
+type Label struct {
+	P   ast.Pos
+	Len int // columns to underline; 0 measures the token at P
+	Msg string
+}
+
+// Diagnostic is one reportable problem.
+type Diagnostic struct {
+	Severity Severity
+	Code     string // e.g. "E0006"; empty when the problem has no code
+	Msg      string
+	Primary  Label   // a zero position means the problem has no location
+	Related  []Label // secondary spans, e.g. an earlier definition
+	Notes    []string
+	Help     string
+	// File is the file the positions refer to, when it is not the one being
+	// reported on, such as an imported file; Source is its text.
+	File   string
+	Source string
+}
+
+// Printer renders diagnostics for one source file.
+type Printer struct {
+	File  string
+	Color bool
+	lines source
+}
+
+// NewPrinter returns a Printer for file. src may be empty when the source is
+// unavailable, in which case snippets are omitted.
+func NewPrinter(file, src string, color bool) *Printer {
+	return &Printer{File: file, Color: color, lines: newSource(src)}
+}
+
+// source is a file split into lines, for locating the spans labels cover.
+type source []string
+
+func newSource(src string) source {
+	if src == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+}
+
+// span returns the 1-based column where l starts, clamped to its line, and
+// the number of columns it covers.
+func (s source) span(l Label) (col, width int) {
+	var line []rune
+	if l.P.Line > 0 && l.P.Line <= len(s) {
+		line = []rune(s[l.P.Line-1])
+	}
+	col = min(max(l.P.Column, 1), len(line)+1)
+	width = l.Len
+	if width <= 0 {
+		width = tokenWidth(line, col-1)
+	}
+	return col, width
+}
+
+// end returns the position just past the span l covers.
+func (s source) end(l Label) ast.Pos {
+	col, width := s.span(l)
+	return ast.Pos{Line: l.P.Line, Column: col + width}
+}
+
+// End returns the position just past the span l covers in the printer's
+// source.
+func (p *Printer) End(l Label) ast.Pos {
+	return p.lines.end(l)
+}
+
+const (
+	bold   = "\x1b[1m"
+	red    = "\x1b[31m"
+	yellow = "\x1b[33m"
+	blue   = "\x1b[34m"
+	reset  = "\x1b[0m"
+)
+
+func (p *Printer) paint(s string, codes ...string) string {
+	if !p.Color || s == "" {
+		return s
+	}
+	return strings.Join(codes, "") + s + reset
+}
+
+func (p *Printer) severityColor(sev Severity) string {
+	if sev == Warning {
+		return yellow
+	}
+	return red
+}
+
+// Fprint writes d to w.
+func (p *Printer) Fprint(w io.Writer, d Diagnostic) {
+	if d.File != "" && d.File != p.File {
+		other := NewPrinter(d.File, d.Source, p.Color)
+		d.File, d.Source = "", ""
+		other.Fprint(w, d)
+		return
+	}
+	if d.Severity == "" {
+		d.Severity = Error
+	}
+	head := string(d.Severity)
+	if d.Code != "" {
+		head += "[" + d.Code + "]"
+	}
+	fmt.Fprintf(w, "%s%s\n", p.paint(head, bold, p.severityColor(d.Severity)), p.paint(": "+d.Msg, bold))
+
+	labels := p.visible(d)
+	gutter := 1
+	for _, l := range labels {
+		gutter = max(gutter, len(strconv.Itoa(l.P.Line)))
+	}
+	pad := strings.Repeat(" ", gutter)
+	bar := p.paint("|", blue)
+
+	if d.Primary.P.Line > 0 {
+		fmt.Fprintf(w, "%s%s %s:%d:%d\n", pad, p.paint("-->", blue), p.File, d.Primary.P.Line, d.Primary.P.Column)
+	}
+	if len(labels) > 0 {
+		fmt.Fprintf(w, "%s %s\n", pad, bar)
+		prev := 0
+		for _, l := range labels {
+			if l.P.Line != prev {
+				if prev != 0 && l.P.Line > prev+1 {
+					fmt.Fprintln(w, p.paint("...", blue))
+				}
+				text := p.lines[l.P.Line-1]
+				fmt.Fprintf(w, "%s %s %s\n", p.paint(fmt.Sprintf("%*d", gutter, l.P.Line), blue), bar, strings.TrimRight(expandTabs(text), " "))
+				prev = l.P.Line
+			}
+			p.underline(w, pad, bar, l)
+		}
+	}
+	if len(d.Notes) > 0 || d.Help != "" {
+		if len(labels) > 0 {
+			fmt.Fprintf(w, "%s %s\n", pad, bar)
+		}
+		for _, n := range d.Notes {
+			fmt.Fprintf(w, "%s %s note: %s\n", pad, p.paint("=", blue), n)
+		}
+		if d.Help != "" {
+			fmt.Fprintf(w, "%s %s help: %s\n", pad, p.paint("=", blue), d.Help)
+		}
+	}
+}
+
+// FprintAll writes every diagnostic in ds to w, separated by blank lines.
+func (p *Printer) FprintAll(w io.Writer, ds []Diagnostic) {
+	for i, d := range ds {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		p.Fprint(w, d)
+	}
+}
+
+// shownLabel is a label that can be drawn against the source.
+type shownLabel struct {
+	Label
+	primary bool
+}
+
+// visible returns the labels whose lines exist in the source, ordered by
+// position with the primary label first among equals.
+func (p *Printer) visible(d Diagnostic) []shownLabel {
+	var out []shownLabel
+	add := func(l Label, primary bool) {
+		if l.P.Line > 0 && l.P.Line <= len(p.lines) {
+			out = append(out, shownLabel{Label: l, primary: primary})
+		}
+	}
+	add(d.Primary, true)
+	for _, l := range d.Related {
+		add(l, false)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].P, out[j].P
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return out
+}
+
+// underline draws the marker row for l beneath its source line.
+func (p *Printer) underline(w io.Writer, pad, bar string, l shownLabel) {
+	line := []rune(p.lines[l.P.Line-1])
+	col, width := p.lines.span(l.Label)
+	mark, color := "-", blue
+	if l.primary {
+		mark, color = "^", red
+	}
+	offset := len(expandTabs(string(line[:col-1])))
+	marks := p.paint(strings.Repeat(mark, width), bold, color)
+	if l.Msg != "" {
+		marks += " " + p.paint(l.Msg, bold, color)
+	}
+	fmt.Fprintf(w, "%s %s %s%s\n", pad, bar, strings.Repeat(" ", offset), marks)
+}
+
+// tokenWidth measures the Fin token starting at line[i]. Positions past the
+// end of the line, such as a missing token at end of line, get one column.
+func tokenWidth(line []rune, i int) int {
+	if i >= len(line) {
+		return 1
+	}
+	j := i
+	switch c := line[i]; {
+	case c == '"':
+		for j++; j < len(line) && line[j] != '"'; j++ {
+			if line[j] == '\\' {
+				j++
+			}
+		}
+		j = min(j+1, len(line))
+	case c == '$' || isWord(c):
+		for j++; j < len(line) && isWord(line[j]); j++ {
+		}
+	default:
+		j++
+		if j < len(line) {
+			switch string(line[i : j+1]) {
+			case "==", "!=", "<=", ">=", "&&", "||", "**", "..",
+				"<<", ">>", "+=", "-=", "*=", "/=", "%=", "++", "--":
+				j++
+			}
+		}
+	}
+	return j - i
+}
+
+func isWord(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", "    ")
+}
@@ -0,0 +1,221 @@
+package diag
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+type goldenCase struct {
+	name     string
+	src      string
+	expected string
+}
+
+func TestRender_Golden(t *testing.T) {
+	cases := []goldenCase{
+		{
+			name: "parse_error_at_end_of_line",
+			src:  "set\n",
+			expected: "error[E0001]: expected identifier after set\n" +
+				" --> script.fin:1:4\n" +
+				"  |\n" +
+				"1 | set\n" +
+				"  |    ^ found end of line\n",
+		},
+		{
+			name: "parse_errors_are_reported_separately",
+			src:  "echo \"ok\"\nfor i 1..2\nrun 5\n",
+			expected: "error[E0001]: expected in after for variable\n" +
+				" --> script.fin:2:7\n" +
+				"  |\n" +
+				"2 | for i 1..2\n" +
+				"  |       ^ found number 1\n" +
+				"\n" +
+				"error[E0001]: expected string after run\n" +
+				" --> script.fin:3:5\n" +
+				"  |\n" +
+				"3 | run 5\n" +
+				"  |     ^ found number 5\n",
+		},
+		{
+			name: "shadowing_with_original_definition",
+			src:  "set x 1\nif $x == 1\n\tset x 2\nend\n",
+			expected: "error[E0006]: name \"x\" is already defined\n" +
+				" --> script.fin:3:2\n" +
+				"  |\n" +
+				"1 | set x 1\n" +
+				"  | --- originally defined here\n" +
+				"...\n" +
+				"3 |     set x 2\n" +
+				"  |     ^^^ redefined here\n" +
+				"  |\n" +
+				"  = help: names cannot be redefined or shadowed; assign to it with `x = <value>` instead\n",
+		},
+		{
+			name: "duplicate_function",
+			src:  "fn f\nend\nfn f\nend\n",
+			expected: "error[E0003]: function \"f\" is already defined\n" +
+				" --> script.fin:3:1\n" +
+				"  |\n" +
+				"1 | fn f\n" +
+				"  | -- first defined here\n" +
+				"...\n" +
+				"3 | fn f\n" +
+				"  | ^^ redefined here\n" +
+				"  |\n" +
+				"  = help: function names must be unique; rename one of them\n",
+		},
+		{
+			name: "wide_gutter_and_token_width",
+			src:  strings.Repeat("\n", 9) + "echo \"value: $missing\" $missing\n",
+			expected: "error[E0002]: undefined name \"missing\"\n" +
+				"  --> script.fin:10:14\n" +
+				"   |\n" +
+				"10 | echo \"value: $missing\" $missing\n" +
+				"   |              ^^^^^^^^ not declared in this scope\n" +
+				"   |\n" +
+				"   = help: declare it first, e.g. `set missing <value>` or `fn missing ... end`\n" +
+				"\n" +
+				"error[E0002]: undefined name \"missing\"\n" +
+				"  --> script.fin:10:24\n" +
+				"   |\n" +
+				"10 | echo \"value: $missing\" $missing\n" +
+				"   |                        ^^^^^^^^ not declared in this scope\n" +
+				"   |\n" +
+				"   = help: declare it first, e.g. `set missing <value>` or `fn missing ... end`\n",
+		},
+		{
+			name: "arity",
+			src:  "fn f a\nend\nf 1 2\n",
+			expected: "error[E0004]: wrong number of arguments to \"f\"\n" +
+				" --> script.fin:3:1\n" +
+				"  |\n" +
+				"3 | f 1 2\n" +
+				"  | ^ expected 1, got 2\n",
+		},
+		{
+			name: "builtin_usage",
+			src:  "set s \"a,b\"\necho (split $s \",\")\n",
+			expected: "error[E0011]: built-in \"split\" returns a list, which can only be assigned to a variable\n" +
+				" --> script.fin:2:7\n" +
+				"  |\n" +
+				"2 | echo (split $s \",\")\n" +
+				"  |       ^^^^^\n",
+		},
+		{
+			name: "type",
+			src:  "set name \"bob\"\nset n $name + 1\n",
+			expected: "error[E0012]: operator + needs a number or decimal\n" +
+				" --> script.fin:2:7\n" +
+				"  |\n" +
+				"2 | set n $name + 1\n" +
+				"  |       ^^^^^ \"name\" is a string\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			NewPrinter("script.fin", tc.src, false).FprintAll(&b, FromError(analyze(tc.src)))
+			if b.String() != tc.expected {
+				t.Fatalf("golden mismatch\n--- got ---\n%s\n--- want ---\n%s", b.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestRender_WithoutSourceOrPosition(t *testing.T) {
+	var b strings.Builder
+	p := NewPrinter("script.fin", "", false)
+	p.Fprint(&b, Diagnostic{Msg: "input must have .fin extension"})
+	p.Fprint(&b, Diagnostic{Code: CodeRuntime, Msg: "division by zero", Primary: Label{P: ast.Pos{Line: 2, Column: 9}},
+		Help: "check the divisor"})
+	want := "error: input must have .fin extension\n" +
+		"error[E0200]: division by zero\n" +
+		" --> script.fin:2:9\n" +
+		"  = help: check the divisor\n"
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRender_Color(t *testing.T) {
+	var b strings.Builder
+	NewPrinter("script.fin", "return 1\n", true).FprintAll(&b, FromError(analyze("return 1\n")))
+	want := "\x1b[1m\x1b[31merror[E0008]\x1b[0m\x1b[1m: return outside of a function\x1b[0m\n" +
+		" \x1b[34m-->\x1b[0m script.fin:1:1\n" +
+		"  \x1b[34m|\x1b[0m\n" +
+		"\x1b[34m1\x1b[0m \x1b[34m|\x1b[0m return 1\n" +
+		"  \x1b[34m|\x1b[0m \x1b[1m\x1b[31m^^^^^^\x1b[0m\n" +
+		"  \x1b[34m|\x1b[0m\n" +
+		"  \x1b[34m=\x1b[0m help: return is only allowed inside `fn ... end`\n"
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant:\n%q", b.String(), want)
+	}
+}
+
+func TestRender_ImportedFile(t *testing.T) {
+	files := map[string]string{
+		"main.fin":  "import \"lib/a.fin\"\nimport \"lib/b.fin\"\n",
+		"lib/a.fin": "fn greet\n    shout\nend\n",
+	}
+	loader := &module.Loader{ReadFile: func(path string) ([]byte, error) {
+		src, ok := files[filepath.ToSlash(path)]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(src), nil
+	}}
+	_, err := loader.Load("main.fin")
+	var b strings.Builder
+	NewPrinter("main.fin", files["main.fin"], false).FprintAll(&b, FromError(err))
+	want := "error[E0002]: undefined name \"shout\"\n" +
+		" --> lib/a.fin:2:5\n" +
+		"  |\n" +
+		"2 |     shout\n" +
+		"  |     ^^^^^ not declared in this scope\n" +
+		"  |\n" +
+		"  = help: declare it first, e.g. `set shout <value>` or `fn shout ... end`\n" +
+		"\n" +
+		"error[E0009]: cannot import \"lib/b.fin\": cannot read lib/b.fin: file does not exist\n" +
+		" --> main.fin:2:1\n" +
+		"  |\n" +
+		"2 | import \"lib/b.fin\"\n" +
+		"  | ^^^^^^\n"
+	if filepath.ToSlash(b.String()) != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestFromError_Fallback(t *testing.T) {
+	ds := FromError(errors.Join(errors.New("plain"), posError{ast.Pos{Line: 3, Column: 4}}))
+	if len(ds) != 2 || ds[0].Msg != "plain" || ds[0].Code != "" {
+		t.Fatalf("unexpected diagnostics %+v", ds)
+	}
+	if ds[1].Primary.P != (ast.Pos{Line: 3, Column: 4}) {
+		t.Fatalf("fallback should keep Pos(), got %+v", ds[1])
+	}
+}
+
+type posError struct{ p ast.Pos }
+
+func (e posError) Error() string { return "positioned" }
+func (e posError) Pos() ast.Pos  { return e.p }
+
+// analyze runs the front end and returns its errors.
+func analyze(src string) error {
+	p := parser.New(parser.CollectTokens(lexer.New(src)))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return sema.New().Analyze(prog)
+}
@@ -0,0 +1,209 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/version"
+)
+
+// Formats accepted by Write.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+)
+
+// Write renders ds for file in the given format. Text goes through a Printer;
+// the machine-readable formats always produce a document, even when ds is
+// empty, so a clean run can be told apart from a crashed one.
+func Write(w io.Writer, format, file, src string, ds []Diagnostic, color bool) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, file, newSource(src), ds)
+	case FormatSARIF:
+		return writeSARIF(w, file, newSource(src), ds)
+	default:
+		NewPrinter(file, src, color).FprintAll(w, ds)
+		return nil
+	}
+}
+
+// jsonDiagnostic is the JSON form of a Diagnostic. Columns count runes from
+// 1, and end positions are exclusive.
+type jsonDiagnostic struct {
+	File      string      `json:"file"`
+	Line      int         `json:"line,omitempty"`
+	Column    int         `json:"column,omitempty"`
+	EndLine   int         `json:"endLine,omitempty"`
+	EndColumn int         `json:"endColumn,omitempty"`
+	Severity  Severity    `json:"severity"`
+	Code      string      `json:"code,omitempty"`
+	Message   string      `json:"message"`
+	Related   []jsonLabel `json:"related,omitempty"`
+	Notes     []string    `json:"notes,omitempty"`
+	Help      string      `json:"help,omitempty"`
+}
+
+type jsonLabel struct {
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Message   string `json:"message,omitempty"`
+}
+
+func writeJSON(w io.Writer, file string, src source, ds []Diagnostic) error {
+	out := make([]jsonDiagnostic, 0, len(ds))
+	for _, d := range ds {
+		file, src := locate(file, src, d)
+		jd := jsonDiagnostic{File: file, Severity: severity(d), Code: d.Code, Message: d.Msg, Notes: d.Notes, Help: d.Help}
+		if d.Primary.P.Line > 0 {
+			end := src.end(d.Primary)
+			jd.Line, jd.Column, jd.EndLine, jd.EndColumn = d.Primary.P.Line, d.Primary.P.Column, end.Line, end.Column
+		}
+		for _, l := range d.Related {
+			end := src.end(l)
+			jd.Related = append(jd.Related, jsonLabel{Line: l.P.Line, Column: l.P.Column, EndLine: end.Line, EndColumn: end.Column, Message: l.Msg})
+		}
+		out = append(out, jd)
+	}
+	return encode(w, out)
+}
+
+// SARIF 2.1.0, reduced to the properties code scanning consumes.
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name    string      `json:"name"`
+		Version string      `json:"version"`
+		Rules   []sarifRule `json:"rules,omitempty"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifResult struct {
+		RuleID           string          `json:"ruleId,omitempty"`
+		Level            string          `json:"level"`
+		Message          sarifMessage    `json:"message"`
+		Locations        []sarifLocation `json:"locations,omitempty"`
+		RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		ID               int                   `json:"id,omitempty"`
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+		Message          *sarifMessage         `json:"message,omitempty"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifact `json:"artifactLocation"`
+		Region           sarifRegion   `json:"region"`
+	}
+	sarifArtifact struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndLine     int `json:"endLine"`
+		EndColumn   int `json:"endColumn"`
+	}
+)
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+func writeSARIF(w io.Writer, file string, src source, ds []Diagnostic) error {
+	location := func(d Diagnostic, l Label) sarifPhysicalLocation {
+		file, src := locate(file, src, d)
+		end := src.end(l)
+		return sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifact{URI: artifactURI(file)},
+			Region:           sarifRegion{StartLine: l.P.Line, StartColumn: l.P.Column, EndLine: end.Line, EndColumn: end.Column},
+		}
+	}
+	results := make([]sarifResult, 0, len(ds))
+	codes := make(map[string]bool)
+	for _, d := range ds {
+		text := d.Msg
+		if d.Help != "" {
+			text += "\nhelp: " + d.Help
+		}
+		r := sarifResult{RuleID: d.Code, Level: string(severity(d)), Message: sarifMessage{Text: text}}
+		if d.Primary.P.Line > 0 {
+			r.Locations = []sarifLocation{{PhysicalLocation: location(d, d.Primary)}}
+		}
+		for i, l := range d.Related {
+			r.RelatedLocations = append(r.RelatedLocations, sarifLocation{ID: i + 1, PhysicalLocation: location(d, l), Message: &sarifMessage{Text: l.Msg}})
+		}
+		if d.Code != "" {
+			codes[d.Code] = true
+		}
+		results = append(results, r)
+	}
+	var rules []sarifRule
+	for code := range codes {
+		rules = append(rules, sarifRule{ID: code, ShortDescription: sarifMessage{Text: codeTitles[code]}})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return encode(w, sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "fin", Version: version.Version, Rules: rules}},
+			Results: results,
+		}},
+	})
+}
+
+// locate returns the file the positions of d refer to and its source.
+func locate(file string, src source, d Diagnostic) (string, source) {
+	if d.File != "" && d.File != file {
+		return d.File, newSource(d.Source)
+	}
+	return file, src
+}
+
+// artifactURI turns a file path into a SARIF artifact URI: a relative
+// reference for relative paths and a file URI for absolute ones.
+func artifactURI(file string) string {
+	p := filepath.ToSlash(file)
+	if !filepath.IsAbs(file) {
+		return (&url.URL{Path: p}).String()
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // Windows drive letter
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+func severity(d Diagnostic) Severity {
+	if d.Severity == "" {
+		return Error
+	}
+	return d.Severity
+}
+
+func encode(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
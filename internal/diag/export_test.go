@@ -0,0 +1,118 @@
+package diag
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/version"
+)
+
+func TestWrite_JSON(t *testing.T) {
+	src := "set x 1\nif $x == 1\n\tset x 2\nend\n"
+	var b strings.Builder
+	if err := Write(&b, FormatJSON, "script.fin", src, FromError(analyze(src)), false); err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "file": "script.fin",
+    "line": 3,
+    "column": 2,
+    "endLine": 3,
+    "endColumn": 5,
+    "severity": "error",
+    "code": "E0006",
+    "message": "name \"x\" is already defined",
+    "related": [
+      {
+        "line": 1,
+        "column": 1,
+        "endLine": 1,
+        "endColumn": 4,
+        "message": "originally defined here"
+      }
+    ],
+    "help": "names cannot be redefined or shadowed; assign to it with ` + "`x = <value>`" + ` instead"
+  }
+]
+`
+	if b.String() != want {
+		t.Fatalf("golden mismatch\n--- got ---\n%s\n--- want ---\n%s", b.String(), want)
+	}
+}
+
+func TestWrite_JSONEmpty(t *testing.T) {
+	var b strings.Builder
+	if err := Write(&b, FormatJSON, "script.fin", "echo 1\n", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "[]\n" {
+		t.Fatalf("expected empty array, got %q", b.String())
+	}
+}
+
+func TestWrite_SARIF(t *testing.T) {
+	src := "fn f\nend\nfn f\nend\nreturn 1\n"
+	var b strings.Builder
+	if err := Write(&b, FormatSARIF, "dir/my script.fin", src, FromError(analyze(src)), false); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal([]byte(b.String()), &log); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b.String())
+	}
+	if log.Version != "2.1.0" || log.Schema != sarifSchema || len(log.Runs) != 1 {
+		t.Fatalf("unexpected log header: %+v", log)
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "fin" || run.Tool.Driver.Version != version.Version {
+		t.Fatalf("unexpected driver: %+v", run.Tool.Driver)
+	}
+	var rules []string
+	for _, r := range run.Tool.Driver.Rules {
+		rules = append(rules, r.ID+" "+r.ShortDescription.Text)
+	}
+	if got := strings.Join(rules, "; "); got != "E0003 Function defined more than once; E0008 Return outside a function" {
+		t.Fatalf("unexpected rules: %s", got)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", run.Results)
+	}
+	dup := run.Results[0]
+	if dup.RuleID != CodeDuplicateFunction || dup.Level != "error" ||
+		dup.Message.Text != "function \"f\" is already defined\nhelp: function names must be unique; rename one of them" {
+		t.Fatalf("unexpected result: %+v", dup)
+	}
+	loc := dup.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "dir/my%20script.fin" || loc.Region != (sarifRegion{StartLine: 3, StartColumn: 1, EndLine: 3, EndColumn: 3}) {
+		t.Fatalf("unexpected location: %+v", loc)
+	}
+	if len(dup.RelatedLocations) != 1 || dup.RelatedLocations[0].ID != 1 ||
+		dup.RelatedLocations[0].Message.Text != "first defined here" ||
+		dup.RelatedLocations[0].PhysicalLocation.Region.StartLine != 1 {
+		t.Fatalf("unexpected related locations: %+v", dup.RelatedLocations)
+	}
+}
+
+func TestWrite_SARIFEmpty(t *testing.T) {
+	var b strings.Builder
+	if err := Write(&b, FormatSARIF, "script.fin", "", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"results": []`) || strings.Contains(b.String(), `"rules"`) {
+		t.Fatalf("clean run should have an empty results array and no rules:\n%s", b.String())
+	}
+}
+
+func TestArtifactURI(t *testing.T) {
+	for in, want := range map[string]string{
+		"script.fin":      "script.fin",
+		"a b/c.fin":       "a%20b/c.fin",
+		"/tmp/script.fin": "file:///tmp/script.fin",
+	} {
+		if got := artifactURI(in); got != want {
+			t.Errorf("artifactURI(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
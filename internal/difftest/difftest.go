@@ -0,0 +1,370 @@
+// Package difftest runs a Fin program two ways — directly with the reference
+// interpreter and as generated batch under the cmd.exe emulator — and reports
+// the first statement where their behaviour diverges.
+//
+// Both executions are traced at statement granularity. After every echo, set,
+// assignment, run and call statement the harness records the stdout produced
+// so far and the visible variables; the generator's source map ties batch
+// lines back to the statement they were lowered from. The two traces are then
+// compared step by step.
+package difftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/batchemu"
+	"github.com/vishnunath-suresh/fin-project/internal/generator"
+	"github.com/vishnunath-suresh/fin-project/internal/interp"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Options configures a differential run. Zero values select defaults.
+type Options struct {
+	Args     []string               // script arguments for both executions
+	Exists   func(path string) bool // shared `exists` oracle; nil means nothing exists
+	MaxSteps int                    // batch command limit, see batchemu.DefaultMaxSteps
+	// Precision is the number of digits decimals keep after the point on
+	// both sides, sema.DefaultPrecision when zero.
+	Precision int
+}
+
+// Result summarises a differential run.
+type Result struct {
+	Steps      int         // statements compared before stopping
+	Batch      string      // the generated batch file
+	Divergence *Divergence // nil when both executions agree
+}
+
+// Divergence describes the first point where the executions disagree.
+type Divergence struct {
+	Kind   string // "stdout", "variable", "control flow" or "error"
+	Msg    string
+	Interp string // what the interpreter produced
+	Batch  string // what the generated batch produced
+	P      ast.Pos
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("%s divergence at %s: %s", d.Kind, posString(d.P), d.Msg)
+}
+
+// Pos returns the source position of the diverging statement.
+func (d *Divergence) Pos() ast.Pos { return d.P }
+
+// Detail renders both sides of the divergence for display.
+func (d *Divergence) Detail() string {
+	return fmt.Sprintf("  interpreter: %q\n  batch:       %q", d.Interp, d.Batch)
+}
+
+// step is the observable state after one traced statement.
+type step struct {
+	pos    ast.Pos
+	stdout int // length of stdout so far
+	vars   map[string]string
+}
+
+// CheckSource parses, analyzes and checks src. Parse and semantic errors are
+// returned as errors; behavioural differences are reported in the Result.
+func CheckSource(src string, opts Options) (*Result, error) {
+	p := parser.New(parser.CollectTokens(lexer.New(src)))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := sema.New().Analyze(prog); err != nil {
+		return nil, err
+	}
+	return Check(prog, opts)
+}
+
+// Check runs a validated program through both executions and compares them.
+// The returned error is non-nil only when the program cannot be compiled.
+func Check(prog *ast.Program, opts Options) (*Result, error) {
+	if opts.Exists == nil {
+		opts.Exists = func(string) bool { return false }
+	}
+	gen := generator.NewBatchGeneratorWithOptions(generator.Options{Precision: opts.Precision})
+	script, err := gen.Generate(prog)
+	if err != nil {
+		return nil, err
+	}
+	leaves := leafPositions(prog)
+
+	iout, isteps, ierr := runInterp(prog, leaves, opts)
+	bout, bsteps, bexit, berr := runBatch(script, gen.SourceMap(), leaves, opts)
+
+	// A statement that fails leaves the batch file from its last line, so
+	// the batch traces it while the interpreter does not.
+	if ierr != nil && berr == nil && bexit.failed(ierr) {
+		if n := len(bsteps); n > len(isteps) && bsteps[n-1].pos == bexit.pos {
+			bsteps = bsteps[:n-1]
+		}
+	}
+	res := &Result{Batch: script}
+	res.Divergence, res.Steps = compare(iout, isteps, bout, bsteps)
+	if res.Divergence == nil {
+		res.Divergence = compareErrors(ierr, berr, bexit, gen.SourceMap())
+	}
+	return res, nil
+}
+
+// batchExit is how a batch run ended: the ERRORLEVEL it left and the
+// position of the statement its last line was lowered from.
+type batchExit struct {
+	level int
+	pos   ast.Pos
+}
+
+// failed reports whether the batch file ended the way the interpreter
+// failed with ierr: with the exit code the failure ends the script with, in
+// the statement on the line of the failing expression.
+func (e batchExit) failed(ierr error) bool {
+	code := 1
+	var cf *interp.CommandFailedError
+	if errors.As(ierr, &cf) {
+		code = cf.Code
+	}
+	p := errorPos(ierr, nil)
+	return e.level == code && e.pos.File == p.File && e.pos.Line == p.Line
+}
+
+func runInterp(prog *ast.Program, leaves map[ast.Pos]bool, opts Options) (string, []step, error) {
+	var out bytes.Buffer
+	var steps []step
+	var in *interp.Interpreter
+	in = interp.New(interp.Options{
+		Stdout:    &out,
+		Stderr:    io.Discard,
+		Args:      opts.Args,
+		Exec:      recordRun,
+		Exists:    opts.Exists,
+		Precision: opts.Precision,
+		Trace: func(stmt ast.Statement) {
+			if leaves[stmt.Pos()] {
+				steps = append(steps, step{pos: stmt.Pos(), stdout: out.Len(), vars: in.Vars()})
+			}
+		},
+	})
+	err := in.Run(prog)
+	return out.String(), steps, err
+}
+
+// runBatch executes the generated script. A statement lowered to several
+// lines produces one step, taken after its last consecutive line. Lines run
+// by functions it calls on the way, as call expressions do, do not count as
+// breaking the run, so the step still follows the steps of those functions.
+func runBatch(script string, srcMap []ast.Pos, leaves map[ast.Pos]bool, opts Options) (string, []step, batchExit, error) {
+	var out bytes.Buffer
+	var steps []step
+	var m *batchemu.Machine
+	var last []ast.Pos // position of the last line traced at each call depth
+	var open []int     // index of the step taken at each depth, or -1
+	var exit batchExit
+	m = batchemu.New(script, batchemu.Options{
+		Stdout:   &out,
+		Args:     opts.Args,
+		Exec:     recordRun,
+		Exists:   opts.Exists,
+		MaxSteps: opts.MaxSteps,
+		Trace: func(line int) {
+			var pos ast.Pos
+			if line-1 < len(srcMap) {
+				pos = srcMap[line-1]
+			}
+			exit.pos = pos
+			d := m.Depth()
+			for len(last) <= d {
+				last = append(last, ast.Pos{})
+				open = append(open, -1)
+			}
+			last, open = last[:d+1], open[:d+1]
+			if !leaves[pos] {
+				open[d] = -1
+			} else {
+				if pos == last[d] && open[d] >= 0 {
+					steps = append(steps[:open[d]], steps[open[d]+1:]...)
+				}
+				open[d] = len(steps)
+				steps = append(steps, step{pos: pos, stdout: out.Len(), vars: m.Vars()})
+			}
+			last[d] = pos
+		},
+	})
+	err := m.Run()
+	exit.level = m.ErrorLevel()
+	return out.String(), steps, exit, err
+}
+
+// recordRun stands in for external commands on both sides, writing the
+// command line to stdout so `run` statements are compared too.
+func recordRun(command string, _ []string, stdout, _ io.Writer) (int, error) {
+	_, err := fmt.Fprintf(stdout, "[run] %s\n", command)
+	return 0, err
+}
+
+func compare(iout string, isteps []step, bout string, bsteps []step) (*Divergence, int) {
+	iprev, bprev := 0, 0
+	for i := 0; i < len(isteps) || i < len(bsteps); i++ {
+		switch {
+		case i >= len(bsteps):
+			s := isteps[i]
+			return &Divergence{Kind: "control flow", P: s.pos,
+				Msg:    "statement ran in the interpreter but the batch output had already finished",
+				Interp: posString(s.pos), Batch: "end of program"}, i
+		case i >= len(isteps):
+			s := bsteps[i]
+			return &Divergence{Kind: "control flow", P: s.pos,
+				Msg:    "statement ran in the batch output after the interpreter had finished",
+				Interp: "end of program", Batch: posString(s.pos)}, i
+		}
+		is, bs := isteps[i], bsteps[i]
+		if is.pos != bs.pos {
+			return &Divergence{Kind: "control flow", P: is.pos,
+				Msg:    fmt.Sprintf("interpreter ran line %d next, batch ran line %d", is.pos.Line, bs.pos.Line),
+				Interp: posString(is.pos), Batch: posString(bs.pos)}, i
+		}
+		if iw, bw := iout[iprev:is.stdout], bout[bprev:bs.stdout]; iw != bw {
+			return &Divergence{Kind: "stdout", P: is.pos, Msg: "statement wrote different output",
+				Interp: iw, Batch: bw}, i
+		}
+		iprev, bprev = is.stdout, bs.stdout
+		if d := compareVars(is.pos, is.vars, bs.vars); d != nil {
+			return d, i
+		}
+	}
+	return nil, len(isteps)
+}
+
+// compareVars checks every variable the interpreter can see against the batch
+// environment. Batch names are case-insensitive and cannot hold an empty
+// value, so lookups fold case and empty matches unset. Variables only the
+// batch side has, such as generator temporaries, are ignored.
+func compareVars(pos ast.Pos, ivars, bvars map[string]string) *Divergence {
+	folded := make(map[string]string, len(bvars))
+	for k, v := range bvars {
+		folded[strings.ToUpper(k)] = v
+	}
+	names := make([]string, 0, len(ivars))
+	for k := range ivars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if iv, bv := ivars[name], folded[strings.ToUpper(name)]; iv != bv {
+			return &Divergence{Kind: "variable", P: pos,
+				Msg:    fmt.Sprintf("variable %s differs", name),
+				Interp: iv, Batch: bv}
+		}
+	}
+	return nil
+}
+
+// compareErrors reports a run that failed on only one side, or on both sides
+// at different statements. When the interpreter failed, the batch file
+// failed too if it ended with the same exit code at the same statement.
+func compareErrors(ierr, berr error, bexit batchExit, srcMap []ast.Pos) *Divergence {
+	if ierr == nil && berr == nil {
+		return nil
+	}
+	if ierr != nil && berr == nil {
+		if bexit.failed(ierr) {
+			return nil
+		}
+		batch := fmt.Sprintf("exit code %d", bexit.level)
+		if bexit.pos.Line > 0 {
+			batch += " at " + posString(bexit.pos)
+		}
+		return &Divergence{Kind: "error", P: errorPos(ierr, nil), Msg: "only one execution failed here",
+			Interp: errString(ierr), Batch: batch}
+	}
+	ipos, bpos := errorPos(ierr, nil), errorPos(berr, srcMap)
+	if ierr != nil && berr != nil && ipos == bpos {
+		return nil
+	}
+	pos := ipos
+	if ierr == nil {
+		pos = bpos
+	}
+	return &Divergence{Kind: "error", P: pos, Msg: "only one execution failed here",
+		Interp: errString(ierr), Batch: errString(berr)}
+}
+
+func errorPos(err error, srcMap []ast.Pos) ast.Pos {
+	var be *batchemu.Error
+	if errors.As(err, &be) {
+		if be.Line > 0 && be.Line-1 < len(srcMap) {
+			return srcMap[be.Line-1]
+		}
+		return ast.Pos{}
+	}
+	if p, ok := err.(interface{ Pos() ast.Pos }); ok {
+		return p.Pos()
+	}
+	return ast.Pos{}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "no error"
+	}
+	return err.Error()
+}
+
+// posString formats p as LINE:COLUMN, after the file when it is in an
+// imported one.
+func posString(p ast.Pos) string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// leafPositions collects the statements that produce trace steps: those that
+// write output or change variables directly.
+func leafPositions(prog *ast.Program) map[ast.Pos]bool {
+	leaves := make(map[ast.Pos]bool)
+	var walk func([]ast.Statement)
+	walk = func(stmts []ast.Statement) {
+		for _, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *ast.EchoStmt, *ast.SetStmt, *ast.AssignStmt, *ast.RunStmt, *ast.CallStmt:
+				leaves[s.Pos()] = true
+			case *ast.IfStmt:
+				walk(s.Then)
+				for _, branch := range s.ElseIfs {
+					walk(branch.Body)
+				}
+				walk(s.Else)
+			case *ast.MatchStmt:
+				for _, arm := range s.Cases {
+					walk(arm.Body)
+				}
+				walk(s.Default)
+			case *ast.ForStmt:
+				walk(s.Body)
+			case *ast.ForEachStmt:
+				walk(s.Body)
+			case *ast.WhileStmt:
+				walk(s.Body)
+			case *ast.UncheckedStmt:
+				walk(s.Body)
+			case *ast.TryStmt:
+				walk(s.Body)
+				walk(s.Catch)
+				walk(s.Finally)
+			case *ast.FnDecl:
+				walk(s.Body)
+			}
+		}
+	}
+	walk(prog.Statements)
+	return leaves
+}
@@ -0,0 +1,309 @@
+package difftest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+)
+
+func TestCheck_Examples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(projectRoot(t), "examples", "*.fin"))
+	if err != nil {
+		t.Fatalf("glob examples: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no examples found")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			prog, err := module.Load(file)
+			if err != nil {
+				t.Fatalf("load errors: %v", err)
+			}
+			res, err := Check(prog, Options{})
+			if err != nil {
+				t.Fatalf("check: %v", err)
+			}
+			if d := res.Divergence; d != nil {
+				t.Fatalf("%v\n%s\nprogram:\n%s\nbatch:\n%s", d, d.Detail(), src, res.Batch)
+			}
+		})
+	}
+}
+
+func TestCheck_RandomPrograms(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		assertAgree(t, RandomProgram(seed))
+	}
+}
+
+func TestRandomProgram_Deterministic(t *testing.T) {
+	if RandomProgram(42) != RandomProgram(42) {
+		t.Fatalf("same seed should produce the same program")
+	}
+	if RandomProgram(1) == RandomProgram(2) {
+		t.Fatalf("different seeds should produce different programs")
+	}
+}
+
+func TestCheck_ReportsVariableDivergence(t *testing.T) {
+	// Batch variable names are case-insensitive, Fin's are not.
+	src := "set total 1\n" +
+		"set Total 2\n" +
+		"echo \"$total\"\n"
+	res, err := CheckSource(src, Options{})
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	d := res.Divergence
+	if d == nil {
+		t.Fatalf("expected a divergence")
+	}
+	if d.Kind != "variable" || d.Pos().Line != 2 || d.Interp != "1" || d.Batch != "2" {
+		t.Fatalf("unexpected divergence %v\n%s", d, d.Detail())
+	}
+	if res.Steps != 1 {
+		t.Fatalf("expected 1 agreeing step, got %d", res.Steps)
+	}
+}
+
+func TestCompare_StdoutAndControlFlow(t *testing.T) {
+	a := ast.Pos{Line: 1, Column: 1}
+	b := ast.Pos{Line: 2, Column: 1}
+	isteps := []step{{pos: a, stdout: 3}, {pos: b, stdout: 6}}
+
+	d, n := compare("hi\nyo\n", isteps, "hi\nno\n", []step{{pos: a, stdout: 3}, {pos: b, stdout: 6}})
+	if d == nil || d.Kind != "stdout" || d.P != b || d.Interp != "yo\n" || d.Batch != "no\n" || n != 1 {
+		t.Fatalf("unexpected stdout divergence %v (steps %d)", d, n)
+	}
+
+	d, _ = compare("hi\nyo\n", isteps, "hi\n", []step{{pos: a, stdout: 3}})
+	if d == nil || d.Kind != "control flow" || d.P != b {
+		t.Fatalf("unexpected control flow divergence %v", d)
+	}
+
+	d, _ = compare("hi\nyo\n", isteps, "yo\n", []step{{pos: b, stdout: 3}})
+	if d == nil || d.Kind != "control flow" || d.P != a || d.Batch != "2:1" {
+		t.Fatalf("unexpected control flow divergence %v", d)
+	}
+}
+
+func TestCheck_BothFailAtTheSameStatement(t *testing.T) {
+	for _, src := range []string{
+		"set z 0.0\necho \"a\"\nset x 1.0 / $z\necho \"not reached\"\n",
+		"set n 40\nif 2 ** $n > 0\n    echo \"not reached\"\nend\n",
+		"set z 0\nif 1 / $z > 0\n    echo \"x\"\nend\necho \"after\"\n",
+		"set z 0\nset x 7\nx %= $z\necho \"after\"\n",
+	} {
+		res, err := CheckSource(src, Options{})
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		if res.Divergence != nil {
+			t.Fatalf("unexpected divergence %v\n%s\n%s", res.Divergence, res.Divergence.Detail(), src)
+		}
+	}
+}
+
+func TestCheck_ComparesRunCommands(t *testing.T) {
+	res, err := CheckSource("set name \"x\"\nrun \"tool $name\"\n", Options{})
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if res.Divergence != nil {
+		t.Fatalf("unexpected divergence %v\n%s", res.Divergence, res.Divergence.Detail())
+	}
+	if res.Steps != 2 {
+		t.Fatalf("expected 2 steps, got %d", res.Steps)
+	}
+}
+
+func TestCheck_ComparesRunArgumentsAndCapture(t *testing.T) {
+	src := "set msg \"fix (a)\"\n" +
+		"run \"git commit -m\" $msg \"100%\" \"a^b!\" ($status + 1)\n" +
+		"set out (run \"git log\" \"--format=%h (short)\")\n" +
+		"echo \"$out $status\"\n"
+	res, err := CheckSource(src, Options{})
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if res.Divergence != nil {
+		t.Fatalf("unexpected divergence %v\n%s\n%s", res.Divergence, res.Divergence.Detail(), res.Batch)
+	}
+	if res.Steps != 4 {
+		t.Fatalf("expected 4 steps, got %d", res.Steps)
+	}
+}
+
+func TestCheck_ContinueInForLoops(t *testing.T) {
+	assertAgree(t, "for i in 0..3\n"+
+		"    if $i == 1\n"+
+		"        continue\n"+
+		"    end\n"+
+		"    echo \"plain $i\"\n"+
+		"end\n"+
+		"for i in 0..3\n"+
+		"    try\n"+
+		"        if $i % 2 == 0\n"+
+		"            continue\n"+
+		"        end\n"+
+		"        echo \"body $i\"\n"+
+		"    finally\n"+
+		"        echo \"finally $i\"\n"+
+		"    end\n"+
+		"end\n")
+}
+
+func TestCheck_MapKeyNamedLikeKeyList(t *testing.T) {
+	assertAgree(t, "set m {keys_len: 5, a: 1}\n"+
+		"echo \"$m.keys_len\"\n"+
+		"for k, v in $m\n"+
+		"    echo \"$k=$v\"\n"+
+		"end\n")
+}
+
+func TestCheck_BuiltinStringArguments(t *testing.T) {
+	assertAgree(t, "set parts (split \"a^b,50%,c&d\" \",\")\n"+
+		"echo \"$parts[0] $parts[1] $parts[2]\"\n"+
+		"set name \"v\"\n"+
+		"echo (upper \"$name^% (a|b) $(lower \\\"A&B\\\")!\")\n"+
+		"echo (len \"<\\\"q\\\" ^!>\")\n")
+}
+
+func TestCheck_InterpolatedIndex(t *testing.T) {
+	assertAgree(t, "set xs [\"a\", \"b\", \"d\"]\n"+
+		"set i 1\n"+
+		"fn show v\n"+
+		"    return \"$v/$xs[$i]\"\n"+
+		"end\n"+
+		"echo \"$xs[$i] $xs[i] $xs[2] $(show \\\"$xs[i]\\\")\"\n"+
+		"for j in 0..2\n"+
+		"    set item \"$j=$xs[$j]\"\n"+
+		"    echo $item\n"+
+		"end\n")
+}
+
+func TestCheck_EqualityComparesText(t *testing.T) {
+	assertAgree(t, "set n 007\n"+
+		"if $n == 7\n"+
+		"    echo \"eq\"\n"+
+		"else\n"+
+		"    echo \"ne\"\n"+
+		"end\n"+
+		"if $n != \"007\" || 010 != 8\n"+
+		"    echo \"text\"\n"+
+		"end\n"+
+		"if $n < 8\n"+
+		"    echo \"number\"\n"+
+		"end\n"+
+		"fn greet who\n"+
+		"    echo \"hi $who\"\n"+
+		"end\n"+
+		"greet \"a b\"\n"+
+		"greet \"\"\n")
+}
+
+func TestCheck_MatchComparesText(t *testing.T) {
+	assertAgree(t, "set n 007\n"+
+		"match $n\n"+
+		"    case 7\n"+
+		"        echo \"number\"\n"+
+		"    case \"007\"\n"+
+		"        echo \"text\"\n"+
+		"end\n"+
+		"set d 1.50\n"+
+		"match $d\n"+
+		"    case 1.5\n"+
+		"        echo \"value\"\n"+
+		"    else\n"+
+		"        echo \"other\"\n"+
+		"end\n")
+}
+
+func TestCheck_DecimalsRoundOnlyTheResult(t *testing.T) {
+	assertAgree(t, "set price 19.99\n"+
+		"set x -3.14159\n"+
+		"set r [$price * 0.075, 0.125 + 0.125, -0.125 - 0.125, 0.005 * 100, 0.994 * -0.5, 1.004 - 0.005]\n"+
+		"echo \"$x $r[0] $r[1] $r[2] $r[3] $r[4] $r[5]\"\n"+
+		"set big 21474.83 * 999.99\n"+
+		"set sq $x * $x\n"+
+		"echo \"$big $sq\"\n"+
+		"echo 21474836.47 + 0.01\n")
+}
+
+func TestCheck_OrderingComparesStringsLikeCmd(t *testing.T) {
+	assertAgree(t, "fn less a b\n"+
+		"    if $a < $b\n"+
+		"        echo \"$a < $b\"\n"+
+		"    else\n"+
+		"        echo \"$a >= $b\"\n"+
+		"    end\n"+
+		"end\n"+
+		"less \"a\" \"B\"\n"+
+		"less \"A\" \"a\"\n"+
+		"less \"co-op\" \"coop\"\n"+
+		"less \"a9\" \"a10\"\n"+
+		"less 9 10\n")
+}
+
+func TestCheck_SortOrdersStringsLikeCmd(t *testing.T) {
+	assertAgree(t, "set xs [\"b\", \"B\", \"a\", \"co-op\", \"coop\", \"A\", \"a10\", \"a9\", \"_x\", -5, \"010\", 7]\n"+
+		"sort $xs\n"+
+		"echo (join $xs \" \")\n")
+}
+
+func TestCheck_SpecialCharactersInValues(t *testing.T) {
+	assertAgree(t, "set name \"a b&c\"\n"+
+		"name = \"(x) | <y>\"\n"+
+		"fn wrap x\n"+
+		"    if $x == \"\"\n"+
+		"        return \"$x & more\"\n"+
+		"    end\n"+
+		"    return \"<$x>\"\n"+
+		"end\n"+
+		"fn greet who\n"+
+		"    echo \"hi $who\"\n"+
+		"end\n"+
+		"set a (wrap \"\")\n"+
+		"set b (wrap \"q\")\n"+
+		"greet \"a&b\"\n"+
+		"greet \"x | (y)\"\n"+
+		"greet $name\n"+
+		"greet \"a^b\"\n"+
+		"greet \"\"\n"+
+		"echo \"$a $b\"\n")
+}
+
+func TestCheck_SemanticErrorsAreErrors(t *testing.T) {
+	if _, err := CheckSource("echo $missing\n", Options{}); err == nil {
+		t.Fatalf("expected an error for an undefined variable")
+	}
+}
+
+func assertAgree(t *testing.T, src string) {
+	t.Helper()
+	res, err := CheckSource(src, Options{})
+	if err != nil {
+		t.Fatalf("check: %v\n%s", err, src)
+	}
+	if d := res.Divergence; d != nil {
+		t.Fatalf("%v\n%s\nprogram:\n%s\nbatch:\n%s", d, d.Detail(), src, res.Batch)
+	}
+}
+
+func projectRoot(t *testing.T) string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatalf("cannot determine caller")
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
@@ -0,0 +1,232 @@
+package difftest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// RandomProgram returns a pseudo-random, semantically valid Fin program for
+// the given seed. Programs stay within integer variables, arithmetic, echo
+// with interpolation, comparisons in if, bounded for and while loops, and
+// functions, so every generated program terminates.
+func RandomProgram(seed int64) string {
+	g := &progGen{r: rand.New(rand.NewSource(seed)), fixed: make(map[string]bool)}
+	return g.program()
+}
+
+type progGen struct {
+	r      *rand.Rand
+	b      strings.Builder
+	indent int
+	vars   []string        // integer variables in scope
+	next   int             // counter for fresh names
+	loops  int             // enclosing while loops, where break is allowed
+	fixed  map[string]bool // for-loop variables, which are never assigned
+	inIf   int             // enclosing if blocks, where loops are not generated
+}
+
+var compareOps = []string{"==", "!=", "<", ">", "<=", ">="}
+
+func (g *progGen) program() string {
+	globals := 2 + g.r.Intn(3)
+	for i := 0; i < globals; i++ {
+		g.declare()
+	}
+	var fns []fnSig
+	for i := g.r.Intn(3); i > 0; i-- {
+		fns = append(fns, g.function())
+	}
+	for i := 3 + g.r.Intn(6); i > 0; i-- {
+		g.stmt(0, fns)
+	}
+	return g.b.String()
+}
+
+func (g *progGen) line(format string, args ...any) {
+	g.b.WriteString(strings.Repeat("    ", g.indent))
+	fmt.Fprintf(&g.b, format, args...)
+	g.b.WriteByte('\n')
+}
+
+func (g *progGen) fresh(prefix string) string {
+	g.next++
+	return fmt.Sprintf("%s%d", prefix, g.next)
+}
+
+// declare introduces a new integer variable with `set`.
+func (g *progGen) declare() {
+	name := g.fresh("v")
+	g.line("set %s %s", name, g.expr(1))
+	g.vars = append(g.vars, name)
+}
+
+// fnSig is a generated function callable from later statements.
+type fnSig struct {
+	name  string
+	arity int
+}
+
+// function emits a declaration. Its body only sees its parameters and locals.
+func (g *progGen) function() fnSig {
+	name := g.fresh("f")
+	params := []string{g.fresh("p")}
+	if g.r.Intn(2) == 0 {
+		params = append(params, g.fresh("p"))
+	}
+	g.line("fn %s %s", name, strings.Join(params, " "))
+	saved := g.vars
+	g.vars = append([]string(nil), params...)
+	g.indent++
+	for i := 1 + g.r.Intn(3); i > 0; i-- {
+		g.stmt(1, nil)
+	}
+	g.line("return %s", g.expr(1))
+	g.indent--
+	g.line("end")
+	g.vars = saved
+	return fnSig{name: name, arity: len(params)}
+}
+
+func (g *progGen) stmt(depth int, fns []fnSig) {
+	n := 6
+	if depth < 2 {
+		n = 10
+	}
+	switch k := g.r.Intn(n); {
+	case k == 0:
+		g.declare()
+	case k <= 2:
+		g.assign()
+	case k <= 4:
+		g.echo()
+	case k == 5:
+		if len(fns) == 0 {
+			g.echo()
+			return
+		}
+		fn := fns[g.r.Intn(len(fns))]
+		args := make([]string, fn.arity)
+		for i := range args {
+			args[i] = g.operand()
+		}
+		g.line("%s %s", fn.name, strings.Join(args, " "))
+	case k <= 7:
+		g.ifStmt(depth, fns)
+	case g.inIf > 0:
+		// Loop labels inside a parenthesised if block are not reachable
+		// by goto in cmd.exe, so the generator cannot lower them yet.
+		g.echo()
+	case k == 8:
+		g.forStmt(depth, fns)
+	default:
+		g.whileStmt(depth, fns)
+	}
+}
+
+// assign updates a variable that is not a loop counter, falling back to
+// declaring a new one.
+func (g *progGen) assign() {
+	var targets []string
+	for _, v := range g.vars {
+		if !g.fixed[v] {
+			targets = append(targets, v)
+		}
+	}
+	if len(targets) == 0 {
+		g.declare()
+		return
+	}
+	g.line("%s = %s", targets[g.r.Intn(len(targets))], g.expr(2))
+}
+
+func (g *progGen) echo() {
+	parts := []string{"out"}
+	for i := g.r.Intn(3); i >= 0; i-- {
+		parts = append(parts, "$"+g.pick())
+	}
+	g.line("echo \"%s\"", strings.Join(parts, " "))
+}
+
+func (g *progGen) block(depth int, fns []fnSig) {
+	saved := len(g.vars)
+	g.indent++
+	for i := 1 + g.r.Intn(3); i > 0; i-- {
+		g.stmt(depth+1, fns)
+	}
+	g.indent--
+	g.vars = g.vars[:saved]
+}
+
+func (g *progGen) ifStmt(depth int, fns []fnSig) {
+	g.line("if %s %s %s", "$"+g.pick(), compareOps[g.r.Intn(len(compareOps))], g.operand())
+	if g.loops > 0 && g.r.Intn(4) == 0 {
+		g.indent++
+		g.line("break")
+		g.indent--
+	} else {
+		g.inIf++
+		g.block(depth, fns)
+		g.inIf--
+	}
+	if g.r.Intn(2) == 0 {
+		g.line("else")
+		g.inIf++
+		g.block(depth, fns)
+		g.inIf--
+	}
+	g.line("end")
+}
+
+func (g *progGen) forStmt(depth int, fns []fnSig) {
+	v := g.fresh("i")
+	g.line("for %s in %d..%d", v, g.r.Intn(3), g.r.Intn(5))
+	g.vars = append(g.vars, v)
+	g.fixed[v] = true
+	g.block(depth, fns)
+	g.vars = g.vars[:len(g.vars)-1]
+	g.line("end")
+}
+
+func (g *progGen) whileStmt(depth int, fns []fnSig) {
+	v := g.fresh("w")
+	g.line("set %s 0", v)
+	g.line("while $%s < %d", v, 1+g.r.Intn(4))
+	g.indent++
+	g.line("%s = $%s + 1", v, v)
+	g.indent--
+	g.loops++
+	g.block(depth, fns)
+	g.loops--
+	g.line("end")
+}
+
+func (g *progGen) pick() string { return g.vars[g.r.Intn(len(g.vars))] }
+
+func (g *progGen) operand() string {
+	if len(g.vars) > 0 && g.r.Intn(2) == 0 {
+		return "$" + g.pick()
+	}
+	return fmt.Sprint(g.r.Intn(20))
+}
+
+// expr builds an arithmetic expression. Division only uses non-zero literal
+// divisors so both executions stay free of runtime errors.
+func (g *progGen) expr(depth int) string {
+	if depth == 0 || g.r.Intn(3) == 0 {
+		return g.operand()
+	}
+	left := g.expr(depth - 1)
+	switch g.r.Intn(5) {
+	case 0:
+		return left + " + " + g.expr(depth-1)
+	case 1:
+		return left + " - " + g.expr(depth-1)
+	case 2:
+		return left + " * " + g.expr(depth-1)
+	case 3:
+		return left + " / " + fmt.Sprint(1+g.r.Intn(5))
+	default:
+		return "(" + left + " + " + g.expr(depth-1) + ") * " + g.operand()
+	}
+}
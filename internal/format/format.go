@@ -8,29 +8,78 @@ import (
 )
 
 // Format program into canonical Fin source (deterministic, minimal spacing).
+// Comments and blank lines recorded in prog.Trivia are kept: runs of blank
+// lines collapse to one, and blank lines at the start or end of a block are
+// dropped.
 func Format(prog *ast.Program) string {
 	if prog == nil {
 		return ""
 	}
-	var b strings.Builder
+	p := &printer{trivia: prog.Trivia}
+	p.block(prog.Statements, p.footer(prog, 0), 0, true)
+	return strings.TrimSuffix(p.b.String(), "\n")
+}
+
+type printer struct {
+	b      strings.Builder
+	trivia map[ast.Node]*ast.Trivia
+}
+
+func (p *printer) get(n ast.Node) *ast.Trivia {
+	if tr := p.trivia[n]; tr != nil {
+		return tr
+	}
+	return &ast.Trivia{}
+}
+
+// footer returns the comments closing block i of n.
+func (p *printer) footer(n ast.Node, i int) []ast.Comment {
+	if tr := p.trivia[n]; tr != nil && i < len(tr.Footers) {
+		return tr.Footers[i]
+	}
+	return nil
+}
+
+func (p *printer) line(indent int, format string, args ...any) {
+	p.b.WriteString(strings.Repeat("    ", indent))
+	fmt.Fprintf(&p.b, format, args...)
+	p.b.WriteByte('\n')
+}
+
+// block writes stmts followed by the footer comments. At the top level a
+// blank line always separates consecutive function declarations.
+func (p *printer) block(stmts []ast.Statement, footer []ast.Comment, indent int, top bool) {
 	var prev ast.Statement
-	first := true
-	for _, stmt := range prog.Statements {
+	for _, stmt := range stmts {
 		if stmt == nil {
 			continue
 		}
-		if !first {
-			if isFnDecl(prev) && isFnDecl(stmt) {
-				b.WriteString("\n\n")
-			} else {
-				b.WriteByte('\n')
+		tr := p.get(stmt)
+		blank := tr.Blank
+		if len(tr.Leading) > 0 {
+			blank = tr.Leading[0].Blank
+		}
+		if prev != nil && (blank || top && isFnDecl(prev) && isFnDecl(stmt)) {
+			p.b.WriteByte('\n')
+		}
+		for i, c := range tr.Leading {
+			if i > 0 && c.Blank {
+				p.b.WriteByte('\n')
 			}
+			p.line(indent, "%s", c.Text)
+		}
+		if len(tr.Leading) > 0 && tr.Blank {
+			p.b.WriteByte('\n')
 		}
-		writeStmt(&b, stmt, 0)
+		p.stmt(stmt, tr, indent)
 		prev = stmt
-		first = false
 	}
-	return b.String()
+	for i, c := range footer {
+		if c.Blank && (i > 0 || prev != nil) {
+			p.b.WriteByte('\n')
+		}
+		p.line(indent, "%s", c.Text)
+	}
 }
 
 func isFnDecl(stmt ast.Statement) bool {
@@ -38,80 +87,194 @@ func isFnDecl(stmt ast.Statement) bool {
 	return ok
 }
 
-func writeStmt(b *strings.Builder, stmt ast.Statement, indent int) {
-	ind := strings.Repeat("    ", indent)
+// withComment appends an end-of-line comment to a formatted line.
+func withComment(s string, c *ast.Comment) string {
+	if c == nil {
+		return s
+	}
+	return s + " " + c.Text
+}
+
+func (p *printer) stmt(stmt ast.Statement, tr *ast.Trivia, indent int) {
+	simple := func(s string) { p.line(indent, "%s", withComment(s, tr.Trailing)) }
 	switch s := stmt.(type) {
 	case *ast.SetStmt:
-		fmt.Fprintf(b, "%sset %s %s", ind, s.Name, formatExpr(s.Value))
+		simple(fmt.Sprintf("set %s %s", s.Name, formatExpr(s.Value)))
+	case *ast.AssignStmt:
+		switch s.Op {
+		case "":
+			simple(fmt.Sprintf("%s = %s", s.Name, formatExpr(s.Value)))
+		case "++", "--":
+			simple(s.Name + s.Op)
+		default:
+			simple(fmt.Sprintf("%s %s %s", s.Name, s.Op, formatExpr(s.Value)))
+		}
 	case *ast.EchoStmt:
-		fmt.Fprintf(b, "%secho %s", ind, formatExpr(s.Value))
+		if s.Value == nil {
+			simple("echo")
+		} else {
+			simple("echo " + formatExpr(s.Value))
+		}
 	case *ast.RunStmt:
-		fmt.Fprintf(b, "%srun %s", ind, formatExpr(s.Command))
+		simple(formatCall("run "+formatExpr(s.Command), s.Args))
 	case *ast.CallStmt:
-		fmt.Fprintf(b, "%s%s", ind, s.Name)
-		for _, a := range s.Args {
-			fmt.Fprintf(b, " %s", formatExpr(a))
-		}
+		simple(formatCall(s.Name, s.Args))
 	case *ast.ReturnStmt:
 		if s.Value != nil {
-			fmt.Fprintf(b, "%sreturn %s", ind, formatExpr(s.Value))
+			simple("return " + formatExpr(s.Value))
 		} else {
-			fmt.Fprintf(b, "%sreturn", ind)
+			simple("return")
 		}
-	case *ast.IfStmt:
-		fmt.Fprintf(b, "%sif %s\n", ind, formatExpr(s.Cond))
-		for i, inner := range s.Then {
-			writeStmt(b, inner, indent+1)
-			b.WriteByte('\n')
-			if i == len(s.Then)-1 && len(s.Else) == 0 {
-				// no extra
+	case *ast.BreakStmt:
+		simple("break")
+	case *ast.ContinueStmt:
+		simple("continue")
+	case *ast.StrictStmt:
+		simple("strict")
+	case *ast.ImportStmt:
+		if s.Alias != "" {
+			simple("import " + quote(s.Path) + " as " + s.Alias)
+		} else {
+			simple("import " + quote(s.Path))
+		}
+	case *ast.ParamsStmt:
+		p.line(indent, "%s", withComment("params", tr.Header))
+		for i, param := range s.Params {
+			for _, c := range p.footer(s, i) {
+				p.line(indent+1, "%s", c.Text)
+			}
+			switch {
+			case param.Flag:
+				p.line(indent+1, "flag %s", param.Name)
+			case param.Default != nil:
+				p.line(indent+1, "param %s default %s", param.Name, formatExpr(param.Default))
+			default:
+				p.line(indent+1, "param %s", param.Name)
 			}
 		}
-		if len(s.Else) > 0 {
-			fmt.Fprintf(b, "%selse\n", ind)
-			for _, inner := range s.Else {
-				writeStmt(b, inner, indent+1)
-				b.WriteByte('\n')
+		for _, c := range p.footer(s, len(s.Params)) {
+			p.line(indent+1, "%s", c.Text)
+		}
+		simple("end")
+	case *ast.IfStmt:
+		p.line(indent, "%s", withComment("if "+formatExpr(s.Cond), tr.Header))
+		p.block(s.Then, p.footer(s, 0), indent+1, false)
+		for i, branch := range s.ElseIfs {
+			p.line(indent, "else if %s", formatExpr(branch.Cond))
+			p.block(branch.Body, p.footer(s, i+1), indent+1, false)
+		}
+		if elseFooter := p.footer(s, len(s.ElseIfs)+1); len(s.Else) > 0 || len(elseFooter) > 0 {
+			p.line(indent, "else")
+			p.block(s.Else, elseFooter, indent+1, false)
+		}
+		simple("end")
+	case *ast.MatchStmt:
+		p.line(indent, "%s", withComment("match "+formatExpr(s.Subject), tr.Header))
+		for _, c := range p.footer(s, 0) {
+			p.line(indent+1, "%s", c.Text)
+		}
+		for i, arm := range s.Cases {
+			values := make([]string, len(arm.Values))
+			for j, v := range arm.Values {
+				values[j] = formatExpr(v)
 			}
+			p.line(indent+1, "case %s", strings.Join(values, ", "))
+			p.block(arm.Body, p.footer(s, i+1), indent+2, false)
+		}
+		if defaultFooter := p.footer(s, len(s.Cases)+1); len(s.Default) > 0 || len(defaultFooter) > 0 {
+			p.line(indent+1, "else")
+			p.block(s.Default, defaultFooter, indent+2, false)
 		}
-		fmt.Fprintf(b, "%send", ind)
+		simple("end")
 	case *ast.ForStmt:
-		fmt.Fprintf(b, "%sfor %s in %s .. %s\n", ind, s.Var, formatExpr(s.Start), formatExpr(s.End))
-		for _, inner := range s.Body {
-			writeStmt(b, inner, indent+1)
-			b.WriteByte('\n')
+		p.line(indent, "%s", withComment(fmt.Sprintf("for %s in %s .. %s", s.Var, formatExpr(s.Start), formatExpr(s.End)), tr.Header))
+		p.block(s.Body, p.footer(s, 0), indent+1, false)
+		simple("end")
+	case *ast.ForEachStmt:
+		vars := s.Var
+		if s.Key != "" {
+			vars = s.Key + ", " + s.Var
 		}
-		fmt.Fprintf(b, "%send", ind)
+		p.line(indent, "%s", withComment(fmt.Sprintf("for %s in %s", vars, formatExpr(s.Iterable)), tr.Header))
+		p.block(s.Body, p.footer(s, 0), indent+1, false)
+		simple("end")
 	case *ast.WhileStmt:
-		fmt.Fprintf(b, "%swhile %s\n", ind, formatExpr(s.Cond))
-		for _, inner := range s.Body {
-			writeStmt(b, inner, indent+1)
-			b.WriteByte('\n')
+		p.line(indent, "%s", withComment("while "+formatExpr(s.Cond), tr.Header))
+		p.block(s.Body, p.footer(s, 0), indent+1, false)
+		simple("end")
+	case *ast.UncheckedStmt:
+		p.line(indent, "%s", withComment("unchecked", tr.Header))
+		p.block(s.Body, p.footer(s, 0), indent+1, false)
+		simple("end")
+	case *ast.TryStmt:
+		p.line(indent, "%s", withComment("try", tr.Header))
+		p.block(s.Body, p.footer(s, 0), indent+1, false)
+		if s.Err != "" {
+			p.line(indent, "catch %s", s.Err)
+			p.block(s.Catch, p.footer(s, 1), indent+1, false)
 		}
-		fmt.Fprintf(b, "%send", ind)
-	case *ast.FnDecl:
-		fmt.Fprintf(b, "%sfn %s", ind, s.Name)
-		for _, p := range s.Params {
-			fmt.Fprintf(b, " %s", p)
-		}
-		b.WriteByte('\n')
-		for _, inner := range s.Body {
-			writeStmt(b, inner, indent+1)
-			b.WriteByte('\n')
+		if len(s.Finally) > 0 || len(p.footer(s, 2)) > 0 || s.Err == "" {
+			p.line(indent, "finally")
+			p.block(s.Finally, p.footer(s, 2), indent+1, false)
 		}
-		fmt.Fprintf(b, "%send", ind)
+		simple("end")
+	case *ast.FnDecl:
+		p.line(indent, "%s", withComment(strings.Join(append([]string{"fn " + s.Name}, s.Params...), " "), tr.Header))
+		p.block(s.Body, p.footer(s, 0), indent+1, false)
+		simple("end")
 	default:
-		fmt.Fprintf(b, "%s# unsupported stmt %T", ind, stmt)
+		p.line(indent, "# unsupported stmt %T", stmt)
+	}
+}
+
+// formatArg formats call argument i. Arguments are parsed as a sequence of
+// expressions, so one after the first that starts with an infix operator
+// would be read as continuing the previous argument.
+func formatArg(e ast.Expr, i int) string {
+	s := formatExpr(e)
+	if i > 0 && (strings.HasPrefix(s, "-") || strings.HasPrefix(s, "[")) {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// formatCall formats a function name and its arguments, as written in a call
+// statement or inside the parentheses of a call expression. Runs pass their
+// keyword and command string as the name.
+func formatCall(name string, args []ast.Expr) string {
+	parts := []string{name}
+	for i, a := range args {
+		parts = append(parts, formatArg(a, i))
 	}
+	return strings.Join(parts, " ")
 }
 
+// binaryPrecedence mirrors the parser's binding powers. `**` is the only
+// right-associative operator.
+var binaryPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"|":  5,
+	"^":  6,
+	"&":  7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+	"**": 12,
+}
+
+// unaryPrecedence is the binding power of a unary operator's operand.
+const unaryPrecedence = 11
+
 func formatExpr(e ast.Expr) string {
 	if e == nil {
 		return ""
 	}
 	switch v := e.(type) {
 	case *ast.StringLit:
-		return v.Value
+		return quote(v.Value)
 	case *ast.NumberLit:
 		return v.Value
 	case *ast.BoolLit:
@@ -134,16 +297,95 @@ func formatExpr(e ast.Expr) string {
 		}
 		return "{" + strings.Join(parts, ", ") + "}"
 	case *ast.IndexExpr:
-		return fmt.Sprintf("%s[%s]", formatExpr(v.Left), formatExpr(v.Index))
+		return fmt.Sprintf("%s[%s]", formatPostfixOperand(v.Left), formatExpr(v.Index))
 	case *ast.PropertyExpr:
-		return fmt.Sprintf("%s.%s", formatExpr(v.Object), v.Field)
+		return fmt.Sprintf("%s.%s", formatPostfixOperand(v.Object), v.Field)
 	case *ast.UnaryExpr:
-		return fmt.Sprintf("%s%s", v.Op, formatExpr(v.Right))
+		operand := formatExpr(v.Right)
+		switch r := v.Right.(type) {
+		case *ast.BinaryExpr:
+			if binaryPrecedence[r.Op] <= unaryPrecedence {
+				operand = "(" + operand + ")"
+			}
+		case *ast.IndexExpr, *ast.PropertyExpr:
+			operand = "(" + operand + ")"
+		}
+		return v.Op + operand
 	case *ast.BinaryExpr:
-		return fmt.Sprintf("(%s %s %s)", formatExpr(v.Left), v.Op, formatExpr(v.Right))
+		prec := binaryPrecedence[v.Op]
+		rightAssoc := v.Op == "**"
+		return fmt.Sprintf("%s %s %s",
+			formatBinaryOperand(v.Left, prec, rightAssoc),
+			v.Op,
+			formatBinaryOperand(v.Right, prec, !rightAssoc))
 	case *ast.ExistsCond:
 		return "exists " + formatExpr(v.Path)
+	case *ast.CallExpr:
+		return "(" + formatCall(v.Name, v.Args) + ")"
+	case *ast.RunExpr:
+		return "(" + formatCall("run "+formatExpr(v.Command), v.Args) + ")"
 	default:
 		return "" // fallback
 	}
 }
+
+// formatBinaryOperand parenthesises an operand that binds looser than its
+// operator, or equally tight on the side the operator does not associate
+// towards. `exists` takes the rest of the expression as its path, so it is
+// always grouped.
+func formatBinaryOperand(e ast.Expr, prec int, groupEqual bool) string {
+	s := formatExpr(e)
+	if isOpenEnded(e) {
+		return "(" + s + ")"
+	}
+	if b, ok := e.(*ast.BinaryExpr); ok {
+		if p := binaryPrecedence[b.Op]; p < prec || p == prec && groupEqual {
+			return "(" + s + ")"
+		}
+	}
+	return s
+}
+
+// formatPostfixOperand formats the left side of an index or property access.
+func formatPostfixOperand(e ast.Expr) string {
+	s := formatExpr(e)
+	switch e.(type) {
+	case *ast.BinaryExpr, *ast.UnaryExpr, *ast.ExistsCond:
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// isOpenEnded reports whether e ends in an `exists` condition, which would
+// absorb any operator written after it.
+func isOpenEnded(e ast.Expr) bool {
+	switch v := e.(type) {
+	case *ast.ExistsCond:
+		return true
+	case *ast.UnaryExpr:
+		return isOpenEnded(v.Right)
+	}
+	return false
+}
+
+// quote renders a string literal with the escapes the lexer understands.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
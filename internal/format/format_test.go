@@ -0,0 +1,249 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+)
+
+func TestFormat_PreservesComments(t *testing.T) {
+	src := "# header comment\n" +
+		"\n" +
+		"\n" +
+		"# about x\n" +
+		"set x 1   # trailing\n" +
+		"set y 2\n" +
+		"\n" +
+		"if $x == 1 # header\n" +
+		"\n" +
+		"    # inside\n" +
+		"    echo \"yes\"\n" +
+		"    # end of then\n" +
+		"else # on else\n" +
+		"    echo \"no\"\n" +
+		"\n" +
+		"    # end of else\n" +
+		"end # after end\n" +
+		"fn f a\n" +
+		"    return $a\n" +
+		"    # end of body\n" +
+		"end\n" +
+		"# before g\n" +
+		"fn g\n" +
+		"end\n" +
+		"\n" +
+		"# end of file\n"
+	want := "# header comment\n" +
+		"\n" +
+		"# about x\n" +
+		"set x 1 # trailing\n" +
+		"set y 2\n" +
+		"\n" +
+		"if $x == 1 # header\n" +
+		"    # inside\n" +
+		"    echo \"yes\"\n" +
+		"    # end of then\n" +
+		"else\n" +
+		"    # on else\n" +
+		"    echo \"no\"\n" +
+		"\n" +
+		"    # end of else\n" +
+		"end # after end\n" +
+		"fn f a\n" +
+		"    return $a\n" +
+		"    # end of body\n" +
+		"end\n" +
+		"\n" +
+		"# before g\n" +
+		"fn g\n" +
+		"end\n" +
+		"\n" +
+		"# end of file"
+	if got := format(t, src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormat_OnlyComments(t *testing.T) {
+	if got := format(t, "\n# one\n\n\n# two\n"); got != "# one\n\n# two" {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
+
+func TestFormat_StrictAndUnchecked(t *testing.T) {
+	src := "strict # fail fast\n" +
+		"unchecked   # may fail\n" +
+		"run \"taskkill\"\n" +
+		"    # done\n" +
+		"end\n"
+	want := "strict # fail fast\n" +
+		"unchecked # may fail\n" +
+		"    run \"taskkill\"\n" +
+		"    # done\n" +
+		"end"
+	if got := format(t, src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormat_Try(t *testing.T) {
+	src := "try # deploy\n" +
+		"run \"deploy\"\n" +
+		"catch   err\n" +
+		"echo $err.code\n" +
+		"finally\n" +
+		"    # nothing to clean up\n" +
+		"end\n" +
+		"try\n" +
+		"run \"a\"\n" +
+		"finally\n" +
+		"end\n"
+	want := "try # deploy\n" +
+		"    run \"deploy\"\n" +
+		"catch err\n" +
+		"    echo $err.code\n" +
+		"finally\n" +
+		"    # nothing to clean up\n" +
+		"end\n" +
+		"try\n" +
+		"    run \"a\"\n" +
+		"finally\n" +
+		"end"
+	if got := format(t, src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormat_Import(t *testing.T) {
+	src := "import   \"lib/strings.fin\"\nimport \"lib/net.fin\"   as   net # http\nnet.get   \"x\"\necho (net.get \"y\")\n"
+	want := "import \"lib/strings.fin\"\n" +
+		"import \"lib/net.fin\" as net # http\n" +
+		"net.get \"x\"\n" +
+		"echo (net.get \"y\")"
+	if got := format(t, src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormat_Params(t *testing.T) {
+	src := "params # arguments\n# where to\nparam   env   default \"dev\"\nparam target\n\n\nflag verbose\n# done\nend\necho $env\n"
+	want := "params # arguments\n" +
+		"    # where to\n" +
+		"    param env default \"dev\"\n" +
+		"    param target\n" +
+		"    flag verbose\n" +
+		"    # done\n" +
+		"end\n" +
+		"echo $env"
+	if got := format(t, src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormat_ElseIfAndMatch(t *testing.T) {
+	src := "if $x==1\necho \"one\"\nelse   if $x>1\necho \"more\"\n# none\nend\n" +
+		"match $x # dispatch\n# first\ncase 1,\"one\"\necho \"one\"\ncase -1\nelse\n# nothing\nend\n"
+	want := "if $x == 1\n" +
+		"    echo \"one\"\n" +
+		"else if $x > 1\n" +
+		"    echo \"more\"\n" +
+		"    # none\n" +
+		"end\n" +
+		"match $x # dispatch\n" +
+		"    # first\n" +
+		"    case 1, \"one\"\n" +
+		"        echo \"one\"\n" +
+		"    case -1\n" +
+		"    else\n" +
+		"        # nothing\n" +
+		"end"
+	if got := format(t, src); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormat_Expressions(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{`echo "say \"hi\"\tnow\\"`, `echo "say \"hi\"\tnow\\"`},
+		{"set x (1 + 2) * 3", "set x (1 + 2) * 3"},
+		{"set x 1 + 2 * 3", "set x 1 + 2 * 3"},
+		{"set x 1 - (2 - 3)", "set x 1 - (2 - 3)"},
+		{"set x (1 - 2) - 3", "set x 1 - 2 - 3"},
+		{"set x 2 ** 3 ** 2", "set x 2 ** 3 ** 2"},
+		{"set x (2 ** 3) ** 2", "set x (2 ** 3) ** 2"},
+		{"set x -(1 + 2)", "set x -(1 + 2)"},
+		{"set x !($a && $b) || $c", "set x !($a && $b) || $c"},
+		{"set x (exists \"a\") && $b", "set x (exists \"a\") && $b"},
+		{"set x -($a[0])", "set x -($a[0])"},
+		{"set x [1, \"two\"]", "set x [1, \"two\"]"},
+		{"set x {a: 1, b: \"b\"}", "set x {a: 1, b: \"b\"}"},
+		{"f 1 (-2) ([3])", "f 1 (-2) ([3])"},
+		{"x = $x + 1", "x = $x + 1"},
+		{"set x $a&$b|$c ^ 1<<2%3", "set x $a & $b | $c ^ 1 << 2 % 3"},
+		{"set x ($a | $b) & ($c << 1) == 0", "set x ($a | $b) & $c << 1 == 0"},
+		{"set x 1 << (2 << 3)", "set x 1 << (2 << 3)"},
+		{"x+=2*3", "x += 2 * 3"},
+		{"x %= (1 | 2)", "x %= 1 | 2"},
+		{"x++", "x++"},
+		{"x --", "x--"},
+		{"set x (add  $a (neg -1))*2", "set x (add $a (neg -1)) * 2"},
+		{"for k,v in $m.opts\nend", "for k, v in $m.opts\nend"},
+		{"run \"git commit -m\"  $msg (-1)", "run \"git commit -m\" $msg (-1)"},
+		{"set out (run  \"git\" \"rev-parse\"  $ref)", "set out (run \"git\" \"rev-parse\" $ref)"},
+		{"echo \"n: $(f \\\"a\\\")\"", "echo \"n: $(f \\\"a\\\")\""},
+	}
+	for _, tc := range cases {
+		if got := format(t, tc.src); got != tc.want {
+			t.Errorf("Format(%q) = %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestFormat_ExamplesRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "..", "examples", "*.fin"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			once := format(t, string(src))
+			if twice := format(t, once); twice != once {
+				t.Fatalf("formatting is not idempotent:\n%s\nthen:\n%s", once, twice)
+			}
+			if got, want := shape(parse(t, once)), shape(parse(t, string(src))); got != want {
+				t.Fatalf("formatting changed the program:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+var positions = regexp.MustCompile(` @\d+:\d+`)
+
+// shape renders a program without source positions.
+func shape(prog *ast.Program) string {
+	return positions.ReplaceAllString(ast.Format(prog), "")
+}
+
+func format(t *testing.T, src string) string {
+	t.Helper()
+	return Format(parse(t, src))
+}
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	p := parser.New(parser.CollectTokens(lexer.New(src)))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return prog
+}
@@ -1,6 +1,11 @@
 package generator
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
 
 // Context holds generator state: output buffer, indentation, and label counter.
 // It is scoped to a generator instance to avoid globals and ensure deterministic output.
@@ -10,11 +15,28 @@ type Context struct {
 	out          *strings.Builder
 	loopStack    []loopLabels
 	returnStack  []returnTarget
+	pos          ast.Pos                // statement currently being lowered
+	srcMap       []ast.Pos              // statement position for each emitted line
+	strict       bool                   // check the exit code of runs and calls
+	handlers     []*handler             // enclosing unchecked blocks and try statements
+	file         string                 // source path for strict-mode failure messages
+	helpers      []string               // built-in helpers the program calls, in order of first use
+	types        map[ast.Expr]sema.Type // types sema inferred for the program's expressions
+	precision    int                    // digits decimals keep after the point
 }
 
 // NewContext constructs an empty generator context.
 func NewContext() *Context {
-	return &Context{out: &strings.Builder{}}
+	return &Context{out: &strings.Builder{}, precision: sema.DefaultPrecision}
+}
+
+// typeOf returns the type of expr. Expressions sema has not seen, such as
+// the copies hoistCalls makes, are typed on their own, without variables.
+func (c *Context) typeOf(expr ast.Expr) sema.Type {
+	if t, ok := c.types[expr]; ok {
+		return t
+	}
+	return sema.TypeOf(expr, nil)
 }
 
 // pushIndent increases indentation level for subsequent lines.
@@ -34,12 +56,22 @@ func (c *Context) emitLine(s string) {
 	}
 	c.out.WriteString(s)
 	c.out.WriteString("\n")
+	c.srcMap = append(c.srcMap, c.pos)
 }
 
 // emitRawLine writes a line with no indentation (useful for labels).
 func (c *Context) emitRawLine(s string) {
 	c.out.WriteString(s)
 	c.out.WriteString("\n")
+	c.srcMap = append(c.srcMap, c.pos)
+}
+
+// setPos makes pos the source of subsequently emitted lines and returns the
+// previous position so callers can restore it.
+func (c *Context) setPos(pos ast.Pos) ast.Pos {
+	prev := c.pos
+	c.pos = pos
+	return prev
 }
 
 // NextLabel returns a new deterministic label id.
@@ -51,14 +83,19 @@ func (c *Context) NextLabel() int {
 // String returns the current output buffer.
 func (c *Context) String() string { return c.out.String() }
 
+// SourceMap returns, for each emitted line, the position of the statement that
+// produced it. Lines emitted outside any statement have a zero position.
+func (c *Context) SourceMap() []ast.Pos { return c.srcMap }
+
 // loopLabels represents break/continue targets for the current loop.
 type loopLabels struct {
 	breakLabel    string
 	continueLabel string
+	depth         int // handlers enclosing the loop
 }
 
 func (c *Context) pushLoop(breakLabel, continueLabel string) {
-	c.loopStack = append(c.loopStack, loopLabels{breakLabel: breakLabel, continueLabel: continueLabel})
+	c.loopStack = append(c.loopStack, loopLabels{breakLabel: breakLabel, continueLabel: continueLabel, depth: len(c.handlers)})
 }
 
 func (c *Context) popLoop() {
@@ -79,10 +116,11 @@ type returnTarget struct {
 	label   string
 	tempVar string
 	outVar  string
+	depth   int // handlers enclosing the function body
 }
 
 func (c *Context) pushReturn(label, tempVar, outVar string) {
-	c.returnStack = append(c.returnStack, returnTarget{label: label, tempVar: tempVar, outVar: outVar})
+	c.returnStack = append(c.returnStack, returnTarget{label: label, tempVar: tempVar, outVar: outVar, depth: len(c.handlers)})
 }
 
 func (c *Context) popReturn() {
@@ -98,3 +136,31 @@ func (c *Context) currentReturn() (returnTarget, bool) {
 	}
 	return c.returnStack[len(c.returnStack)-1], true
 }
+
+// handlerKind is the kind of block a handler stands for.
+type handlerKind int
+
+const (
+	handleUnchecked handlerKind = iota
+	handleTry                   // the body of a try statement
+	handleCatch                 // its catch block
+	handleFinally               // its finally block
+)
+
+// handler is an enclosing block that decides what a failing command does.
+type handler struct {
+	kind    handlerKind
+	id      int    // label id of the try statement
+	errVar  string // catch variable; empty without a catch block
+	finally bool   // the try statement has a finally block
+	raised  bool   // a failure continues past the finally block
+}
+
+func (c *Context) pushHandler(h *handler) { c.handlers = append(c.handlers, h) }
+
+func (c *Context) popHandler() {
+	if len(c.handlers) == 0 {
+		return
+	}
+	c.handlers = c.handlers[:len(c.handlers)-1]
+}
@@ -1,9 +1,8 @@
 package generator
 
 import (
-	"fmt"
-
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
 )
 
 // Generator is the public interface for batch code generation.
@@ -16,17 +15,49 @@ type BatchGenerator struct {
 	ctx *Context
 }
 
+// Options configures code generation.
+type Options struct {
+	// Strict makes a failing run or function call abort the script, as if
+	// the program started with the strict directive.
+	Strict bool
+	// File is the source path named in strict-mode failure messages. When
+	// empty, the messages only give the line.
+	File string
+	// Precision is the number of digits decimals keep after the point,
+	// sema.DefaultPrecision when zero.
+	Precision int
+}
+
 // NewBatchGenerator constructs a batch generator with fresh context.
 func NewBatchGenerator() *BatchGenerator {
 	return &BatchGenerator{ctx: NewContext()}
 }
 
+// NewBatchGeneratorWithOptions constructs a batch generator configured by opts.
+func NewBatchGeneratorWithOptions(opts Options) *BatchGenerator {
+	ctx := NewContext()
+	ctx.strict = opts.Strict
+	ctx.file = opts.File
+	if opts.Precision > 0 {
+		ctx.precision = opts.Precision
+	}
+	return &BatchGenerator{ctx: ctx}
+}
+
 // Generate emits batch code for the provided program.
-// Assumes the AST has been semantically validated.
+// Assumes the AST has been semantically validated. Lowering follows the
+// types sema infers for its expressions.
 func (g *BatchGenerator) Generate(p *ast.Program) (string, error) {
 	if p == nil {
 		return "", nil
 	}
+	g.ctx.types = sema.AnalyzeDefinitions(p).Types
+
+	for _, stmt := range p.Statements {
+		if _, ok := stmt.(*ast.StrictStmt); ok {
+			g.ctx.strict = true
+		}
+	}
 
 	g.ctx.emitLine("@echo off")
 	g.ctx.emitLine("setlocal EnableDelayedExpansion")
@@ -47,6 +78,7 @@ func (g *BatchGenerator) Generate(p *ast.Program) (string, error) {
 			return "", err
 		}
 	}
+	emitHelpers(g.ctx)
 
 	g.ctx.emitLine("endlocal")
 	return g.ctx.String(), nil
@@ -57,14 +89,23 @@ func (g *BatchGenerator) emitTopLevel(stmt ast.Statement) error {
 }
 
 func (g *BatchGenerator) emitFunction(fn *ast.FnDecl) error {
+	defer g.ctx.setPos(g.ctx.setPos(fn.Pos()))
 	return lowerFnDecl(g.ctx, fn, g.emitStmt)
 }
 
+// SourceMap maps each line of the generated output (0-based) to the position
+// of the Fin statement it was lowered from, for tools that trace execution of
+// the batch file back to the source.
+func (g *BatchGenerator) SourceMap() []ast.Pos {
+	return g.ctx.SourceMap()
+}
+
 // emitStmt lowers a statement; returns an error for unsupported nodes.
 func (g *BatchGenerator) emitStmt(stmt ast.Statement) error {
 	if stmt == nil {
 		return errUnsupportedStmt(ast.Pos{}, stmt)
 	}
+	defer g.ctx.setPos(g.ctx.setPos(stmt.Pos()))
 	switch s := stmt.(type) {
 	case *ast.EchoStmt:
 		lowerEchoStmt(g.ctx, s)
@@ -76,8 +117,12 @@ func (g *BatchGenerator) emitStmt(stmt ast.Statement) error {
 		lowerAssignStmt(g.ctx, s)
 	case *ast.IfStmt:
 		return lowerIfStmt(g.ctx, s, g.emitStmt)
+	case *ast.MatchStmt:
+		return lowerMatchStmt(g.ctx, s, g.emitStmt)
 	case *ast.ForStmt:
 		return lowerForStmt(g.ctx, s, g.emitStmt)
+	case *ast.ForEachStmt:
+		return lowerForEachStmt(g.ctx, s, g.emitStmt)
 	case *ast.WhileStmt:
 		return lowerWhileStmt(g.ctx, s, g.emitStmt)
 	case *ast.CallStmt:
@@ -90,6 +135,14 @@ func (g *BatchGenerator) emitStmt(stmt ast.Statement) error {
 		return lowerBreakStmt(g.ctx, s)
 	case *ast.ContinueStmt:
 		return lowerContinueStmt(g.ctx, s)
+	case *ast.StrictStmt:
+		// applied to the whole program by Generate
+	case *ast.ParamsStmt:
+		lowerParamsStmt(g.ctx, s)
+	case *ast.UncheckedStmt:
+		return lowerUncheckedStmt(g.ctx, s, g.emitStmt)
+	case *ast.TryStmt:
+		return lowerTryStmt(g.ctx, s, g.emitStmt)
 	case *ast.FnDecl:
 		return errFunctionNotLifted(s.Pos(), s.Name)
 	default:
@@ -97,12 +150,3 @@ func (g *BatchGenerator) emitStmt(stmt ast.Statement) error {
 	}
 	return nil
 }
-
-func lowerCondition(c ast.Expr) string {
-	switch cond := c.(type) {
-	case *ast.ExistsCond:
-		return fmt.Sprintf("exist %s", lowerExpr(cond.Path))
-	default:
-		return lowerExpr(cond)
-	}
-}
@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
@@ -24,6 +25,7 @@ func TestGenerate_TopLevelSetEchoRun(t *testing.T) {
 		"set x=10\n" +
 		"echo !x!\n" +
 		"git status\n" +
+		"set status=!ERRORLEVEL!\n" +
 		"endlocal\n"
 	if out != want {
 		t.Fatalf("unexpected output:\n%s", out)
@@ -93,15 +95,15 @@ func TestGenerate_Call(t *testing.T) {
 
 	want := "@echo off\n" +
 		"setlocal EnableDelayedExpansion\n" +
-		"call :fn_greet \"foo bar^&baz\"\n" +
+		"call :fn_greet \"foo bar&baz\"\n" +
 		"goto :eof\n" +
 		":fn_greet\n" +
 		"setlocal EnableDelayedExpansion\n" +
-		"set name=%1\n" +
+		"set \"name=%~1\"\n" +
 		"set ret_greet_tmp_1=\n" +
 		"    echo !name!\n" +
 		":fn_ret_greet\n" +
-		"endlocal & set fn_greet_ret=%ret_greet_tmp_1%\n" +
+		"endlocal & set \"fn_greet_ret=%ret_greet_tmp_1%\"\n" +
 		"goto :eof\n" +
 		"endlocal\n"
 
@@ -134,12 +136,12 @@ func TestGenerate_Function(t *testing.T) {
 		"goto :eof\n" +
 		":fn_greet\n" +
 		"setlocal EnableDelayedExpansion\n" +
-		"set name=%1\n" +
+		"set \"name=%~1\"\n" +
 		"set ret_greet_tmp_1=\n" +
 		"    echo Hi\n" +
 		"    echo !name!\n" +
 		":fn_ret_greet\n" +
-		"endlocal & set fn_greet_ret=%ret_greet_tmp_1%\n" +
+		"endlocal & set \"fn_greet_ret=%ret_greet_tmp_1%\"\n" +
 		"goto :eof\n" +
 		"endlocal\n"
 
@@ -148,6 +150,62 @@ func TestGenerate_Function(t *testing.T) {
 	}
 }
 
+func TestGenerate_ReturnArithmetic(t *testing.T) {
+	g := NewBatchGenerator()
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.FnDecl{
+			Name:   "add",
+			Params: []string{"a", "b"},
+			Body: []ast.Statement{
+				&ast.ReturnStmt{Value: &ast.BinaryExpr{Op: "+", Left: &ast.IdentExpr{Name: "a"}, Right: &ast.IdentExpr{Name: "b"}}},
+			},
+		},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if !strings.Contains(out, "    set /a ret_add_tmp_1=a + b\n") {
+		t.Fatalf("return value should be computed with set /a:\n%s", out)
+	}
+}
+
+func TestGenerate_SourceMap(t *testing.T) {
+	g := NewBatchGenerator()
+	echoPos := ast.Pos{Line: 2, Column: 5}
+	forPos := ast.Pos{Line: 1, Column: 1}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.ForStmt{
+			Var: "i", Start: &ast.NumberLit{Value: "1"}, End: &ast.NumberLit{Value: "2"},
+			Body: []ast.Statement{&ast.EchoStmt{Value: &ast.IdentExpr{Name: "i"}, P: echoPos}},
+			P:    forPos,
+		},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	srcMap := g.SourceMap()
+	if len(srcMap) != len(lines) {
+		t.Fatalf("source map has %d entries for %d lines", len(srcMap), len(lines))
+	}
+	for i, line := range lines {
+		var want ast.Pos
+		switch {
+		case strings.HasPrefix(line, "    echo"):
+			want = echoPos
+		case i >= 2 && i < len(lines)-1:
+			want = forPos
+		}
+		if srcMap[i] != want {
+			t.Fatalf("line %d %q maps to %v, want %v", i+1, line, srcMap[i], want)
+		}
+	}
+}
+
 func TestGenerate_IfElse(t *testing.T) {
 	g := NewBatchGenerator()
 	prog := &ast.Program{Statements: []ast.Statement{
@@ -180,3 +238,535 @@ func TestGenerate_IfElse(t *testing.T) {
 		t.Fatalf("unexpected output:\n%s", out)
 	}
 }
+
+func TestGenerate_CallExpr(t *testing.T) {
+	g := NewBatchGenerator()
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.FnDecl{
+			Name:   "add",
+			Params: []string{"a", "b"},
+			Body: []ast.Statement{
+				&ast.ReturnStmt{Value: &ast.BinaryExpr{Op: "+", Left: &ast.IdentExpr{Name: "a"}, Right: &ast.IdentExpr{Name: "b"}}},
+			},
+		},
+		&ast.SetStmt{Name: "x", Value: &ast.BinaryExpr{
+			Op: "*",
+			Left: &ast.CallExpr{Name: "add", Args: []ast.Expr{
+				&ast.CallExpr{Name: "add", Args: []ast.Expr{&ast.NumberLit{Value: "1"}, &ast.NumberLit{Value: "2"}}},
+				&ast.BinaryExpr{Op: "-", Left: &ast.NumberLit{Value: "5"}, Right: &ast.NumberLit{Value: "1"}},
+			}},
+			Right: &ast.NumberLit{Value: "2"},
+		}},
+		&ast.EchoStmt{Value: &ast.StringLit{
+			Value: "x=$x, $(add 1 $x)!",
+			Calls: []*ast.CallExpr{{Name: "add", Args: []ast.Expr{&ast.NumberLit{Value: "1"}, &ast.IdentExpr{Name: "x"}}}},
+		}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"call :fn_add 1 2\n" +
+		"set call_tmp_1=!fn_add_ret!\n" +
+		"set /a arg_tmp_2=5 - 1\n" +
+		"call :fn_add \"!call_tmp_1!\" \"!arg_tmp_2!\"\n" +
+		"set call_tmp_3=!fn_add_ret!\n" +
+		"set /a x=call_tmp_3 * 2\n" +
+		"call :fn_add 1 \"!x!\"\n" +
+		"set call_tmp_4=!fn_add_ret!\n" +
+		"echo x=!x!, !call_tmp_4!^^!\n" +
+		"goto :eof\n" +
+		":fn_add\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set \"a=%~1\"\n" +
+		"set \"b=%~2\"\n" +
+		"set ret_add_tmp_5=\n" +
+		"    set /a ret_add_tmp_5=a + b\n" +
+		"    goto fn_ret_add\n" +
+		":fn_ret_add\n" +
+		"endlocal & set \"fn_add_ret=%ret_add_tmp_5%\"\n" +
+		"goto :eof\n" +
+		"endlocal\n"
+
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_InterpolatedIndex(t *testing.T) {
+	g := NewBatchGenerator()
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.EchoStmt{Value: &ast.StringLit{Value: "$xs[$i] $xs[i] $xs[2] $$xs[i]"}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"call :__fin_copy index_tmp_1 xs_!i!\n" +
+		"call :__fin_copy index_tmp_2 xs_!i!\n" +
+		"echo !index_tmp_1! !index_tmp_2! !xs_2! $xs[i]\n" +
+		"goto :eof\n" +
+		":__fin_copy\n" +
+		"set %1=!%2!\n" +
+		"goto :eof\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_Strict(t *testing.T) {
+	g := NewBatchGeneratorWithOptions(Options{File: `C:\ci\deploy (1).fin`})
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.StrictStmt{P: ast.Pos{Line: 1, Column: 1}},
+		&ast.FnDecl{
+			Name: "stop",
+			Body: []ast.Statement{
+				&ast.RunStmt{Command: &ast.StringLit{Value: "net stop app"}, P: ast.Pos{Line: 3, Column: 5}},
+			},
+		},
+		&ast.UncheckedStmt{Body: []ast.Statement{
+			&ast.RunStmt{Command: &ast.StringLit{Value: "taskkill"}, P: ast.Pos{Line: 6, Column: 5}},
+			&ast.CallStmt{Name: "stop"},
+		}},
+		&ast.CallStmt{Name: "stop"},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"taskkill\n" +
+		"set status=!ERRORLEVEL!\n" +
+		"call :fn_stop \n" +
+		"call :fn_stop \n" +
+		"if !ERRORLEVEL! NEQ 0 exit /b !ERRORLEVEL!\n" +
+		"goto :eof\n" +
+		":fn_stop\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set ret_stop_tmp_1=\n" +
+		"    net stop app\n" +
+		"    set status=!ERRORLEVEL!\n" +
+		"    if !status! NEQ 0 (\n" +
+		"        >&2 echo fin: C:\\ci\\deploy ^(1^).fin:3: command failed with exit code !status!\n" +
+		"        exit /b !status!\n" +
+		"    )\n" +
+		":fn_ret_stop\n" +
+		"endlocal & set \"fn_stop_ret=%ret_stop_tmp_1%\"\n" +
+		"exit /b 0\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_StrictOptionWithoutFile(t *testing.T) {
+	g := NewBatchGeneratorWithOptions(Options{Strict: true})
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.RunStmt{Command: &ast.StringLit{Value: "git pull"}, P: ast.Pos{Line: 2, Column: 1}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	if !strings.Contains(out, ">&2 echo fin: line 2: command failed with exit code !status!\n") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_Params(t *testing.T) {
+	g := NewBatchGeneratorWithOptions(Options{File: "deploy.fin"})
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.ParamsStmt{
+			Params: []ast.Param{
+				{Name: "target"},
+				{Name: "env", Default: &ast.StringLit{Value: "dev"}},
+				{Name: "verbose", Flag: true},
+			},
+			P: ast.Pos{Line: 1, Column: 1},
+		},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set target=\n" +
+		"set env=dev\n" +
+		"set verbose=false\n" +
+		":params_next_1\n" +
+		"if \"%~1\"==\"\" goto params_end_1\n" +
+		"set \"__fin_params_arg=%~1\"\n" +
+		"if /i \"!__fin_params_arg!\"==\"--help\" goto params_help_1\n" +
+		"if \"!__fin_params_arg!\"==\"/?\" goto params_help_1\n" +
+		"if /i \"!__fin_params_arg!\"==\"--target\" (\n" +
+		"    if \"%~2\"==\"\" (\n" +
+		"        >&2 echo fin: deploy.fin:1: missing value for --target\n" +
+		"        goto params_fail_1\n" +
+		"    )\n" +
+		"    set \"target=%~2\"\n" +
+		"    shift\n" +
+		"    goto params_shift_1\n" +
+		")\n" +
+		"if /i \"!__fin_params_arg:~0,8!\"==\"/target:\" (\n" +
+		"    set \"target=!__fin_params_arg:~8!\"\n" +
+		"    goto params_shift_1\n" +
+		")\n" +
+		"if /i \"!__fin_params_arg!\"==\"--env\" (\n" +
+		"    if \"%~2\"==\"\" (\n" +
+		"        >&2 echo fin: deploy.fin:1: missing value for --env\n" +
+		"        goto params_fail_1\n" +
+		"    )\n" +
+		"    set \"env=%~2\"\n" +
+		"    shift\n" +
+		"    goto params_shift_1\n" +
+		")\n" +
+		"if /i \"!__fin_params_arg:~0,5!\"==\"/env:\" (\n" +
+		"    set \"env=!__fin_params_arg:~5!\"\n" +
+		"    goto params_shift_1\n" +
+		")\n" +
+		"if /i \"!__fin_params_arg!\"==\"--verbose\" (\n" +
+		"    set verbose=true\n" +
+		"    goto params_shift_1\n" +
+		")\n" +
+		"if /i \"!__fin_params_arg!\"==\"/verbose\" (\n" +
+		"    set verbose=true\n" +
+		"    goto params_shift_1\n" +
+		")\n" +
+		">&2 echo fin: deploy.fin:1: unknown argument !__fin_params_arg!\n" +
+		"goto params_fail_1\n" +
+		":params_shift_1\n" +
+		"shift\n" +
+		"goto params_next_1\n" +
+		":params_help_1\n" +
+		"echo Options:\n" +
+		"echo   --target VALUE  required\n" +
+		"echo   --env VALUE     default: dev\n" +
+		"echo   --verbose\n" +
+		"echo   --help          show this help\n" +
+		"exit /b 0\n" +
+		":params_fail_1\n" +
+		">&2 echo Options:\n" +
+		">&2 echo   --target VALUE  required\n" +
+		">&2 echo   --env VALUE     default: dev\n" +
+		">&2 echo   --verbose\n" +
+		">&2 echo   --help          show this help\n" +
+		"exit /b 1\n" +
+		":params_end_1\n" +
+		"if not defined target (\n" +
+		"    >&2 echo fin: deploy.fin:1: missing required parameter --target\n" +
+		"    goto params_fail_1\n" +
+		")\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_Try(t *testing.T) {
+	g := NewBatchGenerator()
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.WhileStmt{
+			Cond: &ast.BoolLit{Value: true},
+			Body: []ast.Statement{
+				&ast.TryStmt{
+					Body: []ast.Statement{
+						&ast.RunStmt{Command: &ast.StringLit{Value: "build"}, P: ast.Pos{Line: 3, Column: 9}},
+						&ast.BreakStmt{},
+					},
+					Err:     "err",
+					Catch:   []ast.Statement{&ast.EchoStmt{Value: &ast.PropertyExpr{Object: &ast.IdentExpr{Name: "err"}, Field: "code"}}},
+					Finally: []ast.Statement{&ast.EchoStmt{Value: &ast.StringLit{Value: "done"}}},
+				},
+			},
+		},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		":while_start_1\n" +
+		"set try_exit_tmp_2=\n" +
+		"    build\n" +
+		"    set status=!ERRORLEVEL!\n" +
+		"    if !status! NEQ 0 (\n" +
+		"        set err_code=!status!\n" +
+		"        set err_command=build\n" +
+		"        goto try_catch_2\n" +
+		"    )\n" +
+		"    set try_exit_tmp_2=while_end_1\n" +
+		"    goto try_finally_2\n" +
+		"goto try_finally_2\n" +
+		":try_catch_2\n" +
+		"    echo !err_code!\n" +
+		":try_finally_2\n" +
+		"    echo done\n" +
+		"if defined try_exit_tmp_2 goto !try_exit_tmp_2!\n" +
+		"goto while_start_1\n" +
+		":while_end_1\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_BuiltinHelpers(t *testing.T) {
+	g := NewBatchGenerator()
+	trim := func(arg ast.Expr) ast.Expr {
+		return &ast.CallExpr{Name: "trim", Args: []ast.Expr{arg}}
+	}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "s", Value: &ast.StringLit{Value: " a b "}},
+		&ast.SetStmt{Name: "t", Value: trim(&ast.IdentExpr{Name: "s"})},
+		&ast.EchoStmt{Value: &ast.CallExpr{Name: "substr", Args: []ast.Expr{
+			trim(&ast.IdentExpr{Name: "t"}),
+			&ast.NumberLit{Value: "0"},
+			&ast.BinaryExpr{Left: &ast.IdentExpr{Name: "t_n"}, Op: "+", Right: &ast.NumberLit{Value: "1"}},
+		}}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set s= a b \n" +
+		"call :__fin_trim s\n" +
+		"set call_tmp_1=!__fin_ret!\n" +
+		"set t=!call_tmp_1!\n" +
+		"call :__fin_trim t\n" +
+		"set call_tmp_2=!__fin_ret!\n" +
+		"set /a arg_tmp_3=t_n + 1\n" +
+		"call :__fin_substr call_tmp_2 \"0\" \"!arg_tmp_3!\"\n" +
+		"set call_tmp_4=!__fin_ret!\n" +
+		"echo !call_tmp_4!\n" +
+		"goto :eof\n" +
+		":__fin_trim\n" +
+		"set __fin_ret=!%1!\n" +
+		":__fin_trim_start\n" +
+		"if not defined __fin_ret goto :eof\n" +
+		"if not \"!__fin_ret:~0,1!\"==\" \" goto __fin_trim_end\n" +
+		"set __fin_ret=!__fin_ret:~1!\n" +
+		"goto __fin_trim_start\n" +
+		":__fin_trim_end\n" +
+		"if not \"!__fin_ret:~-1!\"==\" \" goto :eof\n" +
+		"set __fin_ret=!__fin_ret:~0,-1!\n" +
+		"goto __fin_trim_end\n" +
+		":__fin_substr\n" +
+		"set __fin_ret=!%1!\n" +
+		"if not defined __fin_ret goto :eof\n" +
+		"set __fin_ret=!__fin_ret:~%~2,%~3!\n" +
+		"goto :eof\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_ListBuiltinStatements(t *testing.T) {
+	g := NewBatchGenerator()
+	xs := &ast.IdentExpr{Name: "xs"}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "xs", Value: &ast.ListLit{Elements: []ast.Expr{&ast.NumberLit{Value: "1"}}}},
+		&ast.CallStmt{Name: "push", Args: []ast.Expr{xs, &ast.NumberLit{Value: "2"}}},
+		&ast.CallStmt{Name: "pop", Args: []ast.Expr{xs}},
+		&ast.SetStmt{Name: "v", Value: &ast.CallExpr{Name: "pop", Args: []ast.Expr{xs}}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set xs_0=1\n" +
+		"set xs_len=1\n" +
+		"set arg_tmp_1=2\n" +
+		"call :__fin_push xs arg_tmp_1\n" +
+		"call :__fin_pop xs\n" +
+		"call :__fin_pop xs\n" +
+		"set call_tmp_2=!__fin_ret!\n" +
+		"set v=!call_tmp_2!\n" +
+		"goto :eof\n" +
+		":__fin_push\n" +
+		"set %1_!%1_len!=!%2!\n" +
+		"set /a %1_len+=1\n" +
+		"goto :eof\n" +
+		":__fin_pop\n" +
+		"set __fin_ret=\n" +
+		"if !%1_len! LEQ 0 goto :eof\n" +
+		"set /a %1_len-=1\n" +
+		"call :__fin_copy __fin_ret %1_!%1_len!\n" +
+		"set %1_!%1_len!=\n" +
+		"goto :eof\n" +
+		":__fin_copy\n" +
+		"set %1=!%2!\n" +
+		"goto :eof\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_ConditionValue(t *testing.T) {
+	g := NewBatchGenerator()
+	a := &ast.IdentExpr{Name: "a"}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "a", Value: &ast.NumberLit{Value: "1"}},
+		&ast.SetStmt{Name: "ok", Value: &ast.BinaryExpr{Op: "<", Left: a, Right: &ast.NumberLit{Value: "2"}}},
+		&ast.EchoStmt{Value: &ast.UnaryExpr{Op: "!", Right: &ast.IdentExpr{Name: "ok"}}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	want := "@echo off\n" +
+		"setlocal EnableDelayedExpansion\n" +
+		"set a=1\n" +
+		"if !a! GEQ 2 goto cond_false_1\n" +
+		"set ok=true\n" +
+		"goto cond_end_1\n" +
+		":cond_false_1\n" +
+		"set ok=false\n" +
+		":cond_end_1\n" +
+		"if \"!ok!\"==\"\" goto cond_skip_4\n" +
+		"if \"!ok!\"==\"false\" goto cond_skip_4\n" +
+		"if \"!ok!\"==\"0\" goto cond_skip_4\n" +
+		"goto cond_false_3\n" +
+		":cond_skip_4\n" +
+		"set cond_tmp_2=true\n" +
+		"goto cond_end_3\n" +
+		":cond_false_3\n" +
+		"set cond_tmp_2=false\n" +
+		":cond_end_3\n" +
+		"echo !cond_tmp_2!\n" +
+		"endlocal\n"
+	if out != want {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestGenerate_ContainsByType(t *testing.T) {
+	g := NewBatchGenerator()
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "s", Value: &ast.StringLit{Value: "abc"}},
+		&ast.SetStmt{Name: "xs", Value: &ast.ListLit{Elements: []ast.Expr{&ast.StringLit{Value: "abc"}}}},
+		&ast.EchoStmt{Value: &ast.CallExpr{Name: "contains", Args: []ast.Expr{&ast.IdentExpr{Name: "s"}, &ast.StringLit{Value: "b"}}}},
+		&ast.EchoStmt{Value: &ast.CallExpr{Name: "contains", Args: []ast.Expr{&ast.IdentExpr{Name: "xs"}, &ast.StringLit{Value: "b"}}}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	for _, want := range []string{"call :__fin_contains s arg_tmp_1\n", "call :__fin_contains_list xs arg_tmp_3\n"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestGenerate_Pow(t *testing.T) {
+	g := NewBatchGeneratorWithOptions(Options{File: "calc.fin"})
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "x", Value: &ast.NumberLit{Value: "3"}},
+		&ast.SetStmt{Name: "y", Value: &ast.BinaryExpr{
+			Left:  &ast.BinaryExpr{Left: &ast.IdentExpr{Name: "x"}, Op: "**", Right: &ast.NumberLit{Value: "2"}, P: ast.Pos{Line: 2, Column: 9}},
+			Op:    "+",
+			Right: &ast.BinaryExpr{Left: &ast.IdentExpr{Name: "x"}, Op: "-", Right: &ast.NumberLit{Value: "1"}},
+		}, P: ast.Pos{Line: 2, Column: 1}},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	want := "call :__fin_pow x 2\n" +
+		"if !ERRORLEVEL! NEQ 0 (\n" +
+		"    >&2 echo fin: calc.fin:2: integer overflow: !__fin_pow_b! ** !__fin_pow_e! does not fit in 32 bits\n" +
+		"    exit /b 1\n" +
+		")\n" +
+		"set pow_tmp_1=!__fin_ret!\n" +
+		"set /a \"y=pow_tmp_1 + (x - 1)\"\n"
+	if !strings.Contains(out, want) || !strings.Contains(out, "\n:__fin_pow\n") {
+		t.Fatalf("missing pow call and helper in:\n%s", out)
+	}
+}
+
+func TestGenerate_Decimal(t *testing.T) {
+	g := NewBatchGeneratorWithOptions(Options{File: "shop.fin", Precision: 3})
+	total := &ast.IdentExpr{Name: "total"}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "price", Value: &ast.NumberLit{Value: "19.99"}},
+		&ast.SetStmt{Name: "n", Value: &ast.NumberLit{Value: "3"}},
+		&ast.SetStmt{Name: "total", Value: &ast.BinaryExpr{
+			Left:  &ast.BinaryExpr{Left: &ast.IdentExpr{Name: "price"}, Op: "*", Right: &ast.IdentExpr{Name: "n"}},
+			Op:    "+",
+			Right: &ast.NumberLit{Value: "1"},
+		}},
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{Left: total, Op: ">", Right: &ast.NumberLit{Value: "50"}},
+			Then: []ast.Statement{
+				&ast.EchoStmt{Value: &ast.BinaryExpr{Left: total, Op: "/", Right: &ast.NumberLit{Value: "0.5"}, P: ast.Pos{Line: 5, Column: 17}}},
+			},
+		},
+	}}
+
+	out, err := g.Generate(prog)
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	want := "set price=19.99\n" +
+		"set n=3\n" +
+		"call :__fin_dec_mul price n 3\n" +
+		"set dec_tmp_1=!__fin_ret!\n" +
+		"set arg_tmp_2=1\n" +
+		"call :__fin_dec_add dec_tmp_1 arg_tmp_2 3\n" +
+		"set dec_tmp_3=!__fin_ret!\n" +
+		"set total=!dec_tmp_3!\n" +
+		"set arg_tmp_4=50\n" +
+		"call :__fin_dec_cmp total arg_tmp_4 3\n" +
+		"set dec_tmp_5=!__fin_ret!\n" +
+		"if !dec_tmp_5! GTR 0 (\n" +
+		"    set arg_tmp_6=0.5\n" +
+		"    call :__fin_dec_div total arg_tmp_6 3\n" +
+		"    if !ERRORLEVEL! NEQ 0 (\n" +
+		"        >&2 echo fin: shop.fin:5: division by zero\n" +
+		"        exit /b 1\n" +
+		"    )\n" +
+		"    set dec_tmp_7=!__fin_ret!\n" +
+		"    echo !dec_tmp_7!\n" +
+		")\n"
+	if !strings.Contains(out, want) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	for _, helper := range []string{"dec_in", "dec_out", "dec_add", "dec_mul", "dec_div", "dec_cmp"} {
+		if !strings.Contains(out, "\n:__fin_"+helper+"\n") {
+			t.Errorf("missing helper %s in:\n%s", helper, out)
+		}
+	}
+}
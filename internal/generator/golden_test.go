@@ -32,11 +32,11 @@ func TestGenerator_Golden(t *testing.T) {
 				"goto :eof\n" +
 				":fn_greet\n" +
 				"setlocal EnableDelayedExpansion\n" +
-				"set name=%1\n" +
+				"set \"name=%~1\"\n" +
 				"set ret_greet_tmp_1=\n" +
 				"    echo !name!\n" +
 				":fn_ret_greet\n" +
-				"endlocal & set fn_greet_ret=%ret_greet_tmp_1%\n" +
+				"endlocal & set \"fn_greet_ret=%ret_greet_tmp_1%\"\n" +
 				"goto :eof\n" +
 				"endlocal\n",
 		},
@@ -53,11 +53,12 @@ func TestGenerator_Golden(t *testing.T) {
 				"setlocal EnableDelayedExpansion\n" +
 				"set total=0\n" +
 				"set /a i=1\n" +
-				":loop_continue_1\n" +
+				":for_start_1\n" +
 				"if !i! GTR 3 goto loop_break_1\n" +
 				"    echo !i!\n" +
+				":loop_continue_1\n" +
 				"set /a i=i+1\n" +
-				"goto loop_continue_1\n" +
+				"goto for_start_1\n" +
 				":loop_break_1\n" +
 				":while_start_2\n" +
 				"goto while_end_2\n" +
@@ -0,0 +1,496 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Built-in functions compile to calls of helper subroutines, emitted after
+// the program's functions and only if the program uses them. A helper takes
+// the names of the variables holding its string arguments, so values only
+// ever go through delayed expansion, and leaves its result in __fin_ret.
+// Its own state lives in variables named after it, so helpers can call each
+// other. String comparisons use `if "a"=="b"`, which is case-sensitive,
+// rather than the case-insensitive :old=new substitution.
+//
+// List helpers take the name of the list and keep NAME_len up to date. An
+// element whose index is itself in a variable is read with the copy helper,
+// `call :__fin_copy dst NAME_!i!`, whose arguments are expanded before the
+// call, so values are never parsed again as `call set` would.
+
+// builtinHelper is a helper subroutine: the helpers it calls and its lines
+// after the label.
+type builtinHelper struct {
+	uses []string
+	body []string
+}
+
+// builtinHelpers holds the helper of each built-in function, and the
+// internal helpers in internalHelpers.
+var builtinHelpers = map[string]builtinHelper{
+	"len": {body: []string{
+		"set __fin_len_s=!%1!",
+		"set __fin_ret=0",
+		":__fin_len_next",
+		"if not defined __fin_len_s goto :eof",
+		"set __fin_len_s=!__fin_len_s:~1!",
+		"set /a __fin_ret+=1",
+		"goto __fin_len_next",
+	}},
+	"upper": {body: caseHelper("abcdefghijklmnopqrstuvwxyz", strings.ToUpper)},
+	"lower": {body: caseHelper("ABCDEFGHIJKLMNOPQRSTUVWXYZ", strings.ToLower)},
+	"substr": {body: []string{
+		"set __fin_ret=!%1!",
+		"if not defined __fin_ret goto :eof",
+		"set __fin_ret=!__fin_ret:~%~2,%~3!",
+		"goto :eof",
+	}},
+	"replace": {uses: []string{"len"}, body: []string{
+		"call :__fin_len %2",
+		"set __fin_replace_n=!__fin_ret!",
+		"set __fin_replace_s=!%1!",
+		"set __fin_ret=!%1!",
+		"if !__fin_replace_n! EQU 0 goto :eof",
+		"set __fin_ret=",
+		":__fin_replace_next",
+		"if not defined __fin_replace_s goto :eof",
+		"if \"!__fin_replace_s:~0,%__fin_replace_n%!\"==\"!%2!\" goto __fin_replace_match",
+		"set __fin_ret=!__fin_ret!!__fin_replace_s:~0,1!",
+		"set __fin_replace_s=!__fin_replace_s:~1!",
+		"goto __fin_replace_next",
+		":__fin_replace_match",
+		"set __fin_ret=!__fin_ret!!%3!",
+		"set __fin_replace_s=!__fin_replace_s:~%__fin_replace_n%!",
+		"goto __fin_replace_next",
+	}},
+	"trim": {body: []string{
+		"set __fin_ret=!%1!",
+		":__fin_trim_start",
+		"if not defined __fin_ret goto :eof",
+		"if not \"!__fin_ret:~0,1!\"==\" \" goto __fin_trim_end",
+		"set __fin_ret=!__fin_ret:~1!",
+		"goto __fin_trim_start",
+		":__fin_trim_end",
+		"if not \"!__fin_ret:~-1!\"==\" \" goto :eof",
+		"set __fin_ret=!__fin_ret:~0,-1!",
+		"goto __fin_trim_end",
+	}},
+	"starts_with": {uses: []string{"len"}, body: affixHelper("starts_with", "~0,")},
+	"ends_with":   {uses: []string{"len"}, body: affixHelper("ends_with", "~-")},
+	"find": {uses: []string{"len"}, body: []string{
+		"call :__fin_len %2",
+		"set __fin_find_n=!__fin_ret!",
+		"set __fin_find_s=!%1!",
+		"set __fin_ret=0",
+		"if !__fin_find_n! EQU 0 goto :eof",
+		":__fin_find_next",
+		"if not defined __fin_find_s goto __fin_find_none",
+		"if \"!__fin_find_s:~0,%__fin_find_n%!\"==\"!%2!\" goto :eof",
+		"set __fin_find_s=!__fin_find_s:~1!",
+		"set /a __fin_ret+=1",
+		"goto __fin_find_next",
+		":__fin_find_none",
+		"set __fin_ret=-1",
+		"goto :eof",
+	}},
+	"contains": {uses: []string{"find"}, body: []string{
+		"call :__fin_find %1 %2",
+		"if !__fin_ret! LSS 0 (set __fin_ret=false) else set __fin_ret=true",
+		"goto :eof",
+	}},
+	// contains_list is contains of a list.
+	"contains_list": {uses: []string{"index_of"}, body: []string{
+		"call :__fin_index_of %1 %2",
+		"if !__fin_ret! LSS 0 (set __fin_ret=false) else set __fin_ret=true",
+		"goto :eof",
+	}},
+	// split takes the name of the list to store the parts in as its third
+	// argument.
+	"split": {uses: []string{"len", "clear"}, body: []string{
+		"set /a __fin_split_old=%3_len",
+		"call :__fin_len %2",
+		"set __fin_split_n=!__fin_ret!",
+		"set __fin_split_s=!%1!",
+		"set __fin_split_i=0",
+		"set __fin_split_part=",
+		"if !__fin_split_n! EQU 0 goto __fin_split_char",
+		":__fin_split_next",
+		"if not defined __fin_split_s goto __fin_split_end",
+		"if \"!__fin_split_s:~0,%__fin_split_n%!\"==\"!%2!\" goto __fin_split_cut",
+		"set __fin_split_part=!__fin_split_part!!__fin_split_s:~0,1!",
+		"set __fin_split_s=!__fin_split_s:~1!",
+		"goto __fin_split_next",
+		":__fin_split_cut",
+		"set %3_!__fin_split_i!=!__fin_split_part!",
+		"set /a __fin_split_i+=1",
+		"set __fin_split_part=",
+		"set __fin_split_s=!__fin_split_s:~%__fin_split_n%!",
+		"goto __fin_split_next",
+		":__fin_split_end",
+		"set %3_!__fin_split_i!=!__fin_split_part!",
+		"set /a %3_len=__fin_split_i+1",
+		"call :__fin_clear %3 __fin_split_old",
+		"goto :eof",
+		":__fin_split_char",
+		"if not defined __fin_split_s goto __fin_split_done",
+		"set %3_!__fin_split_i!=!__fin_split_s:~0,1!",
+		"set /a __fin_split_i+=1",
+		"set __fin_split_s=!__fin_split_s:~1!",
+		"goto __fin_split_char",
+		":__fin_split_done",
+		"set %3_len=!__fin_split_i!",
+		"call :__fin_clear %3 __fin_split_old",
+		"goto :eof",
+	}},
+	// The list helpers take the name of the list, rather than a variable
+	// holding a value, as their first argument.
+	"join": {body: []string{
+		"set /a __fin_join_n=%1_len",
+		"set __fin_join_i=0",
+		"set __fin_ret=",
+		":__fin_join_next",
+		"if !__fin_join_i! GEQ !__fin_join_n! goto :eof",
+		"if !__fin_join_i! GTR 0 set __fin_ret=!__fin_ret!!%2!",
+		"set __fin_ret=!__fin_ret!!%1_%__fin_join_i%!",
+		"set /a __fin_join_i+=1",
+		"goto __fin_join_next",
+	}},
+	"push": {body: []string{
+		"set %1_!%1_len!=!%2!",
+		"set /a %1_len+=1",
+		"goto :eof",
+	}},
+	"pop": {uses: []string{"copy"}, body: []string{
+		"set __fin_ret=",
+		"if !%1_len! LEQ 0 goto :eof",
+		"set /a %1_len-=1",
+		"call :__fin_copy __fin_ret %1_!%1_len!",
+		"set %1_!%1_len!=",
+		"goto :eof",
+	}},
+	"insert": {uses: []string{"copy"}, body: []string{
+		"set /a __fin_insert_at=%2, __fin_insert_i=%1_len",
+		"if !__fin_insert_at! LSS 0 set __fin_insert_at=0",
+		"if !__fin_insert_at! GTR !%1_len! set __fin_insert_at=!%1_len!",
+		":__fin_insert_next",
+		"if !__fin_insert_i! LEQ !__fin_insert_at! goto __fin_insert_put",
+		"set /a __fin_insert_j=__fin_insert_i-1",
+		"call :__fin_copy %1_!__fin_insert_i! %1_!__fin_insert_j!",
+		"set __fin_insert_i=!__fin_insert_j!",
+		"goto __fin_insert_next",
+		":__fin_insert_put",
+		"set %1_!__fin_insert_at!=!%3!",
+		"set /a %1_len+=1",
+		"goto :eof",
+	}},
+	"remove_at": {uses: []string{"copy"}, body: []string{
+		"set __fin_ret=",
+		"set /a __fin_remove_at_i=%2",
+		"if !__fin_remove_at_i! LSS 0 goto :eof",
+		"if !__fin_remove_at_i! GEQ !%1_len! goto :eof",
+		"call :__fin_copy __fin_ret %1_!__fin_remove_at_i!",
+		"set /a %1_len-=1",
+		":__fin_remove_at_next",
+		"if !__fin_remove_at_i! GEQ !%1_len! goto __fin_remove_at_end",
+		"set /a __fin_remove_at_j=__fin_remove_at_i+1",
+		"call :__fin_copy %1_!__fin_remove_at_i! %1_!__fin_remove_at_j!",
+		"set __fin_remove_at_i=!__fin_remove_at_j!",
+		"goto __fin_remove_at_next",
+		":__fin_remove_at_end",
+		"set %1_!%1_len!=",
+		"goto :eof",
+	}},
+	"index_of": {uses: []string{"copy"}, body: []string{
+		"set __fin_index_of_i=0",
+		":__fin_index_of_next",
+		"if !__fin_index_of_i! GEQ !%1_len! goto __fin_index_of_none",
+		"call :__fin_copy __fin_index_of_v %1_!__fin_index_of_i!",
+		"if \"!__fin_index_of_v!\"==\"!%2!\" goto __fin_index_of_found",
+		"set /a __fin_index_of_i+=1",
+		"goto __fin_index_of_next",
+		":__fin_index_of_found",
+		"set __fin_ret=!__fin_index_of_i!",
+		"goto :eof",
+		":__fin_index_of_none",
+		"set __fin_ret=-1",
+		"goto :eof",
+	}},
+	"reverse": {uses: []string{"copy"}, body: []string{
+		"set __fin_reverse_i=0",
+		"set /a __fin_reverse_j=%1_len-1",
+		":__fin_reverse_next",
+		"if !__fin_reverse_i! GEQ !__fin_reverse_j! goto :eof",
+		"call :__fin_copy __fin_reverse_v %1_!__fin_reverse_i!",
+		"call :__fin_copy %1_!__fin_reverse_i! %1_!__fin_reverse_j!",
+		"set %1_!__fin_reverse_j!=!__fin_reverse_v!",
+		"set /a __fin_reverse_i+=1, __fin_reverse_j-=1",
+		"goto __fin_reverse_next",
+	}},
+	// sort is an insertion sort, so it is stable.
+	"sort": {uses: []string{"copy", "order"}, body: []string{
+		"set __fin_sort_i=1",
+		":__fin_sort_next",
+		"if !__fin_sort_i! GEQ !%1_len! goto :eof",
+		"call :__fin_copy __fin_sort_v %1_!__fin_sort_i!",
+		"set __fin_sort_j=!__fin_sort_i!",
+		":__fin_sort_shift",
+		"if !__fin_sort_j! EQU 0 goto __fin_sort_put",
+		"set /a __fin_sort_k=__fin_sort_j-1",
+		"call :__fin_copy __fin_sort_w %1_!__fin_sort_k!",
+		"call :__fin_order __fin_sort_w __fin_sort_v",
+		"if !__fin_ret! EQU 0 goto __fin_sort_put",
+		"set %1_!__fin_sort_j!=!__fin_sort_w!",
+		"set __fin_sort_j=!__fin_sort_k!",
+		"goto __fin_sort_shift",
+		":__fin_sort_put",
+		"set %1_!__fin_sort_j!=!__fin_sort_v!",
+		"set /a __fin_sort_i+=1",
+		"goto __fin_sort_next",
+	}},
+	// slice takes the name of the list to store the elements in as its
+	// fourth argument.
+	"slice": {uses: []string{"copy", "clear"}, body: []string{
+		"set /a __fin_slice_i=%2, __fin_slice_e=%3, __fin_slice_n=0, __fin_slice_old=%4_len",
+		"if !__fin_slice_i! LSS 0 set /a __fin_slice_i+=%1_len",
+		"if !__fin_slice_i! LSS 0 set __fin_slice_i=0",
+		"if !__fin_slice_e! LSS 0 set /a __fin_slice_e+=%1_len",
+		"if !__fin_slice_e! GTR !%1_len! set __fin_slice_e=!%1_len!",
+		":__fin_slice_next",
+		"if !__fin_slice_i! GEQ !__fin_slice_e! goto __fin_slice_end",
+		"call :__fin_copy %4_!__fin_slice_n! %1_!__fin_slice_i!",
+		"set /a __fin_slice_i+=1, __fin_slice_n+=1",
+		"goto __fin_slice_next",
+		":__fin_slice_end",
+		"set %4_len=!__fin_slice_n!",
+		"call :__fin_clear %4 __fin_slice_old",
+		"goto :eof",
+	}},
+	// clear removes the elements of the list %1 past its end, up to the
+	// length in the variable %2 that it had before it was stored.
+	"clear": {body: []string{
+		"set __fin_clear_i=!%1_len!",
+		":__fin_clear_next",
+		"if !__fin_clear_i! GEQ !%2! goto :eof",
+		"set %1_!__fin_clear_i!=",
+		"set /a __fin_clear_i+=1",
+		"goto __fin_clear_next",
+	}},
+	// pow sets __fin_ret to %1 ** %2, both read with set /a. A negative
+	// exponent truncates toward zero like division, so it gives 0 unless
+	// the base is 1 or -1, and 0 ** 0 is 1. It exits with code 1 when the
+	// result does not fit in 32 bits, which the quotient of each product
+	// and the base detects.
+	"pow": {body: []string{
+		"set /a __fin_pow_b=%1, __fin_pow_e=%2, __fin_pow_i=0, __fin_ret=1",
+		"if !__fin_pow_b! EQU 1 exit /b 0",
+		"if !__fin_pow_b! EQU -1 goto __fin_pow_sign",
+		"if !__fin_pow_e! LSS 0 set __fin_ret=0",
+		"if !__fin_pow_e! LSS 0 exit /b 0",
+		"if !__fin_pow_b! EQU 0 goto __fin_pow_zero",
+		":__fin_pow_next",
+		"if !__fin_pow_i! GEQ !__fin_pow_e! exit /b 0",
+		"set /a __fin_pow_r=__fin_ret*__fin_pow_b",
+		"set /a __fin_pow_q=__fin_pow_r/__fin_pow_b",
+		"if !__fin_pow_q! NEQ !__fin_ret! exit /b 1",
+		"set /a __fin_ret=__fin_pow_r, __fin_pow_i+=1",
+		"goto __fin_pow_next",
+		":__fin_pow_zero",
+		"if !__fin_pow_e! GTR 0 set __fin_ret=0",
+		"exit /b 0",
+		":__fin_pow_sign",
+		"set /a __fin_pow_r=__fin_pow_e %% 2",
+		"if !__fin_pow_r! NEQ 0 set __fin_ret=-1",
+		"exit /b 0",
+	}},
+	"copy": {body: []string{
+		"set %1=!%2!",
+		"goto :eof",
+	}},
+	// order sets __fin_ret to 1 if the value of %1 sorts after the value of
+	// %2, and to 0 otherwise. Integers, as set /a prints them, compare as
+	// numbers; anything else compares as a quoted string, so values with
+	// spaces cannot break the if.
+	"order": {body: []string{
+		"set __fin_ret=0",
+		"set /a __fin_order_a=%1, __fin_order_b=%2",
+		"if not \"!__fin_order_a!\"==\"!%1!\" goto __fin_order_text",
+		"if not \"!__fin_order_b!\"==\"!%2!\" goto __fin_order_text",
+		"if !__fin_order_a! GTR !__fin_order_b! set __fin_ret=1",
+		"goto :eof",
+		":__fin_order_text",
+		"if \"!%1!\" GTR \"!%2!\" set __fin_ret=1",
+		"goto :eof",
+	}},
+	"dec_in":  {body: decInHelper},
+	"dec_out": {body: decOutHelper},
+	"dec_add": {uses: []string{"dec_in", "dec_out"}, body: decSumHelper("add", "+")},
+	"dec_sub": {uses: []string{"dec_in", "dec_out"}, body: decSumHelper("sub", "-")},
+	"dec_mul": {uses: []string{"dec_in", "dec_out"}, body: decMulHelper},
+	"dec_div": {uses: []string{"dec_in", "dec_out"}, body: decDivHelper},
+	"dec_cmp": {uses: []string{"dec_in"}, body: decCmpHelper},
+}
+
+// internalHelpers are the helpers that are not built-in functions
+// themselves: those other helpers call, contains_list, which contains calls
+// on a list, pow, which computes the ** operator, and the dec_ helpers,
+// which compute decimal arithmetic.
+var internalHelpers = map[string]bool{
+	"find": true, "clear": true, "copy": true, "order": true, "contains_list": true, "pow": true,
+	"dec_in": true, "dec_out": true, "dec_add": true, "dec_sub": true, "dec_mul": true, "dec_div": true, "dec_cmp": true,
+}
+
+// listBuiltins are the built-in functions whose first argument is a list,
+// passed by name.
+var listBuiltins = map[string]bool{
+	"join": true, "push": true, "pop": true, "insert": true, "remove_at": true,
+	"index_of": true, "reverse": true, "sort": true, "slice": true,
+	"contains_list": true,
+}
+
+// caseHelper converts the letters of the value to the other case with one
+// substitution per letter. Substitution ignores case, so :a=A turns both a
+// and A into A.
+func caseHelper(letters string, convert func(string) string) []string {
+	body := []string{
+		"set __fin_ret=!%1!",
+		"if not defined __fin_ret goto :eof",
+	}
+	for _, c := range letters {
+		body = append(body, fmt.Sprintf("set __fin_ret=!__fin_ret:%c=%s!", c, convert(string(c))))
+	}
+	return append(body, "goto :eof")
+}
+
+// affixHelper compares the start or the end of the value, as selected by
+// the substring modifier prefix sub, with the second argument.
+func affixHelper(name, sub string) []string {
+	n, s := "__fin_"+name+"_n", "__fin_"+name+"_s"
+	return []string{
+		"call :__fin_len %2",
+		fmt.Sprintf("set %s=!__fin_ret!", n),
+		fmt.Sprintf("set %s=!%%1!", s),
+		"set __fin_ret=true",
+		fmt.Sprintf("if !%s! EQU 0 goto :eof", n),
+		"set __fin_ret=false",
+		fmt.Sprintf("if not defined %s goto :eof", s),
+		fmt.Sprintf("if \"!%s:%s%%%s%%!\"==\"!%%2!\" set __fin_ret=true", s, sub, n),
+		"goto :eof",
+	}
+}
+
+// isBuiltin reports whether a call names a built-in function.
+func isBuiltin(name string) bool {
+	_, ok := builtinHelpers[name]
+	return ok && !internalHelpers[name]
+}
+
+// lowerBuiltin emits the call of a built-in function's helper and returns
+// the temp holding its result. The arguments have already been hoisted.
+func lowerBuiltin(ctx *Context, e *ast.CallExpr, args []ast.Expr) ast.Expr {
+	name := e.Name
+	if name == "contains" && ctx.typeOf(e.Args[0]) == sema.TypeList {
+		name = "contains_list"
+	}
+	callHelper(ctx, name, builtinParams(ctx, name, args))
+	temp := mangleTemp("call", ctx.NextLabel())
+	ctx.emitLine(fmt.Sprintf("set %s=!__fin_ret!", temp))
+	return &ast.IdentExpr{Name: temp, P: e.P}
+}
+
+// lowerBuiltinStmt emits the call of a built-in that changes its list,
+// ignoring any result.
+func lowerBuiltinStmt(ctx *Context, s *ast.CallStmt, args []ast.Expr) {
+	callHelper(ctx, s.Name, builtinParams(ctx, s.Name, args))
+}
+
+// lowerListCall stores the list a call of split or slice returns as name.
+func lowerListCall(ctx *Context, name string, e *ast.CallExpr) {
+	args := make([]ast.Expr, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = hoistCalls(ctx, arg)
+	}
+	callHelper(ctx, e.Name, append(builtinParams(ctx, e.Name, args), name))
+}
+
+// isListCall reports whether a value is a call of a built-in that returns a
+// list.
+func isListCall(e ast.Expr) (*ast.CallExpr, bool) {
+	call, ok := e.(*ast.CallExpr)
+	return call, ok && (call.Name == "split" || call.Name == "slice")
+}
+
+// builtinParams returns the parameters of a built-in's helper: the names of
+// variables holding the arguments, or the name of the list.
+func builtinParams(ctx *Context, name string, args []ast.Expr) []string {
+	var params []string
+	switch {
+	case name == "substr":
+		// The bounds are spliced into the substring modifier as numbers.
+		params = []string{valueVar(ctx, args[0])}
+		for _, arg := range args[1:] {
+			params = append(params, "\""+lowerExpr(computeArg(ctx, arg))+"\"")
+		}
+		return params
+	case listBuiltins[name]:
+		params = []string{args[0].(*ast.IdentExpr).Name}
+		args = args[1:]
+	}
+	for _, arg := range args {
+		params = append(params, valueVar(ctx, arg))
+	}
+	return params
+}
+
+func callHelper(ctx *Context, name string, params []string) {
+	ctx.useHelper(name)
+	ctx.emitLine(fmt.Sprintf("call :%s %s", helperLabel(name), strings.Join(params, " ")))
+}
+
+// valueVar returns the name of a variable holding the value of arg. Values
+// other than plain variables are stored in a temp first, with the characters
+// special to batch escaped.
+func valueVar(ctx *Context, arg ast.Expr) string {
+	if id, ok := computeArg(ctx, arg).(*ast.IdentExpr); ok {
+		return id.Name
+	}
+	temp := mangleTemp("arg", ctx.NextLabel())
+	if s, ok := arg.(*ast.StringLit); ok {
+		ctx.emitLine(setStringLine(temp, s.Value))
+		return temp
+	}
+	ctx.emitLine(fmt.Sprintf("set %s=%s", temp, escapeBatchSpecials(lowerExpr(arg))))
+	return temp
+}
+
+// useHelper records that the program calls the helper name, and the helpers
+// it calls in turn.
+func (c *Context) useHelper(name string) {
+	for _, used := range c.helpers {
+		if used == name {
+			return
+		}
+	}
+	c.helpers = append(c.helpers, name)
+	for _, dep := range builtinHelpers[name].uses {
+		c.useHelper(dep)
+	}
+}
+
+// emitHelpers emits the helpers the program uses, in the order of their
+// first use. Every helper ends in a goto, so only the code before the first
+// needs one to stay out of them.
+func emitHelpers(ctx *Context) {
+	defer ctx.setPos(ctx.setPos(ast.Pos{}))
+	for i, name := range ctx.helpers {
+		if i == 0 {
+			ctx.emitLine("goto :eof")
+		}
+		ctx.emitLine(":" + helperLabel(name))
+		for _, line := range builtinHelpers[name].body {
+			ctx.emitLine(line)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// hoistCalls emits the calls, command captures, powers and decimal
+// arithmetic in expr ahead of the statement that uses it and returns a copy
+// of expr in which each is replaced by the temp holding its result.
+// Arguments are evaluated before the call, so nested calls run innermost
+// first. Expressions without calls are returned unchanged.
+func hoistCalls(ctx *Context, expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.CallExpr:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = hoistCalls(ctx, arg)
+		}
+		if isBuiltin(e.Name) {
+			return lowerBuiltin(ctx, e, args)
+		}
+		emitCall(ctx, e.Name, args)
+		temp := mangleTemp("call", ctx.NextLabel())
+		ctx.emitLine(fmt.Sprintf("set %s=!%s_ret!", temp, mangleFunc(e.Name)))
+		return &ast.IdentExpr{Name: temp, P: e.P}
+	case *ast.RunExpr:
+		return captureRun(ctx, e)
+	case *ast.StringLit:
+		value := e.Value
+		if spans := ast.Substitutions(value); len(e.Calls) > 0 && len(spans) == len(e.Calls) {
+			// The result is spliced in between refMarks, which
+			// interpolateParts turns into a batch expansion.
+			var b strings.Builder
+			last := 0
+			for i, span := range spans {
+				b.WriteString(value[last:span[0]])
+				b.WriteString(refMark + trimPercents(lowerExpr(hoistCalls(ctx, e.Calls[i]))) + refMark)
+				last = span[1]
+			}
+			b.WriteString(value[last:])
+			value = b.String()
+		}
+		if value = hoistIndexes(ctx, value); value == e.Value {
+			return e
+		}
+		return &ast.StringLit{Value: value, P: e.P}
+	case *ast.BinaryExpr:
+		// The copy has lost the types of its operands, so whether the
+		// operator is decimal is decided on the original.
+		decimal := ctx.isDecimalOp(e)
+		c := *e
+		c.Left = hoistCalls(ctx, e.Left)
+		c.Right = hoistCalls(ctx, e.Right)
+		switch {
+		case decimal:
+			return lowerDecimal(ctx, &c)
+		case c.Op == "**":
+			return lowerPow(ctx, &c)
+		}
+		return &c
+	case *ast.UnaryExpr:
+		decimal := e.Op == "-" && ctx.typeOf(e) == sema.TypeDecimal
+		// A negative decimal literal is kept as written, like a positive one.
+		if lit, ok := e.Right.(*ast.NumberLit); ok && decimal {
+			return &ast.NumberLit{Value: "-" + lit.Value, P: e.P}
+		}
+		c := *e
+		c.Right = hoistCalls(ctx, e.Right)
+		if decimal {
+			return lowerDecimal(ctx, &ast.BinaryExpr{Op: "-", Left: &ast.NumberLit{Value: "0", P: e.P}, Right: c.Right, P: e.P})
+		}
+		return &c
+	case *ast.IndexExpr:
+		c := *e
+		c.Left = hoistCalls(ctx, e.Left)
+		c.Index = hoistCalls(ctx, e.Index)
+		return &c
+	case *ast.PropertyExpr:
+		c := *e
+		c.Object = hoistCalls(ctx, e.Object)
+		return &c
+	case *ast.ExistsCond:
+		c := *e
+		c.Path = hoistCalls(ctx, e.Path)
+		return &c
+	case *ast.ListLit:
+		c := *e
+		c.Elements = make([]ast.Expr, len(e.Elements))
+		for i, el := range e.Elements {
+			c.Elements[i] = hoistCalls(ctx, el)
+		}
+		return &c
+	case *ast.MapLit:
+		c := *e
+		c.Pairs = make([]ast.MapPair, len(e.Pairs))
+		for i, p := range e.Pairs {
+			p.Value = hoistCalls(ctx, p.Value)
+			c.Pairs[i] = p
+		}
+		return &c
+	default:
+		return expr
+	}
+}
+
+// hoistIndexes copies the element each $name[index] reference in the value
+// of a string literal reads, when the index is a variable, into a temp and
+// splices the temp in between refMarks. The copy helper's arguments are
+// expanded before the call, so NAME_!index! names the element.
+func hoistIndexes(ctx *Context, s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '$' {
+			b.WriteString("$$")
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(s) && (isIdentStart(s[j]) || j > i+1 && isIdentPart(s[j])) {
+			j++
+		}
+		end := strings.IndexByte(s[j:], ']')
+		if j == i+1 || j >= len(s) || s[j] != '[' || end < 0 {
+			b.WriteByte('$')
+			continue
+		}
+		index := strings.TrimPrefix(s[j+1:j+end], "$")
+		if isNumericIndex(index) {
+			b.WriteByte('$')
+			continue
+		}
+		temp := mangleTemp("index", ctx.NextLabel())
+		callHelper(ctx, "copy", []string{temp, fmt.Sprintf("%s_!%s!", s[i+1:j], index)})
+		b.WriteString(refMark + temp + refMark)
+		i = j + end
+	}
+	return b.String()
+}
+
+// lowerPow emits the call of the pow helper for a power, which set /a has no
+// operator for, and returns the temp holding the result. A result that does
+// not fit in 32 bits is reported and leaves the script, or the function the
+// power is in, with exit code 1.
+func lowerPow(ctx *Context, e *ast.BinaryExpr) ast.Expr {
+	callHelper(ctx, "pow", []string{powOperand(ctx, e.Left), powOperand(ctx, e.Right)})
+	ctx.emitLine("if !ERRORLEVEL! NEQ 0 (")
+	ctx.pushIndent()
+	ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: integer overflow: !__fin_pow_b! ** !__fin_pow_e! does not fit in 32 bits", ctx.where(e.P)))
+	ctx.emitLine("exit /b 1")
+	ctx.popIndent()
+	ctx.emitLine(")")
+	temp := mangleTemp("pow", ctx.NextLabel())
+	ctx.emitLine(fmt.Sprintf("set %s=!__fin_ret!", temp))
+	return &ast.IdentExpr{Name: temp, P: e.P}
+}
+
+// powOperand returns an operand of the pow helper, which reads it with
+// set /a: a variable name or a number. Other operands are computed into a
+// temp first.
+func powOperand(ctx *Context, e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.IdentExpr:
+		return e.Name
+	case *ast.NumberLit:
+		return e.Value
+	}
+	temp := mangleTemp("arg", ctx.NextLabel())
+	emitSetArith(ctx, temp, e)
+	return temp
+}
+
+// emitCall emits `call :fn_NAME args`, followed by the strict-mode check.
+func emitCall(ctx *Context, name string, args []ast.Expr) {
+	var b strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(escapeCallArg(lowerExpr(computeArg(ctx, arg))))
+	}
+	ctx.emitLine(fmt.Sprintf("call :%s %s", mangleFunc(name), b.String()))
+	checkCall(ctx, name)
+}
+
+// computeArg computes an arithmetic or boolean argument into a temp first so
+// the callee receives its value rather than the expression text. Other
+// arguments are returned unchanged.
+func computeArg(ctx *Context, arg ast.Expr) ast.Expr {
+	switch {
+	case ctx.isArithmetic(arg):
+		temp := mangleTemp("arg", ctx.NextLabel())
+		emitSetArith(ctx, temp, arg)
+		return &ast.IdentExpr{Name: temp, P: arg.Pos()}
+	case ctx.isCondition(arg):
+		return condTemp(ctx, arg)
+	}
+	return arg
+}
@@ -0,0 +1,233 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// jumpIf lowers a condition to if/goto lines that jump to label when the
+// condition evaluates to want and fall through otherwise. && and || short
+// circuit: the right operand, and any call in it, is only evaluated when
+// the left one does not decide the result.
+func jumpIf(ctx *Context, cond ast.Expr, want bool, label string) {
+	switch c := cond.(type) {
+	case *ast.UnaryExpr:
+		if c.Op == "!" {
+			jumpIf(ctx, c.Right, !want, label)
+			return
+		}
+	case *ast.BoolLit:
+		if c.Value == want {
+			ctx.emitLine("goto " + label)
+		}
+		return
+	case *ast.BinaryExpr:
+		switch c.Op {
+		case "&&", "||":
+			// The left operand decides an || when true and an && when false.
+			decides := c.Op == "||"
+			if want == decides {
+				jumpIf(ctx, c.Left, want, label)
+				jumpIf(ctx, c.Right, want, label)
+				return
+			}
+			skip := condSkipLabel(ctx.NextLabel())
+			jumpIf(ctx, c.Left, decides, skip)
+			jumpIf(ctx, c.Right, want, label)
+			ctx.emitRawLine(":" + skip)
+			return
+		}
+		if negatedCompareOps[c.Op] != "" {
+			if !want {
+				n := *c
+				n.Op = negatedCompareOps[c.Op]
+				c = &n
+			}
+			ctx.emitLine(fmt.Sprintf("if %s goto %s", condTest(ctx, hoistCalls(ctx, c)), label))
+			return
+		}
+	case *ast.ExistsCond:
+		not := ""
+		if !want {
+			not = "not "
+		}
+		ctx.emitLine(fmt.Sprintf("if %s%s goto %s", not, condTest(ctx, hoistCalls(ctx, c)), label))
+		return
+	}
+	jumpIfTruthy(ctx, cond, want, label)
+}
+
+// jumpIfTruthy jumps to label when the truth of a plain value is want. As
+// in the interpreter, the empty string, false and 0 are false and every
+// other value is true.
+func jumpIfTruthy(ctx *Context, cond ast.Expr, want bool, label string) {
+	v := truthValue(ctx, hoistCalls(ctx, cond))
+	target := label
+	if want {
+		target = condSkipLabel(ctx.NextLabel())
+	}
+	for _, falsy := range []string{"", "false", "0"} {
+		ctx.emitLine(fmt.Sprintf("if \"%s\"==\"%s\" goto %s", v, falsy, target))
+	}
+	if want {
+		ctx.emitLine("goto " + label)
+		ctx.emitRawLine(":" + target)
+	}
+}
+
+// truthValue lowers the value tested for truth. Arithmetic is computed into
+// a temp first.
+func truthValue(ctx *Context, e ast.Expr) string {
+	if !ctx.isArithmetic(e) {
+		return lowerExpr(e)
+	}
+	temp := mangleTemp("cond", ctx.NextLabel())
+	emitSetArith(ctx, temp, e)
+	return "!" + temp + "!"
+}
+
+// isCondition reports whether e is a boolean that a test computes, such as
+// a comparison, rather than a value copied as it is.
+func (c *Context) isCondition(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.BinaryExpr, *ast.UnaryExpr, *ast.ExistsCond:
+		return c.typeOf(e) == sema.TypeBool
+	}
+	return false
+}
+
+// lowerCondValue sets name to true or false, as cond evaluates. cond is
+// lowered as the condition of an if statement, so && and || short circuit.
+func lowerCondValue(ctx *Context, name string, cond ast.Expr) {
+	id := ctx.NextLabel()
+	jumpIf(ctx, cond, false, condFalseLabel(id))
+	ctx.emitLine(fmt.Sprintf("set %s=true", name))
+	ctx.emitLine("goto " + condEndLabel(id))
+	ctx.emitRawLine(":" + condFalseLabel(id))
+	ctx.emitLine(fmt.Sprintf("set %s=false", name))
+	ctx.emitRawLine(":" + condEndLabel(id))
+}
+
+// condTemp computes a boolean into a temp and returns the temp.
+func condTemp(ctx *Context, cond ast.Expr) ast.Expr {
+	temp := mangleTemp("cond", ctx.NextLabel())
+	lowerCondValue(ctx, temp, cond)
+	return &ast.IdentExpr{Name: temp, P: cond.Pos()}
+}
+
+// singleTest reports whether cond lowers to the test of one if command.
+func singleTest(cond ast.Expr) bool {
+	switch c := cond.(type) {
+	case *ast.ExistsCond, *ast.BoolLit:
+		return true
+	case *ast.BinaryExpr:
+		return negatedCompareOps[c.Op] != ""
+	case *ast.UnaryExpr:
+		return c.Op == "!" && singleTest(c.Right)
+	}
+	return false
+}
+
+// condTest lowers a condition accepted by singleTest to the test of a batch
+// if command, emitting the temps it needs first. Ordering comparisons are
+// numeric; == and != compare the quoted strings.
+func condTest(ctx *Context, cond ast.Expr) string {
+	switch c := cond.(type) {
+	case *ast.ExistsCond:
+		return fmt.Sprintf("exist \"%s\"", lowerExpr(c.Path))
+	case *ast.BoolLit:
+		return fmt.Sprintf("\"%t\"==\"true\"", c.Value)
+	case *ast.UnaryExpr:
+		if c.Op == "!" {
+			return "not " + condTest(ctx, c.Right)
+		}
+	case *ast.BinaryExpr:
+		switch {
+		case isNumericComparisonOp(c.Op):
+			left, right := comparisonOperands(ctx, c)
+			return fmt.Sprintf("%s %s %s", left, batchCompareOps[c.Op], right)
+		case c.Op == "==" || c.Op == "!=":
+			left := lowerExpr(computeArg(ctx, c.Left))
+			right := lowerExpr(computeArg(ctx, c.Right))
+			if c.Op == "==" {
+				return fmt.Sprintf("\"%s\"==\"%s\"", left, right)
+			}
+			return fmt.Sprintf("\"%s\" NEQ \"%s\"", left, right)
+		}
+	}
+	return fmt.Sprintf("\"%s\"==\"true\"", lowerExpr(cond))
+}
+
+// batchCompareOps maps Fin comparison operators to those of the if command.
+var batchCompareOps = map[string]string{
+	"<": "LSS", "<=": "LEQ", ">": "GTR", ">=": "GEQ", "==": "EQU", "!=": "NEQ",
+}
+
+// negatedCompareOps maps each comparison operator to its negation.
+var negatedCompareOps = map[string]string{
+	"<": ">=", "<=": ">", ">": "<=", ">=": "<", "==": "!=", "!=": "==",
+}
+
+func isNumericComparisonOp(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// comparisonOperands lowers the operands of a numeric comparison. Operands
+// that need arithmetic are computed into temps first.
+func comparisonOperands(ctx *Context, c *ast.BinaryExpr) (string, string) {
+	left := lowerExprArithmetic(c.Left)
+	right := lowerExprArithmetic(c.Right)
+
+	// We need to compute left and right if they're complex expressions
+	leftExpr := c.Left
+	rightExpr := c.Right
+
+	// Check if left or right contain arithmetic that needs pre-computation
+	if needsPreCompute(c.Left) {
+		leftTemp := mangleTemp("left", ctx.NextLabel())
+		emitArith(ctx, setArithLine(leftTemp, left), c.Left)
+		left = leftTemp
+		leftExpr = nil // Mark as temp variable
+	}
+	if needsPreCompute(c.Right) {
+		rightTemp := mangleTemp("right", ctx.NextLabel())
+		emitArith(ctx, setArithLine(rightTemp, right), c.Right)
+		right = rightTemp
+		rightExpr = nil // Mark as temp variable
+	}
+
+	// Wrap variables in !...! but not literals
+	formatForCompare := func(val string, expr ast.Expr) string {
+		// If expr is nil, it's a temp variable we created
+		if expr == nil {
+			return fmt.Sprintf("!%s!", val)
+		}
+		switch expr.(type) {
+		case *ast.IdentExpr, *ast.PropertyExpr, *ast.IndexExpr:
+			return fmt.Sprintf("!%s!", val)
+		default:
+			return val
+		}
+	}
+
+	return formatForCompare(left, leftExpr), formatForCompare(right, rightExpr)
+}
+
+func needsPreCompute(e ast.Expr) bool {
+	switch v := e.(type) {
+	case *ast.NumberLit, *ast.IdentExpr:
+		return false
+	case *ast.BinaryExpr:
+		return true
+	case *ast.UnaryExpr:
+		return needsPreCompute(v.Right)
+	default:
+		return true
+	}
+}
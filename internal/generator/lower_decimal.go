@@ -0,0 +1,205 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Decimals are computed by helpers, since set /a only has integers. Each
+// operand is read into an integer scaled by 10 ** precision, the helpers do
+// integer arithmetic on those, and results are written back as text with
+// exactly precision digits after the point. The precision is passed to each
+// helper as its last argument.
+
+// decimalHelpers maps each decimal arithmetic operator to its helper.
+// Comparisons use dec_cmp.
+var decimalHelpers = map[string]string{"+": "dec_add", "-": "dec_sub", "*": "dec_mul", "/": "dec_div"}
+
+// isDecimalOp reports whether e is decimal arithmetic, or a comparison with
+// a decimal operand.
+func (c *Context) isDecimalOp(e *ast.BinaryExpr) bool {
+	if negatedCompareOps[e.Op] != "" {
+		return c.typeOf(e.Left) == sema.TypeDecimal || c.typeOf(e.Right) == sema.TypeDecimal
+	}
+	return c.typeOf(e) == sema.TypeDecimal
+}
+
+// lowerDecimal emits the call of the helper for a decimal operator, whose
+// operands have already been hoisted, and returns the temp holding the
+// result. A comparison is returned as the comparison of the helper's
+// result, -1, 0 or 1, with 0. Division by zero is reported and leaves the
+// script, or the function the division is in, with exit code 1.
+func lowerDecimal(ctx *Context, e *ast.BinaryExpr) ast.Expr {
+	name, arithmetic := decimalHelpers[e.Op]
+	if !arithmetic {
+		name = "dec_cmp"
+	}
+	callHelper(ctx, name, []string{valueVar(ctx, e.Left), valueVar(ctx, e.Right), strconv.Itoa(ctx.precision)})
+	if e.Op == "/" {
+		ctx.emitLine("if !ERRORLEVEL! NEQ 0 (")
+		ctx.pushIndent()
+		ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: division by zero", ctx.where(e.P)))
+		ctx.emitLine("exit /b 1")
+		ctx.popIndent()
+		ctx.emitLine(")")
+	}
+	temp := mangleTemp("dec", ctx.NextLabel())
+	ctx.emitLine(fmt.Sprintf("set %s=!__fin_ret!", temp))
+	result := &ast.IdentExpr{Name: temp, P: e.P}
+	if arithmetic {
+		return result
+	}
+	return &ast.BinaryExpr{Op: e.Op, Left: result, Right: &ast.NumberLit{Value: "0", P: e.P}, P: e.P}
+}
+
+// decInHelper reads the value of the variable %1 into __fin_ret as an
+// integer scaled by 10 ** %2, and leaves the scale in __fin_dec_in_z. The
+// first digit past the precision rounds the last one kept, away from zero.
+// For the helpers that round only their result, the value is also left
+// unrounded: __fin_dec_in_t holds its magnitude cut at the precision,
+// __fin_dec_in_x the next %2 digits, and __fin_dec_in_n is defined when it
+// is negative. Leading zeros are dropped and the fraction is read behind a
+// 1, so that set /a does not take either for octal.
+var decInHelper = []string{
+	"set __fin_dec_in_s=!%1!",
+	"if not defined __fin_dec_in_s set __fin_dec_in_s=0",
+	"set __fin_dec_in_n=",
+	"if \"!__fin_dec_in_s:~0,1!\"==\"-\" set __fin_dec_in_n=1",
+	"if defined __fin_dec_in_n set __fin_dec_in_s=!__fin_dec_in_s:~1!",
+	"set __fin_dec_in_f=!__fin_dec_in_s:*.=!",
+	"if \"!__fin_dec_in_f!\"==\"!__fin_dec_in_s!\" set __fin_dec_in_f=",
+	"call set __fin_dec_in_i=%%__fin_dec_in_s:.!__fin_dec_in_f!=%%",
+	":__fin_dec_in_zero",
+	"if not defined __fin_dec_in_i goto __fin_dec_in_int",
+	"if not \"!__fin_dec_in_i:~0,1!\"==\"0\" goto __fin_dec_in_int",
+	"set __fin_dec_in_i=!__fin_dec_in_i:~1!",
+	"goto __fin_dec_in_zero",
+	":__fin_dec_in_int",
+	"if not defined __fin_dec_in_i set __fin_dec_in_i=0",
+	"set __fin_dec_in_z=000000",
+	"set __fin_dec_in_z=1!__fin_dec_in_z:~0,%2!",
+	"set __fin_dec_in_f=!__fin_dec_in_f!00000000",
+	"set /a __fin_ret=__fin_dec_in_i*__fin_dec_in_z+1!__fin_dec_in_f:~0,%2!-__fin_dec_in_z",
+	"set /a __fin_dec_in_t=__fin_ret, __fin_dec_in_x=1!__fin_dec_in_f:~%2,%2!-__fin_dec_in_z",
+	"if \"!__fin_dec_in_f:~%2,1!\" GEQ \"5\" set /a __fin_ret+=1",
+	"if defined __fin_dec_in_n set /a __fin_ret=-__fin_ret",
+	"goto :eof",
+}
+
+// decOutHelper writes the integer in the variable %1, scaled by 10 ** %2,
+// to __fin_ret as a decimal with %2 digits after the point. The parts are
+// split before the sign is dropped, since the magnitude of the smallest
+// integer does not fit in 32 bits. The fraction is written with the scale
+// added, so its leading zeros are kept, and the 1 in front is dropped.
+var decOutHelper = []string{
+	"set /a __fin_dec_out_v=%1",
+	"set __fin_dec_out_z=000000",
+	"set __fin_dec_out_z=1!__fin_dec_out_z:~0,%2!",
+	"set /a __fin_dec_out_i=__fin_dec_out_v/__fin_dec_out_z, __fin_dec_out_f=__fin_dec_out_v%%__fin_dec_out_z",
+	"set __fin_dec_out_n=",
+	"if !__fin_dec_out_v! LSS 0 set __fin_dec_out_n=-",
+	"if !__fin_dec_out_v! LSS 0 set /a __fin_dec_out_i=-__fin_dec_out_i, __fin_dec_out_f=-__fin_dec_out_f",
+	"set /a __fin_dec_out_f+=__fin_dec_out_z",
+	"set __fin_ret=!__fin_dec_out_n!!__fin_dec_out_i!.!__fin_dec_out_f:~1!",
+	"goto :eof",
+}
+
+// decHelper returns the body of the helper dec_NAME, which reads the
+// decimals in the variables %1 and %2 into NAME_a and NAME_b at precision
+// %3 and then runs lines.
+func decHelper(name string, lines ...string) []string {
+	prefix := "__fin_dec_" + name
+	return append([]string{
+		"call :__fin_dec_in %1 %3",
+		"set " + prefix + "_a=!__fin_ret!",
+		"call :__fin_dec_in %2 %3",
+		"set " + prefix + "_b=!__fin_ret!",
+	}, lines...)
+}
+
+// decExactHelper returns the body of the helper dec_NAME, which reads the
+// decimals in the variables %1 and %2 unrounded, as NAME_a and NAME_b cut
+// at precision %3, NAME_ax and NAME_bx with the next %3 digits, and NAME_as
+// and NAME_bs with their signs, 1 or -1, and then runs lines.
+func decExactHelper(name string, lines ...string) []string {
+	prefix := "__fin_dec_" + name
+	var body []string
+	for i, arg := range []string{"a", "b"} {
+		v := prefix + "_" + arg
+		body = append(body,
+			fmt.Sprintf("call :__fin_dec_in %%%d %%3", i+1),
+			fmt.Sprintf("set /a %s=__fin_dec_in_t, %sx=__fin_dec_in_x, %ss=1", v, v, v),
+			fmt.Sprintf("if defined __fin_dec_in_n set %ss=-1", v),
+		)
+	}
+	return append(body, lines...)
+}
+
+// decSumHelper returns the body of dec_add or dec_sub, which only differ in
+// their operator. The parts cut at the precision and the digits past it are
+// summed apart; the sum of the digits past it, offset to stay positive, is
+// carried into the result, and what is left rounds it away from zero.
+func decSumHelper(name, op string) []string {
+	p := "__fin_dec_" + name
+	return decExactHelper(name,
+		fmt.Sprintf("set /a %s_r=%s_as*%s_a%s%s_bs*%s_b, %s_g=%s_as*%s_ax%s%s_bs*%s_bx+2*__fin_dec_in_z", p, p, p, op, p, p, p, p, p, op, p, p),
+		fmt.Sprintf("set /a %s_r+=%s_g/__fin_dec_in_z-2, %s_g=%s_g%%%%__fin_dec_in_z*2", p, p, p, p),
+		fmt.Sprintf("if !%s_r! GEQ 0 if !%s_g! GEQ !__fin_dec_in_z! set /a %s_r+=1", p, p, p),
+		fmt.Sprintf("if !%s_r! LSS 0 if !%s_g! GTR !__fin_dec_in_z! set /a %s_r+=1", p, p, p),
+		"call :__fin_dec_out "+p+"_r %3",
+		"goto :eof",
+	)
+}
+
+// decMulHelper multiplies the magnitudes of the operands as their whole
+// parts, the digits of their fractions up to the precision and the digits
+// past it, so that no partial product carries the scale twice, which would
+// overflow long before the result does. The partial products below the
+// precision are carried up and round the result, which takes the sign last.
+var decMulHelper = decExactHelper("mul",
+	"set /a __fin_dec_mul_ai=__fin_dec_mul_a/__fin_dec_in_z, __fin_dec_mul_af=__fin_dec_mul_a%%__fin_dec_in_z",
+	"set /a __fin_dec_mul_bi=__fin_dec_mul_b/__fin_dec_in_z, __fin_dec_mul_bf=__fin_dec_mul_b%%__fin_dec_in_z",
+	"set /a __fin_dec_mul_r=__fin_dec_mul_ai*__fin_dec_mul_b+__fin_dec_mul_af*__fin_dec_mul_bi+__fin_dec_mul_ai*__fin_dec_mul_bx/__fin_dec_in_z+__fin_dec_mul_ax*__fin_dec_mul_bi/__fin_dec_in_z",
+	"set /a __fin_dec_mul_u=__fin_dec_mul_af*__fin_dec_mul_bf+__fin_dec_mul_ai*__fin_dec_mul_bx%%__fin_dec_in_z+__fin_dec_mul_ax*__fin_dec_mul_bi%%__fin_dec_in_z",
+	"set /a __fin_dec_mul_v=__fin_dec_mul_af*__fin_dec_mul_bx+__fin_dec_mul_ax*__fin_dec_mul_bf+__fin_dec_mul_ax*__fin_dec_mul_bx/__fin_dec_in_z",
+	"set /a __fin_dec_mul_u+=__fin_dec_mul_v/__fin_dec_in_z, __fin_dec_mul_v%%=__fin_dec_in_z",
+	"set /a __fin_dec_mul_r+=__fin_dec_mul_u/__fin_dec_in_z, __fin_dec_mul_u%%=__fin_dec_in_z",
+	"set /a \"__fin_dec_mul_u=(__fin_dec_mul_u*__fin_dec_in_z+__fin_dec_mul_v)*2, __fin_dec_mul_h=__fin_dec_in_z*__fin_dec_in_z\"",
+	"if !__fin_dec_mul_u! GEQ !__fin_dec_mul_h! set /a __fin_dec_mul_r+=1",
+	"set /a __fin_dec_mul_r*=__fin_dec_mul_as*__fin_dec_mul_bs",
+	"call :__fin_dec_out __fin_dec_mul_r %3",
+	"goto :eof",
+)
+
+// decDivHelper divides by long division, one digit of the fraction at a
+// time, and rounds on the remainder. It exits with code 1 when dividing by
+// zero.
+var decDivHelper = decHelper("div",
+	"if !__fin_dec_div_b! EQU 0 exit /b 1",
+	"set /a __fin_dec_div_s=1",
+	"if !__fin_dec_div_a! LSS 0 set /a __fin_dec_div_s=-__fin_dec_div_s, __fin_dec_div_a=-__fin_dec_div_a",
+	"if !__fin_dec_div_b! LSS 0 set /a __fin_dec_div_s=-__fin_dec_div_s, __fin_dec_div_b=-__fin_dec_div_b",
+	"set /a __fin_dec_div_r=__fin_dec_div_a/__fin_dec_div_b, __fin_dec_div_m=__fin_dec_div_a%%__fin_dec_div_b, __fin_dec_div_k=0",
+	":__fin_dec_div_next",
+	"if !__fin_dec_div_k! GEQ %3 goto __fin_dec_div_round",
+	"set /a __fin_dec_div_m*=10, __fin_dec_div_r=__fin_dec_div_r*10+__fin_dec_div_m/__fin_dec_div_b, __fin_dec_div_m%%=__fin_dec_div_b, __fin_dec_div_k+=1",
+	"goto __fin_dec_div_next",
+	":__fin_dec_div_round",
+	"set /a __fin_dec_div_m*=2",
+	"if !__fin_dec_div_m! GEQ !__fin_dec_div_b! set /a __fin_dec_div_r+=1",
+	"set /a __fin_dec_div_r*=__fin_dec_div_s",
+	"call :__fin_dec_out __fin_dec_div_r %3",
+	"exit /b 0",
+)
+
+// decCmpHelper sets __fin_ret to -1, 0 or 1 as the first operand is less
+// than, equal to or greater than the second.
+var decCmpHelper = decHelper("cmp",
+	"set __fin_ret=0",
+	"if !__fin_dec_cmp_a! LSS !__fin_dec_cmp_b! set __fin_ret=-1",
+	"if !__fin_dec_cmp_a! GTR !__fin_dec_cmp_b! set __fin_ret=1",
+	"goto :eof",
+)
@@ -49,9 +49,24 @@ func lowerExprWithContext(expr ast.Expr, arithmetic bool) string {
 	case *ast.BinaryExpr:
 		left := lowerExprWithContext(e.Left, arithmetic)
 		right := lowerExprWithContext(e.Right, arithmetic)
+		if arithmetic {
+			// The AST carries no grouping nodes, so restore the parentheses
+			// that set /a needs to evaluate operands in source order.
+			prec := arithPrecedence[e.Op]
+			if p, ok := binaryPrecedence(e.Left); ok && p < prec {
+				left = "(" + left + ")"
+			}
+			if p, ok := binaryPrecedence(e.Right); ok && p <= prec {
+				right = "(" + right + ")"
+			}
+		}
 		return fmt.Sprintf("%s %s %s", left, e.Op, right)
 	case *ast.UnaryExpr:
-		return fmt.Sprintf("%s%s", e.Op, lowerExprWithContext(e.Right, arithmetic))
+		operand := lowerExprWithContext(e.Right, arithmetic)
+		if _, ok := binaryPrecedence(e.Right); ok && arithmetic {
+			operand = "(" + operand + ")"
+		}
+		return fmt.Sprintf("%s%s", e.Op, operand)
 	case *ast.ListLit:
 		// Lists lower as comma-separated literal elements.
 		out := ""
@@ -79,6 +94,28 @@ func lowerExprWithContext(expr ast.Expr, arithmetic bool) string {
 	}
 }
 
+// arithPrecedence is the binding power of the operators set /a evaluates.
+var arithPrecedence = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4, ">>": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+	"**": 7,
+}
+
+// binaryPrecedence reports the set /a precedence of an arithmetic binary
+// expression.
+func binaryPrecedence(e ast.Expr) (int, bool) {
+	b, ok := e.(*ast.BinaryExpr)
+	if !ok {
+		return 0, false
+	}
+	p, ok := arithPrecedence[b.Op]
+	return p, ok
+}
+
 // trimPercents removes leading/trailing % used for identifier expansion.
 func trimPercents(s string) string {
 	if len(s) >= 2 {
@@ -94,10 +131,35 @@ func trimPercents(s string) string {
 
 var identPlaceholder = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
 
+// refMark stands in for a batch expansion in the text interpolateParts
+// returns. hoistCalls also uses it to splice a temp into the value of a
+// string literal, as refMark + name + refMark, which keeps the temp apart
+// from the text written in the string.
+const refMark = "\x00"
+
 // interpolateString replaces $ident, $ident.property, and $ident[index] with batch expansion.
 func interpolateString(s string) string {
+	return fillRefs(interpolateParts(s))
+}
+
+// interpolateParts splits the value of a string literal into its text, with
+// refMark in place of each reference, and the batch expansions of the
+// references in order. $$ becomes a literal $.
+func interpolateParts(s string) (string, []string) {
 	var b strings.Builder
+	var refs []string
+	ref := func(expansion string) {
+		b.WriteString(refMark)
+		refs = append(refs, expansion)
+	}
 	for i := 0; i < len(s); {
+		if s[i] == refMark[0] {
+			if end := strings.Index(s[i+1:], refMark); end >= 0 {
+				ref("!" + s[i+1:i+1+end] + "!")
+				i += end + 2
+				continue
+			}
+		}
 		if s[i] == '$' {
 			// Escaped dollar
 			if i+1 < len(s) && s[i+1] == '$' {
@@ -123,7 +185,7 @@ func interpolateString(s string) string {
 							k++
 						}
 						prop := s[j+1 : k]
-						b.WriteString("!" + name + "_" + prop + "!")
+						ref("!" + name + "_" + prop + "!")
 						i = k
 						continue
 					}
@@ -141,11 +203,11 @@ func interpolateString(s string) string {
 						// Check if index is a number literal or variable
 						if isNumericIndex(indexStr) {
 							// Literal index: use !array_N!
-							b.WriteString("!" + name + "_" + indexStr + "!")
+							ref("!" + name + "_" + indexStr + "!")
 						} else {
 							// Variable index: use !array_!idx!! for delayed expansion
 							// Note: This nested expansion may need special handling
-							b.WriteString("!" + name + "_!" + indexStr + "!!")
+							ref("!" + name + "_!" + indexStr + "!!")
 						}
 						i = k + 1
 						continue
@@ -153,7 +215,7 @@ func interpolateString(s string) string {
 				}
 
 				// Simple variable
-				b.WriteString("!" + name + "!")
+				ref("!" + name + "!")
 				i = j
 				continue
 			}
@@ -161,7 +223,28 @@ func interpolateString(s string) string {
 		b.WriteByte(s[i])
 		i++
 	}
-	return b.String()
+	return b.String(), refs
+}
+
+// fillRefs puts the expansions refs in place of the refMarks in text.
+func fillRefs(text string, refs []string) string {
+	for _, r := range refs {
+		text = strings.Replace(text, refMark, r, 1)
+	}
+	return text
+}
+
+// setStringLine builds a set of name to the value of a string literal. Text
+// holding characters special to batch is written as `set "name=value"` and
+// escaped like a quoted run argument; the expansions are left as they are,
+// since their values are not parsed again.
+func setStringLine(name, value string) string {
+	text, refs := interpolateParts(value)
+	if !strings.ContainsAny(text, "^%&|<>()\"!") {
+		return fmt.Sprintf("set %s=%s", name, fillRefs(text, refs))
+	}
+	bang := len(refs) > 0 || strings.Contains(text, "!")
+	return "set " + fillRefs(escapeRunLiteral("\""+name+"="+text+"\"", bang), refs)
 }
 
 func isNumericIndex(s string) bool {
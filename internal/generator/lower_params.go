@@ -0,0 +1,107 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// paramsArg holds the script argument the generated parser is looking at.
+const paramsArg = "__fin_params_arg"
+
+// lowerParamsStmt emits the parser for the script arguments a params block
+// declares. It runs in place, at the top level of the script, and shifts the
+// arguments it reads out of %1..%9; %* is left as it was. A param is set by
+// `--name VALUE` or `/name:VALUE` and a flag by `--name` or `/name`, with
+// names compared without regard to case. Reading stops at the first empty
+// argument. The arguments are not passed to a subroutine, since `call`
+// prints its own help when one of them is /?.
+//
+// --help and /? print the usage and end the script with exit code 0. An
+// unknown argument, a param without its value and a required param left
+// empty are reported, followed by the usage, on stderr, and end the script
+// with exit code 1.
+func lowerParamsStmt(ctx *Context, s *ast.ParamsStmt) {
+	id := ctx.NextLabel()
+	next, shift, fail := paramsNextLabel(id), paramsShiftLabel(id), paramsFailLabel(id)
+	for _, p := range s.Params {
+		switch {
+		case p.Flag:
+			ctx.emitLine(fmt.Sprintf("set %s=false", p.Name))
+		case p.Default != nil:
+			lowerSetStmt(ctx, &ast.SetStmt{Name: p.Name, Value: p.Default, P: p.P})
+		default:
+			ctx.emitLine(fmt.Sprintf("set %s=", p.Name))
+		}
+	}
+	ctx.emitRawLine(":" + next)
+	ctx.emitLine(fmt.Sprintf("if \"%%~1\"==\"\" goto %s", paramsEndLabel(id)))
+	ctx.emitLine(fmt.Sprintf("set \"%s=%%~1\"", paramsArg))
+	ctx.emitLine(fmt.Sprintf("if /i \"!%s!\"==\"--help\" goto %s", paramsArg, paramsHelpLabel(id)))
+	ctx.emitLine(fmt.Sprintf("if \"!%s!\"==\"/?\" goto %s", paramsArg, paramsHelpLabel(id)))
+	for _, p := range s.Params {
+		option := "--" + p.Name
+		if p.Flag {
+			for _, arg := range []string{option, "/" + p.Name} {
+				ctx.emitLine(fmt.Sprintf("if /i \"!%s!\"==\"%s\" (", paramsArg, arg))
+				ctx.pushIndent()
+				ctx.emitLine(fmt.Sprintf("set %s=true", p.Name))
+				ctx.emitLine("goto " + shift)
+				ctx.popIndent()
+				ctx.emitLine(")")
+			}
+			continue
+		}
+		ctx.emitLine(fmt.Sprintf("if /i \"!%s!\"==\"%s\" (", paramsArg, option))
+		ctx.pushIndent()
+		ctx.emitLine("if \"%~2\"==\"\" (")
+		ctx.pushIndent()
+		ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: missing value for %s", ctx.where(s.P), option))
+		ctx.emitLine("goto " + fail)
+		ctx.popIndent()
+		ctx.emitLine(")")
+		ctx.emitLine(fmt.Sprintf("set \"%s=%%~2\"", p.Name))
+		ctx.emitLine("shift")
+		ctx.emitLine("goto " + shift)
+		ctx.popIndent()
+		ctx.emitLine(")")
+		prefix := "/" + p.Name + ":"
+		ctx.emitLine(fmt.Sprintf("if /i \"!%s:~0,%d!\"==\"%s\" (", paramsArg, len(prefix), prefix))
+		ctx.pushIndent()
+		ctx.emitLine(fmt.Sprintf("set \"%s=!%s:~%d!\"", p.Name, paramsArg, len(prefix)))
+		ctx.emitLine("goto " + shift)
+		ctx.popIndent()
+		ctx.emitLine(")")
+	}
+	ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: unknown argument !%s!", ctx.where(s.P), paramsArg))
+	ctx.emitLine("goto " + fail)
+	ctx.emitRawLine(":" + shift)
+	ctx.emitLine("shift")
+	ctx.emitLine("goto " + next)
+	ctx.emitRawLine(":" + paramsHelpLabel(id))
+	emitUsage(ctx, s, "")
+	ctx.emitLine("exit /b 0")
+	ctx.emitRawLine(":" + fail)
+	emitUsage(ctx, s, ">&2 ")
+	ctx.emitLine("exit /b 1")
+	ctx.emitRawLine(":" + paramsEndLabel(id))
+	for _, p := range s.Params {
+		if p.Flag || p.Default != nil {
+			continue
+		}
+		ctx.emitLine(fmt.Sprintf("if not defined %s (", p.Name))
+		ctx.pushIndent()
+		ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: missing required parameter --%s", ctx.where(s.P), p.Name))
+		ctx.emitLine("goto " + fail)
+		ctx.popIndent()
+		ctx.emitLine(")")
+	}
+}
+
+// emitUsage echoes the usage text of s, each line behind redirect.
+func emitUsage(ctx *Context, s *ast.ParamsStmt, redirect string) {
+	for _, line := range s.Usage() {
+		ctx.emitLine(redirect + "echo " + escapeRunLiteral(line, strings.Contains(line, "!")))
+	}
+}
@@ -0,0 +1,167 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// lowerRunStmt emits the command line, then copies ERRORLEVEL into status.
+func lowerRunStmt(ctx *Context, s *ast.RunStmt) {
+	cmd, args := runParts(ctx, s.Command, s.Args)
+	ctx.emitLine(cmd + args)
+	ctx.emitLine("set status=!ERRORLEVEL!")
+	checkStatus(ctx, s.P, escapeForIn(cmd)+args)
+}
+
+// captureRun emits a for /f loop that keeps the first line the command
+// prints and returns the temp holding it. for /f does not expose the
+// command's exit code, so the command is followed by a `call echo` of it,
+// which the call expands only after the command has run. Each line is held
+// in status until the next one arrives, so the first is only kept when it
+// is not that last one. Strict mode does not check captures.
+func captureRun(ctx *Context, e *ast.RunExpr) ast.Expr {
+	cmd, args := runParts(ctx, e.Command, e.Args)
+	temp := mangleTemp("capture", ctx.NextLabel())
+	level := "%%^^errorlevel%%"
+	if strings.Contains(cmd+args, "!") {
+		// Delayed expansion of the line takes one more caret.
+		level = "%%^^^^errorlevel%%"
+	}
+	ctx.emitLine(fmt.Sprintf("set %s=", temp))
+	ctx.emitLine("set status=")
+	ctx.emitLine(fmt.Sprintf("for /f \"usebackq delims=\" %%%%l in (`%s%s ^& call echo %s %s`) do (",
+		escapeForIn(cmd), args, statusMark, level))
+	ctx.pushIndent()
+	ctx.emitLine(fmt.Sprintf("if defined status if not defined %s set \"%s=!status!\"", temp, temp))
+	ctx.emitLine("set \"status=%%l\"")
+	ctx.popIndent()
+	ctx.emitLine(")")
+	ctx.emitLine(fmt.Sprintf("set status=!status:~%d!", len(statusMark)+1))
+	return &ast.IdentExpr{Name: temp, P: e.P}
+}
+
+// statusMark starts the line with which a capture's command reports its exit
+// code.
+const statusMark = "__fin_status"
+
+// runParts lowers the command text of a run and its arguments, each preceded
+// by a space. The command text is emitted as written. Literal arguments are
+// quoted at compile time; other values are only known when the script runs,
+// so they are always quoted.
+func runParts(ctx *Context, command ast.Expr, args []ast.Expr) (string, string) {
+	cmd := strings.TrimSpace(lowerExpr(hoistCalls(ctx, command)))
+	parts := make([]string, len(args))
+	literal := make([]bool, len(args))
+	bang := strings.Contains(cmd, "!")
+	for i, arg := range args {
+		if v, ok := literalArg(arg); ok {
+			parts[i], literal[i] = quoteArg(v), true
+		} else {
+			parts[i] = "\"" + lowerExpr(computeArg(ctx, hoistCalls(ctx, arg))) + "\""
+		}
+		bang = bang || strings.Contains(parts[i], "!")
+	}
+	var b strings.Builder
+	for i, part := range parts {
+		if literal[i] {
+			part = escapeRunLiteral(part, bang)
+		}
+		b.WriteString(" " + part)
+	}
+	return cmd, b.String()
+}
+
+// literalArg returns the value of an argument known at compile time.
+func literalArg(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.StringLit:
+		if strings.Contains(e.Value, "$") {
+			return "", false
+		}
+		return e.Value, true
+	case *ast.NumberLit:
+		return e.Value, true
+	case *ast.BoolLit:
+		return strconv.FormatBool(e.Value), true
+	}
+	return "", false
+}
+
+// quoteArg quotes s as one argument for the C runtime's command-line
+// splitting. Empty arguments and those holding whitespace, quotes or cmd.exe
+// operators are wrapped in quotes; embedded quotes, and the backslashes
+// before them, are escaped with backslashes.
+func quoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"&|<>()^,;=") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			slashes++
+		case '"':
+			b.WriteString(strings.Repeat("\\", slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteString(strings.Repeat("\\", slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// escapeRunLiteral escapes a quoted literal argument so the batch parser
+// passes it through unchanged. Percent signs are doubled. cmd.exe toggles its
+// own quoting at every quote, escaped or not, so operators outside its notion
+// of a quoted section are caret-escaped. When the line also goes through
+// delayed expansion (bang), ^ and ! need one more caret for that pass.
+func escapeRunLiteral(s string, bang bool) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '%':
+			b.WriteByte('%')
+		}
+		text := string(c)
+		if bang && (c == '^' || c == '!') {
+			text = "^" + text
+		}
+		for j := 0; j < len(text); j++ {
+			if !quoted && strings.IndexByte("^&|<>()", text[j]) >= 0 {
+				b.WriteByte('^')
+			}
+			b.WriteByte(text[j])
+		}
+	}
+	return b.String()
+}
+
+// escapeForIn caret-escapes the operators outside quotes in a command placed
+// in the in clause of for /f, so they reach the command instead of ending
+// the clause.
+func escapeForIn(s string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted && strings.IndexByte("&|<>()", c) >= 0 {
+			b.WriteByte('^')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
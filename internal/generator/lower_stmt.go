@@ -5,20 +5,27 @@ import (
 	"strings"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
 )
 
 // lowerSetStmt handles lowering of set statements, including lists and maps.
 func lowerSetStmt(ctx *Context, s *ast.SetStmt) {
-	switch v := s.Value.(type) {
+	if call, ok := isListCall(s.Value); ok {
+		lowerListCall(ctx, s.Name, call)
+		return
+	}
+	if ctx.isCondition(s.Value) {
+		lowerCondValue(ctx, s.Name, s.Value)
+		return
+	}
+	switch v := hoistCalls(ctx, s.Value).(type) {
 	case *ast.ListLit:
 		for i, el := range v.Elements {
-			ctx.emitLine(fmt.Sprintf("set %s_%d=%s", s.Name, i, lowerExpr(el)))
+			ctx.emitLine(fmt.Sprintf("set %s_%d=%s", s.Name, i, lowerExpr(computeArg(ctx, el))))
 		}
 		ctx.emitLine(fmt.Sprintf("set %s_len=%d", s.Name, len(v.Elements)))
 	case *ast.MapLit:
-		for _, p := range v.Pairs {
-			ctx.emitLine(fmt.Sprintf("set %s_%s=%s", s.Name, p.Key, lowerExpr(p.Value)))
-		}
+		lowerMapLit(ctx, s.Name, v)
 	case *ast.IndexExpr:
 		// Index access depends on whether index is literal or variable
 		left, ok := v.Left.(*ast.IdentExpr)
@@ -43,25 +50,50 @@ func lowerSetStmt(ctx *Context, s *ast.SetStmt) {
 			}
 		}
 	default:
-		if isArithmeticExpr(s.Value) {
-			ctx.emitLine(fmt.Sprintf("set /a %s=%s", s.Name, lowerExprArithmetic(s.Value)))
+		if ctx.isArithmetic(v) {
+			emitSetArith(ctx, s.Name, v)
+		} else if str, ok := v.(*ast.StringLit); ok {
+			ctx.emitLine(setStringLine(s.Name, str.Value))
 		} else {
-			ctx.emitLine(fmt.Sprintf("set %s=%s", s.Name, lowerExpr(s.Value)))
+			ctx.emitLine(fmt.Sprintf("set %s=%s", s.Name, lowerExpr(v)))
 		}
 	}
 }
 
+// lowerMapLit sets one variable per map entry, plus the list of keys that
+// for loops iterate.
+func lowerMapLit(ctx *Context, name string, m *ast.MapLit) {
+	for _, p := range m.Pairs {
+		ctx.emitLine(fmt.Sprintf("set %s_%s=%s", name, p.Key, lowerExpr(computeArg(ctx, p.Value))))
+	}
+	keys := mapKeys(name)
+	for i, p := range m.Pairs {
+		ctx.emitLine(fmt.Sprintf("set %s_%d=%s", keys, i, p.Key))
+	}
+	ctx.emitLine(fmt.Sprintf("set %s_len=%d", keys, len(m.Pairs)))
+}
+
 func lowerAssignStmt(ctx *Context, s *ast.AssignStmt) {
-	switch v := s.Value.(type) {
+	if s.Op != "" {
+		lowerCompoundAssign(ctx, s)
+		return
+	}
+	if call, ok := isListCall(s.Value); ok {
+		lowerListCall(ctx, s.Name, call)
+		return
+	}
+	if ctx.isCondition(s.Value) {
+		lowerCondValue(ctx, s.Name, s.Value)
+		return
+	}
+	switch v := hoistCalls(ctx, s.Value).(type) {
 	case *ast.ListLit:
 		for i, el := range v.Elements {
-			ctx.emitLine(fmt.Sprintf("set %s_%d=%s", s.Name, i, lowerExpr(el)))
+			ctx.emitLine(fmt.Sprintf("set %s_%d=%s", s.Name, i, lowerExpr(computeArg(ctx, el))))
 		}
 		ctx.emitLine(fmt.Sprintf("set %s_len=%d", s.Name, len(v.Elements)))
 	case *ast.MapLit:
-		for _, p := range v.Pairs {
-			ctx.emitLine(fmt.Sprintf("set %s_%s=%s", s.Name, p.Key, lowerExpr(p.Value)))
-		}
+		lowerMapLit(ctx, s.Name, v)
 	case *ast.IndexExpr:
 		// Index access depends on whether index is literal or variable
 		left, ok := v.Left.(*ast.IdentExpr)
@@ -86,32 +118,123 @@ func lowerAssignStmt(ctx *Context, s *ast.AssignStmt) {
 			}
 		}
 	default:
-		if isArithmeticExpr(s.Value) {
-			ctx.emitLine(fmt.Sprintf("set /a %s=%s", s.Name, lowerExprArithmetic(s.Value)))
+		if ctx.isArithmetic(v) {
+			emitSetArith(ctx, s.Name, v)
+		} else if str, ok := v.(*ast.StringLit); ok {
+			ctx.emitLine(setStringLine(s.Name, str.Value))
 		} else {
-			ctx.emitLine(fmt.Sprintf("set %s=%s", s.Name, lowerExpr(s.Value)))
+			ctx.emitLine(fmt.Sprintf("set %s=%s", s.Name, lowerExpr(v)))
 		}
 	}
 }
 
-func isArithmeticExpr(e ast.Expr) bool {
-	switch v := e.(type) {
+// lowerCompoundAssign lowers `x += value` to the matching set /a operator,
+// and `x++` and `x--` to `x+=1` and `x-=1`.
+func lowerCompoundAssign(ctx *Context, s *ast.AssignStmt) {
+	switch s.Op {
+	case "++":
+		ctx.emitLine(arithLine(s.Name + "+=1"))
+		return
+	case "--":
+		ctx.emitLine(arithLine(s.Name + "-=1"))
+		return
+	}
+	value := hoistCalls(ctx, s.Value)
+	if _, ok := value.(*ast.IndexExpr); ok {
+		temp := mangleTemp("arg", ctx.NextLabel())
+		lowerSetStmt(ctx, &ast.SetStmt{Name: temp, Value: value, P: s.P})
+		value = &ast.IdentExpr{Name: temp, P: s.P}
+	}
+	emitArith(ctx, arithLine(s.Name+s.Op+lowerExprArithmetic(value)), s.Result())
+}
+
+// setArithLine builds a set /a assignment of expr to name.
+func setArithLine(name, expr string) string {
+	return arithLine(name + "=" + expr)
+}
+
+// emitSetArith emits the set /a assignment of e to name.
+func emitSetArith(ctx *Context, name string, e ast.Expr) {
+	emitArith(ctx, setArithLine(name, lowerExprArithmetic(e)), e)
+}
+
+// emitArith emits line, a set /a command computing e. When e divides by
+// anything but a nonzero literal, a failing set /a, which can only be a
+// division by zero, is reported and leaves the script, or the function the
+// division is in, with exit code 1, as decimal division does.
+func emitArith(ctx *Context, line string, e ast.Expr) {
+	d := divisionByVariable(e)
+	if d == nil {
+		ctx.emitLine(line)
+		return
+	}
+	ctx.emitLine(line + " || (")
+	ctx.pushIndent()
+	ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: division by zero", ctx.where(d.P)))
+	ctx.emitLine("exit /b 1")
+	ctx.popIndent()
+	ctx.emitLine(")")
+}
+
+// divisionByVariable returns the first / or % in e whose divisor is not a
+// nonzero literal, or nil.
+func divisionByVariable(e ast.Expr) *ast.BinaryExpr {
+	switch e := e.(type) {
+	case *ast.UnaryExpr:
+		return divisionByVariable(e.Right)
 	case *ast.BinaryExpr:
-		switch v.Op {
-		case "+", "-", "*", "/", "**":
-			return true
+		if d := divisionByVariable(e.Left); d != nil {
+			return d
 		}
-	case *ast.UnaryExpr:
-		if v.Op == "-" {
-			return true
+		if d := divisionByVariable(e.Right); d != nil {
+			return d
 		}
+		if e.Op == "/" || e.Op == "%" {
+			if n, ok := e.Right.(*ast.NumberLit); !ok || strings.Trim(n.Value, "0") == "" {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// arithLine builds a set /a command. Expressions containing parentheses are
+// quoted so a ')' cannot close an enclosing if block, and so are those using
+// &, |, ^, < or >, which cmd.exe would otherwise read as command separators,
+// pipes, escapes and redirections. % is doubled, as in any batch file, and
+// when the line also expands a !variable!, ^ is doubled to survive the caret
+// escaping delayed expansion then applies even inside quotes.
+func arithLine(expr string) string {
+	expr = strings.ReplaceAll(expr, "%", "%%")
+	if strings.Contains(expr, "!") {
+		expr = strings.ReplaceAll(expr, "^", "^^")
+	}
+	if strings.ContainsAny(expr, "()&|^<>") {
+		return fmt.Sprintf("set /a \"%s\"", expr)
+	}
+	return "set /a " + expr
+}
+
+// isArithmetic reports whether e is a number that set /a computes, rather
+// than a value copied as it is.
+func (c *Context) isArithmetic(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.BinaryExpr, *ast.UnaryExpr:
+		return c.typeOf(e) == sema.TypeNumber
 	}
 	return false
 }
 
 // lowerEchoStmt emits an echo with expression lowering for interpolation.
+// Arithmetic and conditions are computed into a temp first.
 func lowerEchoStmt(ctx *Context, s *ast.EchoStmt) {
-	val := lowerExpr(s.Value)
+	var value ast.Expr
+	if ctx.isCondition(s.Value) {
+		value = condTemp(ctx, s.Value)
+	} else {
+		value = computeArg(ctx, hoistCalls(ctx, s.Value))
+	}
+	val := lowerExpr(value)
 	// Escape batch special characters in echo output
 	val = escapeBatchSpecials(val)
 	ctx.emitLine("echo " + val)
@@ -188,106 +311,135 @@ func isIdentStartByte(c byte) bool {
 	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_'
 }
 
-// lowerRunStmt emits a command invocation with expression lowering.
-func lowerRunStmt(ctx *Context, s *ast.RunStmt) {
-	cmd := lowerExpr(s.Command)
-	cmd = strings.TrimSpace(cmd)
-	cmd = strings.Trim(cmd, "\"")
-	ctx.emitLine(cmd)
-}
-
-// lowerIfStmt lowers an if/else statement with proper indentation.
+// lowerIfStmt lowers an if/else statement whose condition is a single test
+// to a parenthesized if block, and any other if to flat label-based dispatch.
 func lowerIfStmt(ctx *Context, s *ast.IfStmt, emit func(ast.Statement) error) error {
-	if b, ok := s.Cond.(*ast.BinaryExpr); ok {
-		leftVal := lowerExpr(b.Left)
-		rightVal := lowerExpr(b.Right)
-
-		// Check if this is a numeric comparison operator (<, >, <=, >=)
-		if isNumericComparisonOp(b.Op) {
-			return lowerIfComparison(ctx, b, s.Then, s.Else, emit)
+	if len(s.ElseIfs) > 0 || !singleTest(s.Cond) {
+		return lowerIfChain(ctx, s, emit)
+	}
+	ctx.emitLine(fmt.Sprintf("if %s (", condTest(ctx, hoistCalls(ctx, s.Cond))))
+	if err := lowerIndented(ctx, s.Then, emit); err != nil {
+		return err
+	}
+	if len(s.Else) > 0 {
+		ctx.emitLine(") else (")
+		if err := lowerIndented(ctx, s.Else, emit); err != nil {
+			return err
 		}
+	}
+	ctx.emitLine(")")
+	return nil
+}
 
-		// Format operand: if it's already a variable expansion (!x!), use as-is
-		// otherwise treat as literal
-		formatOperand := func(val string, expr ast.Expr) string {
-			switch expr.(type) {
-			case *ast.IdentExpr, *ast.PropertyExpr, *ast.IndexExpr:
-				// It's a variable reference - lowerExpr already added !...!
-				return fmt.Sprintf("\"%s\"", val)
-			default:
-				// It's a literal value
-				return fmt.Sprintf("\"%s\"", val)
-			}
+// lowerIfChain lowers an if with else-if branches or a compound condition.
+// Each condition is tested only when the ones before it were false, and
+// jumps past its block when false:
+//
+//	if not TEST goto if_next_N_1
+//	    then block
+//	    goto if_end_N
+//	:if_next_N_1
+//	if not TEST goto if_next_N_2
+//	    else-if block
+//	    goto if_end_N
+//	:if_next_N_2
+//	    else block
+//	:if_end_N
+func lowerIfChain(ctx *Context, s *ast.IfStmt, emit func(ast.Statement) error) error {
+	id := ctx.NextLabel()
+	branches := append([]ast.ElseIf{{Cond: s.Cond, Body: s.Then, P: s.P}}, s.ElseIfs...)
+	for i, branch := range branches {
+		next := ifNextLabel(id, i+1)
+		jumpIf(ctx, branch.Cond, false, next)
+		if err := lowerIndented(ctx, branch.Body, emit); err != nil {
+			return err
 		}
+		if i < len(branches)-1 || len(s.Else) > 0 {
+			ctx.emitLine("goto " + ifEndLabel(id))
+		}
+		ctx.emitRawLine(":" + next)
+	}
+	if err := lowerIndented(ctx, s.Else, emit); err != nil {
+		return err
+	}
+	if len(branches) > 1 || len(s.Else) > 0 {
+		ctx.emitRawLine(":" + ifEndLabel(id))
+	}
+	return nil
+}
 
-		left := formatOperand(leftVal, b.Left)
-		right := formatOperand(rightVal, b.Right)
-
-		var header string
-		switch b.Op {
-		case "==":
-			header = fmt.Sprintf("if %s==%s (", left, right)
-		case "!=":
-			header = fmt.Sprintf("if %s NEQ %s (", left, right)
+// lowerMatchStmt lowers a match statement to one test per case value, each
+// jumping to its case block, followed by the else arm and the case blocks:
+//
+//	if "!x!"=="a" goto match_case_N_1
+//	if "!x!"=="b" goto match_case_N_2
+//	    else block
+//	goto match_end_N
+//	:match_case_N_1
+//	    case block
+//	    goto match_end_N
+//	:match_case_N_2
+//	    case block
+//	:match_end_N
+//
+// A subject other than a plain variable is evaluated into a temp first.
+// Case values are compared as text, decimals included.
+func lowerMatchStmt(ctx *Context, s *ast.MatchStmt, emit func(ast.Statement) error) error {
+	id := ctx.NextLabel()
+	subject := hoistCalls(ctx, s.Subject)
+	if _, ok := subject.(*ast.IdentExpr); !ok {
+		temp := mangleTemp("match", id)
+		lowerSetStmt(ctx, &ast.SetStmt{Name: temp, Value: subject, P: s.P})
+		subject = &ast.IdentExpr{Name: temp, P: s.P}
+	}
+	for i, arm := range s.Cases {
+		for _, v := range arm.Values {
+			test := condTest(ctx, &ast.BinaryExpr{Op: "==", Left: subject, Right: hoistCalls(ctx, v), P: v.Pos()})
+			ctx.emitLine(fmt.Sprintf("if %s goto %s", test, matchCaseLabel(id, i+1)))
 		}
-		if header != "" {
-			ctx.emitLine(header)
-			ctx.pushIndent()
-			for _, inner := range s.Then {
-				if err := emit(inner); err != nil {
-					return err
-				}
-			}
-			ctx.popIndent()
-			if len(s.Else) > 0 {
-				ctx.emitLine(") else (")
-				ctx.pushIndent()
-				for _, inner := range s.Else {
-					if err := emit(inner); err != nil {
-						return err
-					}
-				}
-				ctx.popIndent()
-			}
-			ctx.emitLine(")")
-			return nil
+	}
+	if err := lowerIndented(ctx, s.Default, emit); err != nil {
+		return err
+	}
+	ctx.emitLine("goto " + matchEndLabel(id))
+	for i, arm := range s.Cases {
+		ctx.emitRawLine(":" + matchCaseLabel(id, i+1))
+		if err := lowerIndented(ctx, arm.Body, emit); err != nil {
+			return err
+		}
+		if i < len(s.Cases)-1 {
+			ctx.emitLine("goto " + matchEndLabel(id))
 		}
 	}
-	cond := lowerExpr(s.Cond)
-	ctx.emitLine(fmt.Sprintf("if \"%s\"==\"true\" (", cond))
+	ctx.emitRawLine(":" + matchEndLabel(id))
+	return nil
+}
+
+// lowerIndented lowers a nested block one level deeper.
+func lowerIndented(ctx *Context, stmts []ast.Statement, emit func(ast.Statement) error) error {
 	ctx.pushIndent()
-	for _, inner := range s.Then {
+	defer ctx.popIndent()
+	for _, inner := range stmts {
 		if err := emit(inner); err != nil {
 			return err
 		}
 	}
-	ctx.popIndent()
-	if len(s.Else) > 0 {
-		ctx.emitLine(") else (")
-		ctx.pushIndent()
-		for _, inner := range s.Else {
-			if err := emit(inner); err != nil {
-				return err
-			}
-		}
-		ctx.popIndent()
-	}
-	ctx.emitLine(")")
 	return nil
 }
 
 // lowerForStmt lowers a numeric range loop using labels to support break/continue.
-
+// continue jumps to the increment.
 func lowerForStmt(ctx *Context, s *ast.ForStmt, emit func(ast.Statement) error) error {
-	startVal := lowerExpr(s.Start)
-	endVal := lowerExpr(s.End)
+	startVal := lowerExpr(computeArg(ctx, hoistCalls(ctx, s.Start)))
+	endVal := lowerExpr(computeArg(ctx, hoistCalls(ctx, s.End)))
 	id := ctx.NextLabel()
-	startLbl := loopContinueLabel(id)
+	startLbl := forStartLabel(id)
+	contLbl := loopContinueLabel(id)
 	endLbl := loopBreakLabel(id)
 	ctx.emitLine(fmt.Sprintf("set /a %s=%s", s.Var, startVal))
 	ctx.emitRawLine(":" + startLbl)
 	ctx.emitLine(fmt.Sprintf("if !%s! GTR %s goto %s", s.Var, endVal, endLbl))
-	ctx.pushLoop(endLbl, startLbl)
+	ctx.pushLoop(endLbl, contLbl)
 	ctx.pushIndent()
 	for _, inner := range s.Body {
 		if err := emit(inner); err != nil {
@@ -298,54 +450,64 @@ func lowerForStmt(ctx *Context, s *ast.ForStmt, emit func(ast.Statement) error)
 	}
 	ctx.popIndent()
 	ctx.popLoop()
+	ctx.emitRawLine(":" + contLbl)
 	ctx.emitLine(fmt.Sprintf("set /a %s=%s+1", s.Var, s.Var))
 	ctx.emitLine(fmt.Sprintf("goto %s", startLbl))
 	ctx.emitRawLine(":" + endLbl)
 	return nil
 }
 
-// lowerWhileStmt lowers a while loop using labels and conditional jumps.
+// lowerForEachStmt lowers iteration over a list, or over the keys a map
+// recorded when it was set, with a hidden index. The length is read once;
+// each element is read with `call set` as the loop reaches it. continue jumps
+// to the increment.
+func lowerForEachStmt(ctx *Context, s *ast.ForEachStmt, emit func(ast.Statement) error) error {
+	base := lowerExprArithmetic(s.Iterable)
+	list := base
+	if s.Key != "" {
+		list = mapKeys(base)
+	}
+	id := ctx.NextLabel()
+	idx := mangleTemp("each", id)
+	n := mangleTemp("each_len", id)
+	startLbl := eachStartLabel(id)
+	contLbl := loopContinueLabel(id)
+	endLbl := loopBreakLabel(id)
+	ctx.emitLine(fmt.Sprintf("set /a %s=0", idx))
+	ctx.emitLine(fmt.Sprintf("set /a %s=%s_len", n, list))
+	ctx.emitRawLine(":" + startLbl)
+	ctx.emitLine(fmt.Sprintf("if !%s! GEQ !%s! goto %s", idx, n, endLbl))
+	if s.Key != "" {
+		ctx.emitLine(fmt.Sprintf("call set %s=%%%%%s_!%s!%%%%", s.Key, list, idx))
+		ctx.emitLine(fmt.Sprintf("call set %s=%%%%%s_!%s!%%%%", s.Var, base, s.Key))
+	} else {
+		ctx.emitLine(fmt.Sprintf("call set %s=%%%%%s_!%s!%%%%", s.Var, list, idx))
+	}
+	ctx.pushLoop(endLbl, contLbl)
+	ctx.pushIndent()
+	for _, inner := range s.Body {
+		if err := emit(inner); err != nil {
+			ctx.popIndent()
+			ctx.popLoop()
+			return err
+		}
+	}
+	ctx.popIndent()
+	ctx.popLoop()
+	ctx.emitRawLine(":" + contLbl)
+	ctx.emitLine(fmt.Sprintf("set /a %s=%s+1", idx, idx))
+	ctx.emitLine("goto " + startLbl)
+	ctx.emitRawLine(":" + endLbl)
+	return nil
+}
 
+// lowerWhileStmt lowers a while loop using labels and conditional jumps.
 func lowerWhileStmt(ctx *Context, s *ast.WhileStmt, emit func(ast.Statement) error) error {
 	id := ctx.NextLabel()
 	start := whileStartLabel(id)
 	end := whileEndLabel(id)
 	ctx.emitRawLine(":" + start)
-	switch c := s.Cond.(type) {
-	case *ast.ExistsCond:
-		cond := lowerCondition(c)
-		ctx.emitLine(fmt.Sprintf("if not %s goto %s", cond, end))
-	case *ast.BinaryExpr:
-		// Handle comparison operators specially since set /a doesn't support them
-		if isComparisonOp(c.Op) {
-			lowerComparisonCondition(ctx, c, end)
-		} else if isBooleanOp(c.Op) {
-			// For && and ||, we need more complex handling
-			// For now, treat as a general expression that evaluates to true/false
-			arith := lowerExprArithmetic(s.Cond)
-			temp := mangleTemp("cond", ctx.NextLabel())
-			ctx.emitLine(fmt.Sprintf("set /a %s=(%s)", temp, arith))
-			ctx.emitLine(fmt.Sprintf("if !%s! equ 0 goto %s", temp, end))
-		} else {
-			// Arithmetic expression
-			arith := lowerExprArithmetic(s.Cond)
-			temp := mangleTemp("cond", ctx.NextLabel())
-			ctx.emitLine(fmt.Sprintf("set /a %s=(%s)", temp, arith))
-			ctx.emitLine(fmt.Sprintf("if !%s! equ 0 goto %s", temp, end))
-		}
-	case *ast.BoolLit:
-		if !c.Value {
-			// while false -> immediately exit
-			ctx.emitLine(fmt.Sprintf("goto %s", end))
-		}
-		// while true -> no condition check needed, infinite loop
-	default:
-		// General expression - try to evaluate as arithmetic
-		arith := lowerExprArithmetic(s.Cond)
-		temp := mangleTemp("cond", ctx.NextLabel())
-		ctx.emitLine(fmt.Sprintf("set /a %s=(%s)", temp, arith))
-		ctx.emitLine(fmt.Sprintf("if !%s! equ 0 goto %s", temp, end))
-	}
+	jumpIf(ctx, s.Cond, false, end)
 	ctx.pushLoop(end, start)
 	for _, inner := range s.Body {
 		if err := emit(inner); err != nil {
@@ -359,171 +521,6 @@ func lowerWhileStmt(ctx *Context, s *ast.WhileStmt, emit func(ast.Statement) err
 	return nil
 }
 
-func isComparisonOp(op string) bool {
-	switch op {
-	case "<", "<=", ">", ">=", "==", "!=":
-		return true
-	}
-	return false
-}
-
-func isNumericComparisonOp(op string) bool {
-	switch op {
-	case "<", "<=", ">", ">=":
-		return true
-	}
-	return false
-}
-
-func isBooleanOp(op string) bool {
-	return op == "&&" || op == "||"
-}
-
-// lowerComparisonCondition handles comparison expressions for while/if conditions
-func lowerComparisonCondition(ctx *Context, c *ast.BinaryExpr, endLabel string) {
-	left := lowerExprArithmetic(c.Left)
-	right := lowerExprArithmetic(c.Right)
-
-	// We need to compute left and right if they're complex expressions
-	leftTemp := ""
-	rightTemp := ""
-	leftExpr := c.Left
-	rightExpr := c.Right
-
-	// Check if left or right contain arithmetic that needs pre-computation
-	if needsPreCompute(c.Left) {
-		leftTemp = mangleTemp("left", ctx.NextLabel())
-		ctx.emitLine(fmt.Sprintf("set /a %s=%s", leftTemp, left))
-		left = leftTemp
-		leftExpr = nil // Mark as temp variable
-	}
-	if needsPreCompute(c.Right) {
-		rightTemp = mangleTemp("right", ctx.NextLabel())
-		ctx.emitLine(fmt.Sprintf("set /a %s=%s", rightTemp, right))
-		right = rightTemp
-		rightExpr = nil // Mark as temp variable
-	}
-
-	// Wrap variables in !...! but not literals
-	formatForCompare := func(val string, expr ast.Expr) string {
-		// If expr is nil, it's a temp variable we created
-		if expr == nil {
-			return fmt.Sprintf("!%s!", val)
-		}
-		switch expr.(type) {
-		case *ast.IdentExpr, *ast.PropertyExpr, *ast.IndexExpr:
-			return fmt.Sprintf("!%s!", val)
-		default:
-			return val
-		}
-	}
-
-	leftCmp := formatForCompare(left, leftExpr)
-	rightCmp := formatForCompare(right, rightExpr)
-
-	// Generate the comparison using if command
-	// Note: We jump to end if condition is FALSE (to exit loop)
-	var cmp string
-	switch c.Op {
-	case "<":
-		// if NOT (left < right) goto end  =>  if left >= right goto end  =>  if left GEQ right goto end
-		cmp = fmt.Sprintf("if %s GEQ %s goto %s", leftCmp, rightCmp, endLabel)
-	case "<=":
-		cmp = fmt.Sprintf("if %s GTR %s goto %s", leftCmp, rightCmp, endLabel)
-	case ">":
-		cmp = fmt.Sprintf("if %s LEQ %s goto %s", leftCmp, rightCmp, endLabel)
-	case ">=":
-		cmp = fmt.Sprintf("if %s LSS %s goto %s", leftCmp, rightCmp, endLabel)
-	case "==":
-		cmp = fmt.Sprintf("if %s NEQ %s goto %s", leftCmp, rightCmp, endLabel)
-	case "!=":
-		cmp = fmt.Sprintf("if %s EQU %s goto %s", leftCmp, rightCmp, endLabel)
-	}
-	ctx.emitLine(cmp)
-}
-
-func needsPreCompute(e ast.Expr) bool {
-	switch v := e.(type) {
-	case *ast.NumberLit, *ast.IdentExpr:
-		return false
-	case *ast.BinaryExpr:
-		return true
-	case *ast.UnaryExpr:
-		return needsPreCompute(v.Right)
-	default:
-		return true
-	}
-}
-
-// lowerIfComparison handles if statements with comparison operators (<, >, <=, >=, ==, !=).
-func lowerIfComparison(ctx *Context, c *ast.BinaryExpr, thenBlock, elseBlock []ast.Statement, emit func(ast.Statement) error) error {
-	left := lowerExprArithmetic(c.Left)
-	right := lowerExprArithmetic(c.Right)
-
-	// Pre-compute complex expressions
-	if needsPreCompute(c.Left) {
-		leftTemp := mangleTemp("left", ctx.NextLabel())
-		ctx.emitLine(fmt.Sprintf("set /a %s=%s", leftTemp, left))
-		left = leftTemp
-	}
-	if needsPreCompute(c.Right) {
-		rightTemp := mangleTemp("right", ctx.NextLabel())
-		ctx.emitLine(fmt.Sprintf("set /a %s=%s", rightTemp, right))
-		right = rightTemp
-	}
-
-	// Map Fin operators to batch comparison operators
-	var batchOp string
-	switch c.Op {
-	case "<":
-		batchOp = "LSS"
-	case "<=":
-		batchOp = "LEQ"
-	case ">":
-		batchOp = "GTR"
-	case ">=":
-		batchOp = "GEQ"
-	case "==":
-		batchOp = "EQU"
-	case "!=":
-		batchOp = "NEQ"
-	}
-
-	// Wrap variables in !...! but not literals
-	formatForCompare := func(val string, expr ast.Expr) string {
-		switch expr.(type) {
-		case *ast.IdentExpr, *ast.PropertyExpr, *ast.IndexExpr:
-			return fmt.Sprintf("!%s!", val)
-		default:
-			return val
-		}
-	}
-
-	leftCmp := formatForCompare(left, c.Left)
-	rightCmp := formatForCompare(right, c.Right)
-
-	ctx.emitLine(fmt.Sprintf("if %s %s %s (", leftCmp, batchOp, rightCmp))
-	ctx.pushIndent()
-	for _, stmt := range thenBlock {
-		if err := emit(stmt); err != nil {
-			return err
-		}
-	}
-	ctx.popIndent()
-	if len(elseBlock) > 0 {
-		ctx.emitLine(") else (")
-		ctx.pushIndent()
-		for _, stmt := range elseBlock {
-			if err := emit(stmt); err != nil {
-				return err
-			}
-		}
-		ctx.popIndent()
-	}
-	ctx.emitLine(")")
-	return nil
-}
-
 // lowerFnDecl lowers a function declaration to a batch label with parameter mapping.
 func lowerFnDecl(ctx *Context, fn *ast.FnDecl, emit func(ast.Statement) error) error {
 	label := mangleFunc(fn.Name)
@@ -535,7 +532,7 @@ func lowerFnDecl(ctx *Context, fn *ast.FnDecl, emit func(ast.Statement) error) e
 	ctx.emitLine(":" + label)
 	ctx.emitLine("setlocal EnableDelayedExpansion")
 	for i, p := range fn.Params {
-		ctx.emitLine(fmt.Sprintf("set %s=%%%d", p, i+1))
+		ctx.emitLine(fmt.Sprintf("set \"%s=%%~%d\"", p, i+1))
 	}
 	ctx.emitLine(fmt.Sprintf("set %s=", retTemp))
 	ctx.pushReturn(ret.label, ret.tempVar, ret.outVar)
@@ -550,37 +547,51 @@ func lowerFnDecl(ctx *Context, fn *ast.FnDecl, emit func(ast.Statement) error) e
 	ctx.popIndent()
 	ctx.popReturn()
 	ctx.emitLine(":" + ret.label)
-	ctx.emitLine(fmt.Sprintf("endlocal & set %s=%%%s%%", ret.outVar, ret.tempVar))
-	ctx.emitLine("goto :eof")
+	ctx.emitLine(fmt.Sprintf("endlocal & set \"%s=%%%s%%\"", ret.outVar, ret.tempVar))
+	if ctx.strict {
+		// Leave ERRORLEVEL at 0 so the caller's check only sees failures.
+		ctx.emitLine("exit /b 0")
+	} else {
+		ctx.emitLine("goto :eof")
+	}
 	return nil
 }
 
 // lowerCallStmt lowers a function call to a batch call label.
 func lowerCallStmt(ctx *Context, s *ast.CallStmt) {
-	label := mangleFunc(s.Name)
-	var b strings.Builder
+	args := make([]ast.Expr, len(s.Args))
 	for i, arg := range s.Args {
-		if i > 0 {
-			b.WriteString(" ")
-		}
-		lowered := lowerExpr(arg)
-		b.WriteString(escapeCallArg(lowered))
+		args[i] = hoistCalls(ctx, arg)
+	}
+	if isBuiltin(s.Name) {
+		lowerBuiltinStmt(ctx, s, args)
+		return
 	}
-	ctx.emitLine(fmt.Sprintf("call :%s %s", label, b.String()))
+	emitCall(ctx, s.Name, args)
 }
 
-// lowerReturnStmt currently emits a stub; return values are not supported.
+// lowerReturnStmt stores the returned value in the function's return temp,
+// which lowerFnDecl copies to fn_NAME_ret on the way out, and jumps to the
+// function end.
 func lowerReturnStmt(ctx *Context, s *ast.ReturnStmt) error {
 	if s.Value != nil {
 		if ret, ok := ctx.currentReturn(); ok {
-			ctx.emitLine(fmt.Sprintf("set %s=%s", ret.tempVar, lowerExpr(s.Value)))
-			ctx.emitLine("goto " + ret.label)
+			if ctx.isCondition(s.Value) {
+				lowerCondValue(ctx, ret.tempVar, s.Value)
+			} else if value := hoistCalls(ctx, s.Value); ctx.isArithmetic(value) {
+				emitSetArith(ctx, ret.tempVar, value)
+			} else if str, ok := value.(*ast.StringLit); ok {
+				ctx.emitLine(setStringLine(ret.tempVar, str.Value))
+			} else {
+				ctx.emitLine(fmt.Sprintf("set %s=%s", ret.tempVar, lowerExpr(value)))
+			}
+			emitJump(ctx, ret.label, ret.depth)
 			return nil
 		}
 		return errUnsupportedStmt(s.Pos(), s)
 	}
 	if ret, ok := ctx.currentReturn(); ok {
-		ctx.emitLine("goto " + ret.label)
+		emitJump(ctx, ret.label, ret.depth)
 		return nil
 	}
 	return errUnsupportedStmt(s.Pos(), s)
@@ -588,7 +599,7 @@ func lowerReturnStmt(ctx *Context, s *ast.ReturnStmt) error {
 
 func lowerBreakStmt(ctx *Context, s *ast.BreakStmt) error {
 	if labels, ok := ctx.currentLoop(); ok {
-		ctx.emitLine("goto " + labels.breakLabel)
+		emitJump(ctx, labels.breakLabel, labels.depth)
 		return nil
 	}
 	return errUnsupportedStmt(s.Pos(), s)
@@ -596,31 +607,31 @@ func lowerBreakStmt(ctx *Context, s *ast.BreakStmt) error {
 
 func lowerContinueStmt(ctx *Context, s *ast.ContinueStmt) error {
 	if labels, ok := ctx.currentLoop(); ok {
-		ctx.emitLine("goto " + labels.continueLabel)
+		emitJump(ctx, labels.continueLabel, labels.depth)
 		return nil
 	}
 	return errUnsupportedStmt(s.Pos(), s)
 }
 
-// escapeCallArg escapes batch specials and quotes when needed.
+// escapeCallArg quotes an argument that is empty, expands a variable or
+// holds blanks or characters special to batch, so that it reaches the
+// function as one argument, which %~N reads without the quotes. Inside the
+// quotes &, |, <, >, ( and ) need no caret.
+// An argument holding a quote cannot be quoted as a whole; its specials are
+// escaped with carets instead.
 func escapeCallArg(arg string) string {
-	specials := "^&|><()\""
-	needQuote := false
-	var b strings.Builder
-	for i := 0; i < len(arg); i++ {
-		ch := arg[i]
-		if ch == ' ' || ch == '\t' {
-			needQuote = true
-		}
-		if strings.ContainsRune(specials, rune(ch)) || ch == '^' {
-			b.WriteByte('^')
-			needQuote = true
+	if strings.Contains(arg, "\"") {
+		var b strings.Builder
+		for i := 0; i < len(arg); i++ {
+			if strings.IndexByte("^&|><()\"", arg[i]) >= 0 {
+				b.WriteByte('^')
+			}
+			b.WriteByte(arg[i])
 		}
-		b.WriteByte(ch)
+		return "\"" + b.String() + "\""
 	}
-	res := b.String()
-	if needQuote {
-		return "\"" + res + "\""
+	if arg == "" || strings.ContainsAny(arg, " \t^&|<>()!") {
+		return "\"" + arg + "\""
 	}
-	return res
+	return arg
 }
@@ -17,6 +17,111 @@ func TestLowerSetStmt_Scalar(t *testing.T) {
 	}
 }
 
+func TestLowerSetStmt_ArithmeticGrouping(t *testing.T) {
+	ctx := NewContext()
+	a := &ast.IdentExpr{Name: "a"}
+	b := &ast.IdentExpr{Name: "b"}
+	// (a + b) * (a - b) / 3
+	value := &ast.BinaryExpr{
+		Op: "/",
+		Left: &ast.BinaryExpr{
+			Op:    "*",
+			Left:  &ast.BinaryExpr{Op: "+", Left: a, Right: b},
+			Right: &ast.BinaryExpr{Op: "-", Left: a, Right: b},
+		},
+		Right: &ast.NumberLit{Value: "3"},
+	}
+	lowerSetStmt(ctx, &ast.SetStmt{Name: "x", Value: value})
+	lowerSetStmt(ctx, &ast.SetStmt{Name: "y", Value: &ast.BinaryExpr{
+		Op:    "-",
+		Left:  a,
+		Right: &ast.BinaryExpr{Op: "-", Left: b, Right: &ast.NumberLit{Value: "1"}},
+	}})
+	want := "set /a \"x=(a + b) * (a - b) / 3\"\n" +
+		"set /a \"y=a - (b - 1)\"\n"
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
+func TestLowerSetStmt_BitwiseGrouping(t *testing.T) {
+	ctx := NewContext()
+	a := &ast.IdentExpr{Name: "a"}
+	b := &ast.IdentExpr{Name: "b"}
+	// (a | b) & (a ^ 1) << b % 2
+	lowerSetStmt(ctx, &ast.SetStmt{Name: "x", Value: &ast.BinaryExpr{
+		Op:   "&",
+		Left: &ast.BinaryExpr{Op: "|", Left: a, Right: b},
+		Right: &ast.BinaryExpr{
+			Op:    "<<",
+			Left:  &ast.BinaryExpr{Op: "^", Left: a, Right: &ast.NumberLit{Value: "1"}},
+			Right: &ast.BinaryExpr{Op: "%", Left: b, Right: &ast.NumberLit{Value: "2"}},
+		},
+	}})
+	want := "set /a \"x=(a | b) & (a ^ 1) << b %% 2\"\n"
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
+func TestArithLine(t *testing.T) {
+	tests := []struct{ expr, want string }{
+		{"x=a + 1", "set /a x=a + 1"},
+		{"x=a % 4", "set /a x=a %% 4"},
+		{"x%=4", "set /a x%%=4"},
+		{"x=a & b | c", "set /a \"x=a & b | c\""},
+		{"x=a << 2 >> 1", "set /a \"x=a << 2 >> 1\""},
+		{"x=a ^ 1", "set /a \"x=a ^ 1\""},
+		{"x=!xs_0! ^ 1", "set /a \"x=!xs_0! ^^ 1\""},
+		{"x=(a + 1) * 2", "set /a \"x=(a + 1) * 2\""},
+	}
+	for _, tt := range tests {
+		if got := arithLine(tt.expr); got != tt.want {
+			t.Errorf("arithLine(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestSetStringLine(t *testing.T) {
+	tests := []struct{ value, want string }{
+		{"a b", "set x=a b"},
+		{"$name, $m.key", "set x=!name!, !m_key!"},
+		{"a^b,50%,c&d", `set "x=a^b,50%%,c&d"`},
+		{"(a) | <b>", `set "x=(a) | <b>"`},
+		{"hi!", `set "x=hi^!"`},
+		{"$name^%", `set "x=!name!^^%%"`},
+		{"say \"a & b\" & c", `set "x=say "a ^& b" & c"`},
+		{"\x00call_tmp_1\x00&$$", `set "x=!call_tmp_1!&$"`},
+	}
+	for _, tt := range tests {
+		if got := setStringLine("x", tt.value); got != tt.want {
+			t.Errorf("setStringLine(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLowerAssignStmt_Compound(t *testing.T) {
+	out := generateFromSource(t, "set x 1\nset n 2\nx += $n * 3\nx -= (len \"ab\")\nx %= 4\nx /= $n - 1\nx++\nx--\n")
+	want := "set x=1\n" +
+		"set n=2\n" +
+		"set /a x+=n * 3\n" +
+		"set arg_tmp_1=ab\n" +
+		"call :__fin_len arg_tmp_1\n" +
+		"set call_tmp_2=!__fin_ret!\n" +
+		"set /a x-=call_tmp_2\n" +
+		"set /a x%%=4\n" +
+		"set /a x/=n - 1 || (\n" +
+		"    >&2 echo fin: line 6: division by zero\n" +
+		"    exit /b 1\n" +
+		")\n" +
+		"set /a x+=1\n" +
+		"set /a x-=1\n" +
+		"goto :eof\n"
+	if !strings.Contains(out, want) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
 func TestLowerWhileStmt(t *testing.T) {
 	ctx := NewContext()
 	if err := lowerWhileStmt(ctx, &ast.WhileStmt{
@@ -50,6 +155,42 @@ func TestLowerWhileStmt(t *testing.T) {
 	}
 }
 
+func TestLowerWhileStmt_BooleanCondition(t *testing.T) {
+	ctx := NewContext()
+	n := &ast.IdentExpr{Name: "n"}
+	// while ($n > 0 && !exists "stop") || $force
+	cond := &ast.BinaryExpr{Op: "||",
+		Left: &ast.BinaryExpr{Op: "&&",
+			Left:  &ast.BinaryExpr{Op: ">", Left: n, Right: &ast.NumberLit{Value: "0"}},
+			Right: &ast.UnaryExpr{Op: "!", Right: &ast.ExistsCond{Path: &ast.StringLit{Value: "stop"}}},
+		},
+		Right: &ast.IdentExpr{Name: "force"},
+	}
+	if err := lowerWhileStmt(ctx, &ast.WhileStmt{Cond: cond}, func(ast.Statement) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("lowerWhileStmt error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		":while_start_1",
+		"if !n! LEQ 0 goto cond_skip_3",
+		"if not exist \"stop\" goto cond_skip_2",
+		":cond_skip_3",
+		"if \"!force!\"==\"\" goto while_end_1",
+		"if \"!force!\"==\"false\" goto while_end_1",
+		"if \"!force!\"==\"0\" goto while_end_1",
+		":cond_skip_2",
+		"goto while_start_1",
+		":while_end_1",
+		"",
+	}, "\n")
+
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
 func TestLowerIfStmt_Nested(t *testing.T) {
 	ctx := NewContext()
 	if err := lowerIfStmt(ctx, &ast.IfStmt{
@@ -130,7 +271,9 @@ func TestLowerSetStmt_Map(t *testing.T) {
 	lowerSetStmt(ctx, &ast.SetStmt{Name: "user", Value: &ast.MapLit{Pairs: []ast.MapPair{
 		{Key: "name", Value: &ast.StringLit{Value: "bob"}},
 	}}})
-	want := "set user_name=bob\n"
+	want := "set user_name=bob\n" +
+		"set __fin_keys_user_0=name\n" +
+		"set __fin_keys_user_len=1\n"
 	if ctx.String() != want {
 		t.Fatalf("unexpected output:\n%s", ctx.String())
 	}
@@ -148,7 +291,49 @@ func TestLowerEchoStmt(t *testing.T) {
 func TestLowerRunStmt(t *testing.T) {
 	ctx := NewContext()
 	lowerRunStmt(ctx, &ast.RunStmt{Command: &ast.StringLit{Value: "git status"}})
-	want := "git status\n"
+	want := "git status\n" +
+		"set status=!ERRORLEVEL!\n"
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
+func TestLowerRunStmt_Args(t *testing.T) {
+	ctx := NewContext()
+	lowerRunStmt(ctx, &ast.RunStmt{
+		Command: &ast.StringLit{Value: "git commit -m"},
+		Args: []ast.Expr{
+			&ast.StringLit{Value: "fix: a & b"},
+			&ast.IdentExpr{Name: "msg"},
+			&ast.BinaryExpr{Left: &ast.IdentExpr{Name: "n"}, Op: "+", Right: &ast.NumberLit{Value: "1"}},
+			&ast.StringLit{Value: "100%"},
+			&ast.StringLit{Value: `say "hi"`},
+			&ast.StringLit{Value: "a^b!"},
+			&ast.StringLit{Value: ""},
+		},
+	})
+	want := "set /a arg_tmp_1=n + 1\n" +
+		`git commit -m "fix: a & b" "!msg!" "!arg_tmp_1!" 100%% "say \"hi\"" "a^^b^!" ""` + "\n" +
+		"set status=!ERRORLEVEL!\n"
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
+func TestLowerSetStmt_RunCapture(t *testing.T) {
+	ctx := NewContext()
+	lowerSetStmt(ctx, &ast.SetStmt{Name: "out", Value: &ast.RunExpr{
+		Command: &ast.StringLit{Value: "git log | find"},
+		Args:    []ast.Expr{&ast.StringLit{Value: "a (b)"}},
+	}})
+	want := "set capture_tmp_1=\n" +
+		"set status=\n" +
+		"for /f \"usebackq delims=\" %%l in (`git log ^| find \"a (b)\" ^& call echo __fin_status %%^^errorlevel%%`) do (\n" +
+		"    if defined status if not defined capture_tmp_1 set \"capture_tmp_1=!status!\"\n" +
+		"    set \"status=%%l\"\n" +
+		")\n" +
+		"set status=!status:~13!\n" +
+		"set out=!capture_tmp_1!\n"
 	if ctx.String() != want {
 		t.Fatalf("unexpected output:\n%s", ctx.String())
 	}
@@ -177,11 +362,75 @@ func TestLowerForStmt(t *testing.T) {
 
 	want := strings.Join([]string{
 		"set /a i=1",
-		":loop_continue_1",
+		":for_start_1",
 		"if !i! GTR 5 goto loop_break_1",
 		"    echo !i!",
+		":loop_continue_1",
 		"set /a i=i+1",
-		"goto loop_continue_1",
+		"goto for_start_1",
+		":loop_break_1",
+		"",
+	}, "\n")
+
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
+func TestLowerForEachStmt(t *testing.T) {
+	ctx := NewContext()
+	var emit func(ast.Statement) error
+	emit = func(st ast.Statement) error {
+		switch s := st.(type) {
+		case *ast.EchoStmt:
+			lowerEchoStmt(ctx, s)
+		case *ast.ContinueStmt:
+			return lowerContinueStmt(ctx, s)
+		case *ast.ForEachStmt:
+			return lowerForEachStmt(ctx, s, emit)
+		default:
+			return fmt.Errorf("unexpected stmt type %T", s)
+		}
+		return nil
+	}
+	err := emit(&ast.ForEachStmt{
+		Var:      "c",
+		Iterable: &ast.IdentExpr{Name: "colors"},
+		Body: []ast.Statement{
+			&ast.ContinueStmt{},
+			&ast.ForEachStmt{
+				Key:      "k",
+				Var:      "v",
+				Iterable: &ast.PropertyExpr{Object: &ast.IdentExpr{Name: "cfg"}, Field: "opts"},
+				Body:     []ast.Statement{&ast.EchoStmt{Value: &ast.IdentExpr{Name: "v"}}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("lowerForEachStmt error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"set /a each_tmp_1=0",
+		"set /a each_len_tmp_1=colors_len",
+		":each_start_1",
+		"if !each_tmp_1! GEQ !each_len_tmp_1! goto loop_break_1",
+		"call set c=%%colors_!each_tmp_1!%%",
+		"    goto loop_continue_1",
+		"    set /a each_tmp_2=0",
+		"    set /a each_len_tmp_2=__fin_keys_cfg_opts_len",
+		":each_start_2",
+		"    if !each_tmp_2! GEQ !each_len_tmp_2! goto loop_break_2",
+		"    call set k=%%__fin_keys_cfg_opts_!each_tmp_2!%%",
+		"    call set v=%%cfg_opts_!k!%%",
+		"        echo !v!",
+		":loop_continue_2",
+		"    set /a each_tmp_2=each_tmp_2+1",
+		"    goto each_start_2",
+		":loop_break_2",
+		":loop_continue_1",
+		"set /a each_tmp_1=each_tmp_1+1",
+		"goto each_start_1",
 		":loop_break_1",
 		"",
 	}, "\n")
@@ -226,3 +475,77 @@ func TestLowerIfStmt_WithElse(t *testing.T) {
 		t.Fatalf("unexpected output:\n%s", ctx.String())
 	}
 }
+
+func TestLowerIfStmt_ElseIfChain(t *testing.T) {
+	ctx := NewContext()
+	x := &ast.IdentExpr{Name: "x"}
+	if err := lowerIfStmt(ctx, &ast.IfStmt{
+		Cond: &ast.BinaryExpr{Op: "==", Left: x, Right: &ast.StringLit{Value: "a"}},
+		Then: []ast.Statement{&ast.EchoStmt{Value: &ast.StringLit{Value: "one"}}},
+		ElseIfs: []ast.ElseIf{
+			{Cond: &ast.BinaryExpr{Op: ">", Left: x, Right: &ast.NumberLit{Value: "2"}},
+				Body: []ast.Statement{&ast.EchoStmt{Value: &ast.StringLit{Value: "two"}}}},
+		},
+		Else: []ast.Statement{&ast.EchoStmt{Value: &ast.StringLit{Value: "other"}}},
+	}, func(st ast.Statement) error {
+		lowerEchoStmt(ctx, st.(*ast.EchoStmt))
+		return nil
+	}); err != nil {
+		t.Fatalf("lowerIfStmt error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"if \"!x!\" NEQ \"a\" goto if_next_1_1",
+		"    echo one",
+		"goto if_end_1",
+		":if_next_1_1",
+		"if !x! LEQ 2 goto if_next_1_2",
+		"    echo two",
+		"goto if_end_1",
+		":if_next_1_2",
+		"    echo other",
+		":if_end_1",
+		"",
+	}, "\n")
+
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
+
+func TestLowerMatchStmt(t *testing.T) {
+	ctx := NewContext()
+	if err := lowerMatchStmt(ctx, &ast.MatchStmt{
+		Subject: &ast.BinaryExpr{Op: "+", Left: &ast.IdentExpr{Name: "n"}, Right: &ast.NumberLit{Value: "1"}},
+		Cases: []ast.MatchCase{
+			{Values: []ast.Expr{&ast.NumberLit{Value: "1"}, &ast.StringLit{Value: "one"}},
+				Body: []ast.Statement{&ast.EchoStmt{Value: &ast.StringLit{Value: "first"}}}},
+			{Values: []ast.Expr{&ast.NumberLit{Value: "2"}},
+				Body: []ast.Statement{&ast.EchoStmt{Value: &ast.StringLit{Value: "second"}}}},
+		},
+	}, func(st ast.Statement) error {
+		lowerEchoStmt(ctx, st.(*ast.EchoStmt))
+		return nil
+	}); err != nil {
+		t.Fatalf("lowerMatchStmt error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"set /a match_tmp_1=n + 1",
+		"if \"!match_tmp_1!\"==\"1\" goto match_case_1_1",
+		"if \"!match_tmp_1!\"==\"one\" goto match_case_1_1",
+		"if \"!match_tmp_1!\"==\"2\" goto match_case_1_2",
+		"goto match_end_1",
+		":match_case_1_1",
+		"    echo first",
+		"goto match_end_1",
+		":match_case_1_2",
+		"    echo second",
+		":match_end_1",
+		"",
+	}, "\n")
+
+	if ctx.String() != want {
+		t.Fatalf("unexpected output:\n%s", ctx.String())
+	}
+}
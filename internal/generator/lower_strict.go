@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// lowerUncheckedStmt lowers the body of an unchecked block in place, without
+// the exit code checks strict mode and try statements add.
+func lowerUncheckedStmt(ctx *Context, s *ast.UncheckedStmt, emit func(ast.Statement) error) error {
+	ctx.pushHandler(&handler{kind: handleUnchecked})
+	defer ctx.popHandler()
+	for _, inner := range s.Body {
+		if err := emit(inner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStatus emits the check after a run whose command line is cmd. When
+// the failure ends the script, it is reported on stderr with the source
+// location first.
+func checkStatus(ctx *Context, pos ast.Pos, cmd string) {
+	if !ctx.failureHandled() {
+		return
+	}
+	ctx.emitLine("if !status! NEQ 0 (")
+	ctx.pushIndent()
+	if ctx.uncaught(len(ctx.handlers)) {
+		ctx.emitLine(fmt.Sprintf(">&2 echo fin: %s: command failed with exit code !status!", ctx.where(pos)))
+	}
+	emitFailure(ctx, len(ctx.handlers), "!status!", cmd)
+	ctx.popIndent()
+	ctx.emitLine(")")
+}
+
+// where names the source line of pos in runtime error messages: FILE:LINE,
+// where FILE is the imported file pos is in or else the main file, or line
+// LINE when the source path is not known.
+func (c *Context) where(pos ast.Pos) string {
+	file := pos.File
+	if file == "" {
+		file = c.file
+	}
+	if file == "" {
+		return "line " + strconv.Itoa(pos.Line)
+	}
+	return escapeRunLiteral(file, true) + ":" + strconv.Itoa(pos.Line)
+}
+
+// checkCall emits the check after a call in strict mode. The failing command
+// inside the function has already been reported, so the code is passed on
+// silently. Without strict mode functions do not report failures.
+func checkCall(ctx *Context, name string) {
+	if !ctx.strict || !ctx.failureHandled() {
+		return
+	}
+	if target, _ := ctx.failTarget(len(ctx.handlers)); target < 0 {
+		ctx.emitLine("if !ERRORLEVEL! NEQ 0 exit /b !ERRORLEVEL!")
+		return
+	}
+	ctx.emitLine("if !ERRORLEVEL! NEQ 0 (")
+	ctx.pushIndent()
+	emitFailure(ctx, len(ctx.handlers), "!ERRORLEVEL!", name)
+	ctx.popIndent()
+	ctx.emitLine(")")
+}
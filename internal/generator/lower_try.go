@@ -0,0 +1,156 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// lowerTryStmt lowers a try statement to labels:
+//
+//	set try_exit_tmp_N=
+//	    body
+//	goto try_finally_N
+//	:try_catch_N
+//	    catch block
+//	:try_finally_N
+//	    finally block
+//	if defined try_exit_tmp_N goto !try_exit_tmp_N!
+//
+// A failing command in the body sets the catch variable's code and command
+// and jumps to try_catch_N. break, continue and return leave through the
+// finally block: they store their target in try_exit_tmp_N first. A failure
+// that is not caught here but is handled further out does the same with
+// try_raise_N, where it is passed on once the finally block has run.
+func lowerTryStmt(ctx *Context, s *ast.TryStmt, emit func(ast.Statement) error) error {
+	id := ctx.NextLabel()
+	h := &handler{kind: handleTry, id: id, errVar: s.Err, finally: len(s.Finally) > 0}
+	exitVar := mangleTemp("try_exit", id)
+	if h.finally {
+		ctx.emitLine(fmt.Sprintf("set %s=", exitVar))
+	}
+	ctx.pushHandler(h)
+	err := lowerIndented(ctx, s.Body, emit)
+	if err == nil && s.Err != "" {
+		if h.finally {
+			ctx.emitLine("goto " + tryFinallyLabel(id))
+		} else {
+			ctx.emitLine("goto " + tryEndLabel(id))
+		}
+		ctx.emitRawLine(":" + tryCatchLabel(id))
+		h.kind = handleCatch
+		err = lowerIndented(ctx, s.Catch, emit)
+	}
+	if err == nil && h.finally {
+		ctx.emitRawLine(":" + tryFinallyLabel(id))
+		h.kind = handleFinally
+		err = lowerIndented(ctx, s.Finally, emit)
+	}
+	ctx.popHandler()
+	if err != nil {
+		return err
+	}
+	if h.finally {
+		ctx.emitLine(fmt.Sprintf("if defined %s goto !%s!", exitVar, exitVar))
+	}
+	if h.raised {
+		ctx.emitLine("goto " + tryEndLabel(id))
+		ctx.emitRawLine(":" + tryRaiseLabel(id))
+		emitFailure(ctx, len(ctx.handlers), "!"+mangleTemp("try_code", id)+"!", "!"+mangleTemp("try_cmd", id)+"!")
+	}
+	if s.Err != "" && !h.finally || h.raised {
+		ctx.emitRawLine(":" + tryEndLabel(id))
+	}
+	return nil
+}
+
+// failTarget returns the index of the innermost of the first depth handlers
+// that acts on a failing command: a try body with a catch block, or a try
+// body or catch block whose finally block must run before the failure is
+// passed on. It returns -1 when the failure is ignored or no handler is
+// left; strict then reports whether the failure ends the script.
+func (c *Context) failTarget(depth int) (target int, strict bool) {
+	for i := depth - 1; i >= 0; i-- {
+		h := c.handlers[i]
+		switch {
+		case h.kind == handleUnchecked:
+			return -1, false
+		case h.kind == handleTry && h.errVar != "":
+			return i, false
+		case h.finally && (h.kind == handleTry || h.kind == handleCatch):
+			// The finally block only needs to run early when the
+			// failure does not end up ignored.
+			if next, strict := c.failTarget(i); next < 0 && !strict {
+				return -1, false
+			}
+			return i, false
+		}
+	}
+	return -1, c.strict
+}
+
+// failureHandled reports whether a command failing here needs a check.
+func (c *Context) failureHandled() bool {
+	target, strict := c.failTarget(len(c.handlers))
+	return target >= 0 || strict
+}
+
+// uncaught reports whether a failure inside the first depth handlers ends
+// the script, possibly after running finally blocks.
+func (c *Context) uncaught(depth int) bool {
+	for i := depth - 1; i >= 0; i-- {
+		h := c.handlers[i]
+		if h.kind == handleUnchecked || h.kind == handleTry && h.errVar != "" {
+			return false
+		}
+	}
+	return c.strict
+}
+
+// emitFailure emits what a failure inside the first depth handlers does,
+// given batch text for its exit code and command line.
+func emitFailure(ctx *Context, depth int, code, cmd string) {
+	i, strict := ctx.failTarget(depth)
+	if i < 0 {
+		if strict {
+			ctx.emitLine("exit /b " + code)
+		}
+		return
+	}
+	h := ctx.handlers[i]
+	if h.kind == handleTry && h.errVar != "" {
+		ctx.emitLine(fmt.Sprintf("set %s_code=%s", h.errVar, code))
+		ctx.emitLine(fmt.Sprintf("set %s_command=%s", h.errVar, cmd))
+		ctx.emitLine("goto " + tryCatchLabel(h.id))
+		return
+	}
+	h.raised = true
+	ctx.emitLine(fmt.Sprintf("set %s=%s", mangleTemp("try_code", h.id), code))
+	ctx.emitLine(fmt.Sprintf("set %s=%s", mangleTemp("try_cmd", h.id), cmd))
+	ctx.emitLine(fmt.Sprintf("set %s=%s", mangleTemp("try_exit", h.id), tryRaiseLabel(h.id)))
+	ctx.emitLine("goto " + tryFinallyLabel(h.id))
+}
+
+// emitJump emits a jump to label, which lies outside the first depth
+// handlers. The finally blocks of the try statements it leaves run first,
+// innermost first, each continuing at the label stored in its exit variable.
+func emitJump(ctx *Context, label string, depth int) {
+	var leaving []*handler
+	for i := len(ctx.handlers) - 1; i >= depth; i-- {
+		if h := ctx.handlers[i]; h.finally && h.kind != handleFinally {
+			leaving = append(leaving, h)
+		}
+	}
+	if len(leaving) == 0 {
+		ctx.emitLine("goto " + label)
+		return
+	}
+	for i, h := range leaving {
+		next := label
+		if i+1 < len(leaving) {
+			next = tryFinallyLabel(leaving[i+1].id)
+		}
+		ctx.emitLine(fmt.Sprintf("set %s=%s", mangleTemp("try_exit", h.id), next))
+	}
+	ctx.emitLine("goto " + tryFinallyLabel(leaving[0].id))
+}
@@ -7,10 +7,35 @@ func whileStartLabel(id int) string    { return fmt.Sprintf("while_start_%d", id
 func whileEndLabel(id int) string      { return fmt.Sprintf("while_end_%d", id) }
 func loopContinueLabel(id int) string  { return fmt.Sprintf("loop_continue_%d", id) }
 func loopBreakLabel(id int) string     { return fmt.Sprintf("loop_break_%d", id) }
+func forStartLabel(id int) string      { return fmt.Sprintf("for_start_%d", id) }
+func eachStartLabel(id int) string     { return fmt.Sprintf("each_start_%d", id) }
 func fnReturnLabel(name string) string { return fmt.Sprintf("fn_ret_%s", name) }
+func tryCatchLabel(id int) string      { return fmt.Sprintf("try_catch_%d", id) }
+func tryFinallyLabel(id int) string    { return fmt.Sprintf("try_finally_%d", id) }
+func tryRaiseLabel(id int) string      { return fmt.Sprintf("try_raise_%d", id) }
+func tryEndLabel(id int) string        { return fmt.Sprintf("try_end_%d", id) }
+func ifNextLabel(id, n int) string     { return fmt.Sprintf("if_next_%d_%d", id, n) }
+func ifEndLabel(id int) string         { return fmt.Sprintf("if_end_%d", id) }
+func matchCaseLabel(id, n int) string  { return fmt.Sprintf("match_case_%d_%d", id, n) }
+func matchEndLabel(id int) string      { return fmt.Sprintf("match_end_%d", id) }
+func condSkipLabel(id int) string      { return fmt.Sprintf("cond_skip_%d", id) }
+func condFalseLabel(id int) string     { return fmt.Sprintf("cond_false_%d", id) }
+func condEndLabel(id int) string       { return fmt.Sprintf("cond_end_%d", id) }
+func paramsNextLabel(id int) string    { return fmt.Sprintf("params_next_%d", id) }
+func paramsShiftLabel(id int) string   { return fmt.Sprintf("params_shift_%d", id) }
+func paramsHelpLabel(id int) string    { return fmt.Sprintf("params_help_%d", id) }
+func paramsFailLabel(id int) string    { return fmt.Sprintf("params_fail_%d", id) }
+func paramsEndLabel(id int) string     { return fmt.Sprintf("params_end_%d", id) }
 
 // Mangle function names deterministically.
 func mangleFunc(name string) string { return fmt.Sprintf("fn_%s", name) }
 
+// helperLabel names the helper subroutine of a built-in function.
+func helperLabel(name string) string { return "__fin_" + name }
+
+// mapKeys names the list in which a map records its keys. The __fin_ prefix
+// keeps it apart from the variables of the map's own keys.
+func mapKeys(name string) string { return "__fin_keys_" + name }
+
 // Mangle temporary variable names deterministically.
 func mangleTemp(prefix string, id int) string { return fmt.Sprintf("%s_tmp_%d", prefix, id) }
@@ -0,0 +1,264 @@
+package interp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/cmdexe"
+)
+
+// builtins lists the built-in functions and the number of arguments each
+// takes. They match the helper subroutines of the generated batch code:
+// strings are handled a byte at a time and every comparison is
+// case-sensitive.
+var builtins = map[string]int{
+	"len": 1, "upper": 1, "lower": 1, "substr": 3, "replace": 3, "trim": 1,
+	"starts_with": 2, "ends_with": 2, "contains": 2, "split": 2, "join": 2,
+	"push": 2, "pop": 1, "insert": 3, "remove_at": 2, "index_of": 2,
+	"reverse": 1, "sort": 1, "slice": 3,
+}
+
+// listBuiltins are the built-ins whose first argument names a list.
+var listBuiltins = map[string]bool{
+	"join": true, "push": true, "pop": true, "insert": true, "remove_at": true,
+	"index_of": true, "reverse": true, "sort": true,
+}
+
+// builtin evaluates a call to a built-in function other than split and
+// slice, whose list results are only stored by assign.
+func (in *Interpreter) builtin(name string, argExprs []ast.Expr, pos ast.Pos) (string, error) {
+	if len(argExprs) != builtins[name] {
+		return "", errRuntime(pos, "function '%s' expects %d args, got %d", name, builtins[name], len(argExprs))
+	}
+	switch name {
+	case "split", "slice":
+		return "", errRuntime(pos, "%s returns a list, which can only be assigned to a variable", name)
+	case "contains":
+		// Like the batch helper, contains searches a list when its first
+		// argument names one.
+		if id, ok := argExprs[0].(*ast.IdentExpr); ok {
+			if _, isList := in.vars[id.Name+"_len"]; isList {
+				i, err := in.listBuiltin("index_of", argExprs)
+				return strconv.FormatBool(i != "-1"), err
+			}
+		}
+	}
+	if listBuiltins[name] {
+		return in.listBuiltin(name, argExprs)
+	}
+	args, err := in.evalArgs(argExprs)
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "len":
+		return itoa(len(args[0])), nil
+	case "upper":
+		return mapASCII(args[0], 'a', 'z', 'A'-'a'), nil
+	case "lower":
+		return mapASCII(args[0], 'A', 'Z', 'a'-'A'), nil
+	case "substr":
+		start, _ := cmdexe.ParseInt(args[1])
+		length, _ := cmdexe.ParseInt(args[2])
+		return substr(args[0], int(start), int(length)), nil
+	case "replace":
+		if args[1] == "" {
+			return args[0], nil
+		}
+		return strings.ReplaceAll(args[0], args[1], args[2]), nil
+	case "trim":
+		return strings.Trim(args[0], " "), nil
+	case "starts_with":
+		return strconv.FormatBool(strings.HasPrefix(args[0], args[1])), nil
+	case "ends_with":
+		return strconv.FormatBool(strings.HasSuffix(args[0], args[1])), nil
+	case "contains":
+		return strconv.FormatBool(strings.Contains(args[0], args[1])), nil
+	}
+	return "", errRuntime(pos, "undefined function '%s'", name)
+}
+
+// listBuiltin evaluates a built-in whose first argument names a list. The
+// others are evaluated as values; indexes that are not numbers read as 0.
+func (in *Interpreter) listBuiltin(name string, argExprs []ast.Expr) (string, error) {
+	list, err := in.refName(argExprs[0])
+	if err != nil {
+		return "", err
+	}
+	args, err := in.evalArgs(argExprs[1:])
+	if err != nil {
+		return "", err
+	}
+	elems := in.list(list)
+	switch name {
+	case "join":
+		return strings.Join(elems, args[0]), nil
+	case "push":
+		in.storeList(list, append(elems, args[0]))
+	case "pop":
+		if len(elems) == 0 {
+			return "", nil
+		}
+		in.storeList(list, elems[:len(elems)-1])
+		return elems[len(elems)-1], nil
+	case "insert":
+		at, _ := cmdexe.ParseInt(args[0])
+		i := min(max(int(at), 0), len(elems))
+		in.storeList(list, append(elems[:i], append([]string{args[1]}, elems[i:]...)...))
+	case "remove_at":
+		at, _ := cmdexe.ParseInt(args[0])
+		i := int(at)
+		if i < 0 || i >= len(elems) {
+			return "", nil
+		}
+		v := elems[i]
+		in.storeList(list, append(elems[:i], elems[i+1:]...))
+		return v, nil
+	case "index_of":
+		for i, v := range elems {
+			if v == args[0] {
+				return itoa(i), nil
+			}
+		}
+		return "-1", nil
+	case "reverse":
+		for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+			elems[i], elems[j] = elems[j], elems[i]
+		}
+		in.storeList(list, elems)
+	case "sort":
+		// An insertion sort, as in the batch helper, so values that do not
+		// compare consistently end up in the same order.
+		for i := 1; i < len(elems); i++ {
+			v := elems[i]
+			j := i
+			for ; j > 0 && sortsAfter(elems[j-1], v); j-- {
+				elems[j] = elems[j-1]
+			}
+			elems[j] = v
+		}
+		in.storeList(list, elems)
+	}
+	return "", nil
+}
+
+// storeList stores elems as the list name, dropping the elements it held
+// past the new end.
+func (in *Interpreter) storeList(name string, elems []string) {
+	n, _ := cmdexe.ParseInt(in.vars[name+"_len"])
+	for i := len(elems); i < int(n); i++ {
+		delete(in.vars, elemName(name, i))
+	}
+	for i, v := range elems {
+		in.vars[elemName(name, i)] = v
+	}
+	in.vars[name+"_len"] = itoa(len(elems))
+}
+
+// assignList stores the list a call of split or slice returns as name.
+func (in *Interpreter) assignList(name string, call *ast.CallExpr) error {
+	if len(call.Args) != builtins[call.Name] {
+		return errRuntime(call.Pos(), "function '%s' expects %d args, got %d", call.Name, builtins[call.Name], len(call.Args))
+	}
+	if call.Name == "slice" {
+		list, err := in.refName(call.Args[0])
+		if err != nil {
+			return err
+		}
+		args, err := in.evalArgs(call.Args[1:])
+		if err != nil {
+			return err
+		}
+		start, _ := cmdexe.ParseInt(args[0])
+		end, _ := cmdexe.ParseInt(args[1])
+		in.storeList(name, slice(in.list(list), int(start), int(end)))
+		return nil
+	}
+	args, err := in.evalArgs(call.Args)
+	if err != nil {
+		return err
+	}
+	// An empty separator cuts the string into single characters.
+	in.storeList(name, strings.Split(args[0], args[1]))
+	return nil
+}
+
+// list returns the elements of the list name.
+func (in *Interpreter) list(name string) []string {
+	n, _ := cmdexe.ParseInt(in.vars[name+"_len"])
+	elems := make([]string, 0, max(n, 0))
+	for i := 0; i < int(n); i++ {
+		elems = append(elems, in.vars[elemName(name, i)])
+	}
+	return elems
+}
+
+func (in *Interpreter) evalArgs(argExprs []ast.Expr) ([]string, error) {
+	args := make([]string, len(argExprs))
+	for i, a := range argExprs {
+		v, err := in.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// slice returns the elements from start up to, but not including, end. A
+// negative index counts from the end of the list.
+func slice(elems []string, start, end int) []string {
+	n := len(elems)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end += n
+	}
+	end = min(end, n)
+	if start >= end {
+		return nil
+	}
+	return append([]string(nil), elems[start:end]...)
+}
+
+// sortsAfter reports whether a sorts after b. Like the sort helper, it
+// compares integers, written the way set /a prints them, numerically and
+// anything else as quoted strings, which is how `if` compares them.
+func sortsAfter(a, b string) bool {
+	an, aok := cmdexe.ParseInt(a)
+	bn, bok := cmdexe.ParseInt(b)
+	if aok && bok && itoa(int(an)) == a && itoa(int(bn)) == b {
+		return an > bn
+	}
+	return cmdexe.CompareStrings("\""+a+"\"", "\""+b+"\"", false) > 0
+}
+
+// substr returns the part of s that the batch expansion %s:~start,length%
+// selects. A negative start counts from the end of s, and a negative length
+// leaves that many characters off the end.
+func substr(s string, start, length int) string {
+	n := len(s)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	start = min(start, n)
+	end := start + length
+	if length < 0 {
+		end = n + length
+	}
+	end = min(max(end, start), n)
+	return s[start:end]
+}
+
+// mapASCII shifts the bytes of s between lo and hi by delta.
+func mapASCII(s string, lo, hi byte, delta int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= lo && c <= hi {
+			b[i] = byte(int(c) + delta)
+		}
+	}
+	return string(b)
+}
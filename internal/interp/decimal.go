@@ -0,0 +1,171 @@
+package interp
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/cmdexe"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Decimals are computed the way the generated helpers compute them: each
+// operand is read into a 32-bit integer scaled by 10 ** precision, rounding
+// half away from zero, and results are written back with exactly precision
+// digits after the point. +, - and * read their operands unrounded instead,
+// to twice the precision, and round only the result.
+
+// isDecimal reports whether sema typed expr as a decimal.
+func (in *Interpreter) isDecimal(expr ast.Expr) bool {
+	return in.types[expr] == sema.TypeDecimal
+}
+
+// evalDecimal applies a decimal arithmetic or comparison operator.
+func (in *Interpreter) evalDecimal(op string, left, right ast.Expr, pos ast.Pos) (string, error) {
+	l, err := in.eval(left)
+	if err != nil {
+		return "", err
+	}
+	r, err := in.eval(right)
+	if err != nil {
+		return "", err
+	}
+	p := in.opts.Precision
+	switch op {
+	case "+":
+		return decOut(decSum(decExact(l, p), decExact(r, p), p), p), nil
+	case "-":
+		d := decExact(r, p)
+		d.sign = -d.sign
+		return decOut(decSum(decExact(l, p), d, p), p), nil
+	case "*":
+		return decOut(decMul(decExact(l, p), decExact(r, p), p), p), nil
+	}
+	a, b := decIn(l, p), decIn(r, p)
+	switch op {
+	case "/":
+		if b == 0 {
+			return "", errRuntime(pos, "division by zero")
+		}
+		return decOut(decDiv(a, b, p), p), nil
+	}
+	c := "0"
+	switch {
+	case a < b:
+		c = "-1"
+	case a > b:
+		c = "1"
+	}
+	return strconv.FormatBool(compare(op, c, "0")), nil
+}
+
+// decScale returns 10 ** p.
+func decScale(p int) int32 {
+	z := int32(1)
+	for i := 0; i < p; i++ {
+		z *= 10
+	}
+	return z
+}
+
+// decIn reads s as a scaled integer. Digits past the precision round the
+// last one kept; a value that is not a number reads as zero.
+func decIn(s string, p int) int32 {
+	d := decExact(s, p)
+	if d.x*2 >= decScale(p) {
+		d.t++
+	}
+	return d.sign * d.t
+}
+
+// decimal is a value read to twice the precision: its sign, 1 or -1, its
+// magnitude cut at the precision as a scaled integer, and the next p digits.
+type decimal struct {
+	sign, t, x int32
+}
+
+// decExact reads s as a decimal without rounding; digits past twice the
+// precision are dropped.
+func decExact(s string, p int) decimal {
+	d := decimal{sign: 1}
+	if strings.HasPrefix(s, "-") {
+		d.sign, s = -1, s[1:]
+	}
+	ip, fp, _ := strings.Cut(s, ".")
+	i, _ := cmdexe.ParseInt(strings.TrimLeft(ip, "0"))
+	fp += "00000000"
+	f, _ := strconv.Atoi(fp[:p])
+	x, _ := strconv.Atoi(fp[p : 2*p])
+	d.t, d.x = i*decScale(p)+int32(f), int32(x)
+	return d
+}
+
+// decOut writes a scaled integer with p digits after the point. The
+// magnitude is taken in 64 bits, since that of the smallest int32 does not
+// fit in 32.
+func decOut(v int32, p int) string {
+	sign, n := "", int64(v)
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	z := int64(decScale(p))
+	f := strconv.FormatInt(n%z+z, 10)
+	return sign + strconv.FormatInt(n/z, 10) + "." + f[1:]
+}
+
+// decSum adds two decimals and rounds the sum to the precision. The parts
+// cut at the precision and the digits past them are summed apart, and the
+// carry of the latter is added to the former.
+func decSum(a, b decimal, p int) int32 {
+	z := decScale(p)
+	r := a.sign*a.t + b.sign*b.t
+	g := a.sign*a.x + b.sign*b.x + 2*z
+	r += g/z - 2
+	g = g % z * 2
+	if r >= 0 && g >= z || r < 0 && g > z {
+		r++
+	}
+	return r
+}
+
+// decMul multiplies two decimals and rounds the product to the precision.
+// The magnitudes are split into their whole parts, the digits of their
+// fractions up to the precision and the digits past it, so that no partial
+// product carries the scale twice, which would overflow long before the
+// result does. The partial products below the precision are carried up and
+// round the result.
+func decMul(a, b decimal, p int) int32 {
+	z := decScale(p)
+	ai, af, bi, bf := a.t/z, a.t%z, b.t/z, b.t%z
+	r := ai*b.t + af*bi + ai*b.x/z + a.x*bi/z
+	u := af*bf + ai*b.x%z + a.x*bi%z
+	v := af*b.x + a.x*bf + a.x*b.x/z
+	u, v = u+v/z, v%z
+	r, u = r+u/z, u%z
+	if (u*z+v)*2 >= z*z {
+		r++
+	}
+	return a.sign * b.sign * r
+}
+
+// decDiv divides two scaled integers by long division, one digit of the
+// fraction at a time, and rounds on the remainder.
+func decDiv(a, b int32, p int) int32 {
+	sign := int32(1)
+	if a < 0 {
+		sign, a = -sign, -a
+	}
+	if b < 0 {
+		sign, b = -sign, -b
+	}
+	r, m := a/b, a%b
+	for i := 0; i < p; i++ {
+		m *= 10
+		r = r*10 + m/b
+		m %= b
+	}
+	if m*2 >= b {
+		r++
+	}
+	return sign * r
+}
@@ -0,0 +1,56 @@
+package interp
+
+import (
+	"fmt"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// RuntimeError is a typed error for failures while evaluating a program.
+type RuntimeError struct {
+	Msg string
+	P   ast.Pos
+}
+
+func (e *RuntimeError) Error() string {
+	if e.P.Line > 0 {
+		return fmt.Sprintf("runtime error at %s: %s", where(e.P), e.Msg)
+	}
+	return fmt.Sprintf("runtime error: %s", e.Msg)
+}
+
+// Pos returns the source position of the failing node.
+func (e *RuntimeError) Pos() ast.Pos { return e.P }
+
+// where formats pos as LINE:COLUMN, after the file when it is in an
+// imported one.
+func where(pos ast.Pos) string {
+	if pos.File != "" {
+		return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+	}
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+func errRuntime(pos ast.Pos, format string, args ...any) error {
+	return &RuntimeError{Msg: fmt.Sprintf(format, args...), P: pos}
+}
+
+// CommandFailedError reports a command that exited with a nonzero code in a
+// strict program. Code is the exit code the script ends with.
+type CommandFailedError struct {
+	Command string
+	Code    int
+	P       ast.Pos
+}
+
+func (e *CommandFailedError) Error() string {
+	return fmt.Sprintf("runtime error at %s: %s", where(e.P), e.Msg())
+}
+
+// Msg describes the failure without its position.
+func (e *CommandFailedError) Msg() string {
+	return fmt.Sprintf("command %q failed with exit code %d", e.Command, e.Code)
+}
+
+// Pos returns the position of the failing run.
+func (e *CommandFailedError) Pos() ast.Pos { return e.P }
@@ -0,0 +1,458 @@
+package interp
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/cmdexe"
+)
+
+// eval evaluates an expression to its runtime string value.
+func (in *Interpreter) eval(expr ast.Expr) (string, error) {
+	switch e := expr.(type) {
+	case nil:
+		return "", nil
+	case *ast.StringLit:
+		return in.evalString(e)
+	case *ast.NumberLit:
+		return e.Value, nil
+	case *ast.BoolLit:
+		return strconv.FormatBool(e.Value), nil
+	case *ast.IdentExpr, *ast.PropertyExpr, *ast.IndexExpr:
+		name, err := in.refName(expr)
+		if err != nil {
+			return "", err
+		}
+		return in.vars[name], nil
+	case *ast.ListLit:
+		parts := make([]string, len(e.Elements))
+		for i, el := range e.Elements {
+			v, err := in.eval(el)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = v
+		}
+		return strings.Join(parts, ","), nil
+	case *ast.MapLit:
+		parts := make([]string, len(e.Pairs))
+		for i, p := range e.Pairs {
+			v, err := in.eval(p.Value)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = p.Key + "=" + v
+		}
+		return strings.Join(parts, ","), nil
+	case *ast.ExistsCond:
+		path, err := in.eval(e.Path)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(in.opts.Exists(path)), nil
+	case *ast.UnaryExpr:
+		switch e.Op {
+		case "!":
+			ok, err := in.truthy(e.Right)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatBool(!ok), nil
+		case "-":
+			if lit, ok := e.Right.(*ast.NumberLit); ok && in.isDecimal(e) {
+				return "-" + lit.Value, nil
+			}
+			if in.isDecimal(e) {
+				return in.evalDecimal("-", &ast.NumberLit{Value: "0"}, e.Right, e.P)
+			}
+			n, err := in.evalInt(e.Right)
+			if err != nil {
+				return "", err
+			}
+			return itoa(int(-n)), nil
+		}
+		return "", errRuntime(e.Pos(), "unsupported unary operator %s", e.Op)
+	case *ast.BinaryExpr:
+		return in.evalBinary(e)
+	case *ast.CallExpr:
+		return in.call(e.Name, e.Args, e.Pos())
+	case *ast.RunExpr:
+		return in.capture(e)
+	default:
+		return "", errRuntime(expr.Pos(), "unsupported expression type %T", expr)
+	}
+}
+
+func (in *Interpreter) evalBinary(e *ast.BinaryExpr) (string, error) {
+	switch e.Op {
+	case "&&", "||":
+		left, err := in.truthy(e.Left)
+		if err != nil {
+			return "", err
+		}
+		if (e.Op == "&&" && !left) || (e.Op == "||" && left) {
+			return strconv.FormatBool(left), nil
+		}
+		right, err := in.truthy(e.Right)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(right), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		if in.isDecimal(e.Left) || in.isDecimal(e.Right) {
+			return in.evalDecimal(e.Op, e.Left, e.Right, e.P)
+		}
+		left, err := in.eval(e.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := in.eval(e.Right)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(compare(e.Op, left, right)), nil
+	}
+	if in.isDecimal(e) {
+		return in.evalDecimal(e.Op, e.Left, e.Right, e.P)
+	}
+
+	left, err := in.evalInt(e.Left)
+	if err != nil {
+		return "", err
+	}
+	right, err := in.evalInt(e.Right)
+	if err != nil {
+		return "", err
+	}
+	var n int32
+	switch e.Op {
+	case "+":
+		n = left + right
+	case "-":
+		n = left - right
+	case "*":
+		n = left * right
+	case "/", "%":
+		if right == 0 {
+			return "", errRuntime(e.Pos(), "division by zero")
+		}
+		if e.Op == "/" {
+			n = left / right
+		} else {
+			n = left % right
+		}
+	case "<<":
+		n = left << (uint32(right) & 31)
+	case ">>":
+		n = left >> (uint32(right) & 31)
+	case "&":
+		n = left & right
+	case "|":
+		n = left | right
+	case "^":
+		n = left ^ right
+	case "**":
+		var ok bool
+		if n, ok = cmdexe.Pow(left, right); !ok {
+			return "", errRuntime(e.Pos(), "integer overflow: %d ** %d does not fit in 32 bits", left, right)
+		}
+	default:
+		return "", errRuntime(e.Pos(), "unsupported binary operator %s", e.Op)
+	}
+	return itoa(int(n)), nil
+}
+
+// evalInt evaluates an expression with `set /a` semantics: 32-bit signed
+// integers, with non-numeric values reading as zero.
+func (in *Interpreter) evalInt(expr ast.Expr) (int32, error) {
+	v, err := in.eval(expr)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := cmdexe.ParseInt(v)
+	return n, nil
+}
+
+// truthy evaluates a condition. Empty strings, "false" and "0" are false;
+// every other value is true.
+func (in *Interpreter) truthy(expr ast.Expr) (bool, error) {
+	v, err := in.eval(expr)
+	if err != nil {
+		return false, err
+	}
+	switch v {
+	case "", "false", "0":
+		return false, nil
+	}
+	return true, nil
+}
+
+// refName resolves a variable reference to the flattened environment name it
+// reads: $a -> a, $a.b -> a_b, $a[i] -> a_<value of i>.
+func (in *Interpreter) refName(expr ast.Expr) (string, error) {
+	switch e := expr.(type) {
+	case *ast.IdentExpr:
+		return e.Name, nil
+	case *ast.PropertyExpr:
+		base, err := in.refName(e.Object)
+		if err != nil {
+			return "", err
+		}
+		return base + "_" + e.Field, nil
+	case *ast.IndexExpr:
+		base, err := in.refName(e.Left)
+		if err != nil {
+			return "", err
+		}
+		idx, err := in.eval(e.Index)
+		if err != nil {
+			return "", err
+		}
+		return base + "_" + idx, nil
+	default:
+		return "", errRuntime(expr.Pos(), "cannot reference %T as a variable", expr)
+	}
+}
+
+// evalString interpolates a string literal, running its $(...) calls in order
+// and splicing in their results.
+func (in *Interpreter) evalString(e *ast.StringLit) (string, error) {
+	spans := ast.Substitutions(e.Value)
+	if len(e.Calls) == 0 || len(spans) != len(e.Calls) {
+		return in.interpolate(e.Value), nil
+	}
+	var b strings.Builder
+	last := 0
+	for i, span := range spans {
+		b.WriteString(in.interpolate(e.Value[last:span[0]]))
+		v, err := in.call(e.Calls[i].Name, e.Calls[i].Args, e.Calls[i].Pos())
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+		last = span[1]
+	}
+	b.WriteString(in.interpolate(e.Value[last:]))
+	return b.String(), nil
+}
+
+// interpolate expands $ident, $ident.prop and $ident[index] inside a string
+// literal; $$ is a literal dollar sign.
+func (in *Interpreter) interpolate(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '$' {
+			b.WriteByte('$')
+			i += 2
+			continue
+		}
+		j := i + 1
+		if j >= len(s) || !isIdentStart(s[j]) {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		for j < len(s) && isIdentPart(s[j]) {
+			j++
+		}
+		name := s[i+1 : j]
+		if j < len(s) && s[j] == '.' && j+1 < len(s) && isIdentStart(s[j+1]) {
+			k := j + 1
+			for k < len(s) && isIdentPart(s[k]) {
+				k++
+			}
+			b.WriteString(in.vars[name+"_"+s[j+1:k]])
+			i = k
+			continue
+		}
+		if j < len(s) && s[j] == '[' {
+			if k := strings.IndexByte(s[j:], ']'); k >= 0 {
+				idx := s[j+1 : j+k]
+				if !isDigits(idx) {
+					idx = in.vars[strings.TrimPrefix(idx, "$")]
+				}
+				b.WriteString(in.vars[name+"_"+idx])
+				i = j + k + 1
+				continue
+			}
+		}
+		b.WriteString(in.vars[name])
+		i = j
+	}
+	return b.String()
+}
+
+// commandLine builds the command line of a run the way the generated batch
+// does: the command text after percent expansion, then each argument. Literal
+// arguments are quoted when they need it; other values always are, since the
+// batch code cannot inspect them.
+func (in *Interpreter) commandLine(command ast.Expr, args []ast.Expr) (string, error) {
+	cmd, err := in.eval(command)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(in.expandPercents(strings.TrimSpace(cmd)))
+	for _, arg := range args {
+		v, err := in.eval(arg)
+		if err != nil {
+			return "", err
+		}
+		if isLiteral(arg) {
+			b.WriteString(" " + quoteArg(v))
+		} else {
+			b.WriteString(" \"" + v + "\"")
+		}
+	}
+	return b.String(), nil
+}
+
+// isLiteral reports whether e is a value known before the program runs.
+func isLiteral(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.StringLit:
+		return !strings.Contains(e.Value, "$")
+	case *ast.NumberLit, *ast.BoolLit:
+		return true
+	}
+	return false
+}
+
+// quoteArg quotes s as one argument for the C runtime's command-line
+// splitting, matching the generator: empty arguments and those holding
+// whitespace, quotes or cmd.exe operators are wrapped in quotes, and embedded
+// quotes, with the backslashes before them, are escaped with backslashes.
+func quoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"&|<>()^,;=") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			slashes++
+		case '"':
+			b.WriteString(strings.Repeat("\\", slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteString(strings.Repeat("\\", slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// expandPercents applies the batch-file percent expansion a `run` command line
+// undergoes: %% is a literal percent, %1..%9 and %* are positional arguments,
+// and %name% reads a Fin variable or, failing that, the host environment.
+func (in *Interpreter) expandPercents(s string) string {
+	args := in.args()
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			break
+		}
+		next := s[i+1]
+		switch {
+		case next == '%':
+			b.WriteByte('%')
+			i++
+		case next == '*':
+			b.WriteString(strings.Join(args, " "))
+			i++
+		case next >= '0' && next <= '9':
+			if n := int(next-'0') + in.shifted(); n >= 1 && n <= len(args) {
+				b.WriteString(args[n-1])
+			}
+			i++
+		default:
+			end := strings.IndexByte(s[i+1:], '%')
+			if end < 0 {
+				continue
+			}
+			name := s[i+1 : i+1+end]
+			if v, ok := in.vars[name]; ok {
+				b.WriteString(v)
+			} else {
+				b.WriteString(lookupEnv(name))
+			}
+			i += end + 1
+		}
+	}
+	return b.String()
+}
+
+// compare applies a comparison operator. == and != compare the text, as the
+// generated batch compares the quoted strings; the ordering operators
+// compare numerically when both operands are integers and otherwise in the
+// order the if command puts strings in.
+func compare(op, left, right string) bool {
+	switch op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+	c := 0
+	ln, lok := cmdexe.ParseInt(left)
+	rn, rok := cmdexe.ParseInt(right)
+	if lok && rok {
+		switch {
+		case ln < rn:
+			c = -1
+		case ln > rn:
+			c = 1
+		}
+	} else {
+		c = cmdexe.CompareStrings(left, right, false)
+	}
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+func lookupEnv(name string) string { return os.Getenv(name) }
+
+func itoa(n int) string { return strconv.Itoa(n) }
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentStart(b byte) bool {
+	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || b == '_'
+}
+
+func isIdentPart(b byte) bool {
+	return isIdentStart(b) || (b >= '0' && b <= '9')
+}
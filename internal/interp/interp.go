@@ -0,0 +1,623 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/cmdexe"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// DefaultMaxDepth bounds function call nesting so runaway recursion fails cleanly.
+const DefaultMaxDepth = 1000
+
+// ExecFunc runs a shell command for a `run` statement and returns its exit code.
+// env holds the full environment (host variables plus Fin variables) for the child.
+type ExecFunc func(command string, env []string, stdout, stderr io.Writer) (int, error)
+
+// Options configures an Interpreter. Zero values select host defaults.
+type Options struct {
+	Stdout   io.Writer
+	Stderr   io.Writer
+	Args     []string // script arguments, visible to `run` commands as %1..%9 and %*
+	Exec     ExecFunc
+	Exists   func(path string) bool
+	MaxDepth int
+	// Strict makes a run that exits with a nonzero code outside an unchecked
+	// block stop the program with a *CommandFailedError, as the strict
+	// directive does.
+	Strict bool
+	// Trace, if set, is called after each statement completes without error.
+	Trace func(stmt ast.Statement)
+	// Precision is the number of digits decimals keep after the point,
+	// sema.DefaultPrecision when zero.
+	Precision int
+}
+
+// Interpreter evaluates a validated AST directly, mirroring the semantics the
+// BatchGenerator targets: every variable is a string in one flat environment,
+// lists expand to name_N plus name_len, maps to name_key, and each function call
+// runs against a private copy of the environment (like setlocal/endlocal).
+type Interpreter struct {
+	opts   Options
+	vars   map[string]string
+	funcs  map[string]*ast.FnDecl
+	frames []frame
+	types  map[ast.Expr]sema.Type // decides, as in the generator, which arithmetic is decimal
+	shift  int                    // script arguments the params block consumed
+
+	strict   bool
+	handlers []handler // enclosing unchecked blocks and try statements in the running function
+}
+
+// handler is an unchecked block or a try statement that a failing command
+// may be inside of.
+type handler struct {
+	unchecked bool
+	catches   bool // running a try body that has a catch block
+}
+
+// frame is one active function call.
+type frame struct {
+	fn   *ast.FnDecl
+	args []string
+	ret  string
+}
+
+// control signals non-local exits from statement lists.
+type control int
+
+const (
+	ctlNone control = iota
+	ctlBreak
+	ctlContinue
+	ctlReturn
+	ctlExit // the script ends successfully, as after --help
+)
+
+// New constructs an Interpreter with the given options.
+func New(opts Options) *Interpreter {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	if opts.Exec == nil {
+		opts.Exec = hostExec
+	}
+	if opts.Exists == nil {
+		opts.Exists = hostExists
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	if opts.Precision <= 0 {
+		opts.Precision = sema.DefaultPrecision
+	}
+	return &Interpreter{
+		opts:   opts,
+		vars:   make(map[string]string),
+		funcs:  make(map[string]*ast.FnDecl),
+		strict: opts.Strict,
+	}
+}
+
+// Run executes the program. Function declarations are hoisted first so calls
+// may appear before the declaration, matching the generated batch layout.
+func (in *Interpreter) Run(p *ast.Program) error {
+	if p == nil {
+		return nil
+	}
+	in.types = sema.AnalyzeDefinitions(p).Types
+	for _, stmt := range p.Statements {
+		switch s := stmt.(type) {
+		case *ast.FnDecl:
+			in.funcs[s.Name] = s
+		case *ast.StrictStmt:
+			in.strict = true
+		}
+	}
+	var body []ast.Statement
+	for _, stmt := range p.Statements {
+		if _, ok := stmt.(*ast.FnDecl); ok {
+			continue
+		}
+		body = append(body, stmt)
+	}
+	ctl, err := in.execBlock(body)
+	if err != nil {
+		return err
+	}
+	if ctl != ctlNone && ctl != ctlExit {
+		return errRuntime(p.Pos(), "break, continue or return outside of a loop or function")
+	}
+	return nil
+}
+
+// Vars returns a copy of the current global environment.
+func (in *Interpreter) Vars() map[string]string {
+	out := make(map[string]string, len(in.vars))
+	for k, v := range in.vars {
+		out[k] = v
+	}
+	return out
+}
+
+// Lookup returns a variable from the current environment.
+func (in *Interpreter) Lookup(name string) (string, bool) {
+	v, ok := in.vars[name]
+	return v, ok
+}
+
+func (in *Interpreter) execBlock(stmts []ast.Statement) (control, error) {
+	for _, stmt := range stmts {
+		ctl, err := in.execStmt(stmt)
+		if err == nil && in.opts.Trace != nil {
+			in.opts.Trace(stmt)
+		}
+		if err != nil || ctl != ctlNone {
+			return ctl, err
+		}
+	}
+	return ctlNone, nil
+}
+
+func (in *Interpreter) execStmt(stmt ast.Statement) (control, error) {
+	switch s := stmt.(type) {
+	case *ast.SetStmt:
+		return ctlNone, in.assign(s.Name, s.Value)
+	case *ast.AssignStmt:
+		return ctlNone, in.assign(s.Name, s.Result())
+	case *ast.EchoStmt:
+		return ctlNone, in.execEcho(s)
+	case *ast.RunStmt:
+		return ctlNone, in.execRun(s)
+	case *ast.CallStmt:
+		return ctlNone, in.execCall(s)
+	case *ast.IfStmt:
+		ok, err := in.truthy(s.Cond)
+		if err != nil {
+			return ctlNone, err
+		}
+		if ok {
+			return in.execBlock(s.Then)
+		}
+		for _, branch := range s.ElseIfs {
+			ok, err := in.truthy(branch.Cond)
+			if err != nil {
+				return ctlNone, err
+			}
+			if ok {
+				return in.execBlock(branch.Body)
+			}
+		}
+		return in.execBlock(s.Else)
+	case *ast.MatchStmt:
+		return in.execMatch(s)
+	case *ast.ForStmt:
+		return in.execFor(s)
+	case *ast.ForEachStmt:
+		return in.execForEach(s)
+	case *ast.WhileStmt:
+		return in.execWhile(s)
+	case *ast.ReturnStmt:
+		return in.execReturn(s)
+	case *ast.BreakStmt:
+		return ctlBreak, nil
+	case *ast.ContinueStmt:
+		return ctlContinue, nil
+	case *ast.StrictStmt:
+		return ctlNone, nil
+	case *ast.ParamsStmt:
+		return in.execParams(s)
+	case *ast.UncheckedStmt:
+		in.handlers = append(in.handlers, handler{unchecked: true})
+		defer func() { in.handlers = in.handlers[:len(in.handlers)-1] }()
+		return in.execBlock(s.Body)
+	case *ast.TryStmt:
+		return in.execTry(s)
+	case *ast.FnDecl:
+		return ctlNone, errRuntime(s.Pos(), "function declaration '%s' must be at top level", s.Name)
+	case nil:
+		return ctlNone, errRuntime(ast.Pos{}, "nil statement")
+	default:
+		return ctlNone, errRuntime(s.Pos(), "unsupported statement type %T", stmt)
+	}
+}
+
+// assign stores a value under name, expanding lists and maps into their
+// flattened variables the same way lowerSetStmt does. A map also records its
+// keys as the list __fin_keys_name, which for loops iterate.
+func (in *Interpreter) assign(name string, value ast.Expr) error {
+	switch v := value.(type) {
+	case *ast.ListLit:
+		for i, el := range v.Elements {
+			s, err := in.eval(el)
+			if err != nil {
+				return err
+			}
+			in.vars[elemName(name, i)] = s
+		}
+		in.vars[name+"_len"] = itoa(len(v.Elements))
+		return nil
+	case *ast.MapLit:
+		keys := keysName(name)
+		for i, p := range v.Pairs {
+			s, err := in.eval(p.Value)
+			if err != nil {
+				return err
+			}
+			in.vars[name+"_"+p.Key] = s
+			in.vars[elemName(keys, i)] = p.Key
+		}
+		in.vars[keys+"_len"] = itoa(len(v.Pairs))
+		return nil
+	case *ast.CallExpr:
+		if v.Name == "split" || v.Name == "slice" {
+			return in.assignList(name, v)
+		}
+	}
+	s, err := in.eval(value)
+	if err != nil {
+		return err
+	}
+	in.vars[name] = s
+	return nil
+}
+
+func (in *Interpreter) execEcho(s *ast.EchoStmt) error {
+	var out string
+	if s.Value != nil {
+		v, err := in.eval(s.Value)
+		if err != nil {
+			return err
+		}
+		out = v
+	}
+	_, err := io.WriteString(in.opts.Stdout, out+"\n")
+	return err
+}
+
+func (in *Interpreter) execRun(s *ast.RunStmt) error {
+	line, err := in.commandLine(s.Command, s.Args)
+	if err != nil {
+		return err
+	}
+	code, err := in.opts.Exec(line, in.environ(), in.opts.Stdout, in.opts.Stderr)
+	if err != nil {
+		return errRuntime(s.Pos(), "run %q: %v", line, err)
+	}
+	in.vars["status"] = strconv.Itoa(code)
+	if checked, _ := in.failure(); code != 0 && checked {
+		return &CommandFailedError{Command: line, Code: code, P: s.Pos()}
+	}
+	return nil
+}
+
+// capture runs the command of a `(run ...)` expression and returns the first
+// line it prints, as the generated for /f loop does: empty lines and lines
+// starting with ';' are skipped. status holds the command's exit code.
+func (in *Interpreter) capture(e *ast.RunExpr) (string, error) {
+	line, err := in.commandLine(e.Command, e.Args)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	code, err := in.opts.Exec(line, in.environ(), &out, in.opts.Stderr)
+	if err != nil {
+		return "", errRuntime(e.Pos(), "run %q: %v", line, err)
+	}
+	in.vars["status"] = strconv.Itoa(code)
+	for _, l := range strings.Split(out.String(), "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		if l != "" && l[0] != ';' {
+			return l, nil
+		}
+	}
+	return "", nil
+}
+
+func (in *Interpreter) execCall(s *ast.CallStmt) error {
+	_, err := in.call(s.Name, s.Args, s.Pos())
+	return err
+}
+
+// call runs the named function and returns its return value, which is also
+// left in fn_NAME_ret where the generated batch code reads it. Calls to
+// built-in functions are evaluated by builtin.
+func (in *Interpreter) call(name string, argExprs []ast.Expr, pos ast.Pos) (string, error) {
+	if _, ok := builtins[name]; ok {
+		return in.builtin(name, argExprs, pos)
+	}
+	fn, ok := in.funcs[name]
+	if !ok {
+		return "", errRuntime(pos, "undefined function '%s'", name)
+	}
+	if len(argExprs) != len(fn.Params) {
+		return "", errRuntime(pos, "function '%s' expects %d args, got %d", name, len(fn.Params), len(argExprs))
+	}
+	if len(in.frames) >= in.opts.MaxDepth {
+		return "", errRuntime(pos, "maximum call depth %d exceeded calling '%s'", in.opts.MaxDepth, name)
+	}
+	args := make([]string, len(argExprs))
+	for i, a := range argExprs {
+		v, err := in.eval(a)
+		if err != nil {
+			return "", err
+		}
+		args[i] = v
+	}
+
+	// Functions run against a private copy of the environment; only the
+	// return slot survives, as with `endlocal & set fn_NAME_ret=...`.
+	saved := in.vars
+	in.vars = make(map[string]string, len(saved)+len(fn.Params))
+	for k, v := range saved {
+		in.vars[k] = v
+	}
+	for i, p := range fn.Params {
+		in.vars[p] = args[i]
+	}
+	// Whether a failure is checked depends on where the code is written,
+	// so the body of a function called from an unchecked block is checked.
+	handlers := in.handlers
+	in.handlers = nil
+	in.frames = append(in.frames, frame{fn: fn, args: args})
+	ctl, err := in.execBlock(fn.Body)
+	ret := in.frames[len(in.frames)-1].ret
+	in.frames = in.frames[:len(in.frames)-1]
+	in.vars = saved
+	in.handlers = handlers
+	var failed *CommandFailedError
+	if errors.As(err, &failed) {
+		switch checked, caught := in.failure(); {
+		case !checked:
+			// The function stopped early, so like the batch code, which
+			// leaves it with exit /b, it does not set a return value.
+			return in.vars[returnVar(fn.Name)], nil
+		case caught:
+			// The caller only sees the exit code of the call.
+			return "", &CommandFailedError{Command: name, Code: failed.Code, P: pos}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if ctl == ctlBreak || ctl == ctlContinue {
+		return "", errRuntime(fn.Pos(), "break or continue outside of a loop in function '%s'", fn.Name)
+	}
+	in.vars[returnVar(fn.Name)] = ret
+	return ret, nil
+}
+
+// execMatch runs the first case whose value has the subject's text, as the
+// generated batch compares the quoted strings, or the else arm when none has.
+func (in *Interpreter) execMatch(s *ast.MatchStmt) (control, error) {
+	subject, err := in.eval(s.Subject)
+	if err != nil {
+		return ctlNone, err
+	}
+	for _, arm := range s.Cases {
+		for _, v := range arm.Values {
+			value, err := in.eval(v)
+			if err != nil {
+				return ctlNone, err
+			}
+			if value == subject {
+				return in.execBlock(arm.Body)
+			}
+		}
+	}
+	return in.execBlock(s.Default)
+}
+
+// execTry runs a try statement. A failing command in the body runs the catch
+// block with its code and command line in ERR_code and ERR_command. The
+// finally block always runs; a break, continue, return or error from it
+// replaces the one that left the body or catch block.
+func (in *Interpreter) execTry(s *ast.TryStmt) (control, error) {
+	depth := len(in.handlers)
+	in.handlers = append(in.handlers, handler{catches: s.Err != ""})
+	defer func() { in.handlers = in.handlers[:depth] }()
+	ctl, err := in.execBlock(s.Body)
+	in.handlers[depth].catches = false
+	var failed *CommandFailedError
+	if s.Err != "" && errors.As(err, &failed) {
+		in.vars[s.Err+"_code"] = strconv.Itoa(failed.Code)
+		in.vars[s.Err+"_command"] = failed.Command
+		ctl, err = in.execBlock(s.Catch)
+	}
+	if len(s.Finally) > 0 {
+		if fctl, ferr := in.execBlock(s.Finally); ferr != nil || fctl != ctlNone {
+			ctl, err = fctl, ferr
+		}
+	}
+	return ctl, err
+}
+
+// failure reports whether a command failing in the running function stops
+// the code it is in, and whether a catch block then handles it.
+func (in *Interpreter) failure() (checked, caught bool) {
+	for i := len(in.handlers) - 1; i >= 0; i-- {
+		switch h := in.handlers[i]; {
+		case h.unchecked:
+			return false, false
+		case h.catches:
+			return true, true
+		}
+	}
+	return in.strict, false
+}
+
+func (in *Interpreter) execReturn(s *ast.ReturnStmt) (control, error) {
+	if len(in.frames) == 0 {
+		return ctlNone, errRuntime(s.Pos(), "return used outside function")
+	}
+	var v string
+	if s.Value != nil {
+		var err error
+		if v, err = in.eval(s.Value); err != nil {
+			return ctlNone, err
+		}
+	}
+	in.frames[len(in.frames)-1].ret = v
+	return ctlReturn, nil
+}
+
+func (in *Interpreter) execFor(s *ast.ForStmt) (control, error) {
+	start, err := in.evalInt(s.Start)
+	if err != nil {
+		return ctlNone, err
+	}
+	end, err := in.evalInt(s.End)
+	if err != nil {
+		return ctlNone, err
+	}
+	i := start
+	for {
+		in.vars[s.Var] = itoa(int(i))
+		if i > end {
+			return ctlNone, nil
+		}
+		ctl, err := in.execBlock(s.Body)
+		if err != nil {
+			return ctlNone, err
+		}
+		switch ctl {
+		case ctlBreak:
+			return ctlNone, nil
+		case ctlReturn:
+			return ctl, nil
+		}
+		i++
+	}
+}
+
+// execForEach runs the body once per list element, or once per map key. The
+// length is read when the loop starts; each element as the loop reaches it.
+func (in *Interpreter) execForEach(s *ast.ForEachStmt) (control, error) {
+	base, err := in.refName(s.Iterable)
+	if err != nil {
+		return ctlNone, err
+	}
+	list := base
+	if s.Key != "" {
+		list = keysName(base)
+	}
+	n, _ := cmdexe.ParseInt(in.vars[list+"_len"])
+	for i := 0; i < int(n); i++ {
+		elem := in.vars[elemName(list, i)]
+		if s.Key != "" {
+			in.vars[s.Key] = elem
+			elem = in.vars[base+"_"+elem]
+		}
+		in.vars[s.Var] = elem
+		ctl, err := in.execBlock(s.Body)
+		if err != nil {
+			return ctlNone, err
+		}
+		switch ctl {
+		case ctlBreak:
+			return ctlNone, nil
+		case ctlReturn:
+			return ctl, nil
+		}
+	}
+	return ctlNone, nil
+}
+
+func (in *Interpreter) execWhile(s *ast.WhileStmt) (control, error) {
+	for {
+		ok, err := in.truthy(s.Cond)
+		if err != nil {
+			return ctlNone, err
+		}
+		if !ok {
+			return ctlNone, nil
+		}
+		ctl, err := in.execBlock(s.Body)
+		if err != nil {
+			return ctlNone, err
+		}
+		switch ctl {
+		case ctlBreak:
+			return ctlNone, nil
+		case ctlReturn:
+			return ctl, nil
+		}
+	}
+}
+
+// environ returns the host environment overlaid with Fin variables, which is
+// what a child process launched from the generated batch would inherit.
+func (in *Interpreter) environ() []string {
+	env := os.Environ()
+	names := make([]string, 0, len(in.vars))
+	for k := range in.vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		env = append(env, k+"="+in.vars[k])
+	}
+	return env
+}
+
+// args returns the positional arguments visible at the current call depth:
+// the script arguments at top level, the call arguments inside a function.
+func (in *Interpreter) args() []string {
+	if n := len(in.frames); n > 0 {
+		return in.frames[n-1].args
+	}
+	return in.opts.Args
+}
+
+// shifted returns how many of the positional arguments visible at the
+// current call depth have been shifted out of %1..%9: at top level, those
+// the params block consumed. As with shift in cmd.exe, %* keeps them.
+func (in *Interpreter) shifted() int {
+	if len(in.frames) > 0 {
+		return 0
+	}
+	return in.shift
+}
+
+func hostExec(command string, env []string, stdout, stderr io.Writer) (int, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return ee.ExitCode(), nil
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
+func hostExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func returnVar(fn string) string { return "fn_" + fn + "_ret" }
+
+func elemName(list string, i int) string { return list + "_" + itoa(i) }
+
+func keysName(m string) string { return "__fin_keys_" + m }
@@ -0,0 +1,757 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+func TestInterp_Examples(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(projectRoot(t), "examples", "*.fin"))
+	if err != nil {
+		t.Fatalf("glob examples: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no examples found")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			prog, err := module.Load(file)
+			if err != nil {
+				t.Fatalf("load errors: %v", err)
+			}
+			_, out, err := runProgram(prog, Options{})
+			if err != nil {
+				t.Fatalf("run error: %v", err)
+			}
+			want := expectedOutput(string(src))
+			if out != want {
+				t.Fatalf("output mismatch\nwant:\n%s\nhave:\n%s", want, out)
+			}
+		})
+	}
+}
+
+func TestInterp_FunctionScopeAndReturn(t *testing.T) {
+	src := "set x 1\n" +
+		"fn bump n\n" +
+		"    set local 5\n" +
+		"    x = $x + $n\n" +
+		"    return $x\n" +
+		"    echo \"unreachable\"\n" +
+		"end\n" +
+		"echo \"$x $(bump 41)\"\n"
+	in, out, err := runInterp(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "1 42\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if v, ok := in.vars["local"]; ok {
+		t.Fatalf("local survived the call: %q", v)
+	}
+}
+
+func TestInterp_Recursion(t *testing.T) {
+	src := "set acc 0\n" +
+		"fn down n\n" +
+		"    if $n <= 0\n" +
+		"        return\n" +
+		"    end\n" +
+		"    echo $n\n" +
+		"    down $n - 1\n" +
+		"end\n" +
+		"down 3\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "3\n2\n1\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_CallExpr(t *testing.T) {
+	src := "fn fib n\n" +
+		"    if $n < 2\n" +
+		"        return $n\n" +
+		"    end\n" +
+		"    return (fib $n - 1) + (fib $n - 2)\n" +
+		"end\n" +
+		"fn twice s\n" +
+		"    echo \"twice $s\"\n" +
+		"    return \"$s$s\"\n" +
+		"end\n" +
+		"set x (fib 10)\n" +
+		"echo \"fib: $x, $(twice (twice \\\"ab\\\")) $$(fib 1)\"\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "twice ab\ntwice abab\nfib: 55, abababab $(fib 1)\n"
+	if out != want {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_BreakContinue(t *testing.T) {
+	src := "for i in 1..10\n" +
+		"    if $i == 2\n" +
+		"        continue\n" +
+		"    end\n" +
+		"    if $i == 4\n" +
+		"        break\n" +
+		"    end\n" +
+		"    echo $i\n" +
+		"end\n" +
+		"set n 0\n" +
+		"while true\n" +
+		"    n = $n + 1\n" +
+		"    if $n > 2\n" +
+		"        break\n" +
+		"    end\n" +
+		"end\n" +
+		"echo \"n=$n\"\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "1\n3\nn=3\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_ListsAndMaps(t *testing.T) {
+	src := "set xs [10, 20, 30]\n" +
+		"set i 2\n" +
+		"set third $xs[$i]\n" +
+		"set m {name: \"Ann\", age: 7}\n" +
+		"echo \"$xs[0] $xs[i] $third $xs_len $m.name $m.age $$5\"\n"
+	in, out, err := runInterp(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "10 30 30 3 Ann 7 $5\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	vars := in.Vars()
+	for name, want := range map[string]string{"xs_0": "10", "xs_2": "30", "xs_len": "3", "m_name": "Ann", "m_age": "7"} {
+		if vars[name] != want {
+			t.Fatalf("%s = %q, want %q", name, vars[name], want)
+		}
+	}
+}
+
+func TestInterp_MapKeyNamedLikeKeyList(t *testing.T) {
+	src := "set m {keys_len: 5, a: 1}\n" +
+		"for k, v in $m\n" +
+		"    echo \"$k=$v $m.keys_len\"\n" +
+		"end\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "keys_len=5 5\na=1 5\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_Conditions(t *testing.T) {
+	src := "set a 3\n" +
+		"set s \"abc\"\n" +
+		"if $a > 1 && $a < 5\n" +
+		"    echo \"and\"\n" +
+		"end\n" +
+		"if $a > 5 || !false\n" +
+		"    echo \"or\"\n" +
+		"end\n" +
+		"if $s == \"abc\"\n" +
+		"    echo \"str\"\n" +
+		"end\n" +
+		"if 010 >= 8 && 010 <= 8\n" +
+		"    echo \"octal\"\n" +
+		"end\n" +
+		"if 007 != 7\n" +
+		"    echo \"text\"\n" +
+		"end\n" +
+		"if exists \"here.txt\"\n" +
+		"    echo \"exists\"\n" +
+		"end\n"
+	exists := func(path string) bool { return path == "here.txt" }
+	out, err := runSource(t, src, Options{Exists: exists})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "and\nor\nstr\noctal\ntext\nexists\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_ElseIfAndMatch(t *testing.T) {
+	src := "fn check n\n" +
+		"    echo \"check $n\"\n" +
+		"    return $n > 1\n" +
+		"end\n" +
+		"if (check 1)\n" +
+		"    echo \"first\"\n" +
+		"else if (check 2)\n" +
+		"    echo \"second\"\n" +
+		"else if (check 3)\n" +
+		"    echo \"third\"\n" +
+		"end\n" +
+		"set v 8\n" +
+		"match $v\n" +
+		"    case \"x\"\n" +
+		"        echo \"x\"\n" +
+		"    case 8\n" +
+		"        echo \"eight\"\n" +
+		"    else\n" +
+		"        echo \"other\"\n" +
+		"end\n" +
+		"match \"007\"\n" +
+		"    case 7\n" +
+		"        echo \"number\"\n" +
+		"    case \"007\"\n" +
+		"        echo \"text\"\n" +
+		"end\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "check 1\ncheck 2\nsecond\neight\ntext\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_Arithmetic(t *testing.T) {
+	src := "set a 7\n" +
+		"set b $a / 2\n" +
+		"set c -$a + 2 ** 3\n" +
+		"set d 2147483647 + 1\n" +
+		"echo \"$b $c $d\"\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "3 1 -2147483648\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestInterp_DivisionByZero(t *testing.T) {
+	_, err := runSource(t, "set a 0\nset b 1 / $a\n", Options{})
+	var re *RuntimeError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected RuntimeError, got %v", err)
+	}
+	if re.Pos().Line != 2 {
+		t.Fatalf("expected error on line 2, got %d", re.Pos().Line)
+	}
+}
+
+func TestInterp_Pow(t *testing.T) {
+	src := "set z 0\n" +
+		"set n (-1)\n" +
+		"echo \"$(pow_all 2 10) $(pow_all (-2) 31) $(pow_all $z $z) $(pow_all 2 (-1)) $(pow_all $n (-3))\"\n" +
+		"set big 3 ** 21\n" +
+		"fn pow_all a b\n" +
+		"    return $a ** $b\n" +
+		"end\n"
+	out, err := runSource(t, src, Options{})
+	if out != "1024 -2147483648 1 0 -1\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	var re *RuntimeError
+	if !errors.As(err, &re) || re.Error() != "runtime error at 4:11: integer overflow: 3 ** 21 does not fit in 32 bits" {
+		t.Fatalf("expected an overflow error, got %v", err)
+	}
+}
+
+func TestInterp_BitwiseAndCompound(t *testing.T) {
+	src := "set a 13\n" +
+		"set b 6\n" +
+		"set r [$a % $b, $a & $b, $a | $b, $a ^ $b, $a << 2, -$a >> 1, 1 << 33, 1 | 2 ^ 7 & 12]\n" +
+		"echo \"$r[0] $r[1] $r[2] $r[3] $r[4] $r[5] $r[6] $r[7]\"\n" +
+		"set x 10\n" +
+		"x += 5\n" +
+		"x -= 2 * 3\n" +
+		"x *= $a - 11\n" +
+		"x /= 4\n" +
+		"x++\n" +
+		"x++\n" +
+		"x--\n" +
+		"echo $x\n" +
+		"x %= 0\n"
+	out, err := runSource(t, src, Options{})
+	if out != "1 4 15 11 52 -7 2 7\n5\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	var re *RuntimeError
+	if !errors.As(err, &re) || re.Error() != "runtime error at 14:3: division by zero" {
+		t.Fatalf("expected a division by zero error, got %v", err)
+	}
+}
+
+func TestInterp_Decimals(t *testing.T) {
+	src := "set price 19.99\n" +
+		"set r [$price * 3, 10 / 3.0, -2 / 3.0, 0.125 + 0, -0.125 + 0, 007.5 - 8, 1.5 * -2.25, -$price]\n" +
+		"echo \"$r[0] $r[1] $r[2] $r[3] $r[4] $r[5] $r[6] $r[7]\"\n" +
+		"set c [1.5 == 1.50, 0.1 + 0.2 < 0.3, $price >= 19.99, \"2.50\" != 2.5]\n" +
+		"echo \"$c[0] $c[1] $c[2] $c[3]\"\n" +
+		"set z 0.0\n" +
+		"echo 1.0 / $z\n"
+	out, err := runSource(t, src, Options{})
+	if out != "59.97 3.33 -0.67 0.13 -0.13 -0.50 -3.38 -19.99\ntrue false true false\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	var re *RuntimeError
+	if !errors.As(err, &re) || re.Error() != "runtime error at 7:10: division by zero" {
+		t.Fatalf("expected a division by zero error, got %v", err)
+	}
+
+	out, err = runSource(t, "echo 2 / 3.0\necho 12.34567 * 1\n", Options{Precision: 4})
+	if err != nil || out != "0.6667\n12.3457\n" {
+		t.Fatalf("unexpected output %q, error %v", out, err)
+	}
+
+	src = "set price 19.99\n" +
+		"set x -3.14159\n" +
+		"set r [$price * 0.075, 0.125 + 0.125, -0.125 - 0.125, 0.005 * 100, 0.994 * -0.5, 1.004 - 0.005]\n" +
+		"echo \"$x $r[0] $r[1] $r[2] $r[3] $r[4] $r[5]\"\n"
+	out, err = runSource(t, src, Options{})
+	if err != nil || out != "-3.14159 1.50 0.25 -0.25 0.50 -0.50 1.00\n" {
+		t.Fatalf("unexpected output %q, error %v", out, err)
+	}
+	out, err = runSource(t, "echo 21474836.47 + 0.01\n", Options{})
+	if err != nil || out != "-21474836.48\n" {
+		t.Fatalf("unexpected output %q, error %v", out, err)
+	}
+}
+
+func TestInterp_RunUsesExecAndArgs(t *testing.T) {
+	var got []string
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		got = append(got, command)
+		for _, kv := range env {
+			if kv == "greeting=hi" {
+				got = append(got, "env ok")
+			}
+		}
+		return 0, nil
+	}
+	src := "set greeting \"hi\"\n" +
+		"run \"tool %1 $greeting %greeting% 100%%\"\n" +
+		"fn f a\n" +
+		"    run \"inner %1\"\n" +
+		"end\n" +
+		"f \"arg\"\n"
+	if _, err := runSource(t, src, Options{Exec: exec, Args: []string{"first"}}); err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := []string{"tool first hi hi 100%", "env ok", "inner arg", "env ok"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("exec calls = %q, want %q", got, want)
+	}
+}
+
+func TestInterp_RunArgsStatusAndCapture(t *testing.T) {
+	var got []string
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		got = append(got, command)
+		if strings.HasPrefix(command, "git rev-parse") {
+			io.WriteString(stdout, "\r\nabc123\r\nextra\r\n")
+			return 0, nil
+		}
+		if command == "silent" {
+			return 0, nil
+		}
+		if command == "broken" {
+			io.WriteString(stdout, "oops\n")
+			return 2, nil
+		}
+		return 3, nil
+	}
+	src := "set who \"a b\"\n" +
+		"run \"greet\" $who \"x y\" \"\" \"say \\\"hi\\\"\" 5\n" +
+		"echo \"status $status\"\n" +
+		"set head (run \"git rev-parse\" \"HEAD\")\n" +
+		"echo \"$head $status\"\n" +
+		"set quiet (run \"silent\")\n" +
+		"echo \"[$quiet] $status\"\n" +
+		"set bad (run \"broken\")\n" +
+		"echo \"[$bad] $status\"\n"
+	out, err := runSource(t, src, Options{Exec: exec})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	if out != "status 3\nabc123 0\n[] 0\n[oops] 2\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	want := []string{`greet "a b" "x y" "" "say \"hi\"" 5`, "git rev-parse HEAD", "silent", "broken"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("exec calls = %q, want %q", got, want)
+	}
+}
+
+func TestInterp_Strict(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		if strings.HasPrefix(command, "fail") {
+			return 4, nil
+		}
+		return 0, nil
+	}
+	src := "strict\n" +
+		"fn stop\n" +
+		"    run \"fail\" \"in stop\"\n" +
+		"    echo \"not reached\"\n" +
+		"    return 1\n" +
+		"end\n" +
+		"unchecked\n" +
+		"    run \"fail\"\n" +
+		"    echo \"status $status\"\n" +
+		"    stop\n" +
+		"end\n" +
+		"echo \"still running\"\n" +
+		"run \"ok\"\n" +
+		"run \"fail\" \"twice\"\n" +
+		"echo \"not reached\"\n"
+	out, err := runSource(t, src, Options{Exec: exec})
+	if out != "status 4\nstill running\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	var failed *CommandFailedError
+	if !errors.As(err, &failed) {
+		t.Fatalf("expected CommandFailedError, got %v", err)
+	}
+	if failed.Command != "fail twice" || failed.Code != 4 || failed.P.Line != 14 {
+		t.Fatalf("unexpected failure %+v", failed)
+	}
+}
+
+func TestInterp_StrictOption(t *testing.T) {
+	exec := func(string, []string, io.Writer, io.Writer) (int, error) { return 2, nil }
+	if _, err := runSource(t, "run \"fail\"\n", Options{Exec: exec}); err != nil {
+		t.Fatalf("unexpected error without strict: %v", err)
+	}
+	_, err := runSource(t, "run \"fail\"\n", Options{Exec: exec, Strict: true})
+	var failed *CommandFailedError
+	if !errors.As(err, &failed) || failed.Code != 2 {
+		t.Fatalf("expected CommandFailedError with code 2, got %v", err)
+	}
+}
+
+func TestInterp_MaxDepth(t *testing.T) {
+	src := "fn loop\n" +
+		"    loop\n" +
+		"end\n" +
+		"loop\n"
+	_, err := runSource(t, src, Options{MaxDepth: 10})
+	if err == nil || !strings.Contains(err.Error(), "maximum call depth") {
+		t.Fatalf("expected call depth error, got %v", err)
+	}
+}
+
+func TestInterp_TopLevelReturn(t *testing.T) {
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.ReturnStmt{P: ast.Pos{Line: 1, Column: 1}},
+	}}
+	err := New(Options{Stdout: io.Discard}).Run(prog)
+	if err == nil {
+		t.Fatalf("expected error for top-level return")
+	}
+}
+
+func TestInterp_Params(t *testing.T) {
+	src := "params\n" +
+		"    param target\n" +
+		"    param env default \"dev\"\n" +
+		"    flag verbose\n" +
+		"end\n" +
+		"run \"tool $target $env $verbose %1\"\n"
+	var got string
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		got = command
+		return 0, nil
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--target", "x"}, "tool x dev false "},
+		{[]string{"/TARGET:y", "--Verbose", "/env:prod"}, "tool y prod true "},
+		{[]string{"/target:z", "/verbose"}, "tool z dev true "},
+	}
+	for _, tt := range tests {
+		got = ""
+		if _, err := runSource(t, src, Options{Exec: exec, Args: tt.args}); err != nil {
+			t.Fatalf("args %q: run error: %v", tt.args, err)
+		}
+		if got != tt.want {
+			t.Fatalf("args %q: ran %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	usage := "Options:\n" +
+		"  --target VALUE  required\n" +
+		"  --env VALUE     default: dev\n" +
+		"  --verbose\n" +
+		"  --help          show this help\n"
+	for _, args := range [][]string{{"--help"}, {"--target", "x", "/?"}} {
+		out, err := runSource(t, src, Options{Args: args})
+		if err != nil {
+			t.Fatalf("args %q: run error: %v", args, err)
+		}
+		if out != usage {
+			t.Fatalf("args %q: output %q, want %q", args, out, usage)
+		}
+	}
+
+	errs := []struct {
+		args []string
+		want string
+	}{
+		{nil, "missing required parameter --target"},
+		{[]string{"--target"}, "missing value for --target"},
+		{[]string{"--target", "x", "--force"}, "unknown argument --force"},
+	}
+	for _, tt := range errs {
+		var stderr bytes.Buffer
+		out, err := runSource(t, src, Options{Args: tt.args, Stderr: &stderr})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("args %q: expected %q error, got %v", tt.args, tt.want, err)
+		}
+		if out != "" || stderr.String() != usage {
+			t.Fatalf("args %q: stdout %q, stderr %q", tt.args, out, stderr.String())
+		}
+	}
+}
+
+func runSource(t *testing.T, src string, opts Options) (string, error) {
+	t.Helper()
+	_, out, err := runInterp(t, src, opts)
+	return out, err
+}
+
+func runInterp(t *testing.T, src string, opts Options) (*Interpreter, string, error) {
+	t.Helper()
+	l := lexer.New(src)
+	p := parser.New(parser.CollectTokens(l))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if err := sema.New().Analyze(prog); err != nil {
+		t.Fatalf("sema errors: %v", err)
+	}
+	return runProgram(prog, opts)
+}
+
+func runProgram(prog *ast.Program, opts Options) (*Interpreter, string, error) {
+	var out bytes.Buffer
+	opts.Stdout = &out
+	if opts.Exec == nil {
+		opts.Exec = func(string, []string, io.Writer, io.Writer) (int, error) { return 0, nil }
+	}
+	in := New(opts)
+	err := in.Run(prog)
+	return in, out.String(), err
+}
+
+// expectedOutput extracts the "# Expected output:" block from an example header.
+func expectedOutput(src string) string {
+	var b strings.Builder
+	in := false
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "# Expected output:") {
+			in = true
+			continue
+		}
+		if !in {
+			continue
+		}
+		if !strings.HasPrefix(line, "#   ") {
+			break
+		}
+		b.WriteString(strings.TrimPrefix(line, "#   "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func projectRoot(t *testing.T) string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatalf("cannot determine caller")
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+func TestInterp_Try(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		if strings.HasPrefix(command, "fail") {
+			return 3, nil
+		}
+		return 0, nil
+	}
+	src := "fn probe\n" +
+		"    try\n" +
+		"        run \"fail\" \"a & b\"\n" +
+		"        echo \"not reached\"\n" +
+		"    catch err\n" +
+		"        echo \"caught $err.code $err.command\"\n" +
+		"        return \"from catch\"\n" +
+		"    finally\n" +
+		"        echo \"cleanup\"\n" +
+		"    end\n" +
+		"    return \"not reached\"\n" +
+		"end\n" +
+		"echo (probe)\n" +
+		"for i in 1..3\n" +
+		"    try\n" +
+		"        if $i == 2\n" +
+		"            break\n" +
+		"        end\n" +
+		"        run \"fail\"\n" +
+		"        echo \"ignored\"\n" +
+		"    finally\n" +
+		"        echo \"finally $i\"\n" +
+		"    end\n" +
+		"end\n" +
+		"try\n" +
+		"    try\n" +
+		"        run \"fail inner\"\n" +
+		"    finally\n" +
+		"        echo \"inner finally\"\n" +
+		"    end\n" +
+		"    echo \"not reached\"\n" +
+		"catch e\n" +
+		"    echo \"outer caught $e.command\"\n" +
+		"end\n"
+	out, err := runSource(t, src, Options{Exec: exec})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "caught 3 fail \"a & b\"\ncleanup\nfrom catch\n" +
+		"ignored\nfinally 1\nfinally 2\n" +
+		"inner finally\nouter caught fail inner\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
+
+func TestInterp_TryStrict(t *testing.T) {
+	exec := func(command string, env []string, stdout, stderr io.Writer) (int, error) {
+		if strings.HasPrefix(command, "fail") {
+			return 4, nil
+		}
+		return 0, nil
+	}
+	src := "strict\n" +
+		"fn deploy\n" +
+		"    run \"fail\"\n" +
+		"end\n" +
+		"try\n" +
+		"    deploy\n" +
+		"catch err\n" +
+		"    echo \"caught $err.code $err.command\"\n" +
+		"end\n" +
+		"try\n" +
+		"    run \"fail\"\n" +
+		"finally\n" +
+		"    echo \"cleanup\"\n" +
+		"end\n" +
+		"echo \"not reached\"\n"
+	out, err := runSource(t, src, Options{Exec: exec})
+	if out != "caught 4 deploy\ncleanup\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	var failed *CommandFailedError
+	if !errors.As(err, &failed) || failed.Code != 4 || failed.P.Line != 11 {
+		t.Fatalf("expected failure on line 11, got %v", err)
+	}
+}
+
+func TestInterp_Builtins(t *testing.T) {
+	src := "set s \"  Hello, World  \"\n" +
+		"set t (trim $s)\n" +
+		"echo \"[$t] $(len $t)\"\n" +
+		"echo (upper $t)\n" +
+		"echo (lower $t)\n" +
+		"echo (substr $t 7 5)\n" +
+		"echo (substr $t (-5) 3)\n" +
+		"echo (substr $t 0 (-7))\n" +
+		"echo (substr $t 20 2)\n" +
+		"echo (replace $t \"l\" \"L\")\n" +
+		"echo (replace $t \"\" \"x\")\n" +
+		"set e \"\"\n" +
+		"echo (starts_with $t \"Hello\")\n" +
+		"echo (ends_with $t \"world\")\n" +
+		"echo (contains $t $e)\n" +
+		"set parts (split $t \", \")\n" +
+		"set sep \"+\"\n" +
+		"echo \"$parts_len $(join $parts $sep)\"\n" +
+		"set chars (split \"abc\" $e)\n" +
+		"for c in chars\n" +
+		"    echo $c\n" +
+		"end\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "[Hello, World] 12\nHELLO, WORLD\nhello, world\nWorld\nWor\nHello\n\n" +
+		"HeLLo, WorLd\nHello, World\ntrue\nfalse\ntrue\n2 Hello+World\na\nb\nc\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
+
+func TestInterp_ListBuiltins(t *testing.T) {
+	src := "set xs [3, 10, 1]\n" +
+		"push $xs \"a b\"\n" +
+		"set last (pop $xs)\n" +
+		"insert $xs 0 \"first\"\n" +
+		"insert $xs 99 \"end\"\n" +
+		"set gone (remove_at $xs 1)\n" +
+		"remove_at $xs 100\n" +
+		"set sep \",\"\n" +
+		"echo \"[$last] [$gone] $xs_len $(join $xs $sep)\"\n" +
+		"echo (index_of $xs \"end\")\n" +
+		"echo (contains $xs \"10\")\n" +
+		"echo (contains $xs \"1\")\n" +
+		"reverse $xs\n" +
+		"echo (join $xs \",\")\n" +
+		"set mixed [10, 9, \"b\", 100, \"a b\", -5, \"010\"]\n" +
+		"sort $mixed\n" +
+		"echo (join $mixed \" \")\n" +
+		"set mid (slice $mixed 1 (-1))\n" +
+		"echo (join $mid \" \")\n" +
+		"mid = (slice $mid (-2) 10)\n" +
+		"echo \"$mid_len $(join $mid $sep) [$mid[2]]\"\n"
+	out, err := runSource(t, src, Options{})
+	if err != nil {
+		t.Fatalf("run error: %v", err)
+	}
+	want := "[a b] [3] 4 first,10,1,end\n3\ntrue\ntrue\nend,1,10,first\n" +
+		"010 -5 9 10 100 a b b\n-5 9 10 100 a b\n2 100,a b []\n"
+	if out != want {
+		t.Fatalf("output mismatch\nwant %q\ngot  %q", want, out)
+	}
+}
@@ -0,0 +1,95 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// execParams sets the parameters of a params block from the script
+// arguments, reading them the way the generated parser does: `--name VALUE`
+// or `/name:VALUE` for a param and `--name` or `/name` for a flag, with
+// names compared without regard to case. Reading stops at the first empty
+// argument. --help and /? print the usage and end the script; an unknown
+// argument, a param without its value and a required param left empty
+// print the usage on stderr and fail.
+func (in *Interpreter) execParams(s *ast.ParamsStmt) (control, error) {
+	for _, p := range s.Params {
+		v := ""
+		switch {
+		case p.Flag:
+			v = "false"
+		case p.Default != nil:
+			var err error
+			if v, err = in.eval(p.Default); err != nil {
+				return ctlNone, err
+			}
+		}
+		in.vars[p.Name] = v
+	}
+	args := in.opts.Args
+	i := 0
+	for ; i < len(args) && args[i] != ""; i++ {
+		arg := args[i]
+		if strings.EqualFold(arg, "--help") || arg == "/?" {
+			writeUsage(in.opts.Stdout, s)
+			return ctlExit, nil
+		}
+		p, value, ok := matchParam(s.Params, arg)
+		switch {
+		case !ok:
+			return ctlNone, in.paramsFailed(s, "unknown argument %s", arg)
+		case p.Flag:
+			value = "true"
+		case strings.HasPrefix(arg, "--"):
+			if i+1 >= len(args) || args[i+1] == "" {
+				return ctlNone, in.paramsFailed(s, "missing value for --%s", p.Name)
+			}
+			i++
+			value = args[i]
+		}
+		in.vars[p.Name] = value
+	}
+	in.shift = i
+	for _, p := range s.Params {
+		if !p.Flag && p.Default == nil && in.vars[p.Name] == "" {
+			return ctlNone, in.paramsFailed(s, "missing required parameter --%s", p.Name)
+		}
+	}
+	return ctlNone, nil
+}
+
+// matchParam finds the parameter arg sets. For `/name:VALUE` it also returns
+// the value.
+func matchParam(params []ast.Param, arg string) (ast.Param, string, bool) {
+	for _, p := range params {
+		if strings.EqualFold(arg, "--"+p.Name) {
+			return p, "", true
+		}
+		if p.Flag {
+			if strings.EqualFold(arg, "/"+p.Name) {
+				return p, "", true
+			}
+			continue
+		}
+		if prefix := "/" + p.Name + ":"; len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+			return p, arg[len(prefix):], true
+		}
+	}
+	return ast.Param{}, "", false
+}
+
+// paramsFailed prints the usage of s on stderr and returns the error that
+// ends the script.
+func (in *Interpreter) paramsFailed(s *ast.ParamsStmt, format string, args ...any) error {
+	writeUsage(in.opts.Stderr, s)
+	return errRuntime(s.P, format, args...)
+}
+
+func writeUsage(w io.Writer, s *ast.ParamsStmt) {
+	for _, line := range s.Usage() {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"unicode"
 
 	"github.com/vishnunath-suresh/fin-project/internal/token"
@@ -39,8 +40,7 @@ func (l *Lexer) NextToken() token.Token {
 		return token.New(token.NEWLINE, "\n", startLine, startCol)
 
 	case ch == '#':
-		l.skipComment()
-		return l.NextToken()
+		return token.New(token.COMMENT, l.readComment(), startLine, startCol)
 
 	case isLetter(ch):
 		literal := l.readIdentifier()
@@ -104,29 +104,69 @@ func (l *Lexer) NextToken() token.Token {
 		if !isLetter(l.peek()) {
 			return token.New(token.ILLEGAL, string(ch), startLine, startCol)
 		}
-		ident := l.readIdentifier()
-		return token.New(token.IDENT, ident, startLine, startCol)
+		tok := token.New(token.IDENT, l.readIdentifier(), startLine, startCol)
+		tok.Var = true
+		return tok
 	case ch == '+':
+		switch l.peekNext() {
+		case '+':
+			l.next()
+			l.next()
+			return token.New(token.INC, "++", startLine, startCol)
+		case '=':
+			l.next()
+			l.next()
+			return token.New(token.PLUSEQ, "+=", startLine, startCol)
+		}
 		l.next()
 		return token.New(token.PLUS, "+", startLine, startCol)
 
 	case ch == '-':
+		switch l.peekNext() {
+		case '-':
+			l.next()
+			l.next()
+			return token.New(token.DEC, "--", startLine, startCol)
+		case '=':
+			l.next()
+			l.next()
+			return token.New(token.MINUSEQ, "-=", startLine, startCol)
+		}
 		l.next()
 		return token.New(token.MINUS, "-", startLine, startCol)
 
 	case ch == '*':
-		if l.peekNext() == '*' {
+		switch l.peekNext() {
+		case '*':
 			l.next()
 			l.next()
 			return token.New(token.POW, "**", startLine, startCol)
+		case '=':
+			l.next()
+			l.next()
+			return token.New(token.STAREQ, "*=", startLine, startCol)
 		}
 		l.next()
 		return token.New(token.STAR, "*", startLine, startCol)
 
 	case ch == '/':
+		if l.peekNext() == '=' {
+			l.next()
+			l.next()
+			return token.New(token.SLASHEQ, "/=", startLine, startCol)
+		}
 		l.next()
 		return token.New(token.SLASH, "/", startLine, startCol)
 
+	case ch == '%':
+		if l.peekNext() == '=' {
+			l.next()
+			l.next()
+			return token.New(token.PERCENTEQ, "%=", startLine, startCol)
+		}
+		l.next()
+		return token.New(token.PERCENT, "%", startLine, startCol)
+
 	case ch == '!':
 		if l.peekNext() == '=' {
 			l.next()
@@ -146,19 +186,29 @@ func (l *Lexer) NextToken() token.Token {
 		return token.New(token.ASSIGN, "=", startLine, startCol)
 
 	case ch == '<':
-		if l.peekNext() == '=' {
+		switch l.peekNext() {
+		case '=':
 			l.next()
 			l.next()
 			return token.New(token.LTE, "<=", startLine, startCol)
+		case '<':
+			l.next()
+			l.next()
+			return token.New(token.SHL, "<<", startLine, startCol)
 		}
 		l.next()
 		return token.New(token.LT, "<", startLine, startCol)
 
 	case ch == '>':
-		if l.peekNext() == '=' {
+		switch l.peekNext() {
+		case '=':
 			l.next()
 			l.next()
 			return token.New(token.GTE, ">=", startLine, startCol)
+		case '>':
+			l.next()
+			l.next()
+			return token.New(token.SHR, ">>", startLine, startCol)
 		}
 		l.next()
 		return token.New(token.GT, ">", startLine, startCol)
@@ -170,7 +220,7 @@ func (l *Lexer) NextToken() token.Token {
 			return token.New(token.AND, "&&", startLine, startCol)
 		}
 		l.next()
-		return token.New(token.ILLEGAL, "&", startLine, startCol)
+		return token.New(token.AMP, "&", startLine, startCol)
 
 	case ch == '|':
 		if l.peekNext() == '|' {
@@ -179,7 +229,11 @@ func (l *Lexer) NextToken() token.Token {
 			return token.New(token.OR, "||", startLine, startCol)
 		}
 		l.next()
-		return token.New(token.ILLEGAL, "|", startLine, startCol)
+		return token.New(token.PIPE, "|", startLine, startCol)
+
+	case ch == '^':
+		l.next()
+		return token.New(token.CARET, "^", startLine, startCol)
 
 	default:
 		l.next()
@@ -226,14 +280,18 @@ func (l *Lexer) skipWhitespaceExceptNewline() {
 	}
 }
 
-func (l *Lexer) skipComment() {
+// readComment consumes a `#` comment up to the end of the line and returns
+// it, including the '#' but without trailing whitespace.
+func (l *Lexer) readComment() string {
+	start := l.pos
 	for {
 		ch := l.peek()
 		if ch == '\n' || ch == 0 {
-			return
+			break
 		}
 		l.next()
 	}
+	return strings.TrimRight(string(l.input[start:l.pos]), " \t\r")
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -244,11 +302,19 @@ func (l *Lexer) readIdentifier() string {
 	return string(l.input[start:l.pos])
 }
 
+// readNumber reads an integer or a decimal such as 3.14. A dot is only part
+// of the number when a digit follows it, so 1..3 is still a range.
 func (l *Lexer) readNumber() string {
 	start := l.pos
 	for isDigit(l.peek()) {
 		l.next()
 	}
+	if l.peek() == '.' && isDigit(l.peekNext()) {
+		l.next()
+		for isDigit(l.peek()) {
+			l.next()
+		}
+	}
 	return string(l.input[start:l.pos])
 }
 
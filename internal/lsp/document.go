@@ -0,0 +1,402 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/diag"
+	"github.com/vishnunath-suresh/fin-project/internal/generator"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/module"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+	"github.com/vishnunath-suresh/fin-project/internal/token"
+)
+
+// document is an open file and what the server derives from it. It is
+// rebuilt from scratch on every change.
+type document struct {
+	uri     string
+	version int
+	text    string
+	lines   []string
+	tokens  []token.Token
+	index   map[ast.Pos]int // token index by position
+	prog    *ast.Program
+	parsed  bool // no syntax errors
+	res     sema.AnalysisResult
+	fns     map[ast.Pos]*ast.FnDecl // by definition position
+	diags   []diag.Diagnostic
+	printer *diag.Printer
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{
+		uri:     uri,
+		version: version,
+		text:    text,
+		index:   make(map[ast.Pos]int),
+		fns:     make(map[ast.Pos]*ast.FnDecl),
+		printer: diag.NewPrinter(uri, text, false),
+	}
+	for _, line := range strings.Split(text, "\n") {
+		d.lines = append(d.lines, strings.TrimSuffix(line, "\r"))
+	}
+	d.tokens = parser.CollectTokens(lexer.New(text))
+	for i, tok := range d.tokens {
+		d.index[tokenPos(tok)] = i
+	}
+
+	p := parser.New(d.tokens)
+	d.prog = p.ParseProgram()
+	d.parsed = len(p.Errors()) == 0
+	// Analyze even a partial program so navigation keeps working while the
+	// user types, but only report its errors once the syntax is valid.
+	d.res = sema.AnalyzeDefinitions(d.prog)
+	for _, stmt := range d.prog.Statements {
+		if fn, ok := stmt.(*ast.FnDecl); ok {
+			d.fns[fn.P] = fn
+		}
+	}
+
+	var err error
+	switch {
+	case !d.parsed:
+		err = errors.Join(p.Errors()...)
+	case imports(d.prog):
+		err = d.load()
+	case len(d.res.Errors) > 0:
+		err = errors.Join(d.res.Errors...)
+	default:
+		_, err = generator.NewBatchGenerator().Generate(d.prog)
+	}
+	d.diags = diag.FromError(err)
+	return d
+}
+
+// imports reports whether prog imports other files.
+func imports(prog *ast.Program) bool {
+	for _, stmt := range prog.Statements {
+		if _, ok := stmt.(*ast.ImportStmt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// load checks a document that imports other files by loading it, as it is
+// in the editor, together with the files it imports from disk. Imports are
+// resolved against the document's path, so only file URIs can have them.
+func (d *document) load() error {
+	u, err := url.Parse(d.uri)
+	if err != nil || u.Scheme != "file" {
+		return errors.New("imports can only be resolved in files on disk")
+	}
+	path := u.Path
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:] // file:///C:/dir on Windows
+	}
+	path = filepath.FromSlash(path)
+	loader := &module.Loader{ReadFile: func(name string) ([]byte, error) {
+		if name == path {
+			return []byte(d.text), nil
+		}
+		return os.ReadFile(name)
+	}}
+	prog, err := loader.Load(path)
+	if err != nil {
+		return err
+	}
+	_, err = generator.NewBatchGenerator().Generate(prog)
+	return err
+}
+
+func tokenPos(tok token.Token) ast.Pos {
+	return ast.Pos{Line: tok.Line, Column: tok.Column}
+}
+
+// position converts a Fin position (1-based, in runes) to an LSP position
+// (0-based, in UTF-16 code units).
+func (d *document) position(p ast.Pos) Position {
+	if p.Line < 1 {
+		return Position{}
+	}
+	if p.Line > len(d.lines) {
+		return Position{Line: p.Line - 1}
+	}
+	line := []rune(d.lines[p.Line-1])
+	col := min(max(p.Column-1, 0), len(line))
+	return Position{Line: p.Line - 1, Character: len(utf16.Encode(line[:col]))}
+}
+
+// pos converts an LSP position to a Fin position.
+func (d *document) pos(p Position) ast.Pos {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return ast.Pos{Line: p.Line + 1, Column: 1}
+	}
+	units, col := 0, 1
+	for _, r := range d.lines[p.Line] {
+		if units >= p.Character {
+			break
+		}
+		units += utf16.RuneLen(r)
+		col++
+	}
+	return ast.Pos{Line: p.Line + 1, Column: col}
+}
+
+// span converts a diagnostic label to an LSP range.
+func (d *document) span(l diag.Label) Range {
+	if l.P.Line < 1 {
+		return Range{}
+	}
+	return Range{Start: d.position(l.P), End: d.position(d.printer.End(l))}
+}
+
+// runeAt returns the rune at p, or 0 if there is none.
+func (d *document) runeAt(p ast.Pos) rune {
+	if p.Line < 1 || p.Line > len(d.lines) {
+		return 0
+	}
+	line := []rune(d.lines[p.Line-1])
+	if p.Column < 1 || p.Column > len(line) {
+		return 0
+	}
+	return line[p.Column-1]
+}
+
+// tokenRange returns the range tok covers in the source.
+func (d *document) tokenRange(tok token.Token) Range {
+	start := tokenPos(tok)
+	width := utf8.RuneCountInString(tok.Literal)
+	switch tok.Type {
+	case token.IDENT:
+		if d.runeAt(start) == '$' {
+			width++
+		}
+	case token.NEWLINE, token.EOF:
+		width = 0
+	}
+	return Range{Start: d.position(start), End: d.position(ast.Pos{Line: start.Line, Column: start.Column + width})}
+}
+
+// rangeAt returns the range of the token at p, or an empty range at p.
+func (d *document) rangeAt(p ast.Pos) Range {
+	if i, ok := d.index[p]; ok {
+		return d.tokenRange(d.tokens[i])
+	}
+	return Range{Start: d.position(p), End: d.position(p)}
+}
+
+// identAt returns the index of the identifier under the cursor, including a
+// cursor placed just after it.
+func (d *document) identAt(p Position) (int, bool) {
+	c := d.pos(p)
+	for i, tok := range d.tokens {
+		if tok.Type != token.IDENT || tok.Line != c.Line {
+			continue
+		}
+		r := d.tokenRange(tok)
+		if r.Start.Character <= p.Character && p.Character <= r.End.Character {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// nameKind classifies the identifier at token i.
+type nameKind int
+
+const (
+	nameVariable nameKind = iota
+	nameFunction
+)
+
+// resolve returns where the identifier at token i is defined and whether it
+// names a variable or a function. Declarations resolve to themselves.
+func (d *document) resolve(i int) (ast.Pos, nameKind, bool) {
+	tok := d.tokens[i]
+	if i > 0 {
+		switch prev := d.tokens[i-1]; prev.Type {
+		case token.FN:
+			return tokenPos(prev), nameFunction, true
+		case token.SET, token.FOR:
+			return tokenPos(prev), nameVariable, true
+		case token.COMMA:
+			// the value variable in `for key, value in $map`
+			if i > 2 && d.tokens[i-3].Type == token.FOR {
+				return tokenPos(d.tokens[i-3]), nameVariable, true
+			}
+		}
+	}
+	key, kind := tokenPos(tok), nameFunction
+	if d.runeAt(key) == '$' {
+		kind = nameVariable
+	} else if i+1 < len(d.tokens) && d.tokens[i+1].Type == token.ASSIGN {
+		key, kind = tokenPos(d.tokens[i+1]), nameVariable
+	}
+	def, ok := d.res.Refs[key]
+	return def, kind, ok
+}
+
+// describe returns hover text for the identifier at token i.
+func (d *document) describe(i int) (string, bool) {
+	def, kind, ok := d.resolve(i)
+	if !ok {
+		return "", false
+	}
+	name := d.tokens[i].Literal
+	if kind == nameFunction {
+		fn := d.fns[def]
+		arity, ok := d.res.Funcs.Lookup(name)
+		if fn == nil || !ok {
+			return "", false
+		}
+		sig := strings.TrimSpace("fn " + name + " " + strings.Join(fn.Params, " "))
+		return fmt.Sprintf("```fin\n%s\n```\nTakes %s. Defined on line %d.", sig, plural(arity, "argument"), def.Line), true
+	}
+	what := "Variable"
+	if j, ok := d.index[def]; ok {
+		switch d.tokens[j].Type {
+		case token.FOR:
+			what = "Loop variable"
+		case token.FN:
+			what = "Parameter of `" + d.fns[def].Name + "`"
+		}
+	}
+	return fmt.Sprintf("```fin\n$%s\n```\n%s defined on line %d.", name, what, def.Line), true
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// symbols returns the outline of the document: top-level variables and
+// functions, with each function's parameters and variables as children.
+func (d *document) symbols() []DocumentSymbol {
+	out := []DocumentSymbol{}
+	for _, stmt := range d.prog.Statements {
+		if fn, ok := stmt.(*ast.FnDecl); ok {
+			out = append(out, d.fnSymbol(fn))
+			continue
+		}
+		out = append(out, d.varSymbols([]ast.Statement{stmt})...)
+	}
+	return out
+}
+
+func (d *document) fnSymbol(fn *ast.FnDecl) DocumentSymbol {
+	i, ok := d.index[fn.P]
+	if !ok || i+1 >= len(d.tokens) {
+		r := d.rangeAt(fn.P)
+		return DocumentSymbol{Name: fn.Name, Kind: symbolFunction, Range: r, SelectionRange: r}
+	}
+	sym := DocumentSymbol{
+		Name:           fn.Name,
+		Detail:         strings.Join(fn.Params, " "),
+		Kind:           symbolFunction,
+		Range:          Range{Start: d.position(fn.P), End: d.tokenRange(d.tokens[d.blockEnd(i)]).End},
+		SelectionRange: d.tokenRange(d.tokens[i+1]),
+	}
+	for j := i + 2; j < len(d.tokens) && d.tokens[j].Type == token.IDENT; j++ {
+		r := d.tokenRange(d.tokens[j])
+		sym.Children = append(sym.Children, DocumentSymbol{Name: d.tokens[j].Literal, Kind: symbolVariable, Range: r, SelectionRange: r})
+	}
+	sym.Children = append(sym.Children, d.varSymbols(fn.Body)...)
+	return sym
+}
+
+// varSymbols returns a symbol for every set statement and script parameter
+// in stmts, including those in nested blocks.
+func (d *document) varSymbols(stmts []ast.Statement) []DocumentSymbol {
+	var out []DocumentSymbol
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.SetStmt:
+			out = append(out, d.lineSymbol(s.Name, s.P)...)
+		case *ast.ParamsStmt:
+			for _, param := range s.Params {
+				out = append(out, d.lineSymbol(param.Name, param.P)...)
+			}
+		case *ast.IfStmt:
+			out = append(out, d.varSymbols(s.Then)...)
+			for _, branch := range s.ElseIfs {
+				out = append(out, d.varSymbols(branch.Body)...)
+			}
+			out = append(out, d.varSymbols(s.Else)...)
+		case *ast.MatchStmt:
+			for _, arm := range s.Cases {
+				out = append(out, d.varSymbols(arm.Body)...)
+			}
+			out = append(out, d.varSymbols(s.Default)...)
+		case *ast.ForStmt:
+			out = append(out, d.varSymbols(s.Body)...)
+		case *ast.ForEachStmt:
+			out = append(out, d.varSymbols(s.Body)...)
+		case *ast.WhileStmt:
+			out = append(out, d.varSymbols(s.Body)...)
+		case *ast.UncheckedStmt:
+			out = append(out, d.varSymbols(s.Body)...)
+		case *ast.TryStmt:
+			out = append(out, d.varSymbols(s.Body)...)
+			out = append(out, d.varSymbols(s.Catch)...)
+			out = append(out, d.varSymbols(s.Finally)...)
+		}
+	}
+	return out
+}
+
+// lineSymbol returns the symbol of a variable declared by the keyword at
+// pos, which is followed by its name, spanning the rest of the line.
+func (d *document) lineSymbol(name string, pos ast.Pos) []DocumentSymbol {
+	i, ok := d.index[pos]
+	if !ok || i+1 >= len(d.tokens) {
+		return nil
+	}
+	line := d.lines[pos.Line-1]
+	return []DocumentSymbol{{
+		Name:           name,
+		Kind:           symbolVariable,
+		Range:          Range{Start: d.position(pos), End: d.position(ast.Pos{Line: pos.Line, Column: utf8.RuneCountInString(line) + 1})},
+		SelectionRange: d.tokenRange(d.tokens[i+1]),
+	}}
+}
+
+// blockEnd returns the index of the end token closing the block opened at
+// token i, or the last token if the block is unterminated.
+func (d *document) blockEnd(i int) int {
+	depth := 0
+	for j := i; j < len(d.tokens); j++ {
+		switch d.tokens[j].Type {
+		case token.IF:
+			// The if of an `else if` continues the open block.
+			if j == 0 || d.tokens[j-1].Type != token.ELSE {
+				depth++
+			}
+		case token.FOR, token.WHILE, token.FN, token.UNCHECKED, token.TRY, token.MATCH, token.PARAMS:
+			depth++
+		case token.END:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(d.tokens) - 1
+}
+
+// fullRange covers the whole document.
+func (d *document) fullRange() Range {
+	last := len(d.lines) - 1
+	return Range{End: Position{Line: last, Character: len(utf16.Encode([]rune(d.lines[last])))}}
+}
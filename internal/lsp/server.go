@@ -0,0 +1,302 @@
+// Package lsp implements a Language Server Protocol server for Fin over a
+// pair of streams, normally stdin and stdout. It keeps every open document
+// parsed and analyzed, publishes diagnostics after each change, and answers
+// definition, hover, document symbol, formatting and completion requests.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/diag"
+	"github.com/vishnunath-suresh/fin-project/internal/format"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+	"github.com/vishnunath-suresh/fin-project/internal/token"
+	"github.com/vishnunath-suresh/fin-project/internal/version"
+)
+
+// ErrExitWithoutShutdown is returned by Run when the client sends exit
+// without a preceding shutdown request.
+var ErrExitWithoutShutdown = errors.New("lsp: exit without shutdown")
+
+// Server is a Fin language server. Requests are handled one at a time in the
+// order they arrive.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+	err         error // first failure to write to out
+}
+
+// NewServer returns a server that reads requests from in and writes
+// responses and notifications to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: make(map[string]*document)}
+}
+
+// Run serves until the client sends exit or in is closed.
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.replyError(nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return ErrExitWithoutShutdown
+			}
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches one message. Only a failure to write is returned; errors
+// in the request itself are reported to the client.
+func (s *Server) handle(req request) error {
+	result, rerr := s.dispatch(req)
+	if req.ID == nil || s.err != nil {
+		return s.err
+	}
+	if rerr != nil {
+		return s.replyError(req.ID, rerr)
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+func (s *Server) dispatch(req request) (result any, rerr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, rerr = nil, &responseError{Code: codeRequestFailed, Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+	if !s.initialized && req.Method != "initialize" {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	switch req.Method {
+	case "initialize":
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:           syncFull,
+				DefinitionProvider:         true,
+				HoverProvider:              true,
+				DocumentSymbolProvider:     true,
+				DocumentFormattingProvider: true,
+				CompletionProvider:         &CompletionOptions{},
+			},
+			ServerInfo: ServerInfo{Name: "fin", Version: version.Version},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Version, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		return nil, nil
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.publish(p.TextDocument.URI, 0, []Diagnostic{})
+		return nil, nil
+	case "textDocument/definition":
+		return s.positional(req.Params, func(d *document, i int) any {
+			def, _, ok := d.resolve(i)
+			if !ok {
+				return nil
+			}
+			return Location{URI: d.uri, Range: d.rangeAt(def)}
+		})
+	case "textDocument/hover":
+		return s.positional(req.Params, func(d *document, i int) any {
+			text, ok := d.describe(i)
+			if !ok {
+				return nil
+			}
+			return Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: d.tokenRange(d.tokens[i])}
+		})
+	case "textDocument/documentSymbol":
+		d, err := s.document(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return d.symbols(), nil
+	case "textDocument/formatting":
+		d, err := s.document(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		if !d.parsed {
+			return nil, &responseError{Code: codeRequestFailed, Message: "cannot format a file with syntax errors"}
+		}
+		formatted := format.Format(d.prog)
+		if formatted == d.text {
+			return []TextEdit{}, nil
+		}
+		return []TextEdit{{Range: d.fullRange(), NewText: formatted}}, nil
+	case "textDocument/completion":
+		d, err := s.document(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return completions(d), nil
+	}
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		return nil, nil // unknown notifications are ignored
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// update re-analyzes a document and publishes its diagnostics.
+func (s *Server) update(uri string, version int, text string) {
+	d := newDocument(uri, version, text)
+	s.docs[uri] = d
+	out := []Diagnostic{}
+	for _, dg := range d.diags {
+		out = append(out, d.diagnostic(dg))
+	}
+	s.publish(uri, version, out)
+}
+
+// publish sends diagnostics for uri. A write failure is kept in s.err and
+// ends Run.
+func (s *Server) publish(uri string, version int, ds []Diagnostic) {
+	err := writeMessage(s.out, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: uri, Version: version, Diagnostics: ds},
+	})
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// diagnostic converts a Fin diagnostic to its LSP form.
+func (d *document) diagnostic(dg diag.Diagnostic) Diagnostic {
+	msg := dg.Msg
+	for _, n := range dg.Notes {
+		msg += "\nnote: " + n
+	}
+	if dg.Help != "" {
+		msg += "\nhelp: " + dg.Help
+	}
+	sev := severityError
+	if dg.Severity == diag.Warning {
+		sev = severityWarning
+	}
+	if dg.File != "" {
+		// Found in an imported file: positions do not refer to this
+		// document, so the location goes in the message.
+		msg = fmt.Sprintf("%s:%d:%d: %s", dg.File, dg.Primary.P.Line, dg.Primary.P.Column, msg)
+		return Diagnostic{Severity: sev, Code: dg.Code, Source: "fin", Message: msg}
+	}
+	out := Diagnostic{Range: d.span(dg.Primary), Severity: sev, Code: dg.Code, Source: "fin", Message: msg}
+	for _, l := range dg.Related {
+		out.RelatedInformation = append(out.RelatedInformation, DiagnosticRelatedInformation{
+			Location: Location{URI: d.uri, Range: d.span(l)},
+			Message:  l.Msg,
+		})
+	}
+	return out
+}
+
+// document returns the open document named by params.
+func (s *Server) document(params json.RawMessage) (*document, *responseError) {
+	var p DocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	d, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "document not open: " + p.TextDocument.URI}
+	}
+	return d, nil
+}
+
+// positional answers a request about the identifier under the cursor, or
+// with null when there is none.
+func (s *Server) positional(params json.RawMessage, answer func(d *document, i int) any) (any, *responseError) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+	d, rerr := s.document(params)
+	if rerr != nil {
+		return nil, rerr
+	}
+	i, ok := d.identAt(p.Position)
+	if !ok {
+		return nil, nil
+	}
+	return answer(d, i), nil
+}
+
+// completions offers the keywords, the built-in functions and the functions
+// defined in d.
+func completions(d *document) []CompletionItem {
+	var out []CompletionItem
+	for kw := range token.Keywords {
+		out = append(out, CompletionItem{Label: kw, Kind: completionKeyword})
+	}
+	for name, arity := range sema.Builtins {
+		out = append(out, CompletionItem{Label: name, Kind: completionFunction, Detail: "built-in, takes " + plural(arity, "argument")})
+	}
+	for _, fn := range d.fns {
+		out = append(out, CompletionItem{Label: fn.Name, Kind: completionFunction, Detail: strings.TrimSpace("fn " + fn.Name + " " + strings.Join(fn.Params, " "))})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Label < out[j].Label
+	})
+	return out
+}
+
+func decode(params json.RawMessage, v any) *responseError {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) replyError(id *json.RawMessage, rerr *responseError) error {
+	return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+}
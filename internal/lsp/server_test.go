@@ -0,0 +1,347 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///work/script.fin"
+
+// src exercises functions, parameters, variables and assignment.
+// Lines and columns in the tests below are 0-based, as in LSP.
+const src = `fn greet name times
+    echo $name
+end
+set x 1
+greet "World" $x
+x = 2
+for i in 1..2
+    echo "😀" == $i
+end
+`
+
+// reply is a decoded server message.
+type reply struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+// session runs a server over the given client messages, framing each one,
+// and returns everything the server wrote and the error Run returned.
+func session(t *testing.T, msgs ...string) ([]reply, error) {
+	t.Helper()
+	var in, out bytes.Buffer
+	for _, m := range msgs {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	err := NewServer(&in, &out).Run()
+	var replies []reply
+	r := bufio.NewReader(&out)
+	for {
+		body, rerr := readMessage(r)
+		if rerr != nil {
+			break
+		}
+		var rp reply
+		if jerr := json.Unmarshal(body, &rp); jerr != nil {
+			t.Fatalf("server wrote invalid JSON: %v\n%s", jerr, body)
+		}
+		replies = append(replies, rp)
+	}
+	return replies, err
+}
+
+// opened runs the messages after initializing and opening src.
+func opened(t *testing.T, msgs ...string) []reply {
+	t.Helper()
+	text, _ := json.Marshal(src)
+	all := append([]string{
+		`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + uri + `","languageId":"fin","version":1,"text":` + string(text) + `}}}`,
+	}, msgs...)
+	replies, err := session(t, all...)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return replies
+}
+
+func at(method string, id, line, char int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":{"textDocument":{"uri":%q},"position":{"line":%d,"character":%d}}}`,
+		id, method, uri, line, char)
+}
+
+func forDoc(method string, id int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":{"textDocument":{"uri":%q},"options":{"tabSize":4,"insertSpaces":true}}}`,
+		id, method, uri)
+}
+
+// result returns the compact JSON result of the response with the given id.
+func result(t *testing.T, replies []reply, id int) string {
+	t.Helper()
+	for _, r := range replies {
+		if r.ID != nil && *r.ID == id {
+			if r.Error != nil {
+				t.Fatalf("request %d failed: %d %s", id, r.Error.Code, r.Error.Message)
+			}
+			var b bytes.Buffer
+			if err := json.Compact(&b, r.Result); err != nil {
+				t.Fatalf("request %d: %v", id, err)
+			}
+			return b.String()
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+	return ""
+}
+
+func diagnostics(t *testing.T, replies []reply) []string {
+	t.Helper()
+	var out []string
+	for _, r := range replies {
+		if r.Method == "textDocument/publishDiagnostics" {
+			out = append(out, string(r.Params))
+		}
+	}
+	return out
+}
+
+func TestInitialize_Capabilities(t *testing.T) {
+	got := result(t, opened(t), 0)
+	for _, want := range []string{
+		`"textDocumentSync":1`, `"definitionProvider":true`, `"hoverProvider":true`,
+		`"documentSymbolProvider":true`, `"documentFormattingProvider":true`, `"completionProvider":{}`,
+		`"serverInfo":{"name":"fin"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("initialize result missing %s: %s", want, got)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	cases := []struct {
+		name       string
+		line, char int
+		want       string
+	}{
+		{"variable", 4, 15, `{"uri":"` + uri + `","range":{"start":{"line":3,"character":0},"end":{"line":3,"character":3}}}`},
+		{"cursor_after_variable", 4, 16, `{"uri":"` + uri + `","range":{"start":{"line":3,"character":0},"end":{"line":3,"character":3}}}`},
+		{"function_call", 4, 2, `{"uri":"` + uri + `","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":2}}}`},
+		{"parameter", 1, 10, `{"uri":"` + uri + `","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":2}}}`},
+		{"assignment", 5, 0, `{"uri":"` + uri + `","range":{"start":{"line":3,"character":0},"end":{"line":3,"character":3}}}`},
+		{"after_utf16_surrogates", 7, 17, `{"uri":"` + uri + `","range":{"start":{"line":6,"character":0},"end":{"line":6,"character":3}}}`},
+		{"declaration", 3, 4, `{"uri":"` + uri + `","range":{"start":{"line":3,"character":0},"end":{"line":3,"character":3}}}`},
+		{"no_identifier", 4, 8, `null`},
+	}
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			replies := opened(t, at("textDocument/definition", i+1, tc.line, tc.char))
+			if got := result(t, replies, i+1); got != tc.want {
+				t.Fatalf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHover(t *testing.T) {
+	replies := opened(t,
+		at("textDocument/hover", 1, 4, 1),
+		at("textDocument/hover", 2, 1, 10),
+		at("textDocument/hover", 3, 7, 17),
+		at("textDocument/hover", 4, 0, 4),
+	)
+	want := []string{
+		`{"contents":{"kind":"markdown","value":"` + "```fin\\nfn greet name times\\n```\\nTakes 2 arguments. Defined on line 1." + `"},"range":{"start":{"line":4,"character":0},"end":{"line":4,"character":5}}}`,
+		`{"contents":{"kind":"markdown","value":"` + "```fin\\n$name\\n```\\nParameter of `greet` defined on line 1." + `"},"range":{"start":{"line":1,"character":9},"end":{"line":1,"character":14}}}`,
+		`{"contents":{"kind":"markdown","value":"` + "```fin\\n$i\\n```\\nLoop variable defined on line 7." + `"},"range":{"start":{"line":7,"character":17},"end":{"line":7,"character":19}}}`,
+		`{"contents":{"kind":"markdown","value":"` + "```fin\\nfn greet name times\\n```\\nTakes 2 arguments. Defined on line 1." + `"},"range":{"start":{"line":0,"character":3},"end":{"line":0,"character":8}}}`,
+	}
+	for i, w := range want {
+		if got := result(t, replies, i+1); got != w {
+			t.Errorf("hover %d:\ngot  %s\nwant %s", i+1, got, w)
+		}
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	got := result(t, opened(t, forDoc("textDocument/documentSymbol", 1)), 1)
+	want := `[` +
+		`{"name":"greet","detail":"name times","kind":12,"range":{"start":{"line":0,"character":0},"end":{"line":2,"character":3}},"selectionRange":{"start":{"line":0,"character":3},"end":{"line":0,"character":8}},"children":[` +
+		`{"name":"name","kind":13,"range":{"start":{"line":0,"character":9},"end":{"line":0,"character":13}},"selectionRange":{"start":{"line":0,"character":9},"end":{"line":0,"character":13}}},` +
+		`{"name":"times","kind":13,"range":{"start":{"line":0,"character":14},"end":{"line":0,"character":19}},"selectionRange":{"start":{"line":0,"character":14},"end":{"line":0,"character":19}}}]},` +
+		`{"name":"x","kind":13,"range":{"start":{"line":3,"character":0},"end":{"line":3,"character":7}},"selectionRange":{"start":{"line":3,"character":4},"end":{"line":3,"character":5}}}]`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+// change replaces the whole text of the document.
+func change(version int, text string) string {
+	b, _ := json.Marshal(text)
+	return fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":%q,"version":%d},"contentChanges":[{"text":%s}]}}`,
+		uri, version, b)
+}
+
+func TestDiagnostics_PublishedOnOpenAndChange(t *testing.T) {
+	replies := opened(t,
+		change(2, "set y 1\nif $y == 1\n    set y 2\nend\n"),
+		change(3, "set\n"),
+		change(4, "echo 1\n"),
+		`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+	)
+	want := []string{
+		`{"uri":"` + uri + `","version":1,"diagnostics":[]}`,
+		`{"uri":"` + uri + `","version":2,"diagnostics":[{"range":{"start":{"line":2,"character":4},"end":{"line":2,"character":7}},"severity":1,"code":"E0006","source":"fin",` +
+			`"message":"name \"y\" is already defined\nhelp: names cannot be redefined or shadowed; assign to it with ` + "`y = <value>`" + ` instead",` +
+			`"relatedInformation":[{"location":{"uri":"` + uri + `","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":3}}},"message":"originally defined here"}]}]}`,
+		`{"uri":"` + uri + `","version":3,"diagnostics":[{"range":{"start":{"line":0,"character":3},"end":{"line":0,"character":3}},"severity":1,"code":"E0001","source":"fin","message":"expected identifier after set"}]}`,
+		`{"uri":"` + uri + `","version":4,"diagnostics":[]}`,
+		`{"uri":"` + uri + `","diagnostics":[]}`,
+	}
+	got := diagnostics(t, replies)
+	if len(got) != len(want) {
+		t.Fatalf("got %d notifications, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("notification %d:\ngot  %s\nwant %s", i, got[i], want[i])
+		}
+	}
+}
+
+// TestDiagnostics_WhileTyping sends the text after every keystroke of a
+// short program, so the server parses each half-typed line, such as
+// `count +`, that a user passes through.
+func TestDiagnostics_WhileTyping(t *testing.T) {
+	const typed = "set count 1\ncount += 1\nif $count * $count > 2\n    echo \"$count\"\nend\n"
+	msgs := []string{}
+	for i := 1; i <= len(typed); i++ {
+		msgs = append(msgs, change(i+1, typed[:i]))
+	}
+	msgs = append(msgs,
+		`{"jsonrpc":"2.0","id":1,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	replies := opened(t, msgs...)
+	got := diagnostics(t, replies)
+	if len(got) != len(typed)+1 {
+		t.Fatalf("got %d notifications, want %d", len(got), len(typed)+1)
+	}
+	if !strings.Contains(got[len("set count 1\ncount +")], `"message":"expected expression`) {
+		t.Errorf("half-typed `count +` not reported:\n%s", got[len("set count 1\ncount +")])
+	}
+	if last := got[len(got)-1]; !strings.HasSuffix(last, `"diagnostics":[]}`) {
+		t.Errorf("finished program still has diagnostics:\n%s", last)
+	}
+	if got := result(t, replies, 1); got != "null" {
+		t.Fatalf("shutdown result = %s, want null", got)
+	}
+}
+
+func TestDiagnostics_Imports(t *testing.T) {
+	dir := t.TempDir()
+	lib := "fn greet name\n    echo $name\nend\nfn broken\n    shout\nend\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.fin"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	docURI := "file://" + filepath.ToSlash(dir) + "/main.fin"
+	open := func(text string) string {
+		b, _ := json.Marshal(text)
+		return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + docURI + `","languageId":"fin","version":1,"text":` + string(b) + `}}}`
+	}
+	replies, err := session(t,
+		`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{}}`,
+		open("import \"lib.fin\" as lib\nlib.greet \"World\"\nimport2 \"x\"\n"),
+	)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	libPath := filepath.Join(dir, "lib.fin")
+	want := `{"uri":"` + docURI + `","version":1,"diagnostics":[` +
+		`{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}},"severity":1,"code":"E0002","source":"fin","message":"` + libPath + `:5:5: undefined name \"shout\"\nhelp: declare it first, e.g. ` + "`set shout <value>` or `fn shout ... end`" + `"},` +
+		`{"range":{"start":{"line":2,"character":0},"end":{"line":2,"character":7}},"severity":1,"code":"E0002","source":"fin","message":"undefined name \"import2\"\nhelp: declare it first, e.g. ` + "`set import2 <value>` or `fn import2 ... end`" + `"}]}`
+	got := diagnostics(t, replies)
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("got  %s\nwant %s", strings.Join(got, "\n"), want)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	got := result(t, opened(t, forDoc("textDocument/formatting", 1)), 1)
+	want := `[{"range":{"start":{"line":0,"character":0},"end":{"line":9,"character":0}},"newText":"fn greet name times\n    echo $name\nend\nset x 1\ngreet \"World\" $x\nx = 2\nfor i in 1 .. 2\n    echo \"😀\" == $i\nend"}]`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestFormatting_SyntaxErrorFails(t *testing.T) {
+	replies, err := session(t,
+		`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"`+uri+`","languageId":"fin","version":1,"text":"set\n"}}}`,
+		forDoc("textDocument/formatting", 1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := replies[len(replies)-1]
+	if last.Error == nil || last.Error.Code != codeRequestFailed {
+		t.Fatalf("expected formatting to fail, got %+v", last)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	got := result(t, opened(t, at("textDocument/completion", 1, 9, 0)), 1)
+	for _, want := range []string{
+		`{"label":"greet","kind":3,"detail":"fn greet name times"}`,
+		`{"label":"substr","kind":3,"detail":"built-in, takes 3 arguments"}`,
+		`{"label":"while","kind":14}`,
+		`{"label":"set","kind":14}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("completion missing %s: %s", want, got)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	replies, err := session(t,
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"workspace/symbol","params":{}}`,
+		`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":3}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///missing.fin"},"position":{"line":0,"character":0}}}`,
+		`not json`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	if err != nil {
+		t.Fatalf("exit after shutdown should succeed: %v", err)
+	}
+	codes := []int{}
+	for _, r := range replies {
+		if r.Error != nil {
+			codes = append(codes, r.Error.Code)
+		}
+	}
+	if fmt.Sprint(codes) != fmt.Sprint([]int{codeServerNotInitialized, codeMethodNotFound, codeInvalidParams, codeParseError}) {
+		t.Fatalf("unexpected error codes %v", codes)
+	}
+	if got := result(t, replies, 5); got != "null" {
+		t.Fatalf("shutdown result = %s, want null", got)
+	}
+
+	if _, err := session(t, `{"jsonrpc":"2.0","method":"exit"}`); err != ErrExitWithoutShutdown {
+		t.Fatalf("exit without shutdown: got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// FileError wraps the errors found in an imported file. Their positions
+// refer to Source, the text of File.
+type FileError struct {
+	File   string
+	Source string
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// ImportError is raised when an import cannot be resolved.
+type ImportError struct {
+	Path string // as written in the import
+	Msg  string
+	P    ast.Pos
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("import %q at %d:%d: %s", e.Path, e.P.Line, e.P.Column, e.Msg)
+}
+
+func (e *ImportError) Pos() ast.Pos { return e.P }
+
+// ImportCycleError is raised when a file imports itself, directly or not.
+// Chain lists the files in the cycle, starting and ending with the same one.
+type ImportCycleError struct {
+	Chain []string
+	P     ast.Pos
+}
+
+func (e *ImportCycleError) Error() string {
+	return fmt.Sprintf("import cycle at %d:%d: %s", e.P.Line, e.P.Column, strings.Join(e.Chain, " -> "))
+}
+
+func (e *ImportCycleError) Pos() ast.Pos { return e.P }
+
+// TopLevelError is raised for a top-level statement in an imported file
+// that is neither a function declaration nor an import.
+type TopLevelError struct {
+	P ast.Pos
+}
+
+func (e *TopLevelError) Error() string {
+	return fmt.Sprintf("statement at %d:%d: imported files may only declare functions and import files", e.P.Line, e.P.Column)
+}
+
+func (e *TopLevelError) Pos() ast.Pos { return e.P }
@@ -0,0 +1,191 @@
+package module
+
+import "github.com/vishnunath-suresh/fin-project/internal/ast"
+
+// link builds the linked program: the statements of the main file without
+// its imports, followed by the functions of the imported files in load
+// order. Every file is renamed in place first, and the positions in imported
+// files are tagged with their path.
+func (ld *loader) link(main *file) *ast.Program {
+	for _, f := range ld.order {
+		r := renamer{names: f.names}
+		if f != main {
+			r.file = f.path
+		}
+		for _, fn := range f.functions() {
+			fn.Name = f.linked(fn.Name)
+		}
+		r.stmts(f.prog.Statements)
+	}
+	prog := &ast.Program{P: main.prog.P, Trivia: main.prog.Trivia}
+	for _, stmt := range main.prog.Statements {
+		if _, ok := stmt.(*ast.ImportStmt); !ok {
+			prog.Statements = append(prog.Statements, stmt)
+		}
+	}
+	for _, f := range ld.order {
+		if f == main {
+			continue
+		}
+		for _, fn := range f.functions() {
+			prog.Statements = append(prog.Statements, fn)
+		}
+	}
+	return prog
+}
+
+// renamer rewrites the name of every call to the linked name of the
+// function it calls, and sets the file of every position to file.
+type renamer struct {
+	names map[string]string
+	file  string
+}
+
+func (r renamer) name(name string) string {
+	if linked, ok := r.names[name]; ok {
+		return linked
+	}
+	return name
+}
+
+func (r renamer) pos(p *ast.Pos) {
+	p.File = r.file
+}
+
+func (r renamer) stmts(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.stmt(stmt)
+	}
+}
+
+func (r renamer) stmt(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.SetStmt:
+		r.pos(&s.P)
+		r.expr(s.Value)
+	case *ast.AssignStmt:
+		r.pos(&s.P)
+		r.expr(s.Value)
+	case *ast.EchoStmt:
+		r.pos(&s.P)
+		r.expr(s.Value)
+	case *ast.RunStmt:
+		r.pos(&s.P)
+		r.expr(s.Command)
+		r.exprs(s.Args)
+	case *ast.CallStmt:
+		r.pos(&s.P)
+		s.Name = r.name(s.Name)
+		r.exprs(s.Args)
+	case *ast.FnDecl:
+		r.pos(&s.P)
+		r.stmts(s.Body)
+	case *ast.IfStmt:
+		r.pos(&s.P)
+		r.expr(s.Cond)
+		r.stmts(s.Then)
+		for i := range s.ElseIfs {
+			branch := &s.ElseIfs[i]
+			r.pos(&branch.P)
+			r.expr(branch.Cond)
+			r.stmts(branch.Body)
+		}
+		r.stmts(s.Else)
+	case *ast.MatchStmt:
+		r.pos(&s.P)
+		r.expr(s.Subject)
+		for i := range s.Cases {
+			arm := &s.Cases[i]
+			r.pos(&arm.P)
+			r.exprs(arm.Values)
+			r.stmts(arm.Body)
+		}
+		r.stmts(s.Default)
+	case *ast.ForStmt:
+		r.pos(&s.P)
+		r.expr(s.Start)
+		r.expr(s.End)
+		r.stmts(s.Body)
+	case *ast.ForEachStmt:
+		r.pos(&s.P)
+		r.expr(s.Iterable)
+		r.stmts(s.Body)
+	case *ast.WhileStmt:
+		r.pos(&s.P)
+		r.expr(s.Cond)
+		r.stmts(s.Body)
+	case *ast.ReturnStmt:
+		r.pos(&s.P)
+		r.expr(s.Value)
+	case *ast.BreakStmt:
+		r.pos(&s.P)
+	case *ast.ContinueStmt:
+		r.pos(&s.P)
+	case *ast.UncheckedStmt:
+		r.pos(&s.P)
+		r.stmts(s.Body)
+	case *ast.TryStmt:
+		r.pos(&s.P)
+		r.stmts(s.Body)
+		r.stmts(s.Catch)
+		r.stmts(s.Finally)
+	}
+}
+
+func (r renamer) exprs(exprs []ast.Expr) {
+	for _, e := range exprs {
+		r.expr(e)
+	}
+}
+
+func (r renamer) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.CallExpr:
+		r.pos(&e.P)
+		e.Name = r.name(e.Name)
+		r.exprs(e.Args)
+	case *ast.StringLit:
+		r.pos(&e.P)
+		for _, call := range e.Calls {
+			r.expr(call)
+		}
+		r.exprs(e.Vars)
+	case *ast.RunExpr:
+		r.pos(&e.P)
+		r.expr(e.Command)
+		r.exprs(e.Args)
+	case *ast.BinaryExpr:
+		r.pos(&e.P)
+		r.expr(e.Left)
+		r.expr(e.Right)
+	case *ast.UnaryExpr:
+		r.pos(&e.P)
+		r.expr(e.Right)
+	case *ast.IndexExpr:
+		r.pos(&e.P)
+		r.expr(e.Left)
+		r.expr(e.Index)
+	case *ast.PropertyExpr:
+		r.pos(&e.P)
+		r.expr(e.Object)
+	case *ast.ExistsCond:
+		r.pos(&e.P)
+		r.expr(e.Path)
+	case *ast.ListLit:
+		r.pos(&e.P)
+		r.exprs(e.Elements)
+	case *ast.MapLit:
+		r.pos(&e.P)
+		for i := range e.Pairs {
+			pair := &e.Pairs[i]
+			r.pos(&pair.P)
+			r.expr(pair.Value)
+		}
+	case *ast.IdentExpr:
+		r.pos(&e.P)
+	case *ast.NumberLit:
+		r.pos(&e.P)
+	case *ast.BoolLit:
+		r.pos(&e.P)
+	}
+}
@@ -0,0 +1,240 @@
+// Package module loads a Fin program split across files. The main file and
+// every file it imports, directly or not, are parsed and analyzed on their
+// own, then linked into one program: imported functions are renamed to
+// FILE.NAME, where FILE is unique to the file they come from, and every call
+// is rewritten to the name of the function it resolves to.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Loader loads programs from source files.
+type Loader struct {
+	// ReadFile reads a source file. Nil means os.ReadFile.
+	ReadFile func(path string) ([]byte, error)
+}
+
+// Load loads the program whose main file is path, reading from disk.
+func Load(path string) (*ast.Program, error) {
+	return (&Loader{}).Load(path)
+}
+
+// Load reads the main file at path and the files it imports, checks each of
+// them, and links them into one program. Errors in the main file are
+// returned as is; errors in imported files are wrapped in a *FileError.
+func (l *Loader) Load(path string) (*ast.Program, error) {
+	read := l.ReadFile
+	if read == nil {
+		read = os.ReadFile
+	}
+	ld := &loader{read: read, files: make(map[string]*file), prefixes: make(map[string]bool)}
+	main, err := ld.load(path, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(ld.errs) > 0 {
+		return nil, errors.Join(ld.errs...)
+	}
+	return ld.link(main), nil
+}
+
+// file is one loaded source file.
+type file struct {
+	path   string // the main path, or an import path joined to the importer's directory
+	abs    string
+	src    string
+	prog   *ast.Program
+	prefix string // qualifies the names of its functions; empty in the main file
+	// names maps each function name callable in the file, as written in
+	// calls, to the name of the function in the linked program.
+	names map[string]string
+}
+
+// linked returns the name of the function name declared in f once linked.
+func (f *file) linked(name string) string {
+	if f.prefix == "" {
+		return name
+	}
+	return f.prefix + "." + name
+}
+
+// functions returns the functions declared in f.
+func (f *file) functions() []*ast.FnDecl {
+	var fns []*ast.FnDecl
+	for _, stmt := range f.prog.Statements {
+		if fn, ok := stmt.(*ast.FnDecl); ok {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+type loader struct {
+	read     func(string) ([]byte, error)
+	main     *file
+	files    map[string]*file // by absolute path
+	active   []*file          // files whose imports are being loaded, outermost first
+	order    []*file          // loaded files, each after the files it imports
+	prefixes map[string]bool  // in lower case, as batch labels ignore case
+	errs     []error
+}
+
+// load parses and analyzes the file at path after loading its imports. It
+// returns an error only when the file cannot be read; other problems are
+// collected in ld.errs.
+func (ld *loader) load(path string, main bool) (*file, error) {
+	src, err := ld.read(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &file{path: path, abs: abs, src: string(src), names: make(map[string]string)}
+	ld.files[abs] = f
+	if main {
+		ld.main = f
+	} else {
+		f.prefix = ld.prefix(path)
+	}
+	p := parser.New(parser.CollectTokens(lexer.New(f.src)))
+	f.prog = p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		ld.fail(f, errs)
+		return f, nil
+	}
+
+	var errs []error
+	if !main {
+		for _, stmt := range f.prog.Statements {
+			switch stmt.(type) {
+			case *ast.FnDecl, *ast.ImportStmt:
+			default:
+				errs = append(errs, &TopLevelError{P: stmt.Pos()})
+			}
+		}
+	}
+	for _, fn := range f.functions() {
+		f.names[fn.Name] = f.linked(fn.Name)
+	}
+
+	funcs := sema.NewFunctionRegistry()
+	aliases := make(map[string]bool)
+	ld.active = append(ld.active, f)
+	for _, stmt := range f.prog.Statements {
+		imp, ok := stmt.(*ast.ImportStmt)
+		if !ok {
+			continue
+		}
+		if imp.Alias != "" {
+			if aliases[imp.Alias] {
+				errs = append(errs, &ImportError{Path: imp.Path, Msg: fmt.Sprintf("alias %s is already used", imp.Alias), P: imp.P})
+				continue
+			}
+			aliases[imp.Alias] = true
+		}
+		dep, err := ld.resolve(f, imp)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, fn := range dep.functions() {
+			name := fn.Name
+			if imp.Alias != "" {
+				name = imp.Alias + "." + fn.Name
+			}
+			if prev, ok := f.names[name]; ok {
+				if prev != dep.linked(fn.Name) {
+					errs = append(errs, &ImportError{Path: imp.Path, Msg: fmt.Sprintf("function %s is already defined", name), P: imp.P})
+				}
+				continue
+			}
+			f.names[name] = dep.linked(fn.Name)
+			// Imported functions have no position in this file.
+			funcs.Define(name, len(fn.Params), ast.Pos{})
+		}
+	}
+	ld.active = ld.active[:len(ld.active)-1]
+
+	errs = append(errs, sema.AnalyzeWithFunctions(f.prog, funcs).Errors...)
+	if len(errs) > 0 {
+		ld.fail(f, errs)
+	}
+	ld.order = append(ld.order, f)
+	return f, nil
+}
+
+// resolve returns the file imp refers to, loading it if needed. Import
+// paths are relative to the directory of the importing file.
+func (ld *loader) resolve(from *file, imp *ast.ImportStmt) (*file, error) {
+	if filepath.Ext(imp.Path) != ".fin" {
+		return nil, &ImportError{Path: imp.Path, Msg: "imported files must have the .fin extension", P: imp.P}
+	}
+	path := filepath.FromSlash(imp.Path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from.path), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, &ImportError{Path: imp.Path, Msg: err.Error(), P: imp.P}
+	}
+	for i, f := range ld.active {
+		if f.abs == abs {
+			var chain []string
+			for _, g := range ld.active[i:] {
+				chain = append(chain, g.path)
+			}
+			return nil, &ImportCycleError{Chain: append(chain, path), P: imp.P}
+		}
+	}
+	if f, ok := ld.files[abs]; ok {
+		return f, nil
+	}
+	f, err := ld.load(path, false)
+	if err != nil {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		return nil, &ImportError{Path: imp.Path, Msg: fmt.Sprintf("cannot read %s: %v", path, err), P: imp.P}
+	}
+	return f, nil
+}
+
+// prefix returns a prefix for the functions of the file at path: its base
+// name, made unique by a number if another file already has it.
+func (ld *loader) prefix(path string) string {
+	base := strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(filepath.Base(path), ".fin"))
+	prefix := base
+	for n := 2; ld.prefixes[strings.ToLower(prefix)]; n++ {
+		prefix = fmt.Sprintf("%s%d", base, n)
+	}
+	ld.prefixes[strings.ToLower(prefix)] = true
+	return prefix
+}
+
+// fail records errs against f. Errors outside the main file carry the file
+// they were found in.
+func (ld *loader) fail(f *file, errs []error) {
+	err := errors.Join(errs...)
+	if f != ld.main {
+		err = &FileError{File: f.path, Source: f.src, Err: err}
+	}
+	ld.errs = append(ld.errs, err)
+}
@@ -0,0 +1,203 @@
+package module
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// loaderFor returns a loader reading from files, keyed by slash-separated
+// path.
+func loaderFor(files map[string]string) *Loader {
+	return &Loader{ReadFile: func(path string) ([]byte, error) {
+		src, ok := files[filepath.ToSlash(path)]
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return []byte(src), nil
+	}}
+}
+
+func TestLoad_LinksImportedFunctions(t *testing.T) {
+	prog, err := loaderFor(map[string]string{
+		"main.fin": "import \"lib/text.fin\"\n" +
+			"import \"lib/math.fin\" as m\n" +
+			"fn twice n\n" +
+			"    return (m.double $n)\n" +
+			"end\n" +
+			"shout \"hi\"\n" +
+			"echo (twice 2)\n",
+		"lib/text.fin": "fn shout s\n" +
+			"    echo $s\n" +
+			"end\n",
+		"lib/math.fin": "import \"text.fin\"\n" +
+			"fn double n\n" +
+			"    shout \"doubling\"\n" +
+			"    return $n * 2\n" +
+			"end\n",
+	}).Load("main.fin")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var names []string
+	for _, stmt := range prog.Statements {
+		switch s := stmt.(type) {
+		case *ast.ImportStmt:
+			t.Fatalf("import left in linked program")
+		case *ast.FnDecl:
+			names = append(names, s.Name)
+		}
+	}
+	if got := strings.Join(names, " "); got != "twice text.shout math.double" {
+		t.Fatalf("functions = %q", got)
+	}
+	out := ast.Format(prog)
+	for _, want := range []string{
+		"CallExpr name=math.double",
+		"CallStmt name=text.shout",
+		"CallExpr name=twice",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestLoad_TagsImportedPositions(t *testing.T) {
+	prog, err := loaderFor(map[string]string{
+		"main.fin": "import \"lib/boom.fin\"\n" +
+			"set z 0.0\n" +
+			"echo (div $z)\n",
+		"lib/boom.fin": "fn div x\n" +
+			"    return 1.0 / $x\n" +
+			"end\n",
+	}).Load("main.fin")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if p := prog.Statements[1].Pos(); p != (ast.Pos{Line: 3, Column: 1}) {
+		t.Fatalf("main file position = %+v", p)
+	}
+	fn := prog.Statements[2].(*ast.FnDecl)
+	ret := fn.Body[0].(*ast.ReturnStmt)
+	div := ret.Value.(*ast.BinaryExpr)
+	for _, p := range []ast.Pos{fn.P, ret.P, div.P, div.Right.Pos()} {
+		if p.File != "lib/boom.fin" {
+			t.Fatalf("imported position = %+v, want it in lib/boom.fin", p)
+		}
+	}
+	if div.P.Line != 2 || div.P.Column != 16 {
+		t.Fatalf("division at %+v, want 2:16", div.P)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "missing file",
+			files: map[string]string{"main.fin": "import \"nope.fin\"\n"},
+			want:  `import "nope.fin" at 1:1: cannot read nope.fin: file does not exist`,
+		},
+		{
+			name:  "extension",
+			files: map[string]string{"main.fin": "import \"lib.txt\"\n"},
+			want:  `import "lib.txt" at 1:1: imported files must have the .fin extension`,
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.fin": "import \"a.fin\"\n",
+				"a.fin":    "import \"b.fin\"\n",
+				"b.fin":    "import \"a.fin\"\n",
+			},
+			want: "b.fin: import cycle at 1:1: a.fin -> b.fin -> a.fin",
+		},
+		{
+			name: "top-level statement",
+			files: map[string]string{
+				"main.fin": "import \"a.fin\"\n",
+				"a.fin":    "echo \"hi\"\n",
+			},
+			want: "a.fin: statement at 1:1: imported files may only declare functions and import files",
+		},
+		{
+			name: "conflict",
+			files: map[string]string{
+				"main.fin": "import \"a.fin\"\nfn greet\n    echo \"hi\"\nend\n",
+				"a.fin":    "fn greet\n    echo \"hello\"\nend\n",
+			},
+			want: `import "a.fin" at 1:1: function greet is already defined`,
+		},
+		{
+			name: "alias reused",
+			files: map[string]string{
+				"main.fin": "import \"a.fin\" as x\nimport \"b.fin\" as x\n",
+				"a.fin":    "",
+				"b.fin":    "",
+			},
+			want: `import "b.fin" at 2:1: alias x is already used`,
+		},
+		{
+			name: "undefined in imported file",
+			files: map[string]string{
+				"main.fin": "import \"a.fin\"\n",
+				"a.fin":    "fn greet\n    shout\nend\n",
+			},
+			want: "a.fin: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loaderFor(tt.files).Load("main.fin")
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("error = %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_FileErrorCarriesSource(t *testing.T) {
+	src := "fn greet\n    shout\nend\n"
+	_, err := loaderFor(map[string]string{
+		"main.fin":  "import \"lib/a.fin\"\n",
+		"lib/a.fin": src,
+	}).Load("main.fin")
+	var fe *FileError
+	if !errors.As(err, &fe) {
+		t.Fatalf("expected a *FileError, got %v", err)
+	}
+	if filepath.ToSlash(fe.File) != "lib/a.fin" || fe.Source != src {
+		t.Fatalf("file error = %q %q", fe.File, fe.Source)
+	}
+}
+
+func TestLoad_UniquePrefixes(t *testing.T) {
+	prog, err := loaderFor(map[string]string{
+		"main.fin": "import \"a/util.fin\" as a\n" +
+			"import \"b/Util.fin\" as b\n" +
+			"a.f\n" +
+			"b.f\n",
+		"a/util.fin": "fn f\n    echo \"a\"\nend\n",
+		"b/Util.fin": "fn f\n    echo \"b\"\nend\n",
+	}).Load("main.fin")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	out := ast.Format(prog)
+	for _, want := range []string{"CallStmt name=util.f", "CallStmt name=Util2.f", "FnDecl name=util.f", "FnDecl name=Util2.f"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in\n%s", want, out)
+		}
+	}
+}
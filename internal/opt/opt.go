@@ -0,0 +1,564 @@
+// Package opt simplifies an analyzed program before code generation. It folds
+// constant expressions, inlines variables that only ever hold one constant
+// and drops code that can never run. The optimized program behaves like the
+// original one, which is left unchanged.
+package opt
+
+import (
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/cmdexe"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+// Optimize returns an optimized copy of prog, which must have passed
+// semantic analysis. Comments are not kept.
+func Optimize(prog *ast.Program) *ast.Program {
+	if prog == nil {
+		return nil
+	}
+	o := &optimizer{writes: map[string]int{}, aggregates: map[string]bool{}}
+	o.countWrites(prog.Statements, "")
+	return &ast.Program{Statements: o.block(prog.Statements, nil), P: prog.P}
+}
+
+type optimizer struct {
+	// writes counts the statements that store each variable.
+	writes map[string]int
+	// aggregates holds the lists and maps, whose elements are stored as
+	// variables named after them.
+	aggregates map[string]bool
+}
+
+// env maps the constant variables readable at a statement to their values.
+type env map[string]ast.Expr
+
+// countWrites records the variables each statement of stmts stores. fn is
+// the function the statements belong to, whose result a return stores.
+func (o *optimizer) countWrites(stmts []ast.Statement, fn string) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.SetStmt:
+			o.write(s.Name, s.Value)
+		case *ast.AssignStmt:
+			o.write(s.Name, s.Value)
+		case *ast.CallStmt:
+			if sema.Modifies(s.Name) && len(s.Args) > 0 {
+				if id, ok := s.Args[0].(*ast.IdentExpr); ok {
+					o.writes[id.Name]++
+				}
+			}
+		case *ast.ReturnStmt:
+			o.writes["fn_"+fn+"_ret"]++
+		case *ast.ParamsStmt:
+			for _, p := range s.Params {
+				o.writes[p.Name]++
+			}
+		case *ast.FnDecl:
+			for _, p := range s.Params {
+				o.writes[p]++
+			}
+			o.countWrites(s.Body, s.Name)
+		case *ast.IfStmt:
+			o.countWrites(s.Then, fn)
+			for _, ei := range s.ElseIfs {
+				o.countWrites(ei.Body, fn)
+			}
+			o.countWrites(s.Else, fn)
+		case *ast.MatchStmt:
+			for _, c := range s.Cases {
+				o.countWrites(c.Body, fn)
+			}
+			o.countWrites(s.Default, fn)
+		case *ast.ForStmt:
+			o.writes[s.Var]++
+			o.countWrites(s.Body, fn)
+		case *ast.ForEachStmt:
+			o.writes[s.Key]++
+			o.writes[s.Var]++
+			o.countWrites(s.Body, fn)
+		case *ast.WhileStmt:
+			o.countWrites(s.Body, fn)
+		case *ast.UncheckedStmt:
+			o.countWrites(s.Body, fn)
+		case *ast.TryStmt:
+			o.writes[s.Err]++
+			o.aggregates[s.Err] = true
+			o.countWrites(s.Body, fn)
+			o.countWrites(s.Catch, fn)
+			o.countWrites(s.Finally, fn)
+		}
+	}
+}
+
+func (o *optimizer) write(name string, value ast.Expr) {
+	o.writes[name]++
+	switch v := value.(type) {
+	case *ast.ListLit, *ast.MapLit:
+		o.aggregates[name] = true
+	case *ast.CallExpr:
+		if sema.ReturnsList(v.Name) {
+			o.aggregates[name] = true
+		}
+	}
+}
+
+// constant reports whether name is stored by a single statement and is not
+// also an element of a list or map.
+func (o *optimizer) constant(name string) bool {
+	if o.writes[name] != 1 {
+		return false
+	}
+	for a := range o.aggregates {
+		if strings.HasPrefix(name, a+"_") {
+			return false
+		}
+	}
+	return true
+}
+
+// block optimizes stmts, dropping those after a return, break or continue.
+// A constant set in the block is inlined into the statements after it, which
+// only run once the set has.
+func (o *optimizer) block(stmts []ast.Statement, outer env) []ast.Statement {
+	consts := maps.Clone(outer)
+	if consts == nil {
+		consts = env{}
+	}
+	var out []ast.Statement
+	for _, stmt := range stmts {
+		out = append(out, o.stmt(stmt, consts)...)
+		if n := len(out); n > 0 && jumps(out[n-1]) {
+			break
+		}
+	}
+	return out
+}
+
+// jumps reports whether stmt always leaves its block.
+func jumps(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt:
+		return true
+	}
+	return false
+}
+
+// stmt optimizes a statement into the statements that replace it.
+func (o *optimizer) stmt(stmt ast.Statement, consts env) []ast.Statement {
+	switch s := stmt.(type) {
+	case *ast.SetStmt:
+		v := o.expr(s.Value, consts, true)
+		if o.constant(s.Name) && isLiteral(v) {
+			consts[s.Name] = v
+		}
+		return []ast.Statement{&ast.SetStmt{Name: s.Name, Value: v, P: s.P}}
+	case *ast.AssignStmt:
+		if s.Value == nil {
+			return []ast.Statement{s}
+		}
+		return []ast.Statement{&ast.AssignStmt{Name: s.Name, Op: s.Op, Value: o.expr(s.Value, consts, true), P: s.P}}
+	case *ast.EchoStmt:
+		return []ast.Statement{&ast.EchoStmt{Value: o.expr(s.Value, consts, true), P: s.P}}
+	case *ast.CallStmt:
+		return []ast.Statement{&ast.CallStmt{Name: s.Name, Args: o.args(s.Args, consts), P: s.P}}
+	case *ast.ReturnStmt:
+		if s.Value == nil {
+			return []ast.Statement{s}
+		}
+		return []ast.Statement{&ast.ReturnStmt{Value: o.expr(s.Value, consts, true), P: s.P}}
+	case *ast.FnDecl:
+		// A function can be called before any top-level set runs.
+		c := *s
+		c.Body = o.block(s.Body, nil)
+		return []ast.Statement{&c}
+	case *ast.IfStmt:
+		return o.ifStmt(s, consts)
+	case *ast.MatchStmt:
+		c := *s
+		c.Subject = o.expr(s.Subject, consts, true)
+		c.Cases = make([]ast.MatchCase, len(s.Cases))
+		for i, mc := range s.Cases {
+			mc.Body = o.block(mc.Body, consts)
+			c.Cases[i] = mc
+		}
+		c.Default = o.block(s.Default, consts)
+		return []ast.Statement{&c}
+	case *ast.ForStmt:
+		c := *s
+		c.Start = o.expr(s.Start, consts, false)
+		c.End = o.expr(s.End, consts, false)
+		c.Body = o.block(s.Body, consts)
+		return []ast.Statement{&c}
+	case *ast.ForEachStmt:
+		c := *s
+		c.Body = o.block(s.Body, consts)
+		return []ast.Statement{&c}
+	case *ast.WhileStmt:
+		cond := o.expr(s.Cond, consts, true)
+		if isLiteral(cond) && !truthy(cond) {
+			return nil
+		}
+		return []ast.Statement{&ast.WhileStmt{Cond: cond, Body: o.block(s.Body, consts), P: s.P}}
+	case *ast.UncheckedStmt:
+		return []ast.Statement{&ast.UncheckedStmt{Body: o.block(s.Body, consts), P: s.P}}
+	case *ast.TryStmt:
+		c := *s
+		c.Body = o.block(s.Body, consts)
+		c.Catch = o.block(s.Catch, consts)
+		c.Finally = o.block(s.Finally, consts)
+		return []ast.Statement{&c}
+	}
+	// Commands are left as written: a run quotes literal arguments only
+	// when they need it, but always quotes other values.
+	return []ast.Statement{stmt}
+}
+
+// ifStmt drops the branches whose condition is always false. A branch whose
+// condition is always true becomes the else block, and an if left with no
+// conditional branch is replaced by its else block.
+func (o *optimizer) ifStmt(s *ast.IfStmt, consts env) []ast.Statement {
+	type branch struct {
+		cond ast.Expr
+		body []ast.Statement
+		p    ast.Pos
+	}
+	branches := []branch{{s.Cond, s.Then, s.P}}
+	for _, ei := range s.ElseIfs {
+		branches = append(branches, branch{ei.Cond, ei.Body, ei.P})
+	}
+	var kept []branch
+	els := s.Else
+	for _, b := range branches {
+		cond := o.expr(b.cond, consts, true)
+		if isLiteral(cond) {
+			if truthy(cond) {
+				els = b.body
+				break
+			}
+			continue
+		}
+		kept = append(kept, branch{cond, o.block(b.body, consts), b.p})
+	}
+	els = o.block(els, consts)
+	if len(kept) == 0 {
+		return els
+	}
+	out := &ast.IfStmt{Cond: kept[0].cond, Then: kept[0].body, Else: els, P: s.P}
+	for _, b := range kept[1:] {
+		out.ElseIfs = append(out.ElseIfs, ast.ElseIf{Cond: b.cond, Body: b.body, P: b.p})
+	}
+	return []ast.Statement{out}
+}
+
+// args optimizes the arguments of a call. Strings are not inlined: a
+// variable holding one is passed differently from a string literal.
+func (o *optimizer) args(args []ast.Expr, consts env) []ast.Expr {
+	out := make([]ast.Expr, len(args))
+	for i, arg := range args {
+		out[i] = o.expr(arg, consts, false)
+	}
+	return out
+}
+
+// expr folds the constant parts of e and inlines the constant variables it
+// reads. Strings are inlined only when strs is set.
+func (o *optimizer) expr(e ast.Expr, consts env, strs bool) ast.Expr {
+	switch e := e.(type) {
+	case *ast.IdentExpr:
+		c, ok := consts[e.Name]
+		if !ok {
+			return e
+		}
+		if _, str := c.(*ast.StringLit); str && !strs {
+			return e
+		}
+		return withPos(c, e.P)
+	case *ast.StringLit:
+		return inlineString(e, consts)
+	case *ast.UnaryExpr:
+		return foldUnary(&ast.UnaryExpr{Op: e.Op, Right: o.expr(e.Right, consts, true), P: e.P})
+	case *ast.BinaryExpr:
+		return foldBinary(&ast.BinaryExpr{
+			Left:  o.expr(e.Left, consts, true),
+			Op:    e.Op,
+			Right: o.expr(e.Right, consts, true),
+			P:     e.P,
+		})
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{Left: e.Left, Index: o.expr(e.Index, consts, false), P: e.P}
+	case *ast.ExistsCond:
+		return &ast.ExistsCond{Path: o.expr(e.Path, consts, true), P: e.P}
+	case *ast.ListLit:
+		c := &ast.ListLit{Elements: make([]ast.Expr, len(e.Elements)), P: e.P}
+		for i, el := range e.Elements {
+			c.Elements[i] = o.expr(el, consts, true)
+		}
+		return c
+	case *ast.MapLit:
+		c := &ast.MapLit{Pairs: make([]ast.MapPair, len(e.Pairs)), P: e.P}
+		for i, p := range e.Pairs {
+			p.Value = o.expr(p.Value, consts, true)
+			c.Pairs[i] = p
+		}
+		return c
+	case *ast.CallExpr:
+		return &ast.CallExpr{Name: e.Name, Args: o.args(e.Args, consts), P: e.P}
+	}
+	return e
+}
+
+// foldUnary evaluates a unary operator applied to a literal.
+func foldUnary(e *ast.UnaryExpr) ast.Expr {
+	if !isLiteral(e.Right) {
+		return e
+	}
+	switch e.Op {
+	case "!":
+		return &ast.BoolLit{Value: !truthy(e.Right), P: e.P}
+	case "-":
+		if n, ok := number(e.Right); ok {
+			return numberLit(-n, e.P)
+		}
+	}
+	return e
+}
+
+// foldBinary evaluates a binary operator applied to literals. && and || are
+// also folded when their left operand decides the result, or when it does
+// not and the right operand is a bool.
+func foldBinary(e *ast.BinaryExpr) ast.Expr {
+	left, right := isLiteral(e.Left), isLiteral(e.Right)
+	switch e.Op {
+	case "&&", "||":
+		if !left {
+			return e
+		}
+		if truthy(e.Left) == (e.Op == "||") {
+			return &ast.BoolLit{Value: e.Op == "||", P: e.P}
+		}
+		if right {
+			return &ast.BoolLit{Value: truthy(e.Right), P: e.P}
+		}
+		if sema.TypeOf(e.Right, nil) == sema.TypeBool {
+			return e.Right
+		}
+		return e
+	}
+	if !left || !right {
+		return e
+	}
+	// Decimals are computed and compared by value at run time, where
+	// 1.5 == 1.50 holds.
+	if sema.TypeOf(e.Left, nil) == sema.TypeDecimal || sema.TypeOf(e.Right, nil) == sema.TypeDecimal {
+		return e
+	}
+	if b, ok := compare(e.Op, text(e.Left), text(e.Right)); ok {
+		return &ast.BoolLit{Value: b, P: e.P}
+	}
+	l, lok := number(e.Left)
+	r, rok := number(e.Right)
+	if !lok || !rok {
+		return e
+	}
+	var n int32
+	switch e.Op {
+	case "+":
+		n = l + r
+	case "-":
+		n = l - r
+	case "*":
+		n = l * r
+	case "/", "%":
+		// Division by zero is left to fail at run time.
+		if r == 0 {
+			return e
+		}
+		if e.Op == "/" {
+			n = l / r
+		} else {
+			n = l % r
+		}
+	case "<<":
+		n = l << (uint32(r) & 31)
+	case ">>":
+		n = l >> (uint32(r) & 31)
+	case "&":
+		n = l & r
+	case "|":
+		n = l | r
+	case "^":
+		n = l ^ r
+	case "**":
+		// An overflow is left to be reported at run time.
+		var ok bool
+		if n, ok = cmdexe.Pow(l, r); !ok {
+			return e
+		}
+	default:
+		return e
+	}
+	return numberLit(n, e.P)
+}
+
+// compare evaluates a comparison of two literal values the way the generated
+// batch does: ordering operators compare numbers, == and != compare text.
+// The result of an ordering is not known when the operands are not numbers.
+func compare(op, left, right string) (result, ok bool) {
+	ln, lok := cmdexe.ParseInt(left)
+	rn, rok := cmdexe.ParseInt(right)
+	switch op {
+	case "==", "!=":
+		return (left == right) == (op == "=="), true
+	case "<":
+		return ln < rn, lok && rok
+	case "<=":
+		return ln <= rn, lok && rok
+	case ">":
+		return ln > rn, lok && rok
+	case ">=":
+		return ln >= rn, lok && rok
+	}
+	return false, false
+}
+
+// inlineString splices the values of the constant variables a string reads
+// into its text. $name.field, $name[index] and $(...) are kept as written.
+func inlineString(e *ast.StringLit, consts env) ast.Expr {
+	if len(e.Vars) == 0 {
+		return e
+	}
+	s := e.Value
+	var b strings.Builder
+	inlined := map[string]bool{}
+	spans := ast.Substitutions(s)
+	for i := 0; i < len(s); {
+		if len(spans) > 0 && i == spans[0][0] {
+			b.WriteString(s[i:spans[0][1]])
+			i = spans[0][1]
+			spans = spans[1:]
+			continue
+		}
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		if s[i+1] == '$' {
+			b.WriteString("$$")
+			i += 2
+			continue
+		}
+		j := identEnd(s, i+1)
+		c, ok := consts[s[i+1:j]]
+		if j == i+1 || !ok || j < len(s) && (s[j] == '[' || s[j] == '.' && identEnd(s, j+1) > j+1) || joins(s[:i], text(c)) {
+			b.WriteString(s[i:j])
+			if j == i+1 {
+				b.WriteByte(s[j])
+				j++
+			}
+			i = j
+			continue
+		}
+		b.WriteString(strings.ReplaceAll(text(c), "$", "$$"))
+		inlined[s[i+1:j]] = true
+		i = j
+	}
+	if len(inlined) == 0 {
+		return e
+	}
+	out := &ast.StringLit{Value: b.String(), Calls: e.Calls, P: e.P}
+	for _, v := range e.Vars {
+		if id, ok := v.(*ast.IdentExpr); !ok || !inlined[id.Name] {
+			out.Vars = append(out.Vars, v)
+		}
+	}
+	return out
+}
+
+// joins reports whether v, spliced in after before, could be read as part of
+// a variable reference that ends before.
+func joins(before, v string) bool {
+	if before == "" || v == "" || identEnd("a"+before[len(before)-1:], 0) == 1 {
+		return false
+	}
+	return v[0] == '.' || v[0] == '[' || identEnd("a"+v[:1], 0) > 1
+}
+
+// identEnd returns the offset just past the identifier starting at offset i
+// in s, or i when there is none.
+func identEnd(s string, i int) int {
+	j := i
+	for j < len(s) {
+		c := s[j]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > i && c >= '0' && c <= '9' {
+			j++
+			continue
+		}
+		break
+	}
+	return j
+}
+
+// isLiteral reports whether e is a value known at compile time: a number,
+// a bool or a string without interpolation.
+func isLiteral(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.NumberLit, *ast.BoolLit:
+		return true
+	case *ast.StringLit:
+		return len(e.Vars) == 0 && len(e.Calls) == 0
+	}
+	return false
+}
+
+// text returns the runtime value of a literal.
+func text(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.NumberLit:
+		return e.Value
+	case *ast.BoolLit:
+		return strconv.FormatBool(e.Value)
+	case *ast.StringLit:
+		return strings.ReplaceAll(e.Value, "$$", "$")
+	}
+	return ""
+}
+
+// truthy reports whether a literal is true as a condition: every value but
+// an empty string, false and 0 is.
+func truthy(e ast.Expr) bool {
+	switch text(e) {
+	case "", "false", "0":
+		return false
+	}
+	return true
+}
+
+// number returns the value of a literal as `set /a` reads it.
+func number(e ast.Expr) (int32, bool) {
+	if _, ok := e.(*ast.NumberLit); !ok {
+		return 0, false
+	}
+	return cmdexe.ParseInt(text(e))
+}
+
+func numberLit(n int32, p ast.Pos) *ast.NumberLit {
+	return &ast.NumberLit{Value: strconv.Itoa(int(n)), P: p}
+}
+
+// withPos returns a copy of the literal c positioned at p.
+func withPos(c ast.Expr, p ast.Pos) ast.Expr {
+	switch c := c.(type) {
+	case *ast.NumberLit:
+		return &ast.NumberLit{Value: c.Value, P: p}
+	case *ast.BoolLit:
+		return &ast.BoolLit{Value: c.Value, P: p}
+	case *ast.StringLit:
+		return &ast.StringLit{Value: c.Value, P: p}
+	}
+	return c
+}
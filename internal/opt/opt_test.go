@@ -0,0 +1,128 @@
+package opt
+
+import (
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/format"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/parser"
+	"github.com/vishnunath-suresh/fin-project/internal/sema"
+)
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	p := parser.New(parser.CollectTokens(lexer.New(src)))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	if err := sema.New().Analyze(prog); err != nil {
+		t.Fatalf("sema errors: %v", err)
+	}
+	return prog
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "arithmetic",
+			src:  "set x 2 ** 10\nset y (1 + 2) * -3 - 10 / 4\nset z 2147483647 + 1\n",
+			want: "set x 1024\nset y -11\nset z -2147483648",
+		},
+		{
+			name: "division by zero and overflow are kept",
+			src:  "set x 1 / 0\nset y 2 ** 31\nset z (-2) ** 31\n",
+			want: "set x 1 / 0\nset y 2 ** 31\nset z -2147483648",
+		},
+		{
+			name: "remainder, shifts and bitwise operators",
+			src:  "set x 13 % 4 | 2 << 3\nset y 7 % 0\nset z -1 >> 28 ^ 5 & 3\nset n 1\nn += 2 * 3\nn++\necho $n\n",
+			want: "set x 17\nset y 7 % 0\nset z -2\nset n 1\nn += 6\nn++\necho $n",
+		},
+		{
+			name: "comparisons",
+			src: "set a 3 < 010\nset b \"x\" == \"x\"\nset c 1 != 2\n" +
+				"set d \"a\" == \"b\"\nset e 010 == 8\n",
+			want: "set a true\nset b true\nset c true\n" +
+				"set d false\nset e false",
+		},
+		{
+			name: "decimals are kept",
+			src:  "set a 1.5 == 1.50\nset b 0.1 + 0.2\nset c -2.5\nset r 1.5\nset d $r * 2 < 3\n",
+			want: "set a 1.5 == 1.50\nset b 0.1 + 0.2\nset c -2.5\nset r 1.5\nset d 1.5 * 2 < 3",
+		},
+		{
+			name: "logic",
+			src: "set n 1\nn = 2\n" +
+				"set a !true || $n > 1\nset b false && $n > 1\nset c true && \"\"\nset d $n > 1 && true\n",
+			want: "set n 1\nn = 2\n" +
+				"set a $n > 1\nset b false\nset c false\nset d $n > 1 && true",
+		},
+		{
+			name: "constant variables",
+			src: "set n 4\nset greeting \"hi $$5\"\nset m $n * $n\n" +
+				"echo \"$greeting, $m $$n\"\nset xs [1, 2, 3]\necho $xs[$n - 3]\n",
+			want: "set n 4\nset greeting \"hi $$5\"\nset m 16\n" +
+				"echo \"hi $$5, 16 $$n\"\nset xs [1, 2, 3]\necho $xs[1]",
+		},
+		{
+			name: "inlined text does not extend a reference",
+			src:  "set ext \".zip\"\nset n \"1\"\nset a \"x\"\na = \"y\"\necho \"$a$ext $a$n $a.$n\"\n",
+			want: "set ext \".zip\"\nset n \"1\"\nset a \"x\"\na = \"y\"\necho \"$a$ext $a$n $a.1\"",
+		},
+		{
+			name: "variables set twice are kept",
+			src:  "set n 1\necho $n\nn = $n + 1\necho $n\n",
+			want: "set n 1\necho $n\nn = $n + 1\necho $n",
+		},
+		{
+			name: "only after the set",
+			src: "set limit 3\nset i 0\nwhile $i < 2\n    set k 5\n    echo $k\n    if $i == 1\n        set j 1\n        echo $j + $limit\n    end\n    i = $i + 1\nend\n" +
+				"fn show\n    echo $limit\nend\nshow\n",
+			want: "set limit 3\nset i 0\nwhile $i < 2\n    set k 5\n    echo 5\n    if $i == 1\n        set j 1\n        echo 4\n    end\n    i = $i + 1\nend\n" +
+				"fn show\n    echo $limit\nend\nshow",
+		},
+		{
+			name: "strings are not inlined into calls",
+			src: "fn greet a b\n    echo \"$a $b\"\nend\n" +
+				"set who \"a b\"\nset times 2\ngreet $who $times\nset s (upper $who)\n",
+			want: "fn greet a b\n    echo \"$a $b\"\nend\n" +
+				"set who \"a b\"\nset times 2\ngreet $who 2\nset s (upper $who)",
+		},
+		{
+			name: "branches",
+			src: "set debug false\nset n 1\nn = 2\n" +
+				"if $debug\n    echo \"debug\"\nelse if $n > 1\n    echo \"big\"\nelse if true\n    echo \"small\"\nelse\n    echo \"never\"\nend\n" +
+				"if 1 > 2\n    echo \"no\"\nelse\n    echo \"yes\"\nend\n" +
+				"while false\n    echo \"no\"\nend\n",
+			want: "set debug false\nset n 1\nn = 2\n" +
+				"if $n > 1\n    echo \"big\"\nelse\n    echo \"small\"\nend\n" +
+				"echo \"yes\"",
+		},
+		{
+			name: "unreachable code",
+			src: "fn f n\n    if $n > 1\n        return 1\n        echo \"no\"\n    end\n    return 2\n    echo \"no\"\nend\n" +
+				"for i in 1..3\n    break\n    echo $i\nend\n",
+			want: "fn f n\n    if $n > 1\n        return 1\n    end\n    return 2\nend\n" +
+				"for i in 1 .. 3\n    break\nend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog := parse(t, tt.src)
+			before := ast.Format(prog)
+			got := format.Format(Optimize(prog))
+			if got != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if ast.Format(prog) != before {
+				t.Fatalf("the original program was modified")
+			}
+		})
+	}
+}
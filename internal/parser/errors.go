@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/token"
+)
+
+// ParseError is a syntax error at a specific token.
+type ParseError struct {
+	Msg      string
+	Tok      token.Token  // the offending token
+	Expected []token.Type // token types that would have been accepted, if known
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at %d:%d: %s, found %s", e.Tok.Line, e.Tok.Column, e.Msg, e.Found())
+}
+
+// Found describes the offending token, e.g. `end of line` or `number 1`.
+func (e *ParseError) Found() string { return describe(e.Tok) }
+
+// Pos returns the position of the offending token.
+func (e *ParseError) Pos() ast.Pos { return ast.Pos{Line: e.Tok.Line, Column: e.Tok.Column} }
+
+// describe names a token the way a user would refer to it in source.
+func describe(tok token.Token) string {
+	switch tok.Type {
+	case token.EOF:
+		return "end of file"
+	case token.NEWLINE:
+		return "end of line"
+	case token.IDENT:
+		return fmt.Sprintf("identifier %q", tok.Literal)
+	case token.NUMBER:
+		return fmt.Sprintf("number %s", tok.Literal)
+	case token.STRING:
+		return fmt.Sprintf("string %q", tok.Literal)
+	case token.ILLEGAL:
+		return fmt.Sprintf("illegal character %q", tok.Literal)
+	}
+	if _, ok := token.Keywords[tok.Literal]; ok {
+		return fmt.Sprintf("keyword %q", tok.Literal)
+	}
+	return fmt.Sprintf("%q", strings.TrimSpace(tok.Literal))
+}
+
+// errorAt records a parse error at tok.
+func (p *Parser) errorAt(tok token.Token, format string, args ...any) {
+	p.addError(&ParseError{Msg: fmt.Sprintf(format, args...), Tok: tok})
+}
+
+// errorExpected records a parse error at the current token, which is not one
+// of the expected types.
+func (p *Parser) errorExpected(msg string, expected ...token.Type) {
+	p.addError(&ParseError{Msg: msg, Tok: p.current(), Expected: expected})
+}
+
+// addError records err unless an error was already reported at the same
+// token, which is usually a consequence of the first.
+func (p *Parser) addError(err *ParseError) {
+	if n := len(p.errors); n > 0 {
+		if last, ok := p.errors[n-1].(*ParseError); ok && last.Pos() == err.Pos() {
+			return
+		}
+	}
+	p.errors = append(p.errors, err)
+}
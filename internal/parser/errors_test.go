@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
+	"github.com/vishnunath-suresh/fin-project/internal/token"
+)
+
+func TestParseErrors_CarryTokenAndPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		pos      ast.Pos
+		found    token.Type
+		expected []token.Type
+		msg      string
+	}{
+		{"set without name", "set\n", ast.Pos{Line: 1, Column: 4}, token.NEWLINE, []token.Type{token.IDENT},
+			"parse error at 1:4: expected identifier after set, found end of line"},
+		{"run without string", "run 5\n", ast.Pos{Line: 1, Column: 5}, token.NUMBER, []token.Type{token.STRING},
+			"parse error at 1:5: expected string after run, found number 5"},
+		{"if without end", "if $a\n    echo 1\n", ast.Pos{Line: 3, Column: 1}, token.EOF, []token.Type{token.ELSE, token.END},
+			"parse error at 3:1: expected end to close if, found end of file"},
+		{"else without end", "if $a\nelse\n", ast.Pos{Line: 3, Column: 1}, token.EOF, []token.Type{token.END},
+			"parse error at 3:1: expected end to close if, found end of file"},
+		{"for without in", "for i 1..2\nend\n", ast.Pos{Line: 1, Column: 7}, token.NUMBER, []token.Type{token.IN},
+			"parse error at 1:7: expected in after for variable, found number 1"},
+		{"fn with bad param", "fn f 1\nend\n", ast.Pos{Line: 1, Column: 6}, token.NUMBER, []token.Type{token.IDENT, token.NEWLINE},
+			"parse error at 1:6: expected newline after fn signature, found number 1"},
+		{"unclosed group", "set x (1 + 2\n", ast.Pos{Line: 1, Column: 13}, token.NEWLINE, []token.Type{token.RPAREN},
+			"parse error at 1:13: expected ), found end of line"},
+		{"map key", "set m {1: 2}\n", ast.Pos{Line: 1, Column: 8}, token.NUMBER, []token.Type{token.IDENT},
+			"parse error at 1:8: expected map key ident, found number 1"},
+		{"illegal", "echo 1 @ 2\n", ast.Pos{Line: 1, Column: 8}, token.ILLEGAL, nil,
+			"parse error at 1:8: illegal token: @, found illegal character \"@\""},
+		{"unexpected keyword", "end\n", ast.Pos{Line: 1, Column: 1}, token.END, nil,
+			"parse error at 1:1: unexpected token: END, found keyword \"end\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(CollectTokens(lexer.New(tt.src)))
+			p.ParseProgram()
+			if len(p.Errors()) == 0 {
+				t.Fatalf("expected a parse error")
+			}
+			var pe *ParseError
+			if !errors.As(p.Errors()[0], &pe) {
+				t.Fatalf("expected *ParseError, got %T", p.Errors()[0])
+			}
+			if pe.Pos() != tt.pos || pe.Tok.Type != tt.found {
+				t.Fatalf("error at %v on %s, want %v on %s", pe.Pos(), pe.Tok.Type, tt.pos, tt.found)
+			}
+			if !reflect.DeepEqual(pe.Expected, tt.expected) {
+				t.Fatalf("expected set = %v, want %v", pe.Expected, tt.expected)
+			}
+			if pe.Error() != tt.msg {
+				t.Fatalf("message = %q, want %q", pe.Error(), tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseErrors_ExpectedExpression(t *testing.T) {
+	p := New(CollectTokens(lexer.New("set x )\n")))
+	p.ParseProgram()
+	var pe *ParseError
+	if len(p.Errors()) != 1 || !errors.As(p.Errors()[0], &pe) {
+		t.Fatalf("expected one *ParseError, got %v", p.Errors())
+	}
+	if pe.Pos() != (ast.Pos{Line: 1, Column: 7}) || len(pe.Expected) == 0 {
+		t.Fatalf("unexpected error %+v", pe)
+	}
+	for _, want := range []token.Type{token.IDENT, token.NUMBER, token.STRING, token.LPAREN} {
+		found := false
+		for _, typ := range pe.Expected {
+			found = found || typ == want
+		}
+		if !found {
+			t.Fatalf("expected set %v is missing %s", pe.Expected, want)
+		}
+	}
+}
+
+func TestParseErrors_CallArgumentThatIsNoExpression(t *testing.T) {
+	for _, src := range []string{"set count 1\ncount +\n", "set x 2\n$x * $x\necho 1\n"} {
+		p := New(CollectTokens(lexer.New(src)))
+		prog := p.ParseProgram()
+		var pe *ParseError
+		if len(p.Errors()) != 1 || !errors.As(p.Errors()[0], &pe) || pe.Pos().Line != 2 {
+			t.Fatalf("%q: expected one *ParseError on line 2, got %v", src, p.Errors())
+		}
+		if len(prog.Statements) != strings.Count(src, "\n")-1 {
+			t.Fatalf("%q: expected the other statements, got %d", src, len(prog.Statements))
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package parser
 
 import (
-	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
+	"github.com/vishnunath-suresh/fin-project/internal/lexer"
 	"github.com/vishnunath-suresh/fin-project/internal/token"
 )
 
@@ -16,11 +19,15 @@ var precedences = map[token.Type]int{
 	token.AND:  2,
 	token.EQEQ: 3, token.NOTEQ: 3,
 	token.LT: 4, token.LTE: 4, token.GT: 4, token.GTE: 4,
-	token.PLUS: 5, token.MINUS: 5,
-	token.STAR: 6, token.SLASH: 6,
-	token.DOT:      7,
-	token.LBRACKET: 7, // index has high precedence
-	token.POW:      8, // highest, right-associative
+	token.PIPE:  5,
+	token.CARET: 6,
+	token.AMP:   7,
+	token.SHL:   8, token.SHR: 8,
+	token.PLUS: 9, token.MINUS: 9,
+	token.STAR: 10, token.SLASH: 10, token.PERCENT: 10,
+	token.DOT:      11,
+	token.LBRACKET: 11, // index has high precedence
+	token.POW:      12, // highest, right-associative
 }
 
 var prefixParseFns map[token.Type]prefixParseFn
@@ -41,6 +48,16 @@ func init() {
 	}
 }
 
+// prefixTypes returns the token types that can start an expression.
+func prefixTypes() []token.Type {
+	types := make([]token.Type, 0, len(prefixParseFns))
+	for t := range prefixParseFns {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
 type prefixParseFn func(*Parser) ast.Expr
 type infixParseFn func(*Parser, ast.Expr) ast.Expr
 
@@ -48,7 +65,7 @@ type infixParseFn func(*Parser, ast.Expr) ast.Expr
 func (p *Parser) parseExpression(precedence int) ast.Expr {
 	prefix := prefixParseFns[p.current().Type]
 	if prefix == nil {
-		p.errors = append(p.errors, fmt.Errorf("no prefix parse function for %s", p.current().Type))
+		p.errorExpected("expected expression", prefixTypes()...)
 		return nil
 	}
 
@@ -73,7 +90,8 @@ func (p *Parser) parseExpression(precedence int) ast.Expr {
 
 func (p *Parser) infixFn(t token.Type) infixParseFn {
 	switch t {
-	case token.PLUS, token.MINUS, token.STAR, token.SLASH,
+	case token.PLUS, token.MINUS, token.STAR, token.SLASH, token.PERCENT,
+		token.AMP, token.PIPE, token.CARET, token.SHL, token.SHR,
 		token.EQEQ, token.NOTEQ,
 		token.LT, token.LTE, token.GT, token.GTE,
 		token.AND, token.OR,
@@ -109,7 +127,114 @@ func parseNumber(p *Parser) ast.Expr {
 
 func parseString(p *Parser) ast.Expr {
 	tok := p.next()
-	return &ast.StringLit{Value: tok.Literal, P: ast.Pos{Line: tok.Line, Column: tok.Column}}
+	lit := &ast.StringLit{Value: tok.Literal, P: ast.Pos{Line: tok.Line, Column: tok.Column}}
+	spans := ast.Substitutions(tok.Literal)
+	for _, span := range spans {
+		if call := p.parseSubstitution(tok, span); call != nil {
+			lit.Calls = append(lit.Calls, call)
+		}
+	}
+	lit.Vars = p.parseInterpolation(tok, spans)
+	return lit
+}
+
+// parseInterpolation parses the variable references in the value of the
+// string token tok, outside the $(...) spans: $name, $name.field, and
+// $name[index] where index is a number or a variable, written with or
+// without its $. They are positioned like the calls of parseSubstitution.
+func (p *Parser) parseInterpolation(tok token.Token, spans [][2]int) []ast.Expr {
+	s := tok.Literal
+	pos := func(i int) ast.Pos {
+		return ast.Pos{Line: tok.Line, Column: tok.Column + 1 + utf8.RuneCountInString(s[:i])}
+	}
+	var vars []ast.Expr
+	for i := 0; i < len(s); i++ {
+		if len(spans) > 0 && i == spans[0][0] {
+			i = spans[0][1] - 1
+			spans = spans[1:]
+			continue
+		}
+		if s[i] != '$' || i+1 >= len(s) {
+			continue
+		}
+		if s[i+1] == '$' {
+			i++
+			continue
+		}
+		end := identEnd(s, i+1)
+		if end == i+1 {
+			continue
+		}
+		var ref ast.Expr = &ast.IdentExpr{Name: s[i+1 : end], P: pos(i)}
+		switch {
+		case end < len(s) && s[end] == '.' && identEnd(s, end+1) > end+1:
+			field := identEnd(s, end+1)
+			ref = &ast.PropertyExpr{Object: ref, Field: s[end+1 : field], P: pos(end)}
+			end = field
+		case end < len(s) && s[end] == '[' && strings.IndexByte(s[end:], ']') > 0:
+			close := end + strings.IndexByte(s[end:], ']')
+			ref = &ast.IndexExpr{Left: ref, Index: p.interpolatedIndex(s[end+1:close], pos(end+1)), P: pos(end)}
+			end = close + 1
+		}
+		vars = append(vars, ref)
+		i = end - 1
+	}
+	return vars
+}
+
+// interpolatedIndex parses the index text of a $name[index] reference
+// starting at pos.
+func (p *Parser) interpolatedIndex(text string, pos ast.Pos) ast.Expr {
+	if text != "" && strings.Trim(text, "0123456789") == "" {
+		return &ast.NumberLit{Value: text, P: pos}
+	}
+	name := strings.TrimPrefix(text, "$")
+	if name == "" || identEnd(name, 0) != len(name) {
+		p.errorAt(token.New(token.STRING, text, pos.Line, pos.Column), "expected a number or a variable as the index in a string")
+		return nil
+	}
+	return &ast.IdentExpr{Name: name, P: pos}
+}
+
+// identEnd returns the offset just past the identifier starting at offset
+// i in s, or i when there is none.
+func identEnd(s string, i int) int {
+	j := i
+	for j < len(s) {
+		c := s[j]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > i && c >= '0' && c <= '9' {
+			j++
+			continue
+		}
+		break
+	}
+	return j
+}
+
+// parseSubstitution parses the $(...) call at span in the value of the string
+// token tok. Its tokens are positioned as if the value were unescaped source
+// text, which is exact unless the string contains escapes before the span.
+func (p *Parser) parseSubstitution(tok token.Token, span [2]int) *ast.CallExpr {
+	src := tok.Literal[span[0]+1 : span[1]]
+	col := tok.Column + 1 + utf8.RuneCountInString(tok.Literal[:span[0]+1])
+	tokens := CollectTokens(lexer.New(src))
+	for i := range tokens {
+		if tokens[i].Line == 1 {
+			tokens[i].Column += col - 1
+		}
+		tokens[i].Line += tok.Line - 1
+	}
+	sub := New(tokens)
+	call, ok := parseGrouped(sub).(*ast.CallExpr)
+	switch {
+	case len(sub.errors) > 0:
+	case !ok:
+		sub.errorAt(tokens[0], "expected function call in $(...)")
+	case !sub.check(token.EOF):
+		sub.errorExpected("expected ) to end substitution", token.RPAREN)
+	}
+	p.errors = append(p.errors, sub.errors...)
+	return call
 }
 
 func parseBool(p *Parser) ast.Expr {
@@ -120,28 +245,75 @@ func parseBool(p *Parser) ast.Expr {
 
 func parseExists(p *Parser) ast.Expr {
 	tok := p.next() // consume 'exists'
-	path := p.parseExpression(0)
+	// The path ends before any comparison or boolean operator, so
+	// `exists "a" && $b` tests "a".
+	path := p.parseExpression(precedences[token.LT])
 	return &ast.ExistsCond{Path: path, P: ast.Pos{Line: tok.Line, Column: tok.Column}}
 }
 
 func parseUnary(p *Parser) ast.Expr {
 	tok := p.next()
-	const prefixPrecedence = 7 // higher than multiplicative to bind unary tightly
+	const prefixPrecedence = 11 // higher than multiplicative to bind unary tightly
 	right := p.parseExpression(prefixPrecedence)
 	return &ast.UnaryExpr{Op: tok.Literal, Right: right, P: ast.Pos{Line: tok.Line, Column: tok.Column}}
 }
 
+// parseGrouped parses a parenthesized expression, or a call expression when
+// the parentheses open with a bare function name: `(add $a 1)`, or a command
+// capture when they open with run: `(run "git rev-parse HEAD")`.
 func parseGrouped(p *Parser) ast.Expr {
 	p.next() // consume '('
+	if tok := p.current(); tok.Type == token.IDENT && !tok.Var {
+		return parseCall(p)
+	}
+	if p.check(token.RUN) {
+		return parseRunExpr(p)
+	}
 	expr := p.parseExpression(0)
 	if !p.check(token.RPAREN) {
-		p.errors = append(p.errors, fmt.Errorf("expected )"))
+		p.errorExpected("expected )", token.RPAREN)
 		return expr
 	}
 	p.next() // consume ')'
 	return expr
 }
 
+// parseCall parses `name args... )` after the opening parenthesis. Each
+// argument is a full expression, as in a call statement.
+func parseCall(p *Parser) ast.Expr {
+	nameTok := p.parseCallName()
+	call := &ast.CallExpr{Name: nameTok.Literal, P: ast.Pos{Line: nameTok.Line, Column: nameTok.Column}}
+	for !p.check(token.RPAREN) && !p.check(token.NEWLINE) && !p.isAtEnd() {
+		arg := p.parseExpression(0)
+		if arg == nil {
+			return call
+		}
+		call.Args = append(call.Args, arg)
+	}
+	if !p.check(token.RPAREN) {
+		p.errorExpected("expected ) after call arguments", token.RPAREN)
+		return call
+	}
+	p.next() // consume ')'
+	return call
+}
+
+// parseRunExpr parses `run "cmd" args... )` after the opening parenthesis.
+func parseRunExpr(p *Parser) ast.Expr {
+	runTok := p.next() // consume 'run'
+	run := &ast.RunExpr{P: ast.Pos{Line: runTok.Line, Column: runTok.Column}}
+	var ok bool
+	if run.Command, run.Args, ok = p.parseRunCommand(); !ok {
+		return run
+	}
+	if !p.check(token.RPAREN) {
+		p.errorExpected("expected ) after run arguments", token.RPAREN)
+		return run
+	}
+	p.next() // consume ')'
+	return run
+}
+
 func parseList(p *Parser) ast.Expr {
 	lTok := p.next() // consume '['
 	var elems []ast.Expr
@@ -160,7 +332,7 @@ func parseList(p *Parser) ast.Expr {
 			p.next()
 			continue
 		}
-		p.errors = append(p.errors, fmt.Errorf("expected , or ] in list"))
+		p.errorExpected("expected , or ] in list", token.COMMA, token.RBRACKET)
 		break
 	}
 	return &ast.ListLit{Elements: elems, P: ast.Pos{Line: lTok.Line, Column: lTok.Column}}
@@ -175,12 +347,12 @@ func parseMap(p *Parser) ast.Expr {
 	}
 	for {
 		if !p.check(token.IDENT) {
-			p.errors = append(p.errors, fmt.Errorf("expected map key ident"))
+			p.errorExpected("expected map key ident", token.IDENT)
 			break
 		}
 		keyTok := p.next()
 		if !p.check(token.COLON) {
-			p.errors = append(p.errors, fmt.Errorf("expected : after map key"))
+			p.errorExpected("expected : after map key", token.COLON)
 			break
 		}
 		p.next() // consume ':'
@@ -195,7 +367,7 @@ func parseMap(p *Parser) ast.Expr {
 			p.next()
 			continue
 		}
-		p.errors = append(p.errors, fmt.Errorf("expected , or } in map"))
+		p.errorExpected("expected , or } in map", token.COMMA, token.RBRACE)
 		break
 	}
 	return &ast.MapLit{Pairs: pairs, P: ast.Pos{Line: mTok.Line, Column: mTok.Column}}
@@ -221,7 +393,7 @@ func parseIndex(p *Parser, left ast.Expr) ast.Expr {
 	p.next() // consume '['
 	index := p.parseExpression(0)
 	if !p.check(token.RBRACKET) {
-		p.errors = append(p.errors, fmt.Errorf("expected ] after index expression"))
+		p.errorExpected("expected ] after index expression", token.RBRACKET)
 	} else {
 		p.next()
 	}
@@ -232,7 +404,7 @@ func parseProperty(p *Parser, left ast.Expr) ast.Expr {
 	dotTok := p.current()
 	p.next() // consume '.'
 	if !p.check(token.IDENT) {
-		p.errors = append(p.errors, fmt.Errorf("expected property name after ."))
+		p.errorExpected("expected property name after .", token.IDENT)
 		return left
 	}
 	nameTok := p.next()
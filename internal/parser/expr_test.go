@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
@@ -226,6 +228,59 @@ func TestParseExpression_ExponentRightAssociative(t *testing.T) {
 	}
 }
 
+func TestParseExpression_BitwisePrecedence(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"1 | 2 ^ 3 & 4", "(1 | (2 ^ (3 & 4)))"},
+		{"1 & 2 << 3 + 4", "(1 & (2 << (3 + 4)))"},
+		{"1 + 2 % 3 * 4", "(1 + ((2 % 3) * 4))"},
+		{"$a & 1 == 1 || $b >> 2 > 0", "(((a & 1) == 1) || ((b >> 2) > 0))"},
+		{"1 << 2 >> 3", "((1 << 2) >> 3)"},
+		{"-1 % 2 ** 3", "((-1) % (2 ** 3))"},
+	}
+	for _, tt := range tests {
+		expr, p := parseExprWithParser(t, tt.src)
+		if errs := p.Errors(); len(errs) > 0 {
+			t.Fatalf("%s: unexpected errors: %v", tt.src, errs)
+		}
+		if got := parenthesize(expr); got != tt.want {
+			t.Errorf("%s parsed as %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseExpression_Decimal(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"3.14 * 2", "(3.14 * 2)"},
+		{"-0.5 + 10.25", "((-0.5) + 10.25)"},
+		{"007.50 / 1.0", "(007.50 / 1.0)"},
+	}
+	for _, tt := range tests {
+		expr, p := parseExprWithParser(t, tt.src)
+		if errs := p.Errors(); len(errs) > 0 {
+			t.Fatalf("%s: unexpected errors: %v", tt.src, errs)
+		}
+		if got := parenthesize(expr); got != tt.want {
+			t.Errorf("%s parsed as %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+// parenthesize renders an arithmetic expression with every operation
+// grouped, to show how it was parsed.
+func parenthesize(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.BinaryExpr:
+		return "(" + parenthesize(e.Left) + " " + e.Op + " " + parenthesize(e.Right) + ")"
+	case *ast.UnaryExpr:
+		return "(" + e.Op + parenthesize(e.Right) + ")"
+	case *ast.NumberLit:
+		return e.Value
+	case *ast.IdentExpr:
+		return e.Name
+	}
+	return fmt.Sprintf("%T", e)
+}
+
 func TestParseExpression_Malformed_NoPanic(t *testing.T) {
 	l := lexer.New("!")
 	toks := CollectTokens(l)
@@ -270,3 +325,131 @@ func TestParseExpression_MapMultipleKeys(t *testing.T) {
 		t.Fatalf("map keys wrong: %q %q", mapLit.Pairs[0].Key, mapLit.Pairs[1].Key)
 	}
 }
+
+func TestParseExpression_CallExpr(t *testing.T) {
+	expr, p := parseExprWithParser(t, "(add $a (neg 1)) * 2")
+	if len(p.Errors()) > 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	bin := requireBinary(t, expr)
+	call, ok := bin.Left.(*ast.CallExpr)
+	if !ok {
+		t.Fatalf("left not CallExpr: %T", bin.Left)
+	}
+	if call.Name != "add" || len(call.Args) != 2 || call.P != (ast.Pos{Line: 1, Column: 2}) {
+		t.Fatalf("unexpected call %+v", call)
+	}
+	if id, ok := call.Args[0].(*ast.IdentExpr); !ok || id.Name != "a" {
+		t.Fatalf("arg[0] not $a: %T", call.Args[0])
+	}
+	if inner, ok := call.Args[1].(*ast.CallExpr); !ok || inner.Name != "neg" || len(inner.Args) != 1 {
+		t.Fatalf("arg[1] not a nested call: %T", call.Args[1])
+	}
+}
+
+func TestParseExpression_GroupedVariableIsNotCall(t *testing.T) {
+	expr, p := parseExprWithParser(t, "($a + 1)")
+	if len(p.Errors()) > 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	requireBinary(t, expr)
+
+	expr, p = parseExprWithParser(t, "(f)")
+	if len(p.Errors()) > 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	if call, ok := expr.(*ast.CallExpr); !ok || call.Name != "f" || len(call.Args) != 0 {
+		t.Fatalf("expected zero-argument call, got %T", expr)
+	}
+}
+
+func TestParseExpression_UnclosedCall(t *testing.T) {
+	_, p := parseExprWithParser(t, "(add 1 2\n")
+	if len(p.Errors()) != 1 {
+		t.Fatalf("expected one error, got %v", p.Errors())
+	}
+	if got := p.Errors()[0].Error(); got != "parse error at 1:9: expected ) after call arguments, found end of line" {
+		t.Fatalf("unexpected error %q", got)
+	}
+}
+
+func TestParseExpression_RunCapture(t *testing.T) {
+	expr, p := parseExprWithParser(t, `(run "git rev-parse" $ref (f 1))`)
+	if len(p.Errors()) > 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	run, ok := expr.(*ast.RunExpr)
+	if !ok {
+		t.Fatalf("expr not RunExpr: %T", expr)
+	}
+	if run.P != (ast.Pos{Line: 1, Column: 2}) || len(run.Args) != 2 {
+		t.Fatalf("unexpected capture %+v", run)
+	}
+	if _, ok := run.Args[1].(*ast.CallExpr); !ok {
+		t.Fatalf("arg[1] not a call: %T", run.Args[1])
+	}
+
+	_, p = parseExprWithParser(t, "(run \"ls\"\n")
+	if len(p.Errors()) != 1 || p.Errors()[0].Error() != "parse error at 1:10: expected ) after run arguments, found end of line" {
+		t.Fatalf("unexpected errors %v", p.Errors())
+	}
+}
+
+func TestParseExpression_StringSubstitutions(t *testing.T) {
+	expr, p := parseExprWithParser(t, `"a $(add 1 (neg $x)) b $$(c) $(f)"`)
+	if len(p.Errors()) > 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	str := expr.(*ast.StringLit)
+	if len(str.Calls) != 2 {
+		t.Fatalf("expected 2 calls, got %d", len(str.Calls))
+	}
+	add := str.Calls[0]
+	if add.Name != "add" || len(add.Args) != 2 || add.P != (ast.Pos{Line: 1, Column: 6}) {
+		t.Fatalf("unexpected first call %+v", add)
+	}
+	if x := add.Args[1].(*ast.CallExpr).Args[0]; x.Pos() != (ast.Pos{Line: 1, Column: 17}) {
+		t.Fatalf("nested argument at %v, want 1:17", x.Pos())
+	}
+	if str.Calls[1].Name != "f" {
+		t.Fatalf("unexpected second call %+v", str.Calls[1])
+	}
+}
+
+func TestParseExpression_StringSubstitutionErrors(t *testing.T) {
+	tests := map[string]string{
+		`"$($x)"`:    "parse error at 1:3: expected function call in $(...), found \"(\"",
+		`"$(f 1 +)"`: "parse error at 1:9: expected expression, found \")\"",
+	}
+	for src, want := range tests {
+		_, p := parseExprWithParser(t, src)
+		if len(p.Errors()) != 1 || p.Errors()[0].Error() != want {
+			t.Errorf("%s: got %v, want %q", src, p.Errors(), want)
+		}
+	}
+}
+
+func TestParseExpression_StringVariables(t *testing.T) {
+	expr, p := parseExprWithParser(t, `"é $a, $b.name $$c $(f $d) $e[2]$f[$i] 5$"`)
+	if len(p.Errors()) > 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+	var got []string
+	for _, v := range expr.(*ast.StringLit).Vars {
+		got = append(got, fmt.Sprintf("%T@%d:%d", v, v.Pos().Line, v.Pos().Column))
+	}
+	want := "*ast.IdentExpr@1:4 *ast.PropertyExpr@1:10 *ast.IndexExpr@1:30 *ast.IndexExpr@1:35"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("vars = %s, want %s", strings.Join(got, " "), want)
+	}
+	idx := expr.(*ast.StringLit).Vars[3].(*ast.IndexExpr)
+	if i, ok := idx.Index.(*ast.IdentExpr); !ok || i.Name != "i" || i.P != (ast.Pos{Line: 1, Column: 36}) {
+		t.Fatalf("unexpected index %+v", idx.Index)
+	}
+
+	_, p = parseExprWithParser(t, `"$xs[a b]"`)
+	want = "parse error at 1:6: expected a number or a variable as the index in a string, found string \"a b\""
+	if len(p.Errors()) != 1 || p.Errors()[0].Error() != want {
+		t.Fatalf("unexpected errors %v", p.Errors())
+	}
+}
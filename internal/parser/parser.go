@@ -1,8 +1,6 @@
 package parser
 
 import (
-	"fmt"
-
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
 	"github.com/vishnunath-suresh/fin-project/internal/token"
 )
@@ -12,11 +10,28 @@ type Parser struct {
 	tokens []token.Token
 	pos    int
 	errors []error
+
+	comments []token.Token // COMMENT tokens, in source order
+	nextCmt  int           // index of the first comment not yet attached
+	lastLine int           // line of the last consumed token or comment
+	trivia   map[ast.Node]*ast.Trivia
+	first    bool // parsing the first top-level statement, where strict may appear
+	top      bool // parsing a top-level statement, where import may appear
+	params   bool // a params block has been parsed
 }
 
-// New creates a parser from a token slice.
+// New creates a parser from a token slice. COMMENT tokens are set aside and
+// attached to the statements around them as ast.Trivia.
 func New(tokens []token.Token) *Parser {
-	return &Parser{tokens: tokens, pos: 0}
+	p := &Parser{pos: 0}
+	for _, tok := range tokens {
+		if tok.Type == token.COMMENT {
+			p.comments = append(p.comments, tok)
+			continue
+		}
+		p.tokens = append(p.tokens, tok)
+	}
+	return p
 }
 
 // current returns the token at the current position safely (EOF if out of bounds).
@@ -36,6 +51,9 @@ func (p *Parser) next() token.Token {
 	if tok.Type != token.EOF && p.pos < len(p.tokens) {
 		p.pos++
 	}
+	if tok.Type != token.NEWLINE && tok.Type != token.EOF {
+		p.lastLine = tok.Line
+	}
 	return tok
 }
 
@@ -86,7 +104,9 @@ func (p *Parser) ParseProgram() *ast.Program {
 			continue
 		}
 
-		stmt := p.parseStatement()
+		p.first = len(prog.Statements) == 0 && len(p.errors) == 0
+		p.top = true
+		stmt := p.parseStatementWithTrivia()
 		if stmt != nil {
 			prog.Statements = append(prog.Statements, stmt)
 			continue
@@ -95,16 +115,85 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.synchronize()
 	}
 
+	p.setTrivia(prog, &ast.Trivia{Footers: [][]ast.Comment{p.commentsBefore(p.current())}})
+	prog.Trivia = p.trivia
 	return prog
 }
 
+// parseStatementWithTrivia parses a statement together with the comments
+// above it and the comment at the end of its last line.
+func (p *Parser) parseStatementWithTrivia() ast.Statement {
+	leading := p.commentsBefore(p.current())
+	blank := p.current().Line > p.lastLine+1
+	stmt := p.parseStatement()
+	if stmt == nil {
+		return nil
+	}
+	p.setTrivia(stmt, &ast.Trivia{Blank: blank, Leading: leading, Trailing: p.trailingComment()})
+	return stmt
+}
+
+// commentsBefore takes the unattached comments that precede tok.
+func (p *Parser) commentsBefore(tok token.Token) []ast.Comment {
+	var out []ast.Comment
+	for p.nextCmt < len(p.comments) {
+		c := p.comments[p.nextCmt]
+		if tok.Type != token.EOF && (c.Line > tok.Line || c.Line == tok.Line && c.Column > tok.Column) {
+			break
+		}
+		out = append(out, ast.Comment{Text: c.Literal, Blank: c.Line > p.lastLine+1, P: ast.Pos{Line: c.Line, Column: c.Column}})
+		p.lastLine = c.Line
+		p.nextCmt++
+	}
+	return out
+}
+
+// trailingComment takes the next comment if it is on the line of the last
+// consumed token.
+func (p *Parser) trailingComment() *ast.Comment {
+	if p.nextCmt >= len(p.comments) || p.comments[p.nextCmt].Line != p.lastLine {
+		return nil
+	}
+	c := p.comments[p.nextCmt]
+	p.nextCmt++
+	return &ast.Comment{Text: c.Literal, P: ast.Pos{Line: c.Line, Column: c.Column}}
+}
+
+// setTrivia merges tr into the trivia recorded for n. Empty trivia is not
+// recorded, so programs without comments keep a nil map.
+func (p *Parser) setTrivia(n ast.Node, tr *ast.Trivia) {
+	if existing := p.trivia[n]; existing != nil {
+		existing.Blank = existing.Blank || tr.Blank
+		existing.Leading = append(existing.Leading, tr.Leading...)
+		if tr.Header != nil {
+			existing.Header = tr.Header
+		}
+		if tr.Trailing != nil {
+			existing.Trailing = tr.Trailing
+		}
+		existing.Footers = append(existing.Footers, tr.Footers...)
+		return
+	}
+	empty := !tr.Blank && len(tr.Leading) == 0 && tr.Header == nil && tr.Trailing == nil
+	for _, f := range tr.Footers {
+		empty = empty && len(f) == 0
+	}
+	if empty {
+		return
+	}
+	if p.trivia == nil {
+		p.trivia = make(map[ast.Node]*ast.Trivia)
+	}
+	p.trivia[n] = tr
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	tok := p.current()
 	if tok.Type == token.EOF {
 		return nil
 	}
 	if tok.Type == token.ILLEGAL {
-		p.errors = append(p.errors, fmt.Errorf("illegal token: %s", tok.Literal))
+		p.errorAt(tok, "illegal token: %s", tok.Literal)
 		p.next()
 		return nil
 	}
@@ -120,6 +209,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseReturn()
 	case token.IF:
 		return p.parseIf()
+	case token.MATCH:
+		return p.parseMatch()
 	case token.FOR:
 		return p.parseFor()
 	case token.WHILE:
@@ -130,14 +221,27 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseBreak()
 	case token.CONTINUE:
 		return p.parseContinue()
+	case token.STRICT:
+		return p.parseStrict()
+	case token.UNCHECKED:
+		return p.parseUnchecked()
+	case token.TRY:
+		return p.parseTry()
+	case token.IMPORT:
+		return p.parseImport()
+	case token.PARAMS:
+		return p.parseParams()
 	case token.IDENT:
 		// lookahead for assignment
-		if next := p.peek(); next.Type == token.ASSIGN {
+		switch p.peek().Type {
+		case token.ASSIGN, token.PLUSEQ, token.MINUSEQ, token.STAREQ, token.SLASHEQ, token.PERCENTEQ:
 			return p.parseAssign()
+		case token.INC, token.DEC:
+			return p.parseIncDec()
 		}
 		return p.parseCall()
 	default:
-		p.errors = append(p.errors, fmt.Errorf("unexpected token: %s", tok.Type))
+		p.errorAt(tok, "unexpected token: %s", tok.Type)
 		p.next()
 		return nil
 	}
@@ -150,16 +254,26 @@ func (p *Parser) peek() token.Token {
 	return p.tokens[p.pos+1]
 }
 
+// parseAssign parses `x = value` and the compound assignments `x += value`,
+// `x -= value`, `x *= value`, `x /= value` and `x %= value`.
 func (p *Parser) parseAssign() ast.Statement {
 	nameTok := p.next() // ident
-	assignTok, ok := p.expect(token.ASSIGN)
-	if !ok {
-		p.errors = append(p.errors, fmt.Errorf("expected '=' after identifier"))
-		return nil
+	assignTok := p.next()
+	op := ""
+	if assignTok.Type != token.ASSIGN {
+		op = assignTok.Literal
 	}
 	val := p.parseExpression(0)
 	p.consumeNewlineIfPresent()
-	return &ast.AssignStmt{Name: nameTok.Literal, Value: val, P: ast.Pos{Line: assignTok.Line, Column: assignTok.Column}}
+	return &ast.AssignStmt{Name: nameTok.Literal, Op: op, Value: val, P: ast.Pos{Line: assignTok.Line, Column: assignTok.Column}}
+}
+
+// parseIncDec parses `x++` and `x--`.
+func (p *Parser) parseIncDec() ast.Statement {
+	nameTok := p.next() // ident
+	opTok := p.next()
+	p.consumeNewlineIfPresent()
+	return &ast.AssignStmt{Name: nameTok.Literal, Op: opTok.Literal, P: ast.Pos{Line: opTok.Line, Column: opTok.Column}}
 }
 
 // synchronize advances until after a newline or EOF to recover from an error.
@@ -185,7 +299,7 @@ func (p *Parser) parseSet() ast.Statement {
 	setTok := p.next() // consume 'set'
 	nameTok, ok := p.expect(token.IDENT)
 	if !ok {
-		p.errors = append(p.errors, fmt.Errorf("expected identifier after set"))
+		p.errorExpected("expected identifier after set", token.IDENT)
 		return nil
 	}
 	val := p.parseExpression(0)
@@ -205,13 +319,31 @@ func (p *Parser) parseEcho() ast.Statement {
 
 func (p *Parser) parseRun() ast.Statement {
 	runTok := p.next() // consume 'run'
-	cmdTok, ok := p.expect(token.STRING)
+	cmd, args, ok := p.parseRunCommand()
 	if !ok {
-		p.errors = append(p.errors, fmt.Errorf("expected string after run"))
 		return nil
 	}
 	p.consumeNewlineIfPresent()
-	return &ast.RunStmt{Command: &ast.StringLit{Value: cmdTok.Literal, P: ast.Pos{Line: cmdTok.Line, Column: cmdTok.Column}}, P: ast.Pos{Line: runTok.Line, Column: runTok.Column}}
+	return &ast.RunStmt{Command: cmd, Args: args, P: ast.Pos{Line: runTok.Line, Column: runTok.Column}}
+}
+
+// parseRunCommand parses the command string and arguments after `run`, up to
+// the end of the line or the parenthesis closing a capture.
+func (p *Parser) parseRunCommand() (ast.Expr, []ast.Expr, bool) {
+	if !p.check(token.STRING) {
+		p.errorExpected("expected string after run", token.STRING)
+		return nil, nil, false
+	}
+	cmd := parseString(p)
+	var args []ast.Expr
+	for !p.check(token.NEWLINE) && !p.check(token.RPAREN) && !p.isAtEnd() {
+		arg := p.parseExpression(0)
+		if arg == nil {
+			return cmd, args, false
+		}
+		args = append(args, arg)
+	}
+	return cmd, args, true
 }
 
 func (p *Parser) parseReturn() ast.Statement {
@@ -225,10 +357,18 @@ func (p *Parser) parseReturn() ast.Statement {
 }
 
 func (p *Parser) parseCall() ast.Statement {
-	nameTok := p.next() // consume ident
+	nameTok := p.parseCallName()
 	var args []ast.Expr
 	for !p.check(token.NEWLINE) && !p.isAtEnd() {
-		args = append(args, p.parseExpression(0))
+		start := p.pos
+		arg := p.parseExpression(0)
+		if arg == nil || p.pos == start {
+			// A token no expression starts with, such as the + of a
+			// half-typed `count += 1`, is never consumed here.
+			p.errorExpected("expected expression", prefixTypes()...)
+			return nil
+		}
+		args = append(args, arg)
 		if p.check(token.NEWLINE) {
 			break
 		}
@@ -241,80 +381,215 @@ func (p *Parser) parseIf() ast.Statement {
 	ifTok := p.next() // consume 'if'
 	cond := p.parseExpression(0)
 	if !p.check(token.NEWLINE) {
-		p.errors = append(p.errors, fmt.Errorf("expected newline after if condition"))
+		p.errorExpected("expected newline after if condition", token.NEWLINE)
 	}
+	header := p.trailingComment()
 	p.consumeNewlineIfPresent()
-	thenBlock := p.parseBlock(token.ELSE, token.END)
-	var elseBlock []ast.Statement
-	if p.check(token.ELSE) {
-		p.next()
-		p.consumeNewlineIfPresent()
-		elseBlock = p.parseBlock(token.END)
+	stmt := &ast.IfStmt{Cond: cond, P: ast.Pos{Line: ifTok.Line, Column: ifTok.Column}}
+	stmt.Then = p.parseBlock(token.ELSE, token.END)
+	footers := [][]ast.Comment{p.commentsBefore(p.current())}
+	hasElse := false
+	for !hasElse && p.check(token.ELSE) {
+		elseTok := p.next()
+		if p.match(token.IF) {
+			branch := ast.ElseIf{Cond: p.parseExpression(0), P: ast.Pos{Line: elseTok.Line, Column: elseTok.Column}}
+			if !p.check(token.NEWLINE) {
+				p.errorExpected("expected newline after else if condition", token.NEWLINE)
+			}
+			p.consumeNewlineIfPresent()
+			branch.Body = p.parseBlock(token.ELSE, token.END)
+			stmt.ElseIfs = append(stmt.ElseIfs, branch)
+		} else {
+			p.consumeNewlineIfPresent()
+			stmt.Else = p.parseBlock(token.END)
+			hasElse = true
+		}
+		footers = append(footers, p.commentsBefore(p.current()))
 	}
 	if !p.check(token.END) {
-		p.errors = append(p.errors, fmt.Errorf("expected end to close if"))
+		closers := []token.Type{token.END}
+		if !hasElse {
+			closers = []token.Type{token.ELSE, token.END}
+		}
+		p.errorExpected("expected end to close if", closers...)
 	} else {
 		p.next() // consume end
 	}
 	p.consumeNewlineIfPresent()
-	return &ast.IfStmt{Cond: cond, Then: thenBlock, Else: elseBlock, P: ast.Pos{Line: ifTok.Line, Column: ifTok.Column}}
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: footers})
+	return stmt
+}
+
+func (p *Parser) parseMatch() ast.Statement {
+	matchTok := p.next() // consume 'match'
+	subject := p.parseExpression(0)
+	if !p.check(token.NEWLINE) {
+		p.errorExpected("expected newline after match value", token.NEWLINE)
+	}
+	header := p.trailingComment()
+	for p.check(token.NEWLINE) {
+		p.next()
+	}
+	stmt := &ast.MatchStmt{Subject: subject, P: ast.Pos{Line: matchTok.Line, Column: matchTok.Column}}
+	footers := [][]ast.Comment{p.commentsBefore(p.current())}
+	hasDefault := false
+	for !hasDefault && (p.check(token.CASE) || p.check(token.ELSE)) {
+		if p.check(token.CASE) {
+			caseTok := p.next()
+			arm := ast.MatchCase{P: ast.Pos{Line: caseTok.Line, Column: caseTok.Column}}
+			for {
+				v := p.parseCaseValue()
+				if v == nil {
+					return nil
+				}
+				arm.Values = append(arm.Values, v)
+				if !p.match(token.COMMA) {
+					break
+				}
+			}
+			if !p.check(token.NEWLINE) {
+				p.errorExpected("expected newline after case values", token.NEWLINE)
+			}
+			p.consumeNewlineIfPresent()
+			arm.Body = p.parseBlock(token.CASE, token.ELSE, token.END)
+			stmt.Cases = append(stmt.Cases, arm)
+		} else {
+			p.next() // consume else
+			p.consumeNewlineIfPresent()
+			stmt.Default = p.parseBlock(token.END)
+			hasDefault = true
+		}
+		footers = append(footers, p.commentsBefore(p.current()))
+	}
+	switch {
+	case !p.check(token.END):
+		closers := []token.Type{token.END}
+		if !hasDefault {
+			closers = []token.Type{token.CASE, token.ELSE, token.END}
+		}
+		p.errorExpected("expected end to close match", closers...)
+	case len(stmt.Cases) == 0:
+		p.errorExpected("expected case before end of match", token.CASE)
+		p.next()
+	default:
+		p.next() // consume end
+	}
+	p.consumeNewlineIfPresent()
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: footers})
+	return stmt
+}
+
+// parseCaseValue parses the string or number literal of a case arm.
+func (p *Parser) parseCaseValue() ast.Expr {
+	switch {
+	case p.check(token.STRING):
+		return parseString(p)
+	case p.check(token.NUMBER):
+		return parseNumber(p)
+	case p.check(token.MINUS) && p.peek().Type == token.NUMBER:
+		minus := p.next()
+		return &ast.NumberLit{Value: "-" + p.next().Literal, P: ast.Pos{Line: minus.Line, Column: minus.Column}}
+	}
+	p.errorExpected("expected string or number after case", token.STRING, token.NUMBER)
+	return nil
 }
 
 func (p *Parser) parseFor() ast.Statement {
 	forTok := p.next() // consume 'for'
 	iterTok, ok := p.expect(token.IDENT)
 	if !ok {
-		p.errors = append(p.errors, fmt.Errorf("expected identifier after for"))
+		p.errorExpected("expected identifier after for", token.IDENT)
 		return nil
 	}
+	var keyTok token.Token
+	if p.match(token.COMMA) {
+		keyTok = iterTok
+		if iterTok, ok = p.expect(token.IDENT); !ok {
+			p.errorExpected("expected value identifier after comma", token.IDENT)
+			return nil
+		}
+	}
 	if !p.check(token.IN) {
-		p.errors = append(p.errors, fmt.Errorf("expected in after for variable"))
+		p.errorExpected("expected in after for variable", token.IN)
 		return nil
 	}
 	p.next() // consume 'in'
+	startTok := p.current()
 	start := p.parseExpression(0)
-	if !p.check(token.DOTDOT) {
-		p.errors = append(p.errors, fmt.Errorf("expected .. in for range"))
+	var end ast.Expr
+	switch {
+	case keyTok.Type == "" && p.check(token.DOTDOT):
+		p.next() // consume '..'
+		end = p.parseExpression(0)
+	case isVarRef(start):
+		// for item in $list, for key, value in $map
+	case keyTok.Type != "":
+		p.errorAt(startTok, "expected map variable after in")
+		return nil
+	default:
+		p.errorExpected("expected .. in for range", token.DOTDOT)
 		return nil
 	}
-	p.next() // consume '..'
-	end := p.parseExpression(0)
 	if !p.check(token.NEWLINE) {
-		p.errors = append(p.errors, fmt.Errorf("expected newline after for header"))
+		p.errorExpected("expected newline after for header", token.NEWLINE)
 	}
+	header := p.trailingComment()
 	p.consumeNewlineIfPresent()
 	body := p.parseBlock(token.END)
+	footer := p.commentsBefore(p.current())
 	if !p.check(token.END) {
-		p.errors = append(p.errors, fmt.Errorf("expected end to close for"))
+		p.errorExpected("expected end to close for", token.END)
 	} else {
 		p.next()
 	}
 	p.consumeNewlineIfPresent()
-	return &ast.ForStmt{Var: iterTok.Literal, Start: start, End: end, Body: body, P: ast.Pos{Line: forTok.Line, Column: forTok.Column}}
+	pos := ast.Pos{Line: forTok.Line, Column: forTok.Column}
+	var stmt ast.Statement
+	if end != nil {
+		stmt = &ast.ForStmt{Var: iterTok.Literal, Start: start, End: end, Body: body, P: pos}
+	} else {
+		stmt = &ast.ForEachStmt{Key: keyTok.Literal, Var: iterTok.Literal, Iterable: start, Body: body, P: pos}
+	}
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: [][]ast.Comment{footer}})
+	return stmt
+}
+
+// isVarRef reports whether e names a variable, as the list or map a for loop
+// iterates over must.
+func isVarRef(e ast.Expr) bool {
+	switch e.(type) {
+	case *ast.IdentExpr, *ast.PropertyExpr:
+		return true
+	}
+	return false
 }
 
 func (p *Parser) parseWhile() ast.Statement {
 	whileTok := p.next() // consume 'while'
 	cond := p.parseExpression(0)
 	if !p.check(token.NEWLINE) {
-		p.errors = append(p.errors, fmt.Errorf("expected newline after while condition"))
+		p.errorExpected("expected newline after while condition", token.NEWLINE)
 	}
+	header := p.trailingComment()
 	p.consumeNewlineIfPresent()
 	body := p.parseBlock(token.END)
+	footer := p.commentsBefore(p.current())
 	if !p.check(token.END) {
-		p.errors = append(p.errors, fmt.Errorf("expected end to close while"))
+		p.errorExpected("expected end to close while", token.END)
 	} else {
 		p.next()
 	}
 	p.consumeNewlineIfPresent()
-	return &ast.WhileStmt{Cond: cond, Body: body, P: ast.Pos{Line: whileTok.Line, Column: whileTok.Column}}
+	stmt := &ast.WhileStmt{Cond: cond, Body: body, P: ast.Pos{Line: whileTok.Line, Column: whileTok.Column}}
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: [][]ast.Comment{footer}})
+	return stmt
 }
 
 func (p *Parser) parseFn() ast.Statement {
 	fnTok := p.next() // consume 'fn'
 	nameTok, ok := p.expect(token.IDENT)
 	if !ok {
-		p.errors = append(p.errors, fmt.Errorf("expected function name"))
+		p.errorExpected("expected function name", token.IDENT)
 		return nil
 	}
 	var params []string
@@ -323,17 +598,21 @@ func (p *Parser) parseFn() ast.Statement {
 		params = append(params, tok.Literal)
 	}
 	if !p.check(token.NEWLINE) {
-		p.errors = append(p.errors, fmt.Errorf("expected newline after fn signature"))
+		p.errorExpected("expected newline after fn signature", token.IDENT, token.NEWLINE)
 	}
+	header := p.trailingComment()
 	p.consumeNewlineIfPresent()
 	body := p.parseBlock(token.END)
+	footer := p.commentsBefore(p.current())
 	if !p.check(token.END) {
-		p.errors = append(p.errors, fmt.Errorf("expected end to close function"))
+		p.errorExpected("expected end to close function", token.END)
 	} else {
 		p.next()
 	}
 	p.consumeNewlineIfPresent()
-	return &ast.FnDecl{Name: nameTok.Literal, Params: params, Body: body, P: ast.Pos{Line: fnTok.Line, Column: fnTok.Column}}
+	stmt := &ast.FnDecl{Name: nameTok.Literal, Params: params, Body: body, P: ast.Pos{Line: fnTok.Line, Column: fnTok.Column}}
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: [][]ast.Comment{footer}})
+	return stmt
 }
 
 func (p *Parser) parseBreak() ast.Statement {
@@ -348,7 +627,195 @@ func (p *Parser) parseContinue() ast.Statement {
 	return &ast.ContinueStmt{P: ast.Pos{Line: ctTok.Line, Column: ctTok.Column}}
 }
 
+// parseImport parses `import "path" [as alias]`, which is only allowed at
+// the top level of a file.
+func (p *Parser) parseImport() ast.Statement {
+	importTok := p.next() // consume 'import'
+	if !p.top {
+		p.errorAt(importTok, "import must be at the top level of the file")
+		return nil
+	}
+	pathTok, ok := p.expect(token.STRING)
+	if !ok {
+		p.errorExpected("expected string after import", token.STRING)
+		return nil
+	}
+	stmt := &ast.ImportStmt{Path: pathTok.Literal, P: ast.Pos{Line: importTok.Line, Column: importTok.Column}}
+	if p.match(token.AS) {
+		if !p.check(token.IDENT) || p.current().Var {
+			p.errorExpected("expected name after as", token.IDENT)
+			return nil
+		}
+		stmt.Alias = p.next().Literal
+	}
+	if !p.check(token.NEWLINE) && !p.isAtEnd() {
+		p.errorExpected("expected newline after import", token.AS, token.NEWLINE)
+		return nil
+	}
+	p.consumeNewlineIfPresent()
+	return stmt
+}
+
+// parseParams parses the `params ... end` block that declares the script's
+// command-line arguments. A file has at most one, at its top level.
+func (p *Parser) parseParams() ast.Statement {
+	paramsTok := p.next() // consume 'params'
+	if !p.top {
+		p.errorAt(paramsTok, "params must be at the top level of the file")
+		return nil
+	}
+	if p.params {
+		p.errorAt(paramsTok, "a file can only have one params block")
+		return nil
+	}
+	p.params = true
+	if !p.check(token.NEWLINE) {
+		p.errorExpected("expected newline after params", token.NEWLINE)
+	}
+	header := p.trailingComment()
+	for p.check(token.NEWLINE) {
+		p.next()
+	}
+	stmt := &ast.ParamsStmt{P: ast.Pos{Line: paramsTok.Line, Column: paramsTok.Column}}
+	footers := [][]ast.Comment{p.commentsBefore(p.current())}
+	for p.check(token.PARAM) || p.check(token.FLAG) {
+		kindTok := p.next()
+		if !p.check(token.IDENT) || p.current().Var {
+			p.errorExpected("expected name after "+kindTok.Literal, token.IDENT)
+			return nil
+		}
+		param := ast.Param{Name: p.next().Literal, Flag: kindTok.Type == token.FLAG, P: ast.Pos{Line: kindTok.Line, Column: kindTok.Column}}
+		expected := []token.Type{token.NEWLINE}
+		if !param.Flag {
+			if p.match(token.DEFAULT) {
+				param.Default = p.parseExpression(0)
+			} else {
+				expected = []token.Type{token.DEFAULT, token.NEWLINE}
+			}
+		}
+		if !p.check(token.NEWLINE) {
+			p.errorExpected("expected newline after "+kindTok.Literal, expected...)
+			return nil
+		}
+		for p.check(token.NEWLINE) {
+			p.next()
+		}
+		stmt.Params = append(stmt.Params, param)
+		footers = append(footers, p.commentsBefore(p.current()))
+	}
+	if !p.check(token.END) {
+		p.errorExpected("expected end to close params", token.PARAM, token.FLAG, token.END)
+		return nil
+	}
+	p.next() // consume end
+	p.consumeNewlineIfPresent()
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: footers})
+	return stmt
+}
+
+// parseCallName consumes the name of a called function, which is qualified
+// as alias.name when the function comes from an aliased import. It returns
+// the name token with the full name as its literal.
+func (p *Parser) parseCallName() token.Token {
+	nameTok := p.next() // consume ident
+	if p.check(token.DOT) && p.peek().Type == token.IDENT && !p.peek().Var {
+		p.next() // consume '.'
+		nameTok.Literal += "." + p.next().Literal
+	}
+	return nameTok
+}
+
+// parseStrict parses the strict directive, which is only allowed as the
+// first statement of a file.
+func (p *Parser) parseStrict() ast.Statement {
+	strictTok := p.next() // consume 'strict'
+	if !p.first {
+		p.errorAt(strictTok, "strict must be the first statement in the file")
+		return nil
+	}
+	if !p.check(token.NEWLINE) && !p.isAtEnd() {
+		p.errorExpected("expected newline after strict", token.NEWLINE)
+		return nil
+	}
+	p.consumeNewlineIfPresent()
+	return &ast.StrictStmt{P: ast.Pos{Line: strictTok.Line, Column: strictTok.Column}}
+}
+
+func (p *Parser) parseUnchecked() ast.Statement {
+	uncheckedTok := p.next() // consume 'unchecked'
+	if !p.check(token.NEWLINE) {
+		p.errorExpected("expected newline after unchecked", token.NEWLINE)
+	}
+	header := p.trailingComment()
+	p.consumeNewlineIfPresent()
+	body := p.parseBlock(token.END)
+	footer := p.commentsBefore(p.current())
+	if !p.check(token.END) {
+		p.errorExpected("expected end to close unchecked", token.END)
+	} else {
+		p.next()
+	}
+	p.consumeNewlineIfPresent()
+	stmt := &ast.UncheckedStmt{Body: body, P: ast.Pos{Line: uncheckedTok.Line, Column: uncheckedTok.Column}}
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: [][]ast.Comment{footer}})
+	return stmt
+}
+
+func (p *Parser) parseTry() ast.Statement {
+	tryTok := p.next() // consume 'try'
+	if !p.check(token.NEWLINE) {
+		p.errorExpected("expected newline after try", token.NEWLINE)
+	}
+	header := p.trailingComment()
+	p.consumeNewlineIfPresent()
+	stmt := &ast.TryStmt{P: ast.Pos{Line: tryTok.Line, Column: tryTok.Column}}
+	stmt.Body = p.parseBlock(token.CATCH, token.FINALLY, token.END)
+	footers := [][]ast.Comment{p.commentsBefore(p.current())}
+	handled := false
+	if p.check(token.CATCH) {
+		p.next()
+		errTok, ok := p.expect(token.IDENT)
+		if !ok {
+			p.errorExpected("expected identifier after catch", token.IDENT)
+			return nil
+		}
+		if !p.check(token.NEWLINE) {
+			p.errorExpected("expected newline after catch variable", token.NEWLINE)
+		}
+		p.consumeNewlineIfPresent()
+		stmt.Err = errTok.Literal
+		stmt.Catch = p.parseBlock(token.FINALLY, token.END)
+		handled = true
+	}
+	footers = append(footers, p.commentsBefore(p.current()))
+	if p.check(token.FINALLY) {
+		p.next()
+		p.consumeNewlineIfPresent()
+		stmt.Finally = p.parseBlock(token.END)
+		handled = true
+	}
+	footers = append(footers, p.commentsBefore(p.current()))
+	switch {
+	case !p.check(token.END):
+		closers := []token.Type{token.END}
+		if !handled {
+			closers = []token.Type{token.CATCH, token.FINALLY, token.END}
+		}
+		p.errorExpected("expected end to close try", closers...)
+	case !handled:
+		p.errorExpected("expected catch or finally before end of try", token.CATCH, token.FINALLY)
+		p.next()
+	default:
+		p.next() // consume end
+	}
+	p.consumeNewlineIfPresent()
+	p.setTrivia(stmt, &ast.Trivia{Header: header, Footers: footers})
+	return stmt
+}
+
 func (p *Parser) parseBlock(until token.Type, others ...token.Type) []ast.Statement {
+	p.first = false
+	p.top = false
 	terminators := append([]token.Type{until}, others...)
 	var stmts []ast.Statement
 	for !p.isAtEnd() {
@@ -361,7 +828,7 @@ func (p *Parser) parseBlock(until token.Type, others ...token.Type) []ast.Statem
 				return stmts
 			}
 		}
-		s := p.parseStatement()
+		s := p.parseStatementWithTrivia()
 		if s != nil {
 			stmts = append(stmts, s)
 		} else {
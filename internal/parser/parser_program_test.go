@@ -111,3 +111,46 @@ func TestParseProgram_ErrorRecovery_MissingEnd(t *testing.T) {
 		t.Fatalf("got %d statements, want 1 (unterminated if captured)", len(prog.Statements))
 	}
 }
+
+func TestParseProgram_AttachesTrivia(t *testing.T) {
+	src := "# lead\n" +
+		"set a 1 # trail\n" +
+		"\n" +
+		"while $a < 3 # head\n" +
+		"    a = $a + 1\n" +
+		"    # foot\n" +
+		"end\n" +
+		"# eof\n"
+	p := New(CollectTokens(lexer.New(src)))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected errors: %v", p.Errors())
+	}
+
+	set := prog.Trivia[prog.Statements[0]]
+	if set == nil || len(set.Leading) != 1 || set.Leading[0].Text != "# lead" || set.Trailing == nil || set.Trailing.Text != "# trail" {
+		t.Fatalf("unexpected set trivia %+v", set)
+	}
+	loop := prog.Trivia[prog.Statements[1]]
+	if loop == nil || !loop.Blank || loop.Header == nil || loop.Header.Text != "# head" {
+		t.Fatalf("unexpected while trivia %+v", loop)
+	}
+	if len(loop.Footers) != 1 || len(loop.Footers[0]) != 1 || loop.Footers[0][0].Text != "# foot" {
+		t.Fatalf("unexpected while footers %+v", loop.Footers)
+	}
+	body := prog.Statements[1].(*ast.WhileStmt).Body[0]
+	if tr := prog.Trivia[body]; tr != nil {
+		t.Fatalf("loop body should have no trivia, got %+v", tr)
+	}
+	file := prog.Trivia[prog]
+	if file == nil || len(file.Footers) != 1 || file.Footers[0][0].Text != "# eof" {
+		t.Fatalf("unexpected file trivia %+v", file)
+	}
+}
+
+func TestParseProgram_NoCommentsNoTrivia(t *testing.T) {
+	p := New(CollectTokens(lexer.New("set a 1\necho $a\n")))
+	if prog := p.ParseProgram(); prog.Trivia != nil {
+		t.Fatalf("expected nil trivia, got %v", prog.Trivia)
+	}
+}
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
@@ -33,6 +34,42 @@ func TestParse_Assign(t *testing.T) {
 	}
 }
 
+func TestParse_CompoundAssign(t *testing.T) {
+	src := "set a 1\na += 2 * 3\na %= 4\na++\na--\n"
+	prog, p := parseProgramWithParser(t, src)
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := []struct {
+		op, value string
+		pos       ast.Pos
+	}{
+		{"+=", "BinaryExpr op=*", ast.Pos{Line: 2, Column: 3}},
+		{"%=", "NumberLit 4", ast.Pos{Line: 3, Column: 3}},
+		{"++", "", ast.Pos{Line: 4, Column: 2}},
+		{"--", "", ast.Pos{Line: 5, Column: 2}},
+	}
+	if len(prog.Statements) != len(want)+1 {
+		t.Fatalf("got %d statements, want %d", len(prog.Statements), len(want)+1)
+	}
+	for i, w := range want {
+		assign, ok := prog.Statements[i+1].(*ast.AssignStmt)
+		if !ok {
+			t.Fatalf("stmt%d not assign: %T", i+1, prog.Statements[i+1])
+		}
+		if assign.Name != "a" || assign.Op != w.op || assign.P != w.pos {
+			t.Errorf("stmt%d = %s %s at %v, want a %s at %v", i+1, assign.Name, assign.Op, assign.P, w.op, w.pos)
+		}
+		got := ""
+		if assign.Value != nil {
+			got = ast.Format(assign.Value)
+		}
+		if !strings.HasPrefix(got, w.value) {
+			t.Errorf("stmt%d value = %q, want %s", i+1, got, w.value)
+		}
+	}
+}
+
 func parseProgramWithParser(t *testing.T, src string) (*ast.Program, *Parser) {
 	t.Helper()
 	l := lexer.New(src)
@@ -103,6 +140,80 @@ func TestParse_IfElse(t *testing.T) {
 	}
 }
 
+func TestParse_ExistsInBooleanCondition(t *testing.T) {
+	src := "if !exists \"a\" && $n < 3\nfoo\nend\n"
+	prog := parseProgram(t, src)
+	ifStmt, ok := prog.Statements[0].(*ast.IfStmt)
+	if !ok {
+		t.Fatalf("stmt not IfStmt: %T", prog.Statements[0])
+	}
+	and, ok := ifStmt.Cond.(*ast.BinaryExpr)
+	if !ok || and.Op != "&&" {
+		t.Fatalf("cond = %#v, want && at the top", ifStmt.Cond)
+	}
+	not, ok := and.Left.(*ast.UnaryExpr)
+	if !ok {
+		t.Fatalf("left = %T, want UnaryExpr", and.Left)
+	}
+	exists, ok := not.Right.(*ast.ExistsCond)
+	if !ok {
+		t.Fatalf("operand of ! = %T, want ExistsCond", not.Right)
+	}
+	if path, ok := exists.Path.(*ast.StringLit); !ok || path.Value != "a" {
+		t.Fatalf("exists path = %#v, want \"a\"", exists.Path)
+	}
+}
+
+func TestParse_ElseIf(t *testing.T) {
+	src := "if $x == 1\n    foo\nelse if $x == 2\n    bar\nelse if $x > 2\n    baz\nelse\n    qux\nend\n"
+	prog := parseProgram(t, src)
+	ifStmt, ok := prog.Statements[0].(*ast.IfStmt)
+	if !ok {
+		t.Fatalf("stmt not IfStmt: %T", prog.Statements[0])
+	}
+	if len(ifStmt.ElseIfs) != 2 || len(ifStmt.Else) != 1 {
+		t.Fatalf("got %d else ifs and %d else statements, want 2 and 1", len(ifStmt.ElseIfs), len(ifStmt.Else))
+	}
+	if b := ifStmt.ElseIfs[1]; b.P.Line != 5 || len(b.Body) != 1 {
+		t.Fatalf("unexpected second else if %+v", b)
+	}
+}
+
+func TestParse_Match(t *testing.T) {
+	src := "match $cmd\n\n    case \"start\"\n        foo\n    case 1, -2\n        bar\n    else\n        baz\nend\n"
+	prog := parseProgram(t, src)
+	m, ok := prog.Statements[0].(*ast.MatchStmt)
+	if !ok {
+		t.Fatalf("stmt not MatchStmt: %T", prog.Statements[0])
+	}
+	if len(m.Cases) != 2 || len(m.Default) != 1 {
+		t.Fatalf("got %d cases and %d default statements, want 2 and 1", len(m.Cases), len(m.Default))
+	}
+	values := m.Cases[1].Values
+	if len(values) != 2 {
+		t.Fatalf("got %d values in case 2, want 2", len(values))
+	}
+	if n, ok := values[1].(*ast.NumberLit); !ok || n.Value != "-2" {
+		t.Fatalf("unexpected value %+v", values[1])
+	}
+}
+
+func TestParse_MatchErrors(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{"match $x\nend\n", "expected case before end of match"},
+		{"match $x\n    case $y\n        foo\nend\n", "expected string or number after case"},
+		{"match $x\n    foo\nend\n", "expected end to close match"},
+		{"match $x\n    case 1\n        foo\n", "expected end to close match"},
+	}
+	for _, c := range cases {
+		_, p := parseProgramWithParser(t, c.src)
+		errs := p.Errors()
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Fatalf("%q: got errors %v, want %q", c.src, errs, c.want)
+		}
+	}
+}
+
 func TestParse_For(t *testing.T) {
 	src := "for i in 1..3\nfoo\nend\n"
 	prog := parseProgram(t, src)
@@ -129,6 +240,51 @@ func TestParse_For_InvalidRange(t *testing.T) {
 	}
 }
 
+func TestParse_ForEach(t *testing.T) {
+	src := "for item in $xs\nfoo\nend\nfor k, v in $cfg.opts\nend\n"
+	prog := parseProgram(t, src)
+	if len(prog.Statements) != 2 {
+		t.Fatalf("got %d statements, want 2", len(prog.Statements))
+	}
+	list, ok := prog.Statements[0].(*ast.ForEachStmt)
+	if !ok {
+		t.Fatalf("stmt not ForEachStmt: %T", prog.Statements[0])
+	}
+	if list.Key != "" || list.Var != "item" || len(list.Body) != 1 {
+		t.Fatalf("unexpected list loop %+v", list)
+	}
+	if id, ok := list.Iterable.(*ast.IdentExpr); !ok || id.Name != "xs" {
+		t.Fatalf("iterable = %T, want $xs", list.Iterable)
+	}
+	m, ok := prog.Statements[1].(*ast.ForEachStmt)
+	if !ok {
+		t.Fatalf("stmt not ForEachStmt: %T", prog.Statements[1])
+	}
+	if m.Key != "k" || m.Var != "v" {
+		t.Fatalf("map loop vars = %q, %q; want k, v", m.Key, m.Var)
+	}
+	if _, ok := m.Iterable.(*ast.PropertyExpr); !ok {
+		t.Fatalf("iterable = %T, want $cfg.opts", m.Iterable)
+	}
+}
+
+func TestParse_ForEach_Errors(t *testing.T) {
+	tests := map[string]string{
+		"for i in [1, 2]\nend\n":  "parse error at 1:16: expected .. in for range, found end of line",
+		"for k, v in 1..3\nend\n": "parse error at 1:13: expected map variable after in, found number 1",
+		"for k, in $m\nend\n":     "parse error at 1:8: expected value identifier after comma, found keyword \"in\"",
+	}
+	for src, want := range tests {
+		prog, p := parseProgramWithParser(t, src)
+		if len(prog.Statements) != 0 {
+			t.Errorf("%q: got %d statements, want 0", src, len(prog.Statements))
+		}
+		if len(p.Errors()) == 0 || p.Errors()[0].Error() != want {
+			t.Errorf("%q: got %v, want %q", src, p.Errors(), want)
+		}
+	}
+}
+
 func TestParse_While(t *testing.T) {
 	src := "while 1\nfoo\nend\n"
 	prog := parseProgram(t, src)
@@ -196,3 +352,169 @@ func TestParse_Fn(t *testing.T) {
 		t.Fatalf("fn body size wrong: %d", len(fn.Body))
 	}
 }
+
+func TestParse_StrictAndUnchecked(t *testing.T) {
+	src := "# deploy\nstrict\nunchecked\n    run \"taskkill\"\nend\n"
+	prog, p := parseProgramWithParser(t, src)
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(prog.Statements) != 2 {
+		t.Fatalf("got %d statements, want 2", len(prog.Statements))
+	}
+	if s, ok := prog.Statements[0].(*ast.StrictStmt); !ok || s.P.Line != 2 {
+		t.Fatalf("stmt0 not strict on line 2: %T", prog.Statements[0])
+	}
+	u, ok := prog.Statements[1].(*ast.UncheckedStmt)
+	if !ok {
+		t.Fatalf("stmt1 not unchecked: %T", prog.Statements[1])
+	}
+	if len(u.Body) != 1 {
+		t.Fatalf("got %d unchecked statements, want 1", len(u.Body))
+	}
+}
+
+func TestParse_StrictNotFirst(t *testing.T) {
+	for _, src := range []string{
+		"echo \"hi\"\nstrict\n",
+		"if true\n    strict\nend\n",
+		"strict\nstrict\n",
+	} {
+		_, p := parseProgramWithParser(t, src)
+		errs := p.Errors()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "strict must be the first statement in the file") {
+			t.Fatalf("%q: unexpected errors %v", src, errs)
+		}
+	}
+}
+
+func TestParse_Try(t *testing.T) {
+	src := "try\n    run \"deploy\"\ncatch err\n    echo $err.code\nfinally\n    run \"cleanup\"\nend\n"
+	prog := parseProgram(t, src)
+	s, ok := prog.Statements[0].(*ast.TryStmt)
+	if !ok {
+		t.Fatalf("stmt not TryStmt: %T", prog.Statements[0])
+	}
+	if s.Err != "err" || len(s.Body) != 1 || len(s.Catch) != 1 || len(s.Finally) != 1 {
+		t.Fatalf("unexpected try %+v", s)
+	}
+
+	prog = parseProgram(t, "try\n    run \"deploy\"\nfinally\n    run \"cleanup\"\nend\n")
+	if s := prog.Statements[0].(*ast.TryStmt); s.Err != "" || len(s.Finally) != 1 {
+		t.Fatalf("unexpected try %+v", s)
+	}
+}
+
+func TestParse_TryErrors(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{"try\n    run \"a\"\nend\n", "expected catch or finally before end of try"},
+		{"try\n    run \"a\"\ncatch\nend\n", "expected identifier after catch"},
+		{"try\n    run \"a\"\ncatch e\n", "expected end to close try"},
+	}
+	for _, c := range cases {
+		_, p := parseProgramWithParser(t, c.src)
+		errs := p.Errors()
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Fatalf("%q: got errors %v, want %q", c.src, errs, c.want)
+		}
+	}
+}
+
+func TestParse_RunArgs(t *testing.T) {
+	src := "run \"git commit -m\" $msg \"-q\"\n"
+	prog := parseProgram(t, src)
+	run, ok := prog.Statements[0].(*ast.RunStmt)
+	if !ok {
+		t.Fatalf("stmt not RunStmt: %T", prog.Statements[0])
+	}
+	if cmd, ok := run.Command.(*ast.StringLit); !ok || cmd.Value != "git commit -m" {
+		t.Fatalf("unexpected command %+v", run.Command)
+	}
+	if len(run.Args) != 2 {
+		t.Fatalf("got %d args, want 2", len(run.Args))
+	}
+	if id, ok := run.Args[0].(*ast.IdentExpr); !ok || id.Name != "msg" {
+		t.Fatalf("arg[0] not $msg: %T", run.Args[0])
+	}
+}
+
+func TestParse_Import(t *testing.T) {
+	src := "import \"lib/strings.fin\"\nimport \"net.fin\" as net\nnet.get \"x\"\nset y (net.get \"y\")\n"
+	prog := parseProgram(t, src)
+	imp, ok := prog.Statements[0].(*ast.ImportStmt)
+	if !ok {
+		t.Fatalf("stmt not ImportStmt: %T", prog.Statements[0])
+	}
+	if imp.Path != "lib/strings.fin" || imp.Alias != "" {
+		t.Fatalf("unexpected import %+v", imp)
+	}
+	if imp := prog.Statements[1].(*ast.ImportStmt); imp.Path != "net.fin" || imp.Alias != "net" {
+		t.Fatalf("unexpected import %+v", imp)
+	}
+	if call := prog.Statements[2].(*ast.CallStmt); call.Name != "net.get" || len(call.Args) != 1 {
+		t.Fatalf("unexpected call %+v", call)
+	}
+	set := prog.Statements[3].(*ast.SetStmt)
+	if call, ok := set.Value.(*ast.CallExpr); !ok || call.Name != "net.get" {
+		t.Fatalf("unexpected value %+v", set.Value)
+	}
+}
+
+func TestParse_ImportErrors(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{"fn f\n    import \"a.fin\"\nend\n", "import must be at the top level of the file"},
+		{"import a\n", "expected string after import"},
+		{"import \"a.fin\" as $a\n", "expected name after as"},
+		{"import \"a.fin\" b\n", "expected newline after import"},
+	}
+	for _, c := range cases {
+		_, p := parseProgramWithParser(t, c.src)
+		errs := p.Errors()
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Fatalf("%q: got errors %v, want %q", c.src, errs, c.want)
+		}
+	}
+}
+
+func TestParse_Params(t *testing.T) {
+	src := "params\n    param env default \"dev\"\n    param target\n\n    flag verbose\nend\necho $env\n"
+	prog := parseProgram(t, src)
+	s, ok := prog.Statements[0].(*ast.ParamsStmt)
+	if !ok {
+		t.Fatalf("stmt not ParamsStmt: %T", prog.Statements[0])
+	}
+	if len(s.Params) != 3 || len(prog.Statements) != 2 {
+		t.Fatalf("unexpected params %+v", s)
+	}
+	if p := s.Params[0]; p.Name != "env" || p.Flag || p.P != (ast.Pos{Line: 2, Column: 5}) {
+		t.Fatalf("unexpected param %+v", p)
+	}
+	if d, ok := s.Params[0].Default.(*ast.StringLit); !ok || d.Value != "dev" {
+		t.Fatalf("unexpected default %+v", s.Params[0].Default)
+	}
+	if p := s.Params[1]; p.Name != "target" || p.Default != nil || p.Flag {
+		t.Fatalf("unexpected param %+v", p)
+	}
+	if p := s.Params[2]; p.Name != "verbose" || !p.Flag {
+		t.Fatalf("unexpected flag %+v", p)
+	}
+}
+
+func TestParse_ParamsErrors(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{"if true\n    params\n    end\nend\n", "params must be at the top level of the file"},
+		{"params\nend\nparams\nend\n", "a file can only have one params block"},
+		{"params\n    param\nend\n", "expected name after param"},
+		{"params\n    flag $v\nend\n", "expected name after flag"},
+		{"params\n    param env \"dev\"\nend\n", "expected newline after param"},
+		{"params\n    flag v default true\nend\n", "expected newline after flag"},
+		{"params\n    set x 1\nend\n", "expected end to close params"},
+	}
+	for _, c := range cases {
+		_, p := parseProgramWithParser(t, c.src)
+		errs := p.Errors()
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), c.want) {
+			t.Fatalf("%q: got errors %v, want %q", c.src, errs, c.want)
+		}
+	}
+}
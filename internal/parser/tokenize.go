@@ -9,7 +9,7 @@ import (
 
 // CollectTokens drains the lexer into a slice of tokens, preserving order and positions.
 // It stops after reading the first EOF and validates that exactly one EOF exists and it is last.
-// NEWLINE tokens are preserved. Panics if the stream is invalid.
+// NEWLINE and COMMENT tokens are preserved. Panics if the stream is invalid.
 func CollectTokens(l *lexer.Lexer) []token.Token {
     var tokens []token.Token
     eofCount := 0
@@ -2,6 +2,7 @@ package sema
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/vishnunath-suresh/fin-project/internal/ast"
 )
@@ -9,6 +10,7 @@ import (
 // FunctionRegistry tracks function signatures by name.
 type FunctionRegistry struct {
 	funcs map[string]int
+	defs  map[string]ast.Pos
 }
 
 // AnalysisResult captures scopes and errors from semantic analysis.
@@ -16,8 +18,16 @@ type AnalysisResult struct {
 	Global      *Scope
 	FuncScopes  map[*ast.FnDecl]*Scope
 	ForScopes   map[*ast.ForStmt]*Scope
+	EachScopes  map[*ast.ForEachStmt]*Scope
 	WhileScopes map[*ast.WhileStmt]*Scope
-	Errors      []error
+	CatchScopes map[*ast.TryStmt]*Scope
+	Funcs       *FunctionRegistry
+	// Refs maps the position of each resolved use of a name to the position
+	// of its definition. Assignments are keyed by the position of their '='.
+	Refs map[ast.Pos]ast.Pos
+	// Types holds the type inferred for each expression analyzed.
+	Types  map[ast.Expr]Type
+	Errors []error
 }
 
 // Analyzer aggregates semantic analysis results safely.
@@ -34,40 +44,65 @@ func New() *Analyzer {
 
 // NewFunctionRegistry creates an empty registry.
 func NewFunctionRegistry() *FunctionRegistry {
-	return &FunctionRegistry{funcs: make(map[string]int)}
+	return &FunctionRegistry{funcs: make(map[string]int), defs: make(map[string]ast.Pos)}
 }
 
 // Define registers a function name and its parameter count.
 // It returns an error if the name already exists. The provided pos is used for diagnostics.
 func (r *FunctionRegistry) Define(name string, arity int, pos ast.Pos) error {
 	if _, exists := r.funcs[name]; exists {
-		return DuplicateFunctionError{Name: name, P: pos}
+		return DuplicateFunctionError{Name: name, P: pos, Def: r.defs[name]}
 	}
 	r.funcs[name] = arity
+	r.defs[name] = pos
 	return nil
 }
 
 // Lookup returns the arity for a function and whether it was found.
+// Built-in functions are always found.
 func (r *FunctionRegistry) Lookup(name string) (int, bool) {
-	arity, ok := r.funcs[name]
+	if arity, ok := r.funcs[name]; ok {
+		return arity, true
+	}
+	arity, ok := Builtins[name]
 	return arity, ok
 }
 
+// Resolve returns the position where a function was defined.
+func (r *FunctionRegistry) Resolve(name string) (ast.Pos, bool) {
+	pos, ok := r.defs[name]
+	return pos, ok
+}
+
 // AnalyzeDefinitionsWithLimit walks the AST to enforce semantic rules with an optional
 // recursion depth limit. If limit <= 0, no depth check is applied.
 func AnalyzeDefinitionsWithLimit(prog *ast.Program, limit int) AnalysisResult {
+	return analyzeProgram(prog, NewFunctionRegistry(), limit)
+}
+
+// AnalyzeWithFunctions is AnalyzeDefinitions for a program that may also
+// call the functions already defined in funcs, such as those it imports.
+// funcs is extended with the functions prog declares.
+func AnalyzeWithFunctions(prog *ast.Program, funcs *FunctionRegistry) AnalysisResult {
+	return analyzeProgram(prog, funcs, 0)
+}
+
+func analyzeProgram(prog *ast.Program, reg *FunctionRegistry, limit int) AnalysisResult {
 	res := AnalysisResult{
 		Global:      NewScope(nil),
 		FuncScopes:  make(map[*ast.FnDecl]*Scope),
 		ForScopes:   make(map[*ast.ForStmt]*Scope),
+		EachScopes:  make(map[*ast.ForEachStmt]*Scope),
 		WhileScopes: make(map[*ast.WhileStmt]*Scope),
+		CatchScopes: make(map[*ast.TryStmt]*Scope),
+		Funcs:       reg,
+		Refs:        make(map[ast.Pos]ast.Pos),
+		Types:       make(map[ast.Expr]Type),
 	}
 	if prog == nil {
 		return res
 	}
 
-	reg := NewFunctionRegistry()
-
 	// Pass 1: register function declarations up front to allow forward references.
 	for _, stmt := range prog.Statements {
 		if fn, ok := stmt.(*ast.FnDecl); ok {
@@ -143,10 +178,13 @@ func analyzeStmt(stmt ast.Statement, scope *Scope, reg *FunctionRegistry, res *A
 		if err := ValidateIdentifier(s.Name, s.P); err != nil {
 			res.Errors = append(res.Errors, err)
 		}
+		t := TypeOf(s.Value, scope)
 		if err := scope.Define(s.Name, s.P); err != nil {
 			res.Errors = append(res.Errors, err)
+		} else {
+			scope.setType(s.Name, t)
 		}
-		analyzeExpr(s.Value, scope, res, depth+1, limit)
+		analyzeValue(s.Value, scope, reg, res, depth+1, limit)
 	case *ast.FnDecl:
 		// Name already validated/registered in pass 1; still validate params and body.
 		fnScope := NewFunctionScope(scope)
@@ -163,17 +201,39 @@ func analyzeStmt(stmt ast.Statement, scope *Scope, reg *FunctionRegistry, res *A
 			analyzeStmt(inner, fnScope, reg, res, depth+1, limit)
 		}
 	case *ast.IfStmt:
-		analyzeExpr(s.Cond, scope, res, depth+1, limit)
+		analyzeExpr(s.Cond, scope, reg, res, depth+1, limit)
 		thenScope := NewScope(scope)
 		for _, inner := range s.Then {
 			analyzeStmt(inner, thenScope, reg, res, depth+1, limit)
 		}
+		for _, branch := range s.ElseIfs {
+			analyzeExpr(branch.Cond, scope, reg, res, depth+1, limit)
+			branchScope := NewScope(scope)
+			for _, inner := range branch.Body {
+				analyzeStmt(inner, branchScope, reg, res, depth+1, limit)
+			}
+		}
 		if len(s.Else) > 0 {
 			elseScope := NewScope(scope)
 			for _, inner := range s.Else {
 				analyzeStmt(inner, elseScope, reg, res, depth+1, limit)
 			}
 		}
+	case *ast.MatchStmt:
+		analyzeExpr(s.Subject, scope, reg, res, depth+1, limit)
+		for _, arm := range s.Cases {
+			for _, v := range arm.Values {
+				analyzeExpr(v, scope, reg, res, depth+1, limit)
+			}
+			armScope := NewScope(scope)
+			for _, inner := range arm.Body {
+				analyzeStmt(inner, armScope, reg, res, depth+1, limit)
+			}
+		}
+		defaultScope := NewScope(scope)
+		for _, inner := range s.Default {
+			analyzeStmt(inner, defaultScope, reg, res, depth+1, limit)
+		}
 	case *ast.ForStmt:
 		loopScope := NewScope(scope)
 		if err := ValidateIdentifier(s.Var, s.P); err != nil {
@@ -181,51 +241,159 @@ func analyzeStmt(stmt ast.Statement, scope *Scope, reg *FunctionRegistry, res *A
 		}
 		if err := loopScope.Define(s.Var, s.P); err != nil {
 			res.Errors = append(res.Errors, err)
+		} else {
+			loopScope.setType(s.Var, TypeNumber)
 		}
 		res.ForScopes[s] = loopScope
-		analyzeExpr(s.Start, scope, res, depth+1, limit)
-		analyzeExpr(s.End, scope, res, depth+1, limit)
+		for _, bound := range []ast.Expr{s.Start, s.End} {
+			analyzeExpr(bound, scope, reg, res, depth+1, limit)
+			res.Errors = appendErr(res.Errors, checkType(bound, scope, "range", TypeNumber))
+		}
+		for _, inner := range s.Body {
+			analyzeStmt(inner, loopScope, reg, res, depth+1, limit)
+		}
+	case *ast.ForEachStmt:
+		loopScope := NewScope(scope)
+		for _, name := range []string{s.Key, s.Var} {
+			if name == "" {
+				continue
+			}
+			if err := ValidateIdentifier(name, s.P); err != nil {
+				res.Errors = append(res.Errors, err)
+			}
+			if err := loopScope.Define(name, s.P); err != nil {
+				res.Errors = append(res.Errors, err)
+			}
+		}
+		if s.Key != "" {
+			loopScope.setType(s.Key, TypeString)
+		}
+		res.EachScopes[s] = loopScope
+		analyzeRef(s.Iterable, scope, reg, res, depth+1, limit)
+		// With a key, the loop walks the keys of a map.
+		want := TypeList
+		if s.Key != "" {
+			want = TypeMap
+		}
+		res.Errors = appendErr(res.Errors, checkType(s.Iterable, scope, "for loop", want))
 		for _, inner := range s.Body {
 			analyzeStmt(inner, loopScope, reg, res, depth+1, limit)
 		}
 	case *ast.WhileStmt:
-		analyzeExpr(s.Cond, scope, res, depth+1, limit)
+		analyzeExpr(s.Cond, scope, reg, res, depth+1, limit)
 		bodyScope := NewScope(scope)
 		res.WhileScopes[s] = bodyScope
 		for _, inner := range s.Body {
 			analyzeStmt(inner, bodyScope, reg, res, depth+1, limit)
 		}
 	case *ast.CallStmt:
-		if arity, ok := reg.Lookup(s.Name); !ok {
-			res.Errors = append(res.Errors, UndefinedVariableError{Name: s.Name, P: s.P})
-		} else if arity != len(s.Args) {
-			res.Errors = append(res.Errors, InvalidArityError{Name: s.Name, Expected: arity, Got: len(s.Args), P: s.P})
-		}
-		for _, arg := range s.Args {
-			analyzeExpr(arg, scope, res, depth+1, limit)
+		if IsBuiltin(s.Name) && !Modifies(s.Name) {
+			res.Errors = append(res.Errors, BuiltinUsageError{Name: s.Name, Msg: "returns a value that must be used", P: s.P})
 		}
+		analyzeCall(s.Name, s.Args, s.P, scope, reg, res, depth, limit)
 	case *ast.AssignStmt:
-		if _, ok := scope.Lookup(s.Name); !ok {
+		if def, ok := scope.Resolve(s.Name); ok {
+			res.Refs[s.P] = def
+			want, _ := scope.Type(s.Name)
+			if got := TypeOf(s.Value, scope); s.Op == "" && !assignable(want, got) {
+				res.Errors = append(res.Errors, TypeError{Name: s.Name, Got: want, Want: []Type{got}, Use: "assignment", P: s.P})
+			}
+		} else {
 			res.Errors = append(res.Errors, UndefinedVariableError{Name: s.Name, P: s.P})
 		}
-		analyzeExpr(s.Value, scope, res, depth+1, limit)
+		if b, ok := s.Result().(*ast.BinaryExpr); ok && s.Op != "" {
+			// A compound assignment is checked as the arithmetic it
+			// stands for, `x += 1` as `$x + 1`. The variable is resolved
+			// above; only the operand is new.
+			analyzeExpr(b.Right, scope, reg, res, depth+1, limit)
+			res.Errors = appendErr(res.Errors, checkType(b.Left, scope, "operator "+s.Op, TypeNumber))
+			res.Errors = appendErr(res.Errors, checkType(b.Right, scope, "operator "+s.Op, TypeNumber))
+		} else {
+			analyzeValue(s.Value, scope, reg, res, depth+1, limit)
+		}
 	case *ast.EchoStmt:
-		analyzeExpr(s.Value, scope, res, depth+1, limit)
+		analyzeExpr(s.Value, scope, reg, res, depth+1, limit)
 	case *ast.RunStmt:
-		analyzeExpr(s.Command, scope, res, depth+1, limit)
+		analyzeExpr(s.Command, scope, reg, res, depth+1, limit)
+		for _, arg := range s.Args {
+			analyzeExpr(arg, scope, reg, res, depth+1, limit)
+		}
+		res.Errors = append(res.Errors, checkRunLine(s.Command, s.Args)...)
 	case *ast.ReturnStmt:
 		if !scope.IsFunctionScope() {
 			res.Errors = append(res.Errors, ReturnOutsideFunctionError{P: s.P})
 		}
 		if s.Value != nil {
-			analyzeExpr(s.Value, scope, res, depth+1, limit)
+			analyzeExpr(s.Value, scope, reg, res, depth+1, limit)
+		}
+	case *ast.TryStmt:
+		bodyScope := NewScope(scope)
+		for _, inner := range s.Body {
+			analyzeStmt(inner, bodyScope, reg, res, depth+1, limit)
+		}
+		if s.Err != "" {
+			catchScope := NewScope(scope)
+			if err := ValidateIdentifier(s.Err, s.P); err != nil {
+				res.Errors = append(res.Errors, err)
+			}
+			if err := catchScope.Define(s.Err, s.P); err != nil {
+				res.Errors = append(res.Errors, err)
+			} else {
+				catchScope.setType(s.Err, TypeMap)
+			}
+			res.CatchScopes[s] = catchScope
+			for _, inner := range s.Catch {
+				analyzeStmt(inner, catchScope, reg, res, depth+1, limit)
+			}
+		}
+		finallyScope := NewScope(scope)
+		for _, inner := range s.Finally {
+			analyzeStmt(inner, finallyScope, reg, res, depth+1, limit)
+		}
+	case *ast.UncheckedStmt:
+		// Not a control-flow block, so names set inside stay visible after it.
+		for _, inner := range s.Body {
+			analyzeStmt(inner, scope, reg, res, depth+1, limit)
+		}
+	case *ast.ParamsStmt:
+		for _, param := range s.Params {
+			if err := ValidateIdentifier(param.Name, param.P); err != nil {
+				res.Errors = append(res.Errors, err)
+			}
+			if param.Name == "help" {
+				res.Errors = append(res.Errors, ParamError{Name: param.Name, Msg: "clashes with --help, which prints the usage", P: param.P})
+			}
+			if param.Default != nil {
+				analyzeExpr(param.Default, scope, reg, res, depth+1, limit)
+				if !isParamDefault(param.Default) {
+					res.Errors = append(res.Errors, ParamError{Name: param.Name, Msg: "needs a string or number literal as its default", P: param.Default.Pos()})
+				}
+			}
+			if err := scope.Define(param.Name, param.P); err != nil {
+				res.Errors = append(res.Errors, err)
+			} else if param.Flag {
+				scope.setType(param.Name, TypeBool)
+			}
 		}
-	case *ast.BreakStmt, *ast.ContinueStmt:
-		// nothing to validate
+	case *ast.BreakStmt, *ast.ContinueStmt, *ast.StrictStmt, *ast.ImportStmt:
+		// nothing to validate; imports are resolved before analysis
 	}
 }
 
-func analyzeExpr(expr ast.Expr, scope *Scope, res *AnalysisResult, depth, limit int) {
+// isParamDefault reports whether e can be the default of a parameter: a
+// string without interpolation or a number. Defaults are printed as written
+// in the usage text.
+func isParamDefault(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.StringLit:
+		return !strings.Contains(e.Value, "$")
+	case *ast.NumberLit:
+		return true
+	}
+	return false
+}
+
+func analyzeExpr(expr ast.Expr, scope *Scope, reg *FunctionRegistry, res *AnalysisResult, depth, limit int) {
 	if expr == nil {
 		return
 	}
@@ -233,37 +401,188 @@ func analyzeExpr(expr ast.Expr, scope *Scope, res *AnalysisResult, depth, limit
 		res.Errors = append(res.Errors, exceeded)
 		return
 	}
+	res.Types[expr] = TypeOf(expr, scope)
 	switch e := expr.(type) {
 	case *ast.IdentExpr:
-		if IsReserved(e.Name) {
-			return
-		}
-		if _, ok := scope.Lookup(e.Name); !ok {
-			res.Errors = append(res.Errors, UndefinedVariableError{Name: e.Name, P: e.P})
+		resolveIdent(e, scope, res)
+		if t := res.Types[e]; !t.scalar() {
+			res.Errors = append(res.Errors, TypeError{Name: e.Name, Got: t, Want: []Type{TypeString, TypeNumber, TypeBool}, Use: "value", P: e.P})
 		}
 	case *ast.IndexExpr:
-		analyzeExpr(e.Left, scope, res, depth+1, limit)
-		analyzeExpr(e.Index, scope, res, depth+1, limit)
+		analyzeRef(e.Left, scope, reg, res, depth+1, limit)
+		analyzeExpr(e.Index, scope, reg, res, depth+1, limit)
+		res.Errors = appendErr(res.Errors, checkType(e.Left, scope, "indexing", TypeList))
+		res.Errors = appendErr(res.Errors, checkType(e.Index, scope, "index", TypeNumber))
 	case *ast.PropertyExpr:
-		analyzeExpr(e.Object, scope, res, depth+1, limit)
+		analyzeRef(e.Object, scope, reg, res, depth+1, limit)
+		res.Errors = appendErr(res.Errors, checkType(e.Object, scope, "property access", TypeMap))
 	case *ast.BinaryExpr:
-		analyzeExpr(e.Left, scope, res, depth+1, limit)
-		analyzeExpr(e.Right, scope, res, depth+1, limit)
+		analyzeExpr(e.Left, scope, reg, res, depth+1, limit)
+		analyzeExpr(e.Right, scope, reg, res, depth+1, limit)
+		if IsArithmeticOp(e.Op) {
+			want := []Type{TypeNumber}
+			if IsDecimalOp(e.Op) {
+				want = append(want, TypeDecimal)
+			}
+			res.Errors = appendErr(res.Errors, checkType(e.Left, scope, "operator "+e.Op, want...))
+			res.Errors = appendErr(res.Errors, checkType(e.Right, scope, "operator "+e.Op, want...))
+		} else if IsOrderingOp(e.Op) {
+			// set /a reads a string operand as a variable name.
+			res.Errors = appendErr(res.Errors, checkType(e.Left, scope, "operator "+e.Op, TypeNumber, TypeDecimal))
+			res.Errors = appendErr(res.Errors, checkType(e.Right, scope, "operator "+e.Op, TypeNumber, TypeDecimal))
+		}
 	case *ast.UnaryExpr:
-		analyzeExpr(e.Right, scope, res, depth+1, limit)
+		analyzeExpr(e.Right, scope, reg, res, depth+1, limit)
+		if e.Op == "-" {
+			res.Errors = appendErr(res.Errors, checkType(e.Right, scope, "operator -", TypeNumber, TypeDecimal))
+		}
 	case *ast.ListLit:
 		for _, el := range e.Elements {
-			analyzeExpr(el, scope, res, depth+1, limit)
+			analyzeExpr(el, scope, reg, res, depth+1, limit)
 		}
 	case *ast.MapLit:
 		for _, p := range e.Pairs {
-			analyzeExpr(p.Value, scope, res, depth+1, limit)
+			analyzeExpr(p.Value, scope, reg, res, depth+1, limit)
 		}
 	case *ast.ExistsCond:
-		analyzeExpr(e.Path, scope, res, depth+1, limit)
-	case *ast.StringLit, *ast.NumberLit, *ast.BoolLit:
+		analyzeExpr(e.Path, scope, reg, res, depth+1, limit)
+	case *ast.CallExpr:
+		if ReturnsList(e.Name) {
+			res.Errors = append(res.Errors, BuiltinUsageError{Name: e.Name, Msg: "returns a list, which can only be assigned to a variable", P: e.P})
+		} else if ReturnsNothing(e.Name) {
+			res.Errors = append(res.Errors, BuiltinUsageError{Name: e.Name, Msg: "does not return a value, so it can only be called as a statement", P: e.P})
+		}
+		analyzeCall(e.Name, e.Args, e.P, scope, reg, res, depth, limit)
+	case *ast.RunExpr:
+		analyzeExpr(e.Command, scope, reg, res, depth+1, limit)
+		for _, arg := range e.Args {
+			analyzeExpr(arg, scope, reg, res, depth+1, limit)
+		}
+		res.Errors = append(res.Errors, checkRunLine(e.Command, e.Args)...)
+	case *ast.StringLit:
+		for _, call := range e.Calls {
+			analyzeExpr(call, scope, reg, res, depth+1, limit)
+		}
+		for _, v := range e.Vars {
+			// Reserved names cannot be declared, so outside builtin
+			// variables such as $status they always read as empty.
+			if id := rootIdent(v); IsReserved(id.Name) && !IsBuiltinVar(id.Name) {
+				res.Errors = append(res.Errors, ReservedNameError{Name: id.Name, P: id.P})
+				continue
+			}
+			analyzeExpr(v, scope, reg, res, depth+1, limit)
+		}
+	case *ast.NumberLit, *ast.BoolLit:
+		return
+	}
+}
+
+// checkRunLine reports the string literals of a run that hold a line break.
+// A batch command line ends at the first one, and `for /f` cannot capture a
+// command spread over several lines.
+func checkRunLine(command ast.Expr, args []ast.Expr) []error {
+	var errs []error
+	for _, e := range append([]ast.Expr{command}, args...) {
+		if s, ok := e.(*ast.StringLit); ok && strings.ContainsAny(s.Value, "\r\n") {
+			errs = append(errs, RunError{Msg: "cannot pass a line break on a batch command line", P: s.P})
+		}
+	}
+	return errs
+}
+
+// analyzeRef analyzes an expression that may name a list or a map, such as
+// the subject of an index or the first argument of a list built-in.
+func analyzeRef(expr ast.Expr, scope *Scope, reg *FunctionRegistry, res *AnalysisResult, depth, limit int) {
+	id, ok := expr.(*ast.IdentExpr)
+	if !ok {
+		analyzeExpr(expr, scope, reg, res, depth, limit)
+		return
+	}
+	res.Types[id] = TypeOf(id, scope)
+	resolveIdent(id, scope, res)
+}
+
+// resolveIdent records the definition a variable refers to. NAME_len refers
+// to the list NAME.
+func resolveIdent(e *ast.IdentExpr, scope *Scope, res *AnalysisResult) {
+	if IsReserved(e.Name) {
 		return
 	}
+	if def, ok := scope.Resolve(e.Name); ok {
+		res.Refs[e.P] = def
+	} else if def, ok := listLen(e.Name, scope); ok {
+		res.Refs[e.P] = def
+	} else {
+		res.Errors = append(res.Errors, UndefinedVariableError{Name: e.Name, P: e.P})
+	}
+}
+
+// analyzeValue analyzes the value of a set or an assignment, the one place
+// a call to a built-in that returns a list may appear.
+func analyzeValue(expr ast.Expr, scope *Scope, reg *FunctionRegistry, res *AnalysisResult, depth, limit int) {
+	if call, ok := expr.(*ast.CallExpr); ok && ReturnsList(call.Name) {
+		res.Types[call] = TypeList
+		analyzeCall(call.Name, call.Args, call.P, scope, reg, res, depth, limit)
+		return
+	}
+	analyzeExpr(expr, scope, reg, res, depth, limit)
+}
+
+// analyzeCall checks that a call statement or call expression names a
+// declared function or a built-in and passes it the right number of
+// arguments.
+func analyzeCall(name string, args []ast.Expr, pos ast.Pos, scope *Scope, reg *FunctionRegistry, res *AnalysisResult, depth, limit int) {
+	if arity, ok := reg.Lookup(name); !ok {
+		res.Errors = append(res.Errors, UndefinedVariableError{Name: name, P: pos})
+	} else {
+		if def, ok := reg.Resolve(name); ok {
+			res.Refs[pos] = def
+		}
+		if arity != len(args) {
+			res.Errors = append(res.Errors, InvalidArityError{Name: name, Expected: arity, Got: len(args), P: pos})
+		} else if IsBuiltin(name) {
+			res.Errors = append(res.Errors, checkBuiltin(name, args, pos, scope)...)
+		}
+	}
+	for i, arg := range args {
+		if IsBuiltin(name) && takesList(name, i) {
+			analyzeRef(arg, scope, reg, res, depth+1, limit)
+		} else {
+			analyzeExpr(arg, scope, reg, res, depth+1, limit)
+		}
+	}
+}
+
+// listLen resolves NAME_len, the length of the list NAME, to the definition
+// of the list.
+func listLen(name string, scope *Scope) (ast.Pos, bool) {
+	list, ok := strings.CutSuffix(name, "_len")
+	if !ok {
+		return ast.Pos{}, false
+	}
+	if t, _ := scope.Type(list); t != TypeList {
+		return ast.Pos{}, false
+	}
+	return scope.Resolve(list)
+}
+
+// rootIdent returns the variable a string interpolation reads from.
+func rootIdent(expr ast.Expr) *ast.IdentExpr {
+	switch e := expr.(type) {
+	case *ast.PropertyExpr:
+		return rootIdent(e.Object)
+	case *ast.IndexExpr:
+		return rootIdent(e.Left)
+	}
+	return expr.(*ast.IdentExpr)
+}
+
+// appendErr appends err to errs unless it is nil.
+func appendErr(errs []error, err error) []error {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
 }
 
 func checkDepth(pos ast.Pos, depth, limit int) error {
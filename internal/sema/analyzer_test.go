@@ -327,6 +327,65 @@ func TestAnalyzeDefinitions_TracksForScope(t *testing.T) {
 	}
 }
 
+func TestAnalyzeDefinitions_TracksForEachScope(t *testing.T) {
+	each := &ast.ForEachStmt{Key: "k", Var: "v", Iterable: &ast.IdentExpr{Name: "m", P: ast.Pos{Line: 2, Column: 13}}, Body: []ast.Statement{
+		&ast.EchoStmt{Value: &ast.IdentExpr{Name: "k", P: ast.Pos{Line: 3, Column: 10}}, P: ast.Pos{Line: 3, Column: 5}},
+	}, P: ast.Pos{Line: 2, Column: 1}}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "m", Value: &ast.MapLit{}, P: ast.Pos{Line: 1, Column: 1}},
+		each,
+		&ast.EchoStmt{Value: &ast.IdentExpr{Name: "v", P: ast.Pos{Line: 5, Column: 6}}, P: ast.Pos{Line: 5, Column: 1}},
+	}}
+	res := AnalyzeDefinitions(prog)
+	if len(res.Errors) != 1 {
+		t.Fatalf("expected only the use of v after the loop to fail, got %v", res.Errors)
+	}
+	var u UndefinedVariableError
+	if !errors.As(res.Errors[0], &u) || u.Name != "v" {
+		t.Fatalf("expected undefined v, got %v", res.Errors[0])
+	}
+	scope, ok := res.EachScopes[each]
+	if !ok || scope == nil {
+		t.Fatalf("expected for-each scope recorded")
+	}
+	for _, name := range []string{"k", "v"} {
+		if _, found := scope.Lookup(name); !found {
+			t.Fatalf("expected loop var %s in scope", name)
+		}
+	}
+	if res.Refs[ast.Pos{Line: 3, Column: 10}] != each.P {
+		t.Fatalf("k should resolve to the loop header")
+	}
+}
+
+func TestAnalyzeDefinitions_RecordsRefs(t *testing.T) {
+	setX := ast.Pos{Line: 1, Column: 1}
+	fnPos := ast.Pos{Line: 2, Column: 1}
+	use := ast.Pos{Line: 3, Column: 6}
+	assign := ast.Pos{Line: 4, Column: 3}
+	call := ast.Pos{Line: 5, Column: 1}
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.SetStmt{Name: "x", Value: &ast.NumberLit{Value: "1", P: ast.Pos{Line: 1, Column: 7}}, P: setX},
+		&ast.FnDecl{Name: "f", Params: []string{"p"}, P: fnPos},
+		&ast.EchoStmt{Value: &ast.IdentExpr{Name: "x", P: use}, P: ast.Pos{Line: 3, Column: 1}},
+		&ast.AssignStmt{Name: "x", Value: &ast.NumberLit{Value: "2", P: ast.Pos{Line: 4, Column: 5}}, P: assign},
+		&ast.CallStmt{Name: "f", Args: []ast.Expr{&ast.IdentExpr{Name: "missing", P: ast.Pos{Line: 5, Column: 3}}}, P: call},
+	}}
+	res := AnalyzeDefinitions(prog)
+	want := map[ast.Pos]ast.Pos{use: setX, assign: setX, call: fnPos}
+	if len(res.Refs) != len(want) {
+		t.Fatalf("got refs %v, want %v", res.Refs, want)
+	}
+	for use, def := range want {
+		if res.Refs[use] != def {
+			t.Fatalf("ref at %v resolved to %v, want %v", use, res.Refs[use], def)
+		}
+	}
+	if arity, ok := res.Funcs.Lookup("f"); !ok || arity != 1 {
+		t.Fatalf("expected f with arity 1 in Funcs, got ok=%v arity=%d", ok, arity)
+	}
+}
+
 func TestAnalyze_NoShadowInFnParams(t *testing.T) {
 	prog := &ast.Program{Statements: []ast.Statement{
 		&ast.SetStmt{Name: "x", Value: &ast.NumberLit{Value: "1", P: ast.Pos{Line: 1, Column: 5}}, P: ast.Pos{Line: 1, Column: 1}},
@@ -364,3 +423,42 @@ func TestAnalyze_NoShadowInNestedSet(t *testing.T) {
 		t.Fatalf("expected def position 1:1, got %d:%d", sh.Def.Line, sh.Def.Column)
 	}
 }
+
+func TestAnalyze_UncheckedSharesScope(t *testing.T) {
+	prog := &ast.Program{Statements: []ast.Statement{
+		&ast.StrictStmt{P: ast.Pos{Line: 1, Column: 1}},
+		&ast.UncheckedStmt{Body: []ast.Statement{
+			&ast.RunStmt{Command: &ast.StringLit{Value: "taskkill"}, P: ast.Pos{Line: 3, Column: 5}},
+			&ast.SetStmt{Name: "rc", Value: &ast.IdentExpr{Name: "status"}, P: ast.Pos{Line: 4, Column: 5}},
+		}, P: ast.Pos{Line: 2, Column: 1}},
+		&ast.EchoStmt{Value: &ast.IdentExpr{Name: "rc"}, P: ast.Pos{Line: 6, Column: 1}},
+	}}
+	if errs := Analyze(prog); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestAnalyze_CatchVariableScope(t *testing.T) {
+	errUse := &ast.PropertyExpr{Object: &ast.IdentExpr{Name: "err", P: ast.Pos{Line: 4, Column: 11}}, Field: "code", P: ast.Pos{Line: 4, Column: 15}}
+	try := &ast.TryStmt{
+		Body:  []ast.Statement{&ast.RunStmt{Command: &ast.StringLit{Value: "deploy"}, P: ast.Pos{Line: 2, Column: 5}}},
+		Err:   "err",
+		Catch: []ast.Statement{&ast.EchoStmt{Value: errUse, P: ast.Pos{Line: 4, Column: 5}}},
+		P:     ast.Pos{Line: 1, Column: 1},
+	}
+	prog := &ast.Program{Statements: []ast.Statement{
+		try,
+		&ast.EchoStmt{Value: &ast.IdentExpr{Name: "err", P: ast.Pos{Line: 6, Column: 6}}, P: ast.Pos{Line: 6, Column: 1}},
+	}}
+	res := AnalyzeDefinitions(prog)
+	if len(res.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", res.Errors)
+	}
+	var u UndefinedVariableError
+	if !errors.As(res.Errors[0], &u) || u.P.Line != 6 {
+		t.Fatalf("expected undefined err on line 6, got %v", res.Errors[0])
+	}
+	if _, ok := res.CatchScopes[try].Resolve("err"); !ok {
+		t.Fatalf("err not defined in catch scope")
+	}
+}
@@ -0,0 +1,114 @@
+package sema
+
+import "github.com/vishnunath-suresh/fin-project/internal/ast"
+
+// Builtins maps each built-in function to the number of arguments it takes.
+// Built-ins are called like functions and their names are reserved.
+var Builtins = map[string]int{
+	"len":         1,
+	"upper":       1,
+	"lower":       1,
+	"substr":      3,
+	"replace":     3,
+	"trim":        1,
+	"starts_with": 2,
+	"ends_with":   2,
+	"contains":    2,
+	"split":       2,
+	"join":        2,
+	"push":        2,
+	"pop":         1,
+	"insert":      3,
+	"remove_at":   2,
+	"index_of":    2,
+	"reverse":     1,
+	"sort":        1,
+	"slice":       3,
+}
+
+// listBuiltins are the built-ins whose first argument is a list variable.
+// contains also takes a list, when its first argument is one.
+var listBuiltins = map[string]bool{
+	"join":      true,
+	"push":      true,
+	"pop":       true,
+	"insert":    true,
+	"remove_at": true,
+	"index_of":  true,
+	"reverse":   true,
+	"sort":      true,
+	"slice":     true,
+}
+
+// builtinTypes holds the type of the value each built-in function returns.
+// pop and remove_at return an element, whose type is unknown.
+var builtinTypes = map[string]Type{
+	"len":         TypeNumber,
+	"upper":       TypeString,
+	"lower":       TypeString,
+	"substr":      TypeString,
+	"replace":     TypeString,
+	"trim":        TypeString,
+	"starts_with": TypeBool,
+	"ends_with":   TypeBool,
+	"contains":    TypeBool,
+	"split":       TypeList,
+	"join":        TypeString,
+	"index_of":    TypeNumber,
+	"slice":       TypeList,
+}
+
+// IsBuiltin reports whether name is a built-in function.
+func IsBuiltin(name string) bool {
+	_, ok := Builtins[name]
+	return ok
+}
+
+// ReturnsList reports whether the built-in function name returns a list.
+// Lists are stored as one variable per element, so such a call can only be
+// the value of a set or an assignment.
+func ReturnsList(name string) bool {
+	return builtinTypes[name] == TypeList
+}
+
+// Modifies reports whether the built-in function name changes the list it
+// is given, and so may be called as a statement.
+func Modifies(name string) bool {
+	switch name {
+	case "push", "pop", "insert", "remove_at", "reverse", "sort":
+		return true
+	}
+	return false
+}
+
+// ReturnsNothing reports whether the built-in function name only changes its
+// list, so it cannot be used as a value.
+func ReturnsNothing(name string) bool {
+	return Modifies(name) && name != "pop" && name != "remove_at"
+}
+
+// checkBuiltin reports built-in calls whose arguments cannot be lowered. A
+// list is read through its variable, so list built-ins need the variable of
+// a list. The other arguments are single values, checked where they are read.
+func checkBuiltin(name string, args []ast.Expr, pos ast.Pos, scope *Scope) []error {
+	switch {
+	case listBuiltins[name]:
+		if _, ok := args[0].(*ast.IdentExpr); !ok {
+			return []error{BuiltinUsageError{Name: name, Msg: "takes a list variable, such as $parts, as its first argument", P: pos}}
+		}
+		if err := checkType(args[0], scope, name, TypeList); err != nil {
+			return []error{err}
+		}
+	case name == "contains":
+		if err := checkType(args[0], scope, name, TypeString, TypeList); err != nil {
+			return []error{err}
+		}
+	}
+	return nil
+}
+
+// takesList reports whether the argument at index i of a call of the
+// built-in name may be a list.
+func takesList(name string, i int) bool {
+	return i == 0 && (listBuiltins[name] || name == "contains")
+}
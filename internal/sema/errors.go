@@ -17,9 +17,11 @@ func (e UndefinedVariableError) Error() string {
 }
 
 // DuplicateFunctionError is raised when a function name is declared more than once.
+// P is the duplicate declaration; Def is the first one.
 type DuplicateFunctionError struct {
 	Name string
 	P    ast.Pos
+	Def  ast.Pos
 }
 
 func (e DuplicateFunctionError) Error() string {
@@ -78,3 +80,57 @@ type ReturnOutsideFunctionError struct {
 func (e ReturnOutsideFunctionError) Error() string {
 	return fmt.Sprintf("return used outside function at %d:%d", e.P.Line, e.P.Column)
 }
+
+// BuiltinUsageError is raised when a built-in function is called in a way
+// the language cannot support, such as using a list it returns as a value.
+type BuiltinUsageError struct {
+	Name string
+	Msg  string
+	P    ast.Pos
+}
+
+func (e BuiltinUsageError) Error() string {
+	return fmt.Sprintf("built-in %q at %d:%d %s", e.Name, e.P.Line, e.P.Column, e.Msg)
+}
+
+// ParamError is raised when a parameter in a params block is declared in a
+// way the generated argument parser cannot support, such as with a default
+// that is not a literal.
+type ParamError struct {
+	Name string
+	Msg  string
+	P    ast.Pos
+}
+
+func (e ParamError) Error() string {
+	return fmt.Sprintf("parameter %q at %d:%d %s", e.Name, e.P.Line, e.P.Column, e.Msg)
+}
+
+// RunError is raised when the command line of a run cannot be written to a
+// batch file, such as a literal argument holding a line break.
+type RunError struct {
+	Msg string
+	P   ast.Pos
+}
+
+func (e RunError) Error() string {
+	return fmt.Sprintf("run at %d:%d %s", e.P.Line, e.P.Column, e.Msg)
+}
+
+// TypeError is raised when a value is used in a way its type does not
+// allow, such as arithmetic on a string, indexing a map or pushing onto a
+// string. Name is empty when the value is not a variable.
+type TypeError struct {
+	Name string
+	Got  Type
+	Want []Type
+	Use  string // what the value is used for, such as "indexing" or "push"
+	P    ast.Pos
+}
+
+func (e TypeError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("%s at %d:%d needs a %s, but got a %s", e.Use, e.P.Line, e.P.Column, JoinTypes(e.Want), e.Got)
+	}
+	return fmt.Sprintf("%s at %d:%d needs a %s, but %q is a %s", e.Use, e.P.Line, e.P.Column, JoinTypes(e.Want), e.Name, e.Got)
+}
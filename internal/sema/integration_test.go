@@ -199,3 +199,354 @@ func containsErrorType[T error](errs []error) bool {
 	}
 	return false
 }
+
+func TestIntegration_CallExprArity(t *testing.T) {
+	src := "fn add a b\n" +
+		"    return $a + $b\n" +
+		"end\n" +
+		"set x (add 1 (add 2))\n" +
+		"echo \"$(add $x 1 2) $(nope)\"\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		switch e := err.(type) {
+		case InvalidArityError:
+			got = append(got, fmt.Sprintf("arity %s %d/%d @%d:%d", e.Name, e.Got, e.Expected, e.P.Line, e.P.Column))
+		case UndefinedVariableError:
+			got = append(got, fmt.Sprintf("undefined %s @%d:%d", e.Name, e.P.Line, e.P.Column))
+		default:
+			t.Fatalf("unexpected error %T: %v", err, err)
+		}
+	}
+	want := []string{"arity add 1/2 @4:15", "arity add 3/2 @5:9", "undefined nope @5:23"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if def := res.Refs[ast.Pos{Line: 4, Column: 8}]; def != (ast.Pos{Line: 1, Column: 1}) {
+		t.Fatalf("call at 4:8 resolved to %v, want 1:1", def)
+	}
+}
+
+func TestIntegration_Builtins(t *testing.T) {
+	src := "set s \"a,b\"\n" +
+		"set parts (split $s \",\")\n" +
+		"echo (join $parts \"-\")\n" +
+		"echo (substr $s 1)\n" +
+		"echo (len (split $s \",\"))\n" +
+		"echo (join (upper $s) \",\")\n" +
+		"trim $s\n" +
+		"set len 1\n" +
+		"if (contains $s \"a\") && (starts_with (lower $s) \"a\")\n" +
+		"    echo (replace $s \",\" \";\")\n" +
+		"end\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		switch e := err.(type) {
+		case InvalidArityError:
+			got = append(got, fmt.Sprintf("arity %s %d/%d @%d:%d", e.Name, e.Got, e.Expected, e.P.Line, e.P.Column))
+		case BuiltinUsageError:
+			got = append(got, fmt.Sprintf("usage %s @%d:%d", e.Name, e.P.Line, e.P.Column))
+		case ReservedNameError:
+			got = append(got, fmt.Sprintf("reserved %s @%d:%d", e.Name, e.P.Line, e.P.Column))
+		default:
+			t.Fatalf("unexpected error %T: %v", err, err)
+		}
+	}
+	want := []string{
+		"arity substr 2/3 @4:7",
+		"usage split @5:12",
+		"usage join @6:7",
+		"usage trim @7:1",
+		"reserved len @8:1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIntegration_ListBuiltins(t *testing.T) {
+	src := "set s \"abc\"\n" +
+		"set m {a: 1}\n" +
+		"set xs [1, 2]\n" +
+		"push $xs $xs_len\n" +
+		"set last (pop $xs)\n" +
+		"set part (slice $xs 0 1)\n" +
+		"echo \"$(contains $xs 1) $(contains $s $last)\"\n" +
+		"echo $s[0]\n" +
+		"push $m 1\n" +
+		"sort (upper $s)\n" +
+		"echo (upper $xs)\n" +
+		"s = [1]\n" +
+		"part = (split $s \"b\")\n" +
+		"echo (reverse $xs)\n" +
+		"echo (contains $m 1)\n" +
+		"echo $s_len\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		switch e := err.(type) {
+		case TypeError:
+			got = append(got, fmt.Sprintf("type %s %s %s->%v @%d:%d", e.Use, e.Name, e.Got, e.Want, e.P.Line, e.P.Column))
+		case BuiltinUsageError:
+			got = append(got, fmt.Sprintf("usage %s @%d:%d", e.Name, e.P.Line, e.P.Column))
+		case UndefinedVariableError:
+			got = append(got, fmt.Sprintf("undefined %s @%d:%d", e.Name, e.P.Line, e.P.Column))
+		default:
+			t.Fatalf("unexpected error %T: %v", err, err)
+		}
+	}
+	want := []string{
+		"type indexing s string->[list] @8:6",
+		"type push m map->[list] @9:6",
+		"usage sort @10:1",
+		"type value xs list->[string number bool] @11:13",
+		"type assignment s string->[list] @12:3",
+		"usage reverse @14:7",
+		"type contains m map->[string list] @15:16",
+		"undefined s_len @16:6",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if def := res.Refs[ast.Pos{Line: 4, Column: 10}]; def != (ast.Pos{Line: 3, Column: 1}) {
+		t.Fatalf("$xs_len at 4:10 resolved to %v, want 3:1", def)
+	}
+}
+
+func TestIntegration_Types(t *testing.T) {
+	src := "set name \"bob\"\n" +
+		"set n 1\n" +
+		"set ok $n < 2\n" +
+		"set xs [1, 2]\n" +
+		"set m {a: 1}\n" +
+		"fn f p\n" +
+		"    return $p + 1\n" +
+		"end\n" +
+		"set a $name + 1\n" +
+		"set b -$ok\n" +
+		"echo $xs.a\n" +
+		"echo $m[0]\n" +
+		"echo $xs[$name]\n" +
+		"echo $xs\n" +
+		"n = \"two\"\n" +
+		"name = $n * 2\n" +
+		"for i in 1..$name\n" +
+		"    echo $i\n" +
+		"end\n" +
+		"for x in $m\n" +
+		"    echo $x\n" +
+		"end\n" +
+		"for k, v in $xs\n" +
+		"    echo $k\n" +
+		"end\n" +
+		"set c (len $name) + $xs_len\n" +
+		"set d $name < \"b\"\n" +
+		"set count (run \"git rev-list --count HEAD\")\n" +
+		"set e $count + (run \"git rev-list --count main\")\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		e, ok := err.(TypeError)
+		if !ok {
+			t.Fatalf("unexpected error %T: %v", err, err)
+		}
+		got = append(got, fmt.Sprintf("%s %q %s->%v @%d:%d", e.Use, e.Name, e.Got, e.Want, e.P.Line, e.P.Column))
+	}
+	want := []string{
+		`operator + "name" string->[number decimal] @9:7`,
+		`operator - "ok" bool->[number decimal] @10:8`,
+		`property access "xs" list->[map] @11:6`,
+		`indexing "m" map->[list] @12:6`,
+		`index "name" string->[number] @13:10`,
+		`value "xs" list->[string number bool] @14:6`,
+		`assignment "n" number->[string] @15:3`,
+		`range "name" string->[number] @17:13`,
+		`for loop "m" map->[list] @20:10`,
+		`for loop "xs" list->[map] @23:13`,
+		`operator < "name" string->[number decimal] @27:7`,
+		`operator < "" string->[number decimal] @27:15`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	types := map[string]Type{}
+	for expr, ty := range res.Types {
+		p := expr.Pos()
+		types[fmt.Sprintf("%d:%d", p.Line, p.Column)] = ty
+	}
+	for pos, ty := range map[string]Type{
+		"3:11":  TypeBool,   // $n < 2
+		"7:15":  TypeNumber, // $p + 1
+		"26:19": TypeNumber, // (len $name) + $xs_len
+		"26:21": TypeNumber, // $xs_len
+	} {
+		if types[pos] != ty {
+			t.Errorf("type at %s = %s, want %s", pos, types[pos], ty)
+		}
+	}
+}
+
+func TestIntegration_CompoundAssign(t *testing.T) {
+	src := "set name \"bob\"\n" +
+		"set n 1\n" +
+		"set ok true\n" +
+		"n += 2 & 3 << $n\n" +
+		"name += 1\n" +
+		"ok++\n" +
+		"n -= \"x\"\n" +
+		"set b $name % 2\n" +
+		"set c $ok | 1\n" +
+		"missing--\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		switch e := err.(type) {
+		case TypeError:
+			got = append(got, fmt.Sprintf("%s %q %s->%v @%d:%d", e.Use, e.Name, e.Got, e.Want, e.P.Line, e.P.Column))
+		default:
+			got = append(got, fmt.Sprintf("%T %v", err, err))
+		}
+	}
+	want := []string{
+		`operator += "name" string->[number] @5:6`,
+		`operator ++ "ok" bool->[number] @6:3`,
+		`operator -= "" string->[number] @7:6`,
+		`operator % "name" string->[number] @8:7`,
+		`operator | "ok" bool->[number] @9:7`,
+		`sema.UndefinedVariableError undefined variable "missing" at 10:8 — referenced before declaration`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIntegration_Decimals(t *testing.T) {
+	src := "set price 19.99\n" +
+		"set n 3\n" +
+		"set total $price * $n\n" +
+		"set half -$total / 2\n" +
+		"set big $total > 50\n" +
+		"price = 20\n" +
+		"n = 1.5\n" +
+		"set r $price % 2\n" +
+		"price += 1\n" +
+		"for i in 1..$price\n" +
+		"    echo $i\n" +
+		"end\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		e, ok := err.(TypeError)
+		if !ok {
+			t.Fatalf("unexpected error %T: %v", err, err)
+		}
+		got = append(got, fmt.Sprintf("%s %q %s->%v @%d:%d", e.Use, e.Name, e.Got, e.Want, e.P.Line, e.P.Column))
+	}
+	want := []string{
+		`assignment "n" number->[decimal] @7:3`,
+		`operator % "price" decimal->[number] @8:7`,
+		`operator += "price" decimal->[number] @9:7`,
+		`range "price" decimal->[number] @10:13`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	types := map[string]Type{}
+	for expr, ty := range res.Types {
+		p := expr.Pos()
+		types[fmt.Sprintf("%d:%d", p.Line, p.Column)] = ty
+	}
+	for pos, ty := range map[string]Type{
+		"1:11": TypeDecimal, // 19.99
+		"3:18": TypeDecimal, // $price * $n
+		"4:10": TypeDecimal, // -$total
+		"4:18": TypeDecimal, // -$total / 2
+		"5:16": TypeBool,    // $total > 50
+	} {
+		if types[pos] != ty {
+			t.Errorf("type at %s = %s, want %s", pos, types[pos], ty)
+		}
+	}
+}
+
+func TestIntegration_StringInterpolation(t *testing.T) {
+	src := "set m {a: 1}\n" +
+		"set xs [1]\n" +
+		"fn f\n" +
+		"    return 1\n" +
+		"end\n" +
+		"f\n" +
+		"echo \"$m.a $xs[0] $xs_len $status\"\n" +
+		"echo \"ok: $nope, $xs.a $m[0] $if $fn_f_ret\"\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		got = append(got, fmt.Sprintf("%T %v", err, err))
+	}
+	want := []string{
+		`sema.UndefinedVariableError undefined variable "nope" at 8:11 — referenced before declaration`,
+		`sema.TypeError property access at 8:18 needs a map, but "xs" is a list`,
+		`sema.TypeError indexing at 8:24 needs a list, but "m" is a map`,
+		`sema.ReservedNameError reserved name "if" at 8:30 — choose a different identifier`,
+		`sema.UndefinedVariableError undefined variable "fn_f_ret" at 8:34 — referenced before declaration`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIntegration_Params(t *testing.T) {
+	src := "params\n" +
+		"    param env default \"dev\"\n" +
+		"    param target\n" +
+		"    param port default 8080\n" +
+		"    flag verbose\n" +
+		"    param help\n" +
+		"    param home default \"$env\"\n" +
+		"    flag env\n" +
+		"    param status\n" +
+		"end\n" +
+		"echo \"$env $target $port\"\n" +
+		"set n $port + 1\n" +
+		"if $verbose\n" +
+		"    verbose = 1\n" +
+		"end\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		got = append(got, fmt.Sprintf("%T %v", err, err))
+	}
+	want := []string{
+		`sema.ParamError parameter "help" at 6:5 clashes with --help, which prints the usage`,
+		`sema.ParamError parameter "home" at 7:24 needs a string or number literal as its default`,
+		`sema.ShadowingError name "env" already defined in an enclosing scope at 8:5 (original at 2:5)`,
+		`sema.ReservedNameError reserved name "status" at 9:5 — choose a different identifier`,
+		`sema.TypeError assignment at 14:13 needs a number, but "verbose" is a bool`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestIntegration_RunLineBreaks(t *testing.T) {
+	src := "set name \"a\"\n" +
+		"run \"printf\" \"line1\\nline2\" $name \"tab\\tok\"\n" +
+		"set out (run \"echo a\\nb\" \"$name\\n\")\n" +
+		"echo \"$out\\n\"\n"
+	res := AnalyzeDefinitions(parseProgram(t, src))
+	var got []string
+	for _, err := range res.Errors {
+		got = append(got, fmt.Sprintf("%T %v", err, err))
+	}
+	want := []string{
+		`sema.RunError run at 2:14 cannot pass a line break on a batch command line`,
+		`sema.RunError run at 3:14 cannot pass a line break on a batch command line`,
+		`sema.RunError run at 3:26 cannot pass a line break on a batch command line`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
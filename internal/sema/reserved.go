@@ -8,11 +8,31 @@ import (
 // reservedNames contains keywords and builtins that cannot be used as identifiers.
 var reservedNames map[string]struct{}
 
+// builtinVars are variables the runtime sets itself. They can be read like
+// any variable but not declared: status holds the exit code of the last run.
+var builtinVars = []string{"status"}
+
 func init() {
-    reservedNames = make(map[string]struct{}, len(token.Keywords))
+    reservedNames = make(map[string]struct{}, len(token.Keywords)+len(builtinVars)+len(Builtins))
     for k := range token.Keywords {
         reservedNames[k] = struct{}{}
     }
+    for _, name := range builtinVars {
+        reservedNames[name] = struct{}{}
+    }
+    for name := range Builtins {
+        reservedNames[name] = struct{}{}
+    }
+}
+
+// IsBuiltinVar reports whether name is a variable the runtime sets itself.
+func IsBuiltinVar(name string) bool {
+    for _, v := range builtinVars {
+        if v == name {
+            return true
+        }
+    }
+    return false
 }
 
 // IsReserved reports whether the given identifier is reserved.
@@ -19,3 +19,10 @@ func TestValidateIdentifier_AllowsNonReserved(t *testing.T) {
         t.Fatalf("unexpected error for non-reserved: %v", err)
     }
 }
+
+func TestValidateIdentifier_RejectsBuiltinVars(t *testing.T) {
+    pos := ast.Pos{Line: 1, Column: 2}
+    if err := ValidateIdentifier("status", pos); err == nil {
+        t.Fatalf("expected reserved error for builtin variable")
+    }
+}
@@ -6,17 +6,18 @@ import "github.com/vishnunath-suresh/fin-project/internal/ast"
 type Scope struct {
 	Parent *Scope
 	vars   map[string]ast.Pos
+	types  map[string]Type
 	isFunc bool
 }
 
 // NewScope creates a new scope with the given parent.
 func NewScope(parent *Scope) *Scope {
-	return &Scope{Parent: parent, vars: make(map[string]ast.Pos)}
+	return &Scope{Parent: parent, vars: make(map[string]ast.Pos), types: make(map[string]Type)}
 }
 
 // NewFunctionScope marks a scope as belonging to a function body.
 func NewFunctionScope(parent *Scope) *Scope {
-	return &Scope{Parent: parent, vars: make(map[string]ast.Pos), isFunc: true}
+	return &Scope{Parent: parent, vars: make(map[string]ast.Pos), types: make(map[string]Type), isFunc: true}
 }
 
 // Define adds a name to the current scope. Shadowing across scopes is disallowed;
@@ -42,6 +43,33 @@ func (s *Scope) Lookup(name string) (*Scope, bool) {
 	return nil, false
 }
 
+// Resolve returns the position where name was defined, searching this scope
+// and then its parents.
+func (s *Scope) Resolve(name string) (ast.Pos, bool) {
+	for sc := s; sc != nil; sc = sc.Parent {
+		if pos, ok := sc.vars[name]; ok {
+			return pos, true
+		}
+	}
+	return ast.Pos{}, false
+}
+
+// setType records the type of the name defined in this scope.
+func (s *Scope) setType(name string, t Type) {
+	s.types[name] = t
+}
+
+// Type returns the type of name, searching this scope and then its parents,
+// and whether name is defined at all.
+func (s *Scope) Type(name string) (Type, bool) {
+	for sc := s; sc != nil; sc = sc.Parent {
+		if _, ok := sc.vars[name]; ok {
+			return sc.types[name], true
+		}
+	}
+	return TypeUnknown, false
+}
+
 // IsFunctionScope reports whether this scope is within a function body (including ancestors).
 func (s *Scope) IsFunctionScope() bool {
 	for sc := s; sc != nil; sc = sc.Parent {
@@ -36,3 +36,20 @@ func TestScope_DuplicateInSameScope(t *testing.T) {
 		t.Fatalf("expected error on duplicate definition in same scope")
 	}
 }
+
+func TestScope_TypeFromParent(t *testing.T) {
+	root := NewScope(nil)
+	root.Define("xs", ast.Pos{Line: 1, Column: 1})
+	root.setType("xs", TypeList)
+	root.Define("p", ast.Pos{Line: 2, Column: 1})
+	child := NewFunctionScope(root)
+	if ty, ok := child.Type("xs"); !ok || ty != TypeList {
+		t.Fatalf("Type(xs) = %v, %v", ty, ok)
+	}
+	if ty, ok := child.Type("p"); !ok || ty != TypeUnknown {
+		t.Fatalf("Type(p) = %v, %v", ty, ok)
+	}
+	if _, ok := child.Type("missing"); ok {
+		t.Fatalf("expected missing to be undefined")
+	}
+}
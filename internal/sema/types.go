@@ -0,0 +1,194 @@
+package sema
+
+import (
+	"strings"
+
+	"github.com/vishnunath-suresh/fin-project/internal/ast"
+)
+
+// Type is the type of a value. Every value is a string at run time, but how
+// a program uses it decides how it is lowered: numbers are computed with
+// set /a, decimals as integers scaled by a power of ten, booleans by tests,
+// and lists and maps are stored as one variable per element.
+type Type int
+
+const (
+	TypeUnknown Type = iota // a string, number or bool only known at run time
+	TypeString
+	TypeNumber
+	TypeBool
+	TypeList
+	TypeMap
+	TypeDecimal
+)
+
+// DefaultPrecision is the number of digits decimals keep after the point
+// unless the compiler is told otherwise; MaxPrecision is the most it can
+// keep, so that a product of two fractions still fits in 32 bits.
+const (
+	DefaultPrecision = 2
+	MaxPrecision     = 4
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeNumber:
+		return "number"
+	case TypeBool:
+		return "bool"
+	case TypeList:
+		return "list"
+	case TypeMap:
+		return "map"
+	case TypeDecimal:
+		return "decimal"
+	}
+	return "unknown"
+}
+
+// scalar reports whether a value of type t is held in a single variable.
+func (t Type) scalar() bool {
+	return t != TypeList && t != TypeMap
+}
+
+// assignable reports whether a value of type got can be stored or used
+// where a value of type want is expected. Numbers, decimals and booleans are
+// strings too, a number is a decimal without a fraction, and a value of
+// unknown type may be any of them.
+func assignable(want, got Type) bool {
+	switch {
+	case want == got:
+		return true
+	case want == TypeDecimal && got == TypeNumber:
+		return true
+	case want.scalar() && got == TypeUnknown:
+		return true
+	case (want == TypeString || want == TypeUnknown) && got.scalar():
+		return true
+	}
+	return false
+}
+
+// TypeOf infers the type of expr, reading the types of variables from scope.
+// With a nil scope, variables are of unknown type.
+func TypeOf(expr ast.Expr, scope *Scope) Type {
+	switch e := expr.(type) {
+	case *ast.StringLit:
+		return TypeString
+	case *ast.RunExpr:
+		// A command prints text, often a number such as a count.
+		return TypeUnknown
+	case *ast.NumberLit:
+		if strings.Contains(e.Value, ".") {
+			return TypeDecimal
+		}
+		return TypeNumber
+	case *ast.BoolLit, *ast.ExistsCond:
+		return TypeBool
+	case *ast.ListLit:
+		return TypeList
+	case *ast.MapLit:
+		return TypeMap
+	case *ast.IdentExpr:
+		if IsBuiltinVar(e.Name) {
+			return TypeNumber
+		}
+		if scope == nil {
+			return TypeUnknown
+		}
+		if t, ok := scope.Type(e.Name); ok {
+			return t
+		}
+		if _, ok := listLen(e.Name, scope); ok {
+			return TypeNumber
+		}
+	case *ast.UnaryExpr:
+		if e.Op == "!" {
+			return TypeBool
+		}
+		if TypeOf(e.Right, scope) == TypeDecimal {
+			return TypeDecimal
+		}
+		return TypeNumber
+	case *ast.BinaryExpr:
+		if !IsArithmeticOp(e.Op) {
+			return TypeBool
+		}
+		if IsDecimalOp(e.Op) && (TypeOf(e.Left, scope) == TypeDecimal || TypeOf(e.Right, scope) == TypeDecimal) {
+			return TypeDecimal
+		}
+		return TypeNumber
+	case *ast.CallExpr:
+		return builtinTypes[e.Name]
+	}
+	return TypeUnknown
+}
+
+// IsArithmeticOp reports whether the binary operator op computes a number.
+func IsArithmeticOp(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "%", "**", "&", "|", "^", "<<", ">>":
+		return true
+	}
+	return false
+}
+
+// IsOrderingOp reports whether the binary operator op orders two numbers.
+func IsOrderingOp(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// IsDecimalOp reports whether the binary operator op computes a decimal when
+// either operand is one. The other arithmetic operators only take numbers.
+func IsDecimalOp(op string) bool {
+	switch op {
+	case "+", "-", "*", "/":
+		return true
+	}
+	return false
+}
+
+// checkType reports a use of expr that needs a value of one of the types in
+// want when expr is known to have another. Values of unknown type are not
+// checked, and lists and maps read where a single value is needed are
+// reported where they are read.
+func checkType(expr ast.Expr, scope *Scope, use string, want ...Type) error {
+	got := TypeOf(expr, scope)
+	if got == TypeUnknown {
+		return nil
+	}
+	scalars := true
+	for _, t := range want {
+		if assignable(t, got) {
+			return nil
+		}
+		scalars = scalars && t.scalar()
+	}
+	if scalars && !got.scalar() {
+		return nil
+	}
+	name := ""
+	if id, ok := expr.(*ast.IdentExpr); ok {
+		name = id.Name
+	}
+	return TypeError{Name: name, Got: got, Want: want, Use: use, P: expr.Pos()}
+}
+
+// JoinTypes lists types the way error messages do: "list", "list or map",
+// "string, number or bool".
+func JoinTypes(types []Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
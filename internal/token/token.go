@@ -10,6 +10,7 @@ const (
 	NUMBER  Type = "NUMBER"
 	STRING  Type = "STRING"
 	NEWLINE Type = "NEWLINE"
+	COMMENT Type = "COMMENT"
 
 	SET    Type = "SET"
 	ECHO   Type = "ECHO"
@@ -22,6 +23,20 @@ const (
 	EXISTS Type = "EXISTS"
 	FN     Type = "FN"
 
+	STRICT    Type = "STRICT"
+	UNCHECKED Type = "UNCHECKED"
+	TRY       Type = "TRY"
+	CATCH     Type = "CATCH"
+	FINALLY   Type = "FINALLY"
+	MATCH     Type = "MATCH"
+	CASE      Type = "CASE"
+	IMPORT    Type = "IMPORT"
+	AS        Type = "AS"
+	PARAMS    Type = "PARAMS"
+	PARAM     Type = "PARAM"
+	FLAG      Type = "FLAG"
+	DEFAULT   Type = "DEFAULT"
+
 	DOTDOT Type = ".."
 	DOT    Type = "."
 
@@ -44,6 +59,7 @@ const (
 	MINUS    Type = "-"
 	STAR     Type = "*"
 	SLASH    Type = "/"
+	PERCENT  Type = "%"
 	BANG     Type = "!"
 	POW      Type = "**"
 	EQEQ     Type = "=="
@@ -54,6 +70,20 @@ const (
 	GTE      Type = ">="
 	AND      Type = "&&"
 	OR       Type = "||"
+
+	AMP   Type = "&"
+	PIPE  Type = "|"
+	CARET Type = "^"
+	SHL   Type = "<<"
+	SHR   Type = ">>"
+
+	PLUSEQ    Type = "+="
+	MINUSEQ   Type = "-="
+	STAREQ    Type = "*="
+	SLASHEQ   Type = "/="
+	PERCENTEQ Type = "%="
+	INC       Type = "++"
+	DEC       Type = "--"
 )
 
 type Token struct {
@@ -61,6 +91,7 @@ type Token struct {
 	Literal string
 	Line    int
 	Column  int
+	Var     bool // an IDENT written as a $name variable reference
 }
 
 func New(t Type, lit string, line, col int) Token {
@@ -73,22 +104,35 @@ func New(t Type, lit string, line, col int) Token {
 }
 
 var Keywords = map[string]Type{
-	"set":      SET,
-	"echo":     ECHO,
-	"run":      RUN,
-	"if":       IF,
-	"else":     ELSE,
-	"end":      END,
-	"for":      FOR,
-	"while":    WHILE,
-	"return":   RETURN,
-	"break":    BREAK,
-	"continue": CONTINUE,
-	"true":     TRUE,
-	"false":    FALSE,
-	"in":       IN,
-	"exists":   EXISTS,
-	"fn":       FN,
+	"set":       SET,
+	"echo":      ECHO,
+	"run":       RUN,
+	"if":        IF,
+	"else":      ELSE,
+	"end":       END,
+	"for":       FOR,
+	"while":     WHILE,
+	"return":    RETURN,
+	"break":     BREAK,
+	"continue":  CONTINUE,
+	"true":      TRUE,
+	"false":     FALSE,
+	"in":        IN,
+	"exists":    EXISTS,
+	"fn":        FN,
+	"strict":    STRICT,
+	"unchecked": UNCHECKED,
+	"try":       TRY,
+	"catch":     CATCH,
+	"finally":   FINALLY,
+	"match":     MATCH,
+	"case":      CASE,
+	"import":    IMPORT,
+	"as":        AS,
+	"params":    PARAMS,
+	"param":     PARAM,
+	"flag":      FLAG,
+	"default":   DEFAULT,
 }
 
 func LookupIdent(ident string) Type {
@@ -82,3 +82,24 @@ func TestCollectTokens_PreservesNewlines(t *testing.T) {
         t.Fatalf("final token is not EOF: %v", toks[2].Type)
     }
 }
+
+func TestCollectTokens_Comments(t *testing.T) {
+    l := lexer.New("set x 1 # note  \r\n# own line\n")
+    toks := parser.CollectTokens(l)
+
+    var comments []token.Token
+    for _, tok := range toks {
+        if tok.Type == token.COMMENT {
+            comments = append(comments, tok)
+        }
+    }
+    if len(comments) != 2 {
+        t.Fatalf("expected 2 COMMENT tokens, got %d", len(comments))
+    }
+    if comments[0].Literal != "# note" || comments[0].Line != 1 || comments[0].Column != 9 {
+        t.Errorf("unexpected first comment %+v", comments[0])
+    }
+    if comments[1].Literal != "# own line" || comments[1].Line != 2 {
+        t.Errorf("unexpected second comment %+v", comments[1])
+    }
+}